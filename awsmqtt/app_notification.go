@@ -9,6 +9,7 @@ import (
 	"fmt"
 	"github.com/jack0liu/conf"
 	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/metrics"
 	"io/ioutil"
 	"net"
 	"net/http"
@@ -49,6 +50,9 @@ type Params struct {
 	Payload      IosPayLoad `json:"payload"`
 }
 
+var appNotifications = metrics.NewCounter("app_notifications_total",
+	"Number of notifications pushed to the app by outcome (sent or failed).", "outcome")
+
 func NotifyApp(deviceToken []string, title string) {
 	if len(deviceToken) == 0 {
 		return
@@ -101,6 +105,7 @@ func NotifyApp(deviceToken []string, title string) {
 	res, err := client.Do(req)
 	if err != nil {
 		logs.Error("client do fail, err:%s", err.Error())
+		appNotifications.Inc("failed")
 		return
 	}
 	defer res.Body.Close()
@@ -108,5 +113,8 @@ func NotifyApp(deviceToken []string, title string) {
 	logs.Debug("respCode:%d, respBody:%s", res.StatusCode, string(respBody))
 	if res.StatusCode < http.StatusOK || res.StatusCode >= http.StatusBadRequest {
 		logs.Error("respCode:%d, respBody:%s", res.StatusCode, string(respBody))
+		appNotifications.Inc("failed")
+		return
 	}
+	appNotifications.Inc("sent")
 }
@@ -18,6 +18,7 @@ import (
 	"github.com/ssrs100/blueserver/bluedb"
 	"github.com/ssrs100/blueserver/common"
 	"github.com/ssrs100/blueserver/influxdb"
+	"github.com/ssrs100/blueserver/metrics"
 	"github.com/ssrs100/blueserver/sesscache"
 	"io/ioutil"
 	"log"
@@ -25,6 +26,7 @@ import (
 	"runtime"
 	"strconv"
 	"strings"
+	"sync"
 	"time"
 )
 
@@ -50,10 +52,11 @@ type AwsIotClient struct {
 	reportChan chan *Shadow
 	awsClient  *Client
 	snsClient  *sns.SNS
-	user       *bluedb.User
+	project    *bluedb.Project
 
-	snsChan  chan *snsSend
-	lossChan chan *LossInfo
+	sns         *snsQueue
+	lossChan    chan *LossInfo
+	lossTracker *lossTracker
 }
 
 type thresh struct {
@@ -66,6 +69,9 @@ type thresh struct {
 const (
 	tempKey     = "temperature"
 	humidityKey = "humidity"
+
+	defaultSnsQueueSize = 200
+	defaultSnsWorkers   = 2
 )
 
 var msgTemplate = "[notice]device(%s) thing(%s) %s is %v, it's out of the range of device settings, please pay attention to it."
@@ -77,7 +83,24 @@ var (
 	useClientCache map[string]*AwsIotClient
 )
 
-var stopChan chan interface{}
+var (
+	stopChan = make(chan interface{})
+	stopOnce sync.Once
+	clientWg sync.WaitGroup
+)
+
+var (
+	mqttReceived = metrics.NewCounter("mqtt_messages_received_total",
+		"Number of reports received from the things by tenant.", "project")
+	mqttRejected = metrics.NewCounter("mqtt_messages_rejected_total",
+		"Number of reports not saved by tenant and reason.", "project", "reason")
+	seqLost = metrics.NewCounter("report_seq_lost_total",
+		"Number of report sequences found missing in the sessions by tenant.", "project")
+	seqBackfilled = metrics.NewCounter("report_seq_backfilled_total",
+		"Number of missing report sequences resent by the things by tenant.", "project")
+	alertOutcomes = metrics.NewCounter("alert_notifications_total",
+		"Number of alert notifications by kind (notice or clean) and outcome.", "project", "kind", "outcome")
+)
 
 var defaultThresh thresh
 
@@ -111,6 +134,42 @@ func listAllDir(path string) []string {
 	return dirs
 }
 
+// certProjectStore is the part of bluedb the cert dirs are resolved by, the
+// tests replace it.
+type certProjectStore interface {
+	QueryUserByName(name string) (*bluedb.User, error)
+	QueryProject(id string) (bluedb.Project, error)
+}
+
+type dbCertProjectStore struct{}
+
+func (dbCertProjectStore) QueryUserByName(name string) (*bluedb.User, error) {
+	return bluedb.QueryUserByName(name)
+}
+
+func (dbCertProjectStore) QueryProject(id string) (bluedb.Project, error) {
+	return bluedb.QueryProject(id)
+}
+
+var certProjects certProjectStore = dbCertProjectStore{}
+
+// queryCertProject returns the project of the cert dir, the dir is named by
+// the user owning the project, the project has the id of the user.
+func queryCertProject(dir string) (*bluedb.Project, error) {
+	user, err := certProjects.QueryUserByName(dir)
+	if err != nil {
+		return nil, err
+	}
+	if user == nil {
+		return nil, fmt.Errorf("user(%s) not found", dir)
+	}
+	project, err := certProjects.QueryProject(user.Id)
+	if err != nil {
+		return nil, err
+	}
+	return &project, nil
+}
+
 func InitAwsClient() {
 	baseDir := utils.GetBasePath()
 	certDir := filepath.Join(baseDir, "conf", "cert")
@@ -119,14 +178,24 @@ func InitAwsClient() {
 	ts := thingStatus{}
 	ts.Init(stopChan)
 
+	lossRetryMax := conf.GetIntWithDefault("loss_retry_max", defaultLossRetryMax)
+	lossRetryInterval := time.Duration(conf.GetIntWithDefault("loss_retry_interval", defaultLossRetryInterval)) * time.Second
+	snsQueueSize := conf.GetIntWithDefault("sns_queue_size", defaultSnsQueueSize)
+	snsWorkers := conf.GetIntWithDefault("sns_workers", defaultSnsWorkers)
+	skew = newClockSkew()
+	initDeviceRegistry()
+	initWebhooks()
+	initMqttBridges()
+	initEvents()
+
 	for _, u := range users {
-		user, err := bluedb.QueryUserByName(u)
+		project, err := queryCertProject(u)
 		if err != nil {
-			logs.Error("get user(%s) fail, err:%s", err.Error())
+			logs.Error("get project of user(%s) fail, err:%s", u, err.Error())
 			continue
 		}
-		if user == nil || len(user.AccessKey) == 0 || len(user.SecretKey) == 0 {
-			logs.Error("get user(%s) fail, or no ak sk set", u)
+		if len(project.AccessKey) == 0 || len(project.SecretKey) == 0 {
+			logs.Error("project of user(%s) has no ak sk set", u)
 			continue
 		}
 		c := conf.LoadFile(filepath.Join(certDir, u, "conf.json"))
@@ -150,22 +219,40 @@ func InitAwsClient() {
 
 		awsIC := AwsIotClient{}
 		awsIC.awsClient = client
-		awsIC.user = user
+		awsIC.project = project
 		awsIC.reportChan, err = awsIC.awsClient.SubscribeForThingReport()
 		if err != nil {
 			logs.Error("subscribe user(%s) thing report fail", u)
 			continue
 		}
-		awsIC.snsChan = make(chan *snsSend, 200)
+		awsIC.sns = newSnsQueue(snsWorkers, snsQueueSize)
 		awsIC.lossChan = make(chan *LossInfo, 200)
-		go awsIC.startAwsClient(user.Id, stopChan)
+		awsIC.lossTracker = newLossTracker(project.Id, lossRetryMax, lossRetryInterval)
+		clientWg.Add(1)
+		go func(projectId string) {
+			defer clientWg.Done()
+			awsIC.startAwsClient(projectId, stopChan)
+		}(project.Id)
 		awsIC.initSns()
-		go awsIC.sendSns()
-		go awsIC.pubLoss()
+		for i := range awsIC.sns.shards {
+			go awsIC.sns.run(i, awsIC.sendSns)
+		}
+		go awsIC.pubLoss(stopChan)
+		go awsIC.retryLoss(stopChan)
 		useClientCache[u] = &awsIC
 	}
 	logs.Info("start aws client success")
 	<-stopChan
+	clientWg.Wait()
+	logs.Info("aws clients stopped")
+}
+
+// Stop stops the aws clients, InitAwsClient returns once the reports in
+// process are handed to the influx writer.
+func Stop() {
+	stopOnce.Do(func() {
+		close(stopChan)
+	})
 }
 
 func (ac *AwsIotClient) publishEcho() {
@@ -190,6 +277,7 @@ func (ac *AwsIotClient) startAwsClient(projectId string, stop chan interface{})
 					go ac.publishEcho()
 					continue
 				}
+				mqttReceived.Inc(projectId)
 				logs.Debug("%s", string(s.Msg))
 
 				// set thing status
@@ -197,6 +285,7 @@ func (ac *AwsIotClient) startAwsClient(projectId string, stop chan interface{})
 				thing := s.Thing
 				if dbThing = bluedb.GetThingByName(thing); dbThing == nil {
 					logs.Info("thing(%s) not register, ignore", thing)
+					mqttRejected.Inc(projectId, "unregistered")
 					if _, ok := cleanCache.Get(thing); !ok {
 						go ac.stopThing(thing)
 					} else {
@@ -208,21 +297,32 @@ func (ac *AwsIotClient) startAwsClient(projectId string, stop chan interface{})
 				if strconv.Itoa(dbThing.Status) != OnLine {
 					dbThing.Status = 1
 					bluedb.UpdateThingStatus(*dbThing)
+					publishStatus(dbThing.ProjectId, thing, OnLine)
 				}
 
 				// save data
 				rdList := influxdb.ReportDataList{}
-				if err := json.Unmarshal(s.Msg, &rdList); err != nil {
-					logs.Error("err:%s, msg:%s", err.Error(), string(s.Msg))
+				encoding, err := influxdb.UnmarshalReport(s.Encoding, s.Msg, &rdList)
+				if err != nil {
+					logs.Error("err:%s, encoding:%s, msg:%q", err.Error(), encoding, s.Msg)
+					mqttRejected.Inc(projectId, "decode")
 					continue
 				}
-				if err := ac.processSession(thing, &rdList); err != nil {
+				backfill, err := ac.processSession(thing, &rdList)
+				if err != nil {
+					mqttRejected.Inc(projectId, "sequence")
 					continue
 				}
 				if len(rdList.Objects) == 0 {
+					if encoding != influxdb.EncodingJson {
+						logs.Info("thing(%s) %s report has no objects", thing, encoding)
+						mqttRejected.Inc(projectId, "empty")
+						continue
+					}
 					rd := influxdb.ReportData{}
 					if err := json.Unmarshal(s.Msg, &rd); err != nil {
 						logs.Error("err:%s, msg:%s", err.Error(), string(s.Msg))
+						mqttRejected.Inc(projectId, "decode")
 						continue
 					}
 					rd.Thing = dbThing.Name
@@ -236,9 +336,16 @@ func (ac *AwsIotClient) startAwsClient(projectId string, stop chan interface{})
 					}
 				}
 
+				skew.correct(thing, rds, s.ReceiveAt.UnixNano()/int64(time.Millisecond), backfill)
+
 				var sensorList, beaconList []*influxdb.RecordData
 				for _, r := range rds {
 					record := ac.transData(r)
+					if !registerDevice(r, record) {
+						logs.Debug("device(%s) is disabled, drop its reading", record.Device)
+						mqttRejected.Inc(projectId, "disabled")
+						continue
+					}
 					if r.DataType == common.DataTypeBroadcast {
 						beaconList = append(beaconList, record)
 					} else {
@@ -251,6 +358,8 @@ func (ac *AwsIotClient) startAwsClient(projectId string, stop chan interface{})
 				if err := influxdb.InsertBeaconData(influxdb.TableBroadcast, beaconList); err != nil {
 					logs.Error("%s", err.Error())
 				}
+				publishReadings(sensorList)
+				publishReadings(beaconList)
 				for _, r := range sensorList {
 					ac.processOneRdMessage(r)
 				}
@@ -258,52 +367,72 @@ func (ac *AwsIotClient) startAwsClient(projectId string, stop chan interface{})
 				//logs.Debug("insert influxdb success")
 			}
 		case <-stop:
-			close(ac.snsChan)
-			close(ac.lossChan)
+			// lossChan is left open, retryLoss may still be sending on it
+			ac.sns.close()
 			logs.Info("stopped")
+			return
 		}
 	}
 }
 
-func (ac *AwsIotClient) processSession(thing string, data *influxdb.ReportDataList) error {
+// processSession checks the report sequence of the session, it returns true
+// if the report is a resent one inside a known gap.
+func (ac *AwsIotClient) processSession(thing string, data *influxdb.ReportDataList) (bool, error) {
 	logs.Debug("process session:%s/%v", thing, *data)
 	if len(data.SessionId) <= 0 {
 		logs.Debug("thing(%s) no session id", thing)
-		return nil
+		return false, nil
 	}
 	lastSeqStr := sesscache.Get(common.SessionKey(thing, data.SessionId))
 	if len(lastSeqStr) <= 0 {
+		// a resend must not lower the highest seq of the session
+		if ac.lossTracker.fill(thing, data.SessionId, data.Seq) {
+			logs.Info("thing(%s) seq(%d) is backfilled", thing, data.Seq)
+			seqBackfilled.Inc(ac.project.Id)
+			sesscache.HIncrBy(common.CompletenessKey(thing), common.CompleteReceived, 1)
+			return true, nil
+		}
 		sesscache.SetWithExpired(common.SessionKey(thing, data.SessionId),
-			strconv.FormatInt(data.Seq, 10), 5*time.Minute)
-		return nil
+			strconv.FormatInt(data.Seq, 10), sessionSeqExpired)
+		sesscache.HIncrBy(common.CompletenessKey(thing), common.CompleteReceived, 1)
+		return false, nil
 	}
 	lastReq, err := strconv.ParseInt(lastSeqStr, 10, 64)
 	if err != nil {
 		logs.Error("last req(%s/%s) is invalid:%s", thing, data.SessionId, lastSeqStr)
 		sesscache.SetWithExpired(common.SessionKey(thing, data.SessionId),
-			strconv.FormatInt(data.Seq, 10), 5*time.Minute)
-		return nil
+			strconv.FormatInt(data.Seq, 10), sessionSeqExpired)
+		return false, nil
 	}
 	if data.Seq <= lastReq {
+		if ac.lossTracker.fill(thing, data.SessionId, data.Seq) {
+			logs.Info("thing(%s) seq(%d) is backfilled", thing, data.Seq)
+			seqBackfilled.Inc(ac.project.Id)
+			sesscache.TouchWithExpired(common.SessionKey(thing, data.SessionId), sessionSeqExpired)
+			sesscache.HIncrBy(common.CompletenessKey(thing), common.CompleteReceived, 1)
+			return true, nil
+		}
 		logs.Info("seq(%d) is less than or equal last req:%d, ignore it", data.Seq, lastReq)
-		sesscache.TouchWithExpired(common.SessionKey(thing, data.SessionId), 5*time.Minute)
-		return errors.New("req is less than last req")
+		sesscache.TouchWithExpired(common.SessionKey(thing, data.SessionId), sessionSeqExpired)
+		return false, errors.New("req is less than last req")
 	} else if data.Seq == lastReq+1 {
 		logs.Debug("thing(%s) match req", thing)
 	} else if data.Seq > lastReq+1 {
-		loss := LossInfo{
-			Thing:    thing,
-			Session:  data.SessionId,
-			StartSeq: lastReq + 1,
-			EndSeq:   data.Seq - 1,
+		seqLost.Add(float64(data.Seq-1-lastReq), ac.project.Id)
+		loss := ac.lossTracker.addGap(thing, data.SessionId, lastReq+1, data.Seq-1)
+		select {
+		case ac.lossChan <- loss:
+		default:
+			// the gap is tracked, its loss request is sent with the retries
+			logs.Warn("loss chan is full, retry later")
 		}
-		ac.lossChan <- &loss
 	} else {
 		logs.Error("unknown case, req:%d, lastReq:%d", data.Seq, lastReq)
 	}
 	sesscache.SetWithExpired(common.SessionKey(thing, data.SessionId),
-		strconv.FormatInt(data.Seq, 10), 5*time.Minute)
-	return nil
+		strconv.FormatInt(data.Seq, 10), sessionSeqExpired)
+	sesscache.HIncrBy(common.CompletenessKey(thing), common.CompleteReceived, 1)
+	return false, nil
 }
 
 func (ac *AwsIotClient) transData(data *influxdb.ReportData) *influxdb.RecordData {
@@ -315,21 +444,31 @@ func (ac *AwsIotClient) transData(data *influxdb.ReportData) *influxdb.RecordDat
 		DeviceName: data.DeviceName,
 		DataType:   data.DataType,
 		Data:       data.Data,
+		TimeSource: data.TimeSource,
 	}
 	if data.DataType == common.DataTypeSensor {
-		humFloat, err := strconv.ParseFloat(string(data.Humidity), 64)
-		if err != nil {
-			logs.Error("humi err: %v", err)
-		} else {
-			rd.Humidity = humFloat
+		rd.MissingHumidity = true
+		if len(data.Humidity) > 0 {
+			humFloat, err := strconv.ParseFloat(string(data.Humidity), 64)
+			if err != nil {
+				logs.Error("humi err: %v", err)
+			} else {
+				rd.Humidity = humFloat
+				rd.MissingHumidity = false
+			}
 		}
 
-		tempFloat, err := strconv.ParseFloat(string(data.Temperature), 64)
-		if err != nil {
-			logs.Error("temperature err: %v", err)
-		} else {
-			rd.Temperature = tempFloat
+		rd.MissingTemperature = true
+		if len(data.Temperature) > 0 {
+			tempFloat, err := strconv.ParseFloat(string(data.Temperature), 64)
+			if err != nil {
+				logs.Error("temperature err: %v", err)
+			} else {
+				rd.Temperature = tempFloat
+				rd.MissingTemperature = false
+			}
 		}
+		calibrate(&rd)
 	}
 
 	if len(data.Power) > 0 {
@@ -352,30 +491,33 @@ func (ac *AwsIotClient) transData(data *influxdb.ReportData) *influxdb.RecordDat
 
 func (ac *AwsIotClient) processOneRdMessage(rd *influxdb.RecordData) {
 	threshDevice := getThresh(rd, &defaultThresh)
-	if rd.Temperature >= threshDevice.maxTemp {
-		ac.snsChan <- &snsSend{key: tempKey, data: rd, upperLimit: true, isClean: false}
-		//go ac.sendSns(tempKey, rd, true, false)
-	} else if rd.Temperature < threshDevice.minTemp {
-		ac.snsChan <- &snsSend{key: tempKey, data: rd, upperLimit: false, isClean: false}
-		//go ac.sendSns(tempKey, rd, false, false)
-	} else {
-		ac.snsChan <- &snsSend{key: tempKey, data: rd, upperLimit: false, isClean: true}
-		//go ac.sendSns(tempKey, rd, false, true)
+	// without a reading the alert state is left as it is
+	if !rd.MissingTemperature {
+		if rd.Temperature >= threshDevice.maxTemp {
+			ac.dispatchSns(&snsSend{key: tempKey, data: rd, upperLimit: true, isClean: false})
+		} else if rd.Temperature < threshDevice.minTemp {
+			ac.dispatchSns(&snsSend{key: tempKey, data: rd, upperLimit: false, isClean: false})
+		} else {
+			ac.dispatchSns(&snsSend{key: tempKey, data: rd, upperLimit: false, isClean: true})
+		}
 	}
 
 	// humidity
-	if rd.Humidity >= threshDevice.maxHum {
-		ac.snsChan <- &snsSend{key: humidityKey, data: rd, upperLimit: true, isClean: false}
-		//go ac.sendSns(humidityKey, rd, true, false)
-	} else if rd.Humidity < threshDevice.minHum {
-		ac.snsChan <- &snsSend{key: humidityKey, data: rd, upperLimit: false, isClean: false}
-		//go ac.sendSns(humidityKey, rd, false, false)
-	} else {
-		ac.snsChan <- &snsSend{key: humidityKey, data: rd, upperLimit: false, isClean: true}
-		//go ac.sendSns(humidityKey, rd, false, true)
+	if !rd.MissingHumidity {
+		if rd.Humidity >= threshDevice.maxHum {
+			ac.dispatchSns(&snsSend{key: humidityKey, data: rd, upperLimit: true, isClean: false})
+		} else if rd.Humidity < threshDevice.minHum {
+			ac.dispatchSns(&snsSend{key: humidityKey, data: rd, upperLimit: false, isClean: false})
+		} else {
+			ac.dispatchSns(&snsSend{key: humidityKey, data: rd, upperLimit: false, isClean: true})
+		}
 	}
 }
 
+func (ac *AwsIotClient) dispatchSns(send *snsSend) {
+	ac.sns.dispatch(send)
+}
+
 func getThresh(data *influxdb.RecordData, defaultThresh *thresh) *thresh {
 
 	dt, _ := bluedb.QueryDevThresh(data.ProjectId, data.Device)
@@ -394,40 +536,35 @@ func getThresh(data *influxdb.RecordData, defaultThresh *thresh) *thresh {
 func (ac *AwsIotClient) initSns() {
 	sess := session.Must(session.NewSession())
 	creds := credentials.NewStaticCredentials(
-		ac.user.AccessKey,
-		ac.user.SecretKey,
+		ac.project.AccessKey,
+		ac.project.SecretKey,
 		"",
 	)
 	ac.snsClient = sns.New(sess, &aws.Config{Credentials: creds, Region: aws.String("us-west-2")})
 	if ac.snsClient == nil {
-		logs.Error("init sns user err:%s", ac.user.Name)
+		logs.Error("init sns of project(%s) err", ac.project.Id)
 	}
 }
 
-func (ac *AwsIotClient) sendSns() {
-	for {
-		send, opened := <-ac.snsChan
-		if !opened {
-			logs.Info("sns chan closed")
-			return
-		}
-		cause := "upper"
-		if !send.upperLimit {
-			cause = "lower"
-		}
-		if send.isClean {
-			ac.sendCleanMsg(send.key, send.data)
-		} else {
-			ac.sendNotifyMsg(cause, send.key, send.data)
-		}
+func (ac *AwsIotClient) sendSns(send *snsSend) {
+	cause := "upper"
+	if !send.upperLimit {
+		cause = "lower"
+	}
+	if send.isClean {
+		ac.sendCleanMsg(send.key, send.data)
+	} else {
+		ac.sendNotifyMsg(cause, send.key, send.data)
 	}
 }
 
-func (ac *AwsIotClient) pubLoss() {
+func (ac *AwsIotClient) pubLoss(stop chan interface{}) {
 	for {
-		loss, opened := <-ac.lossChan
-		if !opened {
-			logs.Info("loss chan closed")
+		var loss *LossInfo
+		select {
+		case loss = <-ac.lossChan:
+		case <-stop:
+			logs.Info("loss publish stopped")
 			return
 		}
 		logs.Info("pub loss data:(thing:%s,start:%d,end:%d)", loss.Thing, loss.StartSeq, loss.EndSeq)
@@ -490,6 +627,7 @@ func (ac *AwsIotClient) sendNotifyMsg(cause, key string, data *influxdb.RecordDa
 	_, err := ac.snsClient.PublishWithContext(ctx, params)
 	if err != nil {
 		logs.Error("publish err:%s", err.Error())
+		alertOutcomes.Inc(data.ProjectId, "notice", "failed")
 		aerr, ok := err.(awserr.RequestFailure)
 		if !ok {
 			logs.Error("expect awserr")
@@ -499,6 +637,8 @@ func (ac *AwsIotClient) sendNotifyMsg(cause, key string, data *influxdb.RecordDa
 		return
 	}
 	logs.Info("send(%s) notify to sns success", data.Device)
+	alertOutcomes.Inc(data.ProjectId, "notice", "sent")
+	publishAlert(key, cause, data, value)
 	n := bluedb.Notify{
 		ProjectId: data.ProjectId,
 		Device:    data.Device,
@@ -567,6 +707,7 @@ func (ac *AwsIotClient) sendCleanMsg(key string, data *influxdb.RecordData) {
 	_, err := ac.snsClient.PublishWithContext(ctx, params)
 	if err != nil {
 		logs.Error("publish err:%s", err.Error())
+		alertOutcomes.Inc(data.ProjectId, "clean", "failed")
 		aerr, ok := err.(awserr.RequestFailure)
 		if !ok {
 			logs.Error("expect awserr")
@@ -576,6 +717,8 @@ func (ac *AwsIotClient) sendCleanMsg(key string, data *influxdb.RecordData) {
 		return
 	}
 	logs.Info("send(%s) clean to sns success", data.Device)
+	alertOutcomes.Inc(data.ProjectId, "clean", "sent")
+	publishAlert(key, "", data, value)
 	sesscache.Del(upKey)
 	sesscache.Del(lwKey)
 	if err := bluedb.DeleteNotice(data.ProjectId, data.Device, key); err != nil {
@@ -0,0 +1,97 @@
+package awsmqtt
+
+import (
+	"errors"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/jack0liu/conf"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/sesscache"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// fakeCertProjects keeps the users by name and the projects by id.
+type fakeCertProjects struct {
+	users    map[string]*bluedb.User
+	projects map[string]bluedb.Project
+}
+
+func (f *fakeCertProjects) QueryUserByName(name string) (*bluedb.User, error) {
+	return f.users[name], nil
+}
+
+func (f *fakeCertProjects) QueryProject(id string) (bluedb.Project, error) {
+	p, ok := f.projects[id]
+	if !ok {
+		return p, errors.New("no row found")
+	}
+	return p, nil
+}
+
+func useCertProjects(s certProjectStore) func() {
+	old := certProjects
+	certProjects = s
+	return func() { certProjects = old }
+}
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	mr, err := miniredis.Run()
+	if err != nil {
+		fmt.Println("start redis fail, err:" + err.Error())
+		return 1
+	}
+	defer mr.Close()
+	dir, err := ioutil.TempDir("", "awsmqtt")
+	if err != nil {
+		fmt.Println("create temp dir fail, err:" + err.Error())
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	confFile := filepath.Join(dir, "awsiot.json")
+	body := fmt.Sprintf(`{"redis_addr": %q}`, mr.Addr())
+	if err := ioutil.WriteFile(confFile, []byte(body), 0600); err != nil {
+		fmt.Println("write conf fail, err:" + err.Error())
+		return 1
+	}
+	if err := conf.Init(confFile); err != nil {
+		fmt.Println("init conf fail, err:" + err.Error())
+		return 1
+	}
+	sesscache.InitRedis()
+	return m.Run()
+}
+
+func TestCertProjectKeys(t *testing.T) {
+	defer useCertProjects(&fakeCertProjects{
+		users: map[string]*bluedb.User{
+			// the user row keeps the keys bound before projects existed
+			"bind-user": {Id: "p-bind", Name: "bind-user", AccessKey: "user-ak", SecretKey: "user-sk"},
+			"lost-user": {Id: "p-lost", Name: "lost-user"},
+		},
+		projects: map[string]bluedb.Project{
+			"p-bind": {Id: "p-bind", Name: "bind-user", AccessKey: "project-ak", SecretKey: "project-sk"},
+		},
+	})()
+
+	p, err := queryCertProject("bind-user")
+	if err != nil {
+		t.Fatalf("query project fail, err:%s", err.Error())
+	}
+	if p.Id != "p-bind" || p.AccessKey != "project-ak" || p.SecretKey != "project-sk" {
+		t.Errorf("ingestion gets project %s keys %q/%q, want the bound keys", p.Id, p.AccessKey, p.SecretKey)
+	}
+
+	if _, err := queryCertProject("unknown-user"); err == nil {
+		t.Errorf("cert dir of an unknown user has a project")
+	}
+	if _, err := queryCertProject("lost-user"); err == nil {
+		t.Errorf("cert dir of a user without project has a project")
+	}
+}
@@ -10,6 +10,7 @@ import (
 	"time"
 
 	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/ssrs100/blueserver/influxdb"
 )
 
 // Thing a structure for working with the AWS IoT device shadows
@@ -29,8 +30,10 @@ type KeyPair struct {
 
 // Shadow device shadow data
 type Shadow struct {
-	Msg   []byte
-	Thing string
+	Msg       []byte
+	Thing     string
+	Encoding  string
+	ReceiveAt time.Time
 }
 
 // NewThing returns a new instance of Thing
@@ -156,23 +159,36 @@ func (t *Client) SubscribeForThingShadowChanges() (chan *Shadow, error) {
 	return shadowChan, token.Error()
 }
 
-// SubscribeForThingReport returns the channel with the shadow updates
+// SubscribeForThingReport returns the channel with the shadow updates, the
+// reports are json, or cbor/proto when published with the encoding suffix
 func (t *Client) SubscribeForThingReport() (chan *Shadow, error) {
 	shadowChan := make(chan *Shadow)
-	token := t.client.Subscribe(
-		fmt.Sprintf("$aws/things/+/reports"),
-		1,
-		func(client mqtt.Client, msg mqtt.Message) {
-			tpc := msg.Topic()
-			thing := tpc[len("$aws/things/") : len(tpc)-len("/reports")]
-			s := Shadow{
-				Msg:   msg.Payload(),
-				Thing: thing,
-			}
-			shadowChan <- &s
-		},
-	)
-	token.Wait()
+	suffixes := []string{
+		"/reports",
+		"/reports/" + influxdb.EncodingCbor,
+		"/reports/" + influxdb.EncodingProto,
+	}
+	for _, suffix := range suffixes {
+		suffix := suffix
+		token := t.client.Subscribe(
+			fmt.Sprintf("$aws/things/+%s", suffix),
+			1,
+			func(client mqtt.Client, msg mqtt.Message) {
+				tpc := msg.Topic()
+				thing := tpc[len("$aws/things/") : len(tpc)-len(suffix)]
+				s := Shadow{
+					Msg:       msg.Payload(),
+					Thing:     thing,
+					Encoding:  influxdb.EncodingFromTopic(tpc),
+					ReceiveAt: time.Now(),
+				}
+				shadowChan <- &s
+			},
+		)
+		if token.Wait() && token.Error() != nil {
+			return shadowChan, token.Error()
+		}
+	}
 
-	return shadowChan, token.Error()
+	return shadowChan, nil
 }
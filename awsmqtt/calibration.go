@@ -0,0 +1,44 @@
+package awsmqtt
+
+import (
+	"github.com/patrickmn/go-cache"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/influxdb"
+	"time"
+)
+
+// calibCache caches the device calibration for a minute, a device without
+// calibration is cached as nil.
+var calibCache = cache.New(time.Minute, 2*time.Minute)
+
+func getCalibration(projectId, device string) *bluedb.DeviceCalibration {
+	key := projectId + "_" + device
+	if c, ok := calibCache.Get(key); ok {
+		return c.(*bluedb.DeviceCalibration)
+	}
+	dc, err := bluedb.QueryDevCalibration(projectId, device)
+	if err != nil {
+		// do not cache, query it again with the next report
+		return nil
+	}
+	calibCache.Set(key, dc, cache.DefaultExpiration)
+	return dc
+}
+
+// calibrate applies the device calibration to the sensor readings, the raw
+// readings are kept in the record.
+func calibrate(rd *influxdb.RecordData) {
+	dc := getCalibration(rd.ProjectId, rd.Device)
+	if dc == nil {
+		return
+	}
+	rd.Calibrated = true
+	rd.RawTemperature = rd.Temperature
+	rd.RawHumidity = rd.Humidity
+	if !rd.MissingTemperature {
+		rd.Temperature = rd.Temperature*dc.TemperatureGain + dc.TemperatureOffset
+	}
+	if !rd.MissingHumidity {
+		rd.Humidity = rd.Humidity*dc.HumidityGain + dc.HumidityOffset
+	}
+}
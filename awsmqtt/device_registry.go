@@ -0,0 +1,78 @@
+package awsmqtt
+
+import (
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/patrickmn/go-cache"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/influxdb"
+	"time"
+)
+
+const defaultDeviceSeenInterval = 300
+
+// seenCache keeps the thing and status of the devices updated in the
+// registry recently, a device is written again when the cache expires or it
+// moves to another thing, so that the registry is not written with every
+// report. A device disabled meanwhile is dropped once its entry expires.
+var seenCache *cache.Cache
+
+type seenDevice struct {
+	thing  string
+	status string
+}
+
+// deviceRegistry is the part of bluedb the ingestion registers the devices
+// with, the tests replace it.
+type deviceRegistry interface {
+	UpsertDeviceSeen(seen bluedb.Device) (*bluedb.Device, error)
+}
+
+type dbDeviceRegistry struct{}
+
+func (dbDeviceRegistry) UpsertDeviceSeen(seen bluedb.Device) (*bluedb.Device, error) {
+	return bluedb.UpsertDeviceSeen(seen)
+}
+
+var registry deviceRegistry = dbDeviceRegistry{}
+
+func initDeviceRegistry() {
+	interval := time.Duration(conf.GetIntWithDefault("device_seen_interval", defaultDeviceSeenInterval)) * time.Second
+	seenCache = cache.New(interval, 2*interval)
+}
+
+// registerDevice upserts the reporting device into the registry, it returns
+// false if the device is disabled and its readings are to be dropped. The
+// readings are kept if the registry fails.
+func registerDevice(rd *influxdb.ReportData, record *influxdb.RecordData) bool {
+	if len(record.Device) == 0 {
+		return true
+	}
+	key := record.ProjectId + "_" + record.Device
+	if v, ok := seenCache.Get(key); ok {
+		if seen := v.(seenDevice); seen.thing == record.Thing {
+			return seen.status != bluedb.DeviceStatusDisabled
+		}
+	}
+	lastSeen := time.Unix(0, record.Timestamp*int64(time.Millisecond))
+	dev := bluedb.Device{
+		ProjectId:  record.ProjectId,
+		DeviceId:   record.Device,
+		Thing:      record.Thing,
+		DeviceName: record.DeviceName,
+		DataType:   record.DataType,
+		Firmware:   rd.Firmware,
+		Battery:    -1,
+		LastSeen:   &lastSeen,
+	}
+	if len(rd.Power) > 0 {
+		dev.Battery = record.Power
+	}
+	registered, err := registry.UpsertDeviceSeen(dev)
+	if err != nil {
+		logs.Error("register device(%s) fail, err:%s", record.Device, err.Error())
+		return true
+	}
+	seenCache.Set(key, seenDevice{thing: record.Thing, status: registered.Status}, cache.DefaultExpiration)
+	return registered.Status != bluedb.DeviceStatusDisabled
+}
@@ -0,0 +1,80 @@
+package awsmqtt
+
+import (
+	"errors"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/influxdb"
+	"testing"
+	"time"
+)
+
+// fakeRegistry keeps the status of the devices, a device not listed is
+// registered as active.
+type fakeRegistry struct {
+	status  map[string]string
+	upserts int
+	err     error
+}
+
+func (f *fakeRegistry) UpsertDeviceSeen(seen bluedb.Device) (*bluedb.Device, error) {
+	f.upserts++
+	if f.err != nil {
+		return nil, f.err
+	}
+	seen.Status = f.status[seen.DeviceId]
+	if len(seen.Status) == 0 {
+		seen.Status = bluedb.DeviceStatusActive
+	}
+	return &seen, nil
+}
+
+func useRegistry(r deviceRegistry) func() {
+	old, oldCache := registry, seenCache
+	registry = r
+	initDeviceRegistry()
+	return func() { registry, seenCache = old, oldCache }
+}
+
+func seenReading(device, thing string) (*influxdb.ReportData, *influxdb.RecordData) {
+	return &influxdb.ReportData{Device: device}, &influxdb.RecordData{
+		ProjectId: "p-registry",
+		Device:    device,
+		Thing:     thing,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+	}
+}
+
+func TestRegisterDisabledDevice(t *testing.T) {
+	reg := &fakeRegistry{status: map[string]string{"dev-off": bluedb.DeviceStatusDisabled}}
+	defer useRegistry(reg)()
+
+	if !registerDevice(seenReading("dev-on", "thing-1")) {
+		t.Errorf("reading of an active device is dropped")
+	}
+	if !registerDevice(seenReading("dev-new", "thing-1")) {
+		t.Errorf("reading of a new device is dropped")
+	}
+	for i := 0; i < 3; i++ {
+		if registerDevice(seenReading("dev-off", "thing-1")) {
+			t.Errorf("reading %d of a disabled device is kept", i)
+		}
+	}
+	if reg.upserts != 3 {
+		t.Errorf("registry is written %d times, want once a device", reg.upserts)
+	}
+	// a move to another thing is written and the device stays disabled
+	if registerDevice(seenReading("dev-off", "thing-2")) {
+		t.Errorf("reading of a moved disabled device is kept")
+	}
+	if reg.upserts != 4 {
+		t.Errorf("move of a device is not written")
+	}
+}
+
+func TestRegisterDeviceFail(t *testing.T) {
+	defer useRegistry(&fakeRegistry{err: errors.New("db is down")})()
+	// the status is unknown, the reading is kept
+	if !registerDevice(seenReading("dev-1", "thing-1")) {
+		t.Errorf("reading is dropped when the registry fails")
+	}
+}
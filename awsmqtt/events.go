@@ -0,0 +1,121 @@
+package awsmqtt
+
+import (
+	"encoding/json"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/influxdb"
+	"github.com/ssrs100/blueserver/metrics"
+	"github.com/ssrs100/blueserver/sesscache"
+	"time"
+)
+
+const defaultEventQueueSize = 4096
+
+var (
+	eventQueue    chan *common.Event
+	eventsDropped = metrics.NewCounter("events_dropped_total",
+		"Number of stream events dropped as the publish queue is full by tenant.", "project")
+)
+
+func initEvents() {
+	eventQueue = make(chan *common.Event, conf.GetIntWithDefault("event_queue_size", defaultEventQueueSize))
+	go publishLoop()
+}
+
+// publishLoop publishes the queued events to redis, the reports are not
+// held up by redis.
+func publishLoop() {
+	for ev := range eventQueue {
+		body, err := json.Marshal(ev)
+		if err != nil {
+			logs.Error("marshal event err:%s", err.Error())
+			continue
+		}
+		if err := sesscache.Publish(common.EventChannel(ev.ProjectId), string(body)); err != nil {
+			logs.Error("publish %s event err:%s", ev.Type, err.Error())
+		}
+	}
+}
+
+// publishEvent fans the event out to the blueserver through redis pub/sub,
+// an event is dropped if the queue is full or redis is not reachable.
+func publishEvent(ev *common.Event) {
+	select {
+	case eventQueue <- ev:
+	default:
+		eventsDropped.Inc(ev.ProjectId)
+	}
+	// readings are pushed to webhooks as records
+	if webhooks != nil && ev.Type != common.EventReading {
+		webhooks.addEvent(ev)
+	}
+}
+
+func readingEvent(rd *influxdb.RecordData) *common.Event {
+	data := map[string]interface{}{
+		"rssi":        rd.Rssi,
+		"power":       rd.Power,
+		"device_name": rd.DeviceName,
+		"time_source": rd.TimeSource,
+	}
+	if rd.DataType == common.DataTypeBroadcast {
+		data["data"] = rd.Data
+	} else {
+		if !rd.MissingTemperature {
+			data[tempKey] = rd.Temperature
+		}
+		if !rd.MissingHumidity {
+			data[humidityKey] = rd.Humidity
+		}
+	}
+	return &common.Event{
+		Type:      common.EventReading,
+		ProjectId: rd.ProjectId,
+		Thing:     rd.Thing,
+		Device:    rd.Device,
+		Timestamp: rd.Timestamp,
+		Data:      data,
+	}
+}
+
+func publishReadings(records []*influxdb.RecordData) {
+	for _, rd := range records {
+		publishEvent(readingEvent(rd))
+	}
+	if webhooks != nil {
+		webhooks.addRecords(records)
+	}
+	bridges.forward(records)
+}
+
+func publishStatus(projectId, thing, status string) {
+	publishEvent(&common.Event{
+		Type:      common.EventStatus,
+		ProjectId: projectId,
+		Thing:     thing,
+		Timestamp: time.Now().UnixNano() / int64(time.Millisecond),
+		Data: map[string]interface{}{
+			"online": status == OnLine,
+		},
+	})
+}
+
+// publishAlert publishes a threshold alert of the metric, cause is upper or
+// lower, or empty when the alert is cleared.
+func publishAlert(metric, cause string, rd *influxdb.RecordData, value float64) {
+	publishEvent(&common.Event{
+		Type:      common.EventAlert,
+		ProjectId: rd.ProjectId,
+		Thing:     rd.Thing,
+		Device:    rd.Device,
+		Metric:    metric,
+		Timestamp: rd.Timestamp,
+		Data: map[string]interface{}{
+			"cause":   cause,
+			"cleared": len(cause) == 0,
+			"value":   value,
+		},
+	})
+}
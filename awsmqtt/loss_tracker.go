@@ -0,0 +1,282 @@
+package awsmqtt
+
+import (
+	"encoding/json"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/sesscache"
+	"sort"
+	"sync"
+	"time"
+)
+
+const (
+	defaultLossRetryMax      = 3
+	defaultLossRetryInterval = 60
+	minLossRetryInterval     = 5 * time.Second
+	sessionGapExpired        = 30 * time.Minute
+
+	// sessionSeqExpired is how long the highest seq of a session is kept, it
+	// outlives the gaps so that a late resend of an idle session is never
+	// taken as the start of the session.
+	sessionSeqExpired = 24 * time.Hour
+)
+
+// seqGap is an outstanding range of sequences the gateway was asked to resend.
+type seqGap struct {
+	start   int64
+	end     int64
+	retries int
+	sentAt  time.Time
+}
+
+type sessionGaps struct {
+	thing    string
+	session  string
+	gaps     []*seqGap
+	updateAt time.Time
+}
+
+// savedGaps is the gaps of a session as saved in redis.
+type savedGaps struct {
+	Thing    string     `json:"thing"`
+	Session  string     `json:"session"`
+	Gaps     [][3]int64 `json:"gaps"` // start, end, retries
+	UpdateAt int64      `json:"update_at"`
+}
+
+// lossTracker keeps the outstanding gaps of every thing session, so that
+// resent sequences can be accepted and loss requests can be retried. The
+// gaps are saved in redis, a restarted service picks them up again.
+type lossTracker struct {
+	sync.Mutex
+	sessions map[string]*sessionGaps
+	savedKey string
+
+	retryMax      int
+	retryInterval time.Duration
+}
+
+func newLossTracker(projectId string, retryMax int, retryInterval time.Duration) *lossTracker {
+	if retryInterval < minLossRetryInterval {
+		logs.Warn("loss retry interval %s is too small, use %s", retryInterval, minLossRetryInterval)
+		retryInterval = minLossRetryInterval
+	}
+	lt := &lossTracker{
+		sessions:      make(map[string]*sessionGaps),
+		savedKey:      common.LossGapsKey(projectId),
+		retryMax:      retryMax,
+		retryInterval: retryInterval,
+	}
+	lt.load()
+	return lt
+}
+
+// load reads the gaps saved before a restart, their loss requests are sent
+// again with the next retry.
+func (lt *lossTracker) load() {
+	for key, val := range sesscache.HGetAll(lt.savedKey) {
+		saved := savedGaps{}
+		if err := json.Unmarshal([]byte(val), &saved); err != nil {
+			logs.Error("invalid saved gaps of %s, err:%s", key, err.Error())
+			sesscache.HDel(lt.savedKey, key)
+			continue
+		}
+		sg := &sessionGaps{
+			thing:    saved.Thing,
+			session:  saved.Session,
+			updateAt: time.Unix(saved.UpdateAt, 0),
+		}
+		for _, g := range saved.Gaps {
+			sg.gaps = append(sg.gaps, &seqGap{start: g[0], end: g[1], retries: int(g[2])})
+		}
+		lt.sessions[key] = sg
+	}
+	if len(lt.sessions) > 0 {
+		logs.Info("load gaps of %d sessions", len(lt.sessions))
+	}
+}
+
+// save writes the gaps of the session to redis, it is called with the lock
+// held.
+func (lt *lossTracker) save(key string, sg *sessionGaps) {
+	if len(sg.gaps) == 0 {
+		sesscache.HDel(lt.savedKey, key)
+		return
+	}
+	saved := savedGaps{
+		Thing:    sg.thing,
+		Session:  sg.session,
+		UpdateAt: sg.updateAt.Unix(),
+	}
+	for _, g := range sg.gaps {
+		saved.Gaps = append(saved.Gaps, [3]int64{g.start, g.end, int64(g.retries)})
+	}
+	val, err := json.Marshal(saved)
+	if err != nil {
+		logs.Error("marshal gaps of %s fail, err:%s", key, err.Error())
+		return
+	}
+	sesscache.HSet(lt.savedKey, key, string(val))
+}
+
+// addGap records a new gap [start, end] and returns the loss request for it.
+// The gaps it overlaps or adjoins are merged into it, the merged gap is
+// requested anew.
+func (lt *lossTracker) addGap(thing, session string, start, end int64) *LossInfo {
+	lt.Lock()
+	defer lt.Unlock()
+	key := common.SessionKey(thing, session)
+	sg, ok := lt.sessions[key]
+	if !ok {
+		sg = &sessionGaps{thing: thing, session: session}
+		lt.sessions[key] = sg
+	}
+	now := time.Now()
+	merged := &seqGap{start: start, end: end, sentAt: now}
+	// only the sequences outside the known gaps are newly missing
+	missing := end - start + 1
+	remain := make([]*seqGap, 0, len(sg.gaps)+1)
+	for _, g := range sg.gaps {
+		if g.end < start-1 || g.start > end+1 {
+			remain = append(remain, g)
+			continue
+		}
+		if overlap := minSeq(g.end, end) - maxSeq(g.start, start) + 1; overlap > 0 {
+			missing -= overlap
+		}
+		merged.start = minSeq(merged.start, g.start)
+		merged.end = maxSeq(merged.end, g.end)
+	}
+	sg.gaps = append(remain, merged)
+	sort.Slice(sg.gaps, func(i, j int) bool { return sg.gaps[i].start < sg.gaps[j].start })
+	sg.updateAt = now
+	lt.save(key, sg)
+	if missing > 0 {
+		sesscache.HIncrBy(common.CompletenessKey(thing), common.CompleteMissing, missing)
+	}
+	return &LossInfo{
+		Thing:    thing,
+		Session:  session,
+		StartSeq: merged.start,
+		EndSeq:   merged.end,
+	}
+}
+
+func minSeq(a, b int64) int64 {
+	if a < b {
+		return a
+	}
+	return b
+}
+
+func maxSeq(a, b int64) int64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// fill removes seq from the outstanding gaps of the session, it returns false
+// if seq is not inside any known gap.
+func (lt *lossTracker) fill(thing, session string, seq int64) bool {
+	lt.Lock()
+	defer lt.Unlock()
+	key := common.SessionKey(thing, session)
+	sg, ok := lt.sessions[key]
+	if !ok {
+		return false
+	}
+	for i, g := range sg.gaps {
+		if seq < g.start || seq > g.end {
+			continue
+		}
+		switch {
+		case g.start == g.end:
+			sg.gaps = append(sg.gaps[:i], sg.gaps[i+1:]...)
+		case seq == g.start:
+			g.start++
+		case seq == g.end:
+			g.end--
+		default:
+			tail := &seqGap{start: seq + 1, end: g.end, retries: g.retries, sentAt: g.sentAt}
+			g.end = seq - 1
+			sg.gaps = append(sg.gaps[:i+1], append([]*seqGap{tail}, sg.gaps[i+1:]...)...)
+		}
+		sg.updateAt = time.Now()
+		if len(sg.gaps) == 0 {
+			delete(lt.sessions, key)
+		}
+		lt.save(key, sg)
+		sesscache.HIncrBy(common.CompletenessKey(thing), common.CompleteBackfilled, 1)
+		return true
+	}
+	return false
+}
+
+// due returns the loss requests which should be sent again. Gaps that have
+// used up their retries, or whose session is idle too long, are given up and
+// counted as lost.
+func (lt *lossTracker) due(now time.Time) []*LossInfo {
+	lt.Lock()
+	defer lt.Unlock()
+	losses := make([]*LossInfo, 0)
+	for key, sg := range lt.sessions {
+		expired := now.Sub(sg.updateAt) > sessionGapExpired
+		remain := make([]*seqGap, 0, len(sg.gaps))
+		retried := false
+		for _, g := range sg.gaps {
+			if expired || g.retries >= lt.retryMax {
+				logs.Info("give up loss(thing:%s,sess:%s,start:%d,end:%d)", sg.thing, sg.session, g.start, g.end)
+				sesscache.HIncrBy(common.CompletenessKey(sg.thing), common.CompleteLost, g.end-g.start+1)
+				continue
+			}
+			remain = append(remain, g)
+			if now.Sub(g.sentAt) < lt.retryInterval {
+				continue
+			}
+			g.retries++
+			g.sentAt = now
+			retried = true
+			losses = append(losses, &LossInfo{
+				Thing:    sg.thing,
+				Session:  sg.session,
+				StartSeq: g.start,
+				EndSeq:   g.end,
+			})
+		}
+		if !retried && len(remain) == len(sg.gaps) {
+			continue
+		}
+		sg.gaps = remain
+		if len(remain) == 0 {
+			delete(lt.sessions, key)
+		}
+		lt.save(key, sg)
+	}
+	return losses
+}
+
+func (ac *AwsIotClient) retryLoss(stop chan interface{}) {
+	ticker := time.NewTicker(ac.lossTracker.retryInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case now := <-ticker.C:
+			for _, loss := range ac.lossTracker.due(now) {
+				logs.Info("retry loss data:(thing:%s,start:%d,end:%d)", loss.Thing, loss.StartSeq, loss.EndSeq)
+				select {
+				case ac.lossChan <- loss:
+				case <-stop:
+					logs.Info("loss retry stopped")
+					return
+				default:
+					logs.Warn("loss chan is full, retry later")
+				}
+			}
+		case <-stop:
+			logs.Info("loss retry stopped")
+			return
+		}
+	}
+}
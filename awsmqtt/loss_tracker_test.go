@@ -0,0 +1,216 @@
+package awsmqtt
+
+import (
+	"fmt"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/sesscache"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+// gapsOf lists the outstanding gaps of the session as start, end pairs.
+func gapsOf(lt *lossTracker, thing, session string) [][2]int64 {
+	lt.Lock()
+	defer lt.Unlock()
+	var gaps [][2]int64
+	if sg, ok := lt.sessions[common.SessionKey(thing, session)]; ok {
+		for _, g := range sg.gaps {
+			gaps = append(gaps, [2]int64{g.start, g.end})
+		}
+	}
+	return gaps
+}
+
+func completeness(thing, field string) int64 {
+	n, _ := strconv.ParseInt(sesscache.HGetAll(common.CompletenessKey(thing))[field], 10, 64)
+	return n
+}
+
+func TestLossGaps(t *testing.T) {
+	for i, c := range []struct {
+		add     [][2]int64
+		fill    []int64
+		request [2]int64 // loss request of the last added gap
+		gaps    [][2]int64
+		missing int64
+	}{
+		{
+			add:     [][2]int64{{5, 9}},
+			request: [2]int64{5, 9},
+			gaps:    [][2]int64{{5, 9}},
+			missing: 5,
+		},
+		{
+			add:     [][2]int64{{5, 9}, {20, 22}},
+			request: [2]int64{20, 22},
+			gaps:    [][2]int64{{5, 9}, {20, 22}},
+			missing: 8,
+		},
+		{
+			// overlapping gaps are merged, the overlap is missing once
+			add:     [][2]int64{{5, 9}, {8, 12}},
+			request: [2]int64{5, 12},
+			gaps:    [][2]int64{{5, 12}},
+			missing: 8,
+		},
+		{
+			// adjacent gaps are merged
+			add:     [][2]int64{{5, 9}, {10, 12}},
+			request: [2]int64{5, 12},
+			gaps:    [][2]int64{{5, 12}},
+			missing: 8,
+		},
+		{
+			// a gap bridging two gaps merges all of them
+			add:     [][2]int64{{20, 22}, {5, 9}, {8, 21}},
+			request: [2]int64{5, 22},
+			gaps:    [][2]int64{{5, 22}},
+			missing: 18,
+		},
+		{
+			// a gap inside a known one adds nothing
+			add:     [][2]int64{{5, 9}, {6, 7}},
+			request: [2]int64{5, 9},
+			gaps:    [][2]int64{{5, 9}},
+			missing: 5,
+		},
+		{
+			// a partial backfill splits the gap
+			add:     [][2]int64{{5, 9}},
+			fill:    []int64{7},
+			request: [2]int64{5, 9},
+			gaps:    [][2]int64{{5, 6}, {8, 9}},
+			missing: 5,
+		},
+		{
+			add:     [][2]int64{{5, 9}},
+			fill:    []int64{5, 9},
+			request: [2]int64{5, 9},
+			gaps:    [][2]int64{{6, 8}},
+			missing: 5,
+		},
+		{
+			add:     [][2]int64{{5, 6}},
+			fill:    []int64{5, 6},
+			request: [2]int64{5, 6},
+			missing: 2,
+		},
+		{
+			// a split gap merges again with a new overlapping gap
+			add:     [][2]int64{{5, 9}, {9, 10}},
+			fill:    []int64{7},
+			request: [2]int64{5, 10},
+			gaps:    [][2]int64{{5, 6}, {8, 10}},
+			missing: 6,
+		},
+	} {
+		thing := fmt.Sprintf("loss-gaps-%d", i)
+		lt := newLossTracker(thing, defaultLossRetryMax, time.Minute)
+		var loss *LossInfo
+		for _, g := range c.add {
+			loss = lt.addGap(thing, "s1", g[0], g[1])
+		}
+		if got := [2]int64{loss.StartSeq, loss.EndSeq}; got != c.request {
+			t.Errorf("case %d: loss request %v, want %v", i, got, c.request)
+		}
+		for _, seq := range c.fill {
+			if !lt.fill(thing, "s1", seq) {
+				t.Errorf("case %d: seq %d is not in a gap", i, seq)
+			}
+		}
+		if got := gapsOf(lt, thing, "s1"); !reflect.DeepEqual(got, c.gaps) {
+			t.Errorf("case %d: gaps %v, want %v", i, got, c.gaps)
+		}
+		if got := completeness(thing, common.CompleteMissing); got != c.missing {
+			t.Errorf("case %d: %d missing, want %d", i, got, c.missing)
+		}
+		if got := completeness(thing, common.CompleteBackfilled); got != int64(len(c.fill)) {
+			t.Errorf("case %d: %d backfilled, want %d", i, got, len(c.fill))
+		}
+		// the gaps survive a restart
+		if got := gapsOf(newLossTracker(thing, defaultLossRetryMax, time.Minute), thing, "s1"); !reflect.DeepEqual(got, c.gaps) {
+			t.Errorf("case %d: loaded gaps %v, want %v", i, got, c.gaps)
+		}
+	}
+}
+
+func TestLossFillUnknown(t *testing.T) {
+	lt := newLossTracker("loss-unknown", defaultLossRetryMax, time.Minute)
+	lt.addGap("loss-unknown", "s1", 5, 9)
+	for _, c := range []struct {
+		session string
+		seq     int64
+	}{{"s1", 4}, {"s1", 10}, {"s2", 7}} {
+		if lt.fill("loss-unknown", c.session, c.seq) {
+			t.Errorf("seq %d of session %s outside the gaps is filled", c.seq, c.session)
+		}
+	}
+	lt.fill("loss-unknown", "s1", 7)
+	if lt.fill("loss-unknown", "s1", 7) {
+		t.Errorf("seq is filled twice")
+	}
+}
+
+// dueRanges lists the start, end pairs of the loss requests due at now.
+func dueRanges(lt *lossTracker, now time.Time) [][2]int64 {
+	var ranges [][2]int64
+	for _, loss := range lt.due(now) {
+		ranges = append(ranges, [2]int64{loss.StartSeq, loss.EndSeq})
+	}
+	return ranges
+}
+
+func TestLossRetries(t *testing.T) {
+	const thing = "loss-retry"
+	interval := time.Minute
+	lt := newLossTracker(thing, 2, interval)
+	lt.addGap(thing, "s1", 5, 9)
+	lt.addGap(thing, "s1", 20, 22)
+	now := time.Now()
+
+	if due := dueRanges(lt, now); len(due) != 0 {
+		t.Fatalf("losses %v are retried before the interval", due)
+	}
+	now = now.Add(interval)
+	if due := dueRanges(lt, now); !reflect.DeepEqual(due, [][2]int64{{5, 9}, {20, 22}}) {
+		t.Fatalf("first retry sends %v", due)
+	}
+	// the parts of a split gap keep its retries
+	lt.fill(thing, "s1", 21)
+	now = now.Add(interval)
+	if due := dueRanges(lt, now); !reflect.DeepEqual(due, [][2]int64{{5, 9}, {20, 20}, {22, 22}}) {
+		t.Fatalf("second retry sends %v", due)
+	}
+	if got := completeness(thing, common.CompleteLost); got != 0 {
+		t.Errorf("%d lost before the retries are used up", got)
+	}
+
+	// the retries are used up, the gaps are given up
+	now = now.Add(interval)
+	if due := dueRanges(lt, now); len(due) != 0 {
+		t.Errorf("losses %v are retried past the limit", due)
+	}
+	if gaps := gapsOf(lt, thing, "s1"); len(gaps) != 0 {
+		t.Errorf("gaps %v are kept past the limit", gaps)
+	}
+	if got := completeness(thing, common.CompleteLost); got != 7 {
+		t.Errorf("%d lost, want 7", got)
+	}
+	if got := len(sesscache.HGetAll(common.LossGapsKey(thing))); got != 0 {
+		t.Errorf("%d sessions of given up gaps are saved", got)
+	}
+}
+
+func TestLossIdleSession(t *testing.T) {
+	const thing = "loss-idle"
+	lt := newLossTracker(thing, defaultLossRetryMax, time.Minute)
+	lt.addGap(thing, "s1", 5, 9)
+	if due := lt.due(time.Now().Add(sessionGapExpired + time.Minute)); len(due) != 0 {
+		t.Errorf("%d losses of an idle session are retried", len(due))
+	}
+	if got := completeness(thing, common.CompleteLost); got != 5 {
+		t.Errorf("%d lost of an idle session, want 5", got)
+	}
+}
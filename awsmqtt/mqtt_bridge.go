@@ -0,0 +1,335 @@
+package awsmqtt
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/influxdb"
+	"net"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBridgeBuffer   = 10000
+	defaultBridgeReload   = 60
+	bridgeConnectTimeout  = 10 * time.Second
+	bridgePublishTimeout  = 10 * time.Second
+	bridgeRetryInterval   = time.Second
+	bridgeMaxRetryBackoff = time.Minute
+	bridgeClientIdPrefix  = "blueserver-bridge-"
+)
+
+// bridge publishes the readings of a project to the broker of the customer,
+// the readings are buffered while the broker is unreachable and the oldest
+// are dropped when the buffer is full.
+type bridge struct {
+	cfg    bluedb.MqttBridge
+	client mqtt.Client
+	queue  chan *influxdb.RecordData
+	stop   chan struct{}
+	done   chan struct{}
+}
+
+func bridgeTLSConfig(cfg *bluedb.MqttBridge) (*tls.Config, error) {
+	tlsConfig := &tls.Config{
+		InsecureSkipVerify: cfg.InsecureSkipVerify,
+	}
+	if len(cfg.CaCert) > 0 {
+		certs := x509.NewCertPool()
+		if !certs.AppendCertsFromPEM([]byte(cfg.CaCert)) {
+			return nil, errors.New("invalid ca cert")
+		}
+		tlsConfig.RootCAs = certs
+	}
+	if len(cfg.ClientCert) > 0 {
+		cert, err := tls.X509KeyPair([]byte(cfg.ClientCert), []byte(cfg.ClientKey))
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.Certificates = []tls.Certificate{cert}
+	}
+	return tlsConfig, nil
+}
+
+// newBridge creates the bridge on the queue, the queue of the replaced
+// bridge is reused so that the buffered readings are kept.
+func newBridge(cfg bluedb.MqttBridge, queue chan *influxdb.RecordData) (*bridge, error) {
+	broker, serverName, err := pinBroker(cfg.BrokerUrl)
+	if err != nil {
+		return nil, err
+	}
+	opts := mqtt.NewClientOptions()
+	opts.AddBroker(broker)
+	clientId := cfg.ClientId
+	if len(clientId) == 0 {
+		clientId = bridgeClientIdPrefix + cfg.ProjectId
+	}
+	opts.SetClientID(clientId)
+	if len(cfg.Username) > 0 {
+		opts.SetUsername(cfg.Username)
+		opts.SetPassword(cfg.Password)
+	}
+	if strings.HasPrefix(cfg.BrokerUrl, "ssl://") || strings.HasPrefix(cfg.BrokerUrl, "tls://") ||
+		strings.HasPrefix(cfg.BrokerUrl, "wss://") {
+		tlsConfig, err := bridgeTLSConfig(&cfg)
+		if err != nil {
+			return nil, err
+		}
+		tlsConfig.ServerName = serverName
+		opts.SetTLSConfig(tlsConfig)
+	}
+	opts.SetAutoReconnect(true)
+	opts.SetMaxReconnectInterval(bridgeMaxRetryBackoff)
+	opts.SetConnectTimeout(bridgeConnectTimeout)
+	opts.SetConnectionLostHandler(func(c mqtt.Client, err error) {
+		logs.Warn("mqtt bridge of project(%s) lost, err:%s", cfg.ProjectId, err.Error())
+	})
+	return bridgeOn(cfg, mqtt.NewClient(opts), queue), nil
+}
+
+func bridgeOn(cfg bluedb.MqttBridge, client mqtt.Client, queue chan *influxdb.RecordData) *bridge {
+	if queue == nil {
+		queue = make(chan *influxdb.RecordData, conf.GetIntWithDefault("mqtt_bridge_buffer", defaultBridgeBuffer))
+	}
+	return &bridge{
+		cfg:    cfg,
+		client: client,
+		queue:  queue,
+		stop:   make(chan struct{}),
+		done:   make(chan struct{}),
+	}
+}
+
+// pinBroker resolves the host of the broker url to a public ip and returns
+// the url on the ip, so that the broker can not be an address of the network
+// of the server. The mqtt client has no hook of its dialer, the websocket
+// urls keep the host for the http handshake, their ips are only checked.
+func pinBroker(raw string) (string, string, error) {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return "", "", err
+	}
+	host := u.Hostname()
+	ip, err := common.ResolvePublicIp(host)
+	if err != nil {
+		return "", "", err
+	}
+	if u.Scheme == "ws" || u.Scheme == "wss" {
+		return raw, host, nil
+	}
+	if port := u.Port(); len(port) > 0 {
+		u.Host = net.JoinHostPort(ip.String(), port)
+	} else if ip.To4() == nil {
+		u.Host = "[" + ip.String() + "]"
+	} else {
+		u.Host = ip.String()
+	}
+	return u.String(), host, nil
+}
+
+func (b *bridge) topic(rd *influxdb.RecordData) string {
+	return strings.NewReplacer(
+		"{project_id}", rd.ProjectId,
+		"{thing}", rd.Thing,
+		"{device}", rd.Device,
+		"{device_name}", rd.DeviceName,
+		"{data_type}", rd.DataType,
+	).Replace(b.cfg.TopicTemplate)
+}
+
+func (b *bridge) forward(rd *influxdb.RecordData) {
+	for {
+		select {
+		case b.queue <- rd:
+			return
+		default:
+		}
+		// drop the oldest reading to buffer the new one
+		select {
+		case <-b.queue:
+			logs.Warn("mqtt bridge buffer of project(%s) is full, drop the oldest reading", b.cfg.ProjectId)
+		default:
+		}
+	}
+}
+
+// wait returns false if the bridge is closed during the wait.
+func (b *bridge) wait(d time.Duration) bool {
+	select {
+	case <-b.stop:
+		return false
+	case <-time.After(d):
+		return true
+	}
+}
+
+// connect connects the broker with backoff until it succeeds, later the
+// client reconnects by itself.
+func (b *bridge) connect() bool {
+	backoff := bridgeRetryInterval
+	for {
+		token := b.client.Connect()
+		if token.WaitTimeout(bridgeConnectTimeout) && token.Error() == nil {
+			logs.Info("mqtt bridge of project(%s) connected to %s", b.cfg.ProjectId, b.cfg.BrokerUrl)
+			return true
+		}
+		if token.Error() != nil {
+			logs.Warn("mqtt bridge of project(%s) connect fail, err:%s", b.cfg.ProjectId, token.Error().Error())
+		}
+		if !b.wait(backoff) {
+			return false
+		}
+		if backoff *= 2; backoff > bridgeMaxRetryBackoff {
+			backoff = bridgeMaxRetryBackoff
+		}
+	}
+}
+
+func (b *bridge) publish(rd *influxdb.RecordData) bool {
+	if !b.client.IsConnectionOpen() {
+		return false
+	}
+	payload, err := json.Marshal(rd)
+	if err != nil {
+		logs.Error("marshal reading err:%s", err.Error())
+		return true
+	}
+	token := b.client.Publish(b.topic(rd), byte(b.cfg.Qos), false, payload)
+	if !token.WaitTimeout(bridgePublishTimeout) {
+		return false
+	}
+	if token.Error() != nil {
+		logs.Warn("mqtt bridge of project(%s) publish fail, err:%s", b.cfg.ProjectId, token.Error().Error())
+		return false
+	}
+	return true
+}
+
+func (b *bridge) run() {
+	defer close(b.done)
+	if !b.connect() {
+		return
+	}
+	defer b.client.Disconnect(250)
+	for {
+		select {
+		case <-b.stop:
+			return
+		case rd := <-b.queue:
+			for !b.publish(rd) {
+				if !b.wait(bridgeRetryInterval) {
+					// keep the reading for the bridge replacing this one
+					b.forward(rd)
+					return
+				}
+			}
+		}
+	}
+}
+
+func (b *bridge) close() {
+	close(b.stop)
+	<-b.done
+}
+
+// bridgeManager keeps a bridge for every project with an enabled bridge
+// config, the configs are reloaded periodically.
+type bridgeManager struct {
+	sync.RWMutex
+	bridges map[string]*bridge
+	create  func(cfg bluedb.MqttBridge, queue chan *influxdb.RecordData) (*bridge, error)
+}
+
+var bridges = &bridgeManager{
+	bridges: make(map[string]*bridge),
+	create:  newBridge,
+}
+
+func initMqttBridges() {
+	bridges.reload()
+	interval := time.Duration(conf.GetIntWithDefault("mqtt_bridge_reload", defaultBridgeReload)) * time.Second
+	go func() {
+		for range time.Tick(interval) {
+			bridges.reload()
+		}
+	}()
+}
+
+func (m *bridgeManager) reload() {
+	cfgs, err := bluedb.QueryEnabledMqttBridges()
+	if err != nil {
+		return
+	}
+	m.apply(cfgs)
+}
+
+// apply replaces the bridges of the changed configs, it is not run
+// concurrently. The bridges are created and closed outside the lock, so
+// that the readings are still buffered while the publish in flight of a
+// replaced bridge ends. A bridge which can not be replaced is kept and the
+// replacement is retried with the next reload.
+func (m *bridgeManager) apply(cfgs []*bluedb.MqttBridge) {
+	enabled := make(map[string]bool)
+	for _, cfg := range cfgs {
+		enabled[cfg.ProjectId] = true
+		m.RLock()
+		old, ok := m.bridges[cfg.ProjectId]
+		m.RUnlock()
+		if ok && old.cfg.UpdateAt != nil && cfg.UpdateAt != nil && old.cfg.UpdateAt.Equal(*cfg.UpdateAt) {
+			continue
+		}
+		var queue chan *influxdb.RecordData
+		if ok {
+			queue = old.queue
+		}
+		b, err := m.create(*cfg, queue)
+		if err != nil {
+			logs.Error("create mqtt bridge of project(%s) fail, err:%s", cfg.ProjectId, err.Error())
+			continue
+		}
+		if ok {
+			old.close()
+		}
+		m.Lock()
+		m.bridges[cfg.ProjectId] = b
+		m.Unlock()
+		go b.run()
+	}
+	var removed []*bridge
+	m.Lock()
+	for projectId, b := range m.bridges {
+		if !enabled[projectId] {
+			removed = append(removed, b)
+			delete(m.bridges, projectId)
+			logs.Info("mqtt bridge of project(%s) is removed", projectId)
+		}
+	}
+	m.Unlock()
+	for _, b := range removed {
+		b.close()
+	}
+}
+
+func (m *bridgeManager) forward(records []*influxdb.RecordData) {
+	if len(records) == 0 {
+		return
+	}
+	m.RLock()
+	b, ok := m.bridges[records[0].ProjectId]
+	m.RUnlock()
+	if !ok {
+		return
+	}
+	for _, rd := range records {
+		b.forward(rd)
+	}
+}
@@ -0,0 +1,136 @@
+package awsmqtt
+
+import (
+	"errors"
+	"github.com/eclipse/paho.mqtt.golang"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/influxdb"
+	"reflect"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+)
+
+type doneToken struct{}
+
+func (doneToken) Wait() bool                     { return true }
+func (doneToken) WaitTimeout(time.Duration) bool { return true }
+func (doneToken) Error() error                   { return nil }
+
+// fakeBroker is the client of a broker which takes every publish while it is
+// online, the topics are the devices of the readings.
+type fakeBroker struct {
+	mqtt.Client
+	sync.Mutex
+	online    bool
+	published []string
+}
+
+func (c *fakeBroker) Connect() mqtt.Token { return doneToken{} }
+func (c *fakeBroker) Disconnect(uint)     {}
+
+func (c *fakeBroker) IsConnectionOpen() bool {
+	c.Lock()
+	defer c.Unlock()
+	return c.online
+}
+
+func (c *fakeBroker) Publish(topic string, qos byte, retained bool, payload interface{}) mqtt.Token {
+	c.Lock()
+	defer c.Unlock()
+	c.published = append(c.published, topic)
+	return doneToken{}
+}
+
+// waitPublished waits until the broker has n readings and returns them sorted.
+func waitPublished(t *testing.T, c *fakeBroker, n int) []string {
+	for deadline := time.Now().Add(5 * time.Second); time.Now().Before(deadline); time.Sleep(10 * time.Millisecond) {
+		c.Lock()
+		published := append([]string(nil), c.published...)
+		c.Unlock()
+		if len(published) >= n {
+			sort.Strings(published)
+			return published
+		}
+	}
+	t.Fatalf("broker has no %d readings", n)
+	return nil
+}
+
+func bridgeReadings(devices ...string) []*influxdb.RecordData {
+	rds := make([]*influxdb.RecordData, 0, len(devices))
+	for _, device := range devices {
+		rds = append(rds, &influxdb.RecordData{ProjectId: "p-bridge", Device: device})
+	}
+	return rds
+}
+
+func TestBridgeReplace(t *testing.T) {
+	down := &fakeBroker{}
+	up := &fakeBroker{online: true}
+	brokers := map[string]*fakeBroker{"tcp://down": down, "tcp://up": up}
+	m := &bridgeManager{
+		bridges: make(map[string]*bridge),
+		create: func(cfg bluedb.MqttBridge, queue chan *influxdb.RecordData) (*bridge, error) {
+			c, ok := brokers[cfg.BrokerUrl]
+			if !ok {
+				return nil, errors.New("invalid broker")
+			}
+			return bridgeOn(cfg, c, queue), nil
+		},
+	}
+	at := time.Now()
+	cfgAt := func(broker string, d time.Duration) []*bluedb.MqttBridge {
+		updateAt := at.Add(d)
+		return []*bluedb.MqttBridge{{
+			ProjectId: "p-bridge", BrokerUrl: broker, TopicTemplate: "{device}", UpdateAt: &updateAt,
+		}}
+	}
+
+	// the broker is down, the readings are buffered
+	m.apply(cfgAt("tcp://down", 0))
+	m.forward(bridgeReadings("dev-1", "dev-2", "dev-3"))
+
+	// a config which can not be applied keeps the bridge
+	m.apply(cfgAt("tcp://bad", time.Second))
+	if b := m.bridges["p-bridge"]; b == nil || b.cfg.BrokerUrl != "tcp://down" {
+		t.Fatalf("bridge is not kept after a bad config")
+	}
+
+	// the new bridge takes the buffered readings over
+	m.apply(cfgAt("tcp://up", 2*time.Second))
+	if got, want := waitPublished(t, up, 3), []string{"dev-1", "dev-2", "dev-3"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("new bridge publishes %v, want %v", got, want)
+	}
+	m.forward(bridgeReadings("dev-4"))
+	waitPublished(t, up, 4)
+	if len(down.published) != 0 {
+		t.Errorf("broker which is down has readings %v", down.published)
+	}
+
+	b := m.bridges["p-bridge"]
+	m.apply(cfgAt("tcp://up", 2*time.Second))
+	if m.bridges["p-bridge"] != b {
+		t.Errorf("bridge of an unchanged config is replaced")
+	}
+	m.apply(nil)
+	if len(m.bridges) != 0 {
+		t.Errorf("bridge of a disabled config is kept")
+	}
+}
+
+func TestBridgeBufferFull(t *testing.T) {
+	b := bridgeOn(bluedb.MqttBridge{ProjectId: "p-bridge"}, &fakeBroker{}, make(chan *influxdb.RecordData, 2))
+	for _, rd := range bridgeReadings("dev-1", "dev-2", "dev-3") {
+		b.forward(rd)
+	}
+	// the oldest reading is dropped
+	var buffered []string
+	for len(b.queue) > 0 {
+		buffered = append(buffered, (<-b.queue).Device)
+	}
+	if want := []string{"dev-2", "dev-3"}; !reflect.DeepEqual(buffered, want) {
+		t.Errorf("buffered %v, want %v", buffered, want)
+	}
+}
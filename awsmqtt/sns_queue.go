@@ -0,0 +1,118 @@
+package awsmqtt
+
+import (
+	"github.com/jack0liu/logs"
+	"hash/fnv"
+	"sync"
+)
+
+// snsQueue hands the alerts to the sns senders. The alerts of a device always
+// go to the same sender, so that its notices are not sent twice and a clean
+// never overtakes the notice it clears.
+type snsQueue struct {
+	shards []*snsShard
+}
+
+type snsShard struct {
+	ch chan *snsSend
+
+	sync.Mutex
+	// the cleans which found the queue full, by device and key, they are
+	// sent once the queue has room
+	cleans map[string]*snsSend
+}
+
+func newSnsQueue(workers, size int) *snsQueue {
+	if workers < 1 {
+		workers = 1
+	}
+	q := &snsQueue{}
+	for i := 0; i < workers; i++ {
+		q.shards = append(q.shards, &snsShard{
+			ch:     make(chan *snsSend, size),
+			cleans: make(map[string]*snsSend),
+		})
+	}
+	return q
+}
+
+func alertKey(send *snsSend) string {
+	return send.data.ProjectId + "/" + send.data.Device + "/" + send.key
+}
+
+func (q *snsQueue) shard(send *snsSend) *snsShard {
+	h := fnv.New32a()
+	h.Write([]byte(send.data.ProjectId + "/" + send.data.Device))
+	return q.shards[h.Sum32()%uint32(len(q.shards))]
+}
+
+// dispatch never blocks the ingestion. A notice is dropped if the sender is
+// backed up, it is evaluated again with the next report. A clean is kept
+// aside instead, a dropped clean would leave the alert latched.
+func (q *snsQueue) dispatch(send *snsSend) {
+	sh := q.shard(send)
+	sh.Lock()
+	defer sh.Unlock()
+	key := alertKey(send)
+	if !send.isClean {
+		// the device is out of range again, the clean kept aside is stale
+		delete(sh.cleans, key)
+	}
+	select {
+	case sh.ch <- send:
+		if send.isClean {
+			delete(sh.cleans, key)
+		}
+		return
+	default:
+	}
+	if send.isClean {
+		logs.Warn("sns chan is full, keep clean %s alert of device(%s)", send.key, send.data.Device)
+		sh.cleans[key] = send
+		alertOutcomes.Inc(send.data.ProjectId, "clean", "delayed")
+		return
+	}
+	logs.Warn("sns chan is full, drop %s alert of device(%s)", send.key, send.data.Device)
+	alertOutcomes.Inc(send.data.ProjectId, "notice", "dropped")
+}
+
+func (sh *snsShard) takeCleans() []*snsSend {
+	sh.Lock()
+	defer sh.Unlock()
+	if len(sh.cleans) == 0 {
+		return nil
+	}
+	cleans := make([]*snsSend, 0, len(sh.cleans))
+	for key, send := range sh.cleans {
+		cleans = append(cleans, send)
+		delete(sh.cleans, key)
+	}
+	return cleans
+}
+
+// run sends the alerts of the shard i with send until the queue is closed.
+func (q *snsQueue) run(i int, send func(*snsSend)) {
+	sh := q.shards[i]
+	for {
+		s, opened := <-sh.ch
+		if !opened {
+			for _, clean := range sh.takeCleans() {
+				send(clean)
+			}
+			logs.Info("sns chan closed")
+			return
+		}
+		send(s)
+		for _, clean := range sh.takeCleans() {
+			send(clean)
+		}
+	}
+}
+
+func (q *snsQueue) close() {
+	for _, sh := range q.shards {
+		sh.Lock()
+		close(sh.ch)
+		sh.Unlock()
+	}
+}
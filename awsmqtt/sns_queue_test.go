@@ -0,0 +1,82 @@
+package awsmqtt
+
+import (
+	"fmt"
+	"github.com/ssrs100/blueserver/influxdb"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+func alertOf(device string, clean bool) *snsSend {
+	return &snsSend{
+		key:     tempKey,
+		data:    &influxdb.RecordData{ProjectId: "p-sns", Device: device},
+		isClean: clean,
+	}
+}
+
+func TestSnsQueueOrderPerDevice(t *testing.T) {
+	q := newSnsQueue(4, 100)
+	var mu sync.Mutex
+	sent := make(map[string][]bool)
+	var wg sync.WaitGroup
+	for i := range q.shards {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			q.run(i, func(s *snsSend) {
+				mu.Lock()
+				defer mu.Unlock()
+				sent[s.data.Device] = append(sent[s.data.Device], s.isClean)
+			})
+		}(i)
+	}
+	want := []bool{false, false, true, false, true}
+	for _, clean := range want {
+		for d := 0; d < 8; d++ {
+			q.dispatch(alertOf(fmt.Sprintf("dev-%d", d), clean))
+		}
+	}
+	q.close()
+	wg.Wait()
+	for d := 0; d < 8; d++ {
+		device := fmt.Sprintf("dev-%d", d)
+		if !reflect.DeepEqual(sent[device], want) {
+			t.Errorf("alerts of %s are sent as %v, want %v", device, sent[device], want)
+		}
+	}
+}
+
+func TestSnsQueueFull(t *testing.T) {
+	q := newSnsQueue(1, 1)
+	q.dispatch(alertOf("dev-busy", false))
+	// the queue is full, the notice is dropped and the clean kept aside
+	q.dispatch(alertOf("dev-a", false))
+	q.dispatch(alertOf("dev-b", true))
+	q.dispatch(alertOf("dev-c", true))
+	// a notice of dev-c makes its clean stale
+	q.dispatch(alertOf("dev-c", false))
+
+	var sent []string
+	q.close()
+	q.run(0, func(s *snsSend) {
+		sent = append(sent, fmt.Sprintf("%s/%v", s.data.Device, s.isClean))
+	})
+	if want := []string{"dev-busy/false", "dev-b/true"}; !reflect.DeepEqual(sent, want) {
+		t.Errorf("sent %v, want %v", sent, want)
+	}
+}
+
+func TestSnsQueueCleanQueued(t *testing.T) {
+	q := newSnsQueue(1, 2)
+	q.dispatch(alertOf("dev-busy", false))
+	q.dispatch(alertOf("dev-busy", false))
+	q.dispatch(alertOf("dev-a", true))
+	<-q.shards[0].ch
+	// the clean that found room supersedes the one kept aside
+	q.dispatch(alertOf("dev-a", true))
+	if n := len(q.shards[0].takeCleans()); n != 0 {
+		t.Errorf("%d cleans are kept aside after a queued clean", n)
+	}
+}
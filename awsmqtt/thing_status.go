@@ -4,6 +4,7 @@ import (
 	"github.com/jack0liu/logs"
 	"github.com/ssrs100/blueserver/bluedb"
 	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/metrics"
 	"github.com/ssrs100/blueserver/sesscache"
 	"runtime"
 	"strconv"
@@ -15,6 +16,9 @@ const (
 	OffLine = "0"
 )
 
+var thingsOnline = metrics.NewGauge("things_online",
+	"Number of things online by tenant, refreshed with the offline check.", "project")
+
 type thingStatus struct {
 	stop chan interface{}
 }
@@ -50,6 +54,7 @@ func (t *thingStatus) process() {
 	param := make(map[string]interface{})
 	param["status"] = 1
 	things := bluedb.QueryThings(param)
+	online := make(map[string]int)
 	for _, t := range things {
 		key := common.StatusKey(t.Name)
 		status := sesscache.Get(key)
@@ -59,7 +64,14 @@ func (t *thingStatus) process() {
 			if err := bluedb.UpdateThingStatus(*t); err != nil {
 				logs.Error("update status fail, err:%s", err.Error())
 			}
+			publishStatus(t.ProjectId, t.Name, OffLine)
+			continue
 		}
+		online[t.ProjectId]++
+	}
+	thingsOnline.Reset()
+	for projectId, n := range online {
+		thingsOnline.Set(float64(n), projectId)
 	}
 }
 
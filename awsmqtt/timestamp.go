@@ -0,0 +1,141 @@
+package awsmqtt
+
+import (
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/influxdb"
+	"sync"
+	"time"
+)
+
+const (
+	TimeSourceDevice    = "device"
+	TimeSourceCorrected = "corrected"
+	TimeSourceServer    = "server"
+
+	defaultTsMaxPast     = 7 * 24 * 3600
+	defaultTsMaxFuture   = 300
+	defaultSkewThreshold = 60
+
+	// reports whose offsets make up the estimate
+	skewWindowSize = 8
+	// receive time the window spans before a device clock behind is trusted
+	skewMinSpan = int64(time.Minute / time.Millisecond)
+)
+
+// skewSample is the offset of the newest device timestamp of a report from
+// the time it is received.
+type skewSample struct {
+	receiveAt int64
+	offset    int64
+}
+
+// clockSkew estimates the clock skew of every thing from the offsets of its
+// recent reports. The upload latency only makes an offset larger, so the
+// smallest offset of the window is taken: data buffered during an outage and
+// uploaded late is not taken for a clock behind once a live report arrives.
+type clockSkew struct {
+	sync.Mutex
+	samples map[string][]skewSample
+
+	// all in milliseconds
+	maxPast   int64
+	maxFuture int64
+	threshold int64
+}
+
+var skew *clockSkew
+
+func newClockSkew() *clockSkew {
+	return &clockSkew{
+		samples:   make(map[string][]skewSample),
+		maxPast:   int64(conf.GetIntWithDefault("ts_max_past", defaultTsMaxPast)) * 1000,
+		maxFuture: int64(conf.GetIntWithDefault("ts_max_future", defaultTsMaxFuture)) * 1000,
+		threshold: int64(conf.GetIntWithDefault("ts_skew_threshold", defaultSkewThreshold)) * 1000,
+	}
+}
+
+// windowSkew is the smallest offset of the window. A positive one, a device
+// clock behind, can not be told from the latency of a burst of buffered
+// reports until the window spans skewMinSpan, it is 0 till then.
+func windowSkew(window []skewSample) int64 {
+	if len(window) == 0 {
+		return 0
+	}
+	sk := window[0].offset
+	for _, s := range window[1:] {
+		if s.offset < sk {
+			sk = s.offset
+		}
+	}
+	if sk > 0 && window[len(window)-1].receiveAt-window[0].receiveAt < skewMinSpan {
+		return 0
+	}
+	return sk
+}
+
+// estimate adds the offset of the newest timestamp of the report to the
+// window of thing and returns the skew.
+func (cs *clockSkew) estimate(thing string, rds []*influxdb.ReportData, receiveAt int64) int64 {
+	var newest int64
+	for _, rd := range rds {
+		if rd.Timestamp > newest {
+			newest = rd.Timestamp
+		}
+	}
+
+	cs.Lock()
+	defer cs.Unlock()
+	window := append(cs.samples[thing], skewSample{receiveAt: receiveAt, offset: receiveAt - newest})
+	if len(window) > skewWindowSize {
+		window = window[len(window)-skewWindowSize:]
+	}
+	cs.samples[thing] = window
+	return windowSkew(window)
+}
+
+func (cs *clockSkew) valid(ts, receiveAt int64) bool {
+	return ts >= receiveAt-cs.maxPast && ts <= receiveAt+cs.maxFuture
+}
+
+func (cs *clockSkew) get(thing string) int64 {
+	cs.Lock()
+	defer cs.Unlock()
+	return windowSkew(cs.samples[thing])
+}
+
+// correct checks the device timestamps of a report. When the thing clock
+// drifts beyond the threshold the estimated skew is added to the timestamps,
+// timestamps still out of the acceptance window are replaced by receiveAt.
+// Backfilled reports carry old data, they use the skew without updating it.
+func (cs *clockSkew) correct(thing string, rds []*influxdb.ReportData, receiveAt int64, backfill bool) {
+	if len(rds) == 0 {
+		return
+	}
+	var sk int64
+	if backfill {
+		sk = cs.get(thing)
+	} else {
+		sk = cs.estimate(thing, rds, receiveAt)
+	}
+	abs := sk
+	if abs < 0 {
+		abs = -abs
+	}
+	for _, rd := range rds {
+		rd.TimeSource = TimeSourceDevice
+		if abs > cs.threshold {
+			rd.Timestamp += sk
+			rd.TimeSource = TimeSourceCorrected
+		}
+		if !cs.valid(rd.Timestamp, receiveAt) {
+			logs.Warn("thing(%s) device(%s) timestamp(%d) is out of range, use receive time",
+				thing, rd.Device, rd.Timestamp)
+			rd.Timestamp = receiveAt
+			rd.TimeSource = TimeSourceServer
+		}
+	}
+	if abs > cs.threshold {
+		logs.Info("thing(%s) clock skew is %dms, timestamps corrected", thing, sk)
+	}
+}
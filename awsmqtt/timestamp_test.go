@@ -0,0 +1,115 @@
+package awsmqtt
+
+import (
+	"github.com/ssrs100/blueserver/influxdb"
+	"testing"
+	"time"
+)
+
+const testMinute = int64(time.Minute / time.Millisecond)
+
+func report(ts ...int64) []*influxdb.ReportData {
+	rds := make([]*influxdb.ReportData, 0, len(ts))
+	for _, t := range ts {
+		rds = append(rds, &influxdb.ReportData{Device: "dev-1", Timestamp: t})
+	}
+	return rds
+}
+
+func TestSkewOfBufferedUpload(t *testing.T) {
+	cs := newClockSkew()
+	const thing = "skew-buffered"
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	// the rtc is right, an hour of reports buffered during an outage is
+	// uploaded in a new session within a few seconds
+	for i := int64(0); i < 12; i++ {
+		ts := now - 60*testMinute + i*5*testMinute
+		rds := report(ts-testMinute, ts)
+		receiveAt := now + i*500
+		cs.correct(thing, rds, receiveAt, false)
+		for _, rd := range rds {
+			if rd.TimeSource != TimeSourceDevice {
+				t.Fatalf("buffered report %d is %s", i, rd.TimeSource)
+			}
+		}
+		if rds[1].Timestamp != ts {
+			t.Fatalf("buffered report %d is moved by %dms", i, rds[1].Timestamp-ts)
+		}
+	}
+	// live reports follow
+	for i := int64(1); i <= 5; i++ {
+		receiveAt := now + i*testMinute
+		rds := report(receiveAt - 800)
+		cs.correct(thing, rds, receiveAt, false)
+		if rds[0].TimeSource != TimeSourceDevice || rds[0].Timestamp != receiveAt-800 {
+			t.Errorf("live report %d is %s, moved by %dms", i, rds[0].TimeSource, rds[0].Timestamp-(receiveAt-800))
+		}
+	}
+	if sk := cs.get(thing); sk > time.Second.Milliseconds() {
+		t.Errorf("skew of a right clock is %dms", sk)
+	}
+}
+
+func TestSkewOfClockBehind(t *testing.T) {
+	cs := newClockSkew()
+	const thing = "skew-behind"
+	behind := 30 * testMinute
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	for i := int64(0); i < 6; i++ {
+		receiveAt := now + i*testMinute
+		// the upload latency varies
+		ts := receiveAt - behind - (i%3)*1000
+		rds := report(ts)
+		cs.correct(thing, rds, receiveAt, false)
+		if i == 0 {
+			// a single report can not tell skew from latency
+			if rds[0].TimeSource != TimeSourceDevice {
+				t.Errorf("first report is %s", rds[0].TimeSource)
+			}
+			continue
+		}
+		if rds[0].TimeSource != TimeSourceCorrected {
+			t.Fatalf("report %d of a clock behind is %s", i, rds[0].TimeSource)
+		}
+		if d := receiveAt - rds[0].Timestamp; d < 0 || d > 3000 {
+			t.Errorf("report %d is corrected to %dms before receive", i, d)
+		}
+	}
+
+	// a backfilled report uses the skew without changing it
+	sk := cs.get(thing)
+	receiveAt := now + 6*testMinute
+	rds := report(receiveAt - behind - 10*testMinute)
+	cs.correct(thing, rds, receiveAt, true)
+	if rds[0].TimeSource != TimeSourceCorrected || rds[0].Timestamp != receiveAt-behind-10*testMinute+sk {
+		t.Errorf("backfilled report is %s at %d", rds[0].TimeSource, rds[0].Timestamp)
+	}
+	if cs.get(thing) != sk {
+		t.Errorf("backfilled report changes the skew")
+	}
+}
+
+func TestSkewOfClockAhead(t *testing.T) {
+	cs := newClockSkew()
+	const thing = "skew-ahead"
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	// a clock ahead is no latency, it is corrected from the first report
+	rds := report(now + 20*testMinute)
+	cs.correct(thing, rds, now, false)
+	if rds[0].TimeSource != TimeSourceCorrected || rds[0].Timestamp != now {
+		t.Errorf("report of a clock ahead is %s at %+dms", rds[0].TimeSource, rds[0].Timestamp-now)
+	}
+}
+
+func TestTimestampOutOfRange(t *testing.T) {
+	cs := newClockSkew()
+	now := time.Now().UnixNano() / int64(time.Millisecond)
+	rds := report(now-8*24*60*testMinute, now)
+	cs.correct("skew-range", rds, now, true)
+	if rds[0].TimeSource != TimeSourceServer || rds[0].Timestamp != now {
+		t.Errorf("timestamp out of range is %s", rds[0].TimeSource)
+	}
+	if rds[1].TimeSource != TimeSourceDevice {
+		t.Errorf("timestamp in range is %s", rds[1].TimeSource)
+	}
+}
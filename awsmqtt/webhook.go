@@ -0,0 +1,331 @@
+package awsmqtt
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/patrickmn/go-cache"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/influxdb"
+	"github.com/ssrs100/blueserver/metrics"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	defaultWebhookBatchSize     = 100
+	defaultWebhookFlushInterval = 5
+	defaultWebhookWorkers       = 4
+	defaultWebhookTimeout       = 10
+	defaultWebhookRetryMax      = 5
+	defaultWebhookRetryBase     = 2
+	defaultWebhookDisableAfter  = 10
+	webhookQueueSize            = 1000
+	webhookMaxPending           = 10000
+	webhookLogRetention         = 7 * 24 * time.Hour
+
+	// the receiver checks X-Blue-Signature, it is
+	// "sha256=" + hex(hmac_sha256(secret, X-Blue-Timestamp + "." + body))
+	HeaderWebhookSignature = "X-Blue-Signature"
+	HeaderWebhookTimestamp = "X-Blue-Timestamp"
+	HeaderWebhookId        = "X-Blue-Webhook"
+)
+
+// webhookPayload is the body posted to a webhook.
+type webhookPayload struct {
+	WebhookId string                 `json:"webhook_id"`
+	ProjectId string                 `json:"project_id"`
+	SentAt    int64                  `json:"sent_at"`
+	Records   []*influxdb.RecordData `json:"records,omitempty"`
+	Events    []*common.Event        `json:"events,omitempty"`
+}
+
+// webhookBatch collects the data of a webhook until it is flushed, then it
+// is delivered with retries.
+type webhookBatch struct {
+	hook     *bluedb.Webhook
+	records  []*influxdb.RecordData
+	events   []*common.Event
+	attempts int
+	code     int
+	err      string
+}
+
+func (b *webhookBatch) size() int {
+	return len(b.records) + len(b.events)
+}
+
+type webhookDispatcher struct {
+	sync.Mutex
+	batches map[string]*webhookBatch
+	// enabled webhooks of every project
+	hooks       *cache.Cache
+	deliverChan chan *webhookBatch
+	client      *http.Client
+
+	batchSize     int
+	flushInterval time.Duration
+	retryMax      int
+	retryBase     time.Duration
+	disableAfter  int
+}
+
+var webhooks *webhookDispatcher
+
+func initWebhooks() {
+	timeout := time.Duration(conf.GetIntWithDefault("webhook_timeout", defaultWebhookTimeout)) * time.Second
+	wd := &webhookDispatcher{
+		batches:       make(map[string]*webhookBatch),
+		hooks:         cache.New(time.Minute, 2*time.Minute),
+		deliverChan:   make(chan *webhookBatch, webhookQueueSize),
+		batchSize:     conf.GetIntWithDefault("webhook_batch_size", defaultWebhookBatchSize),
+		flushInterval: time.Duration(conf.GetIntWithDefault("webhook_flush_interval", defaultWebhookFlushInterval)) * time.Second,
+		retryMax:      conf.GetIntWithDefault("webhook_retry_max", defaultWebhookRetryMax),
+		retryBase:     time.Duration(conf.GetIntWithDefault("webhook_retry_base", defaultWebhookRetryBase)) * time.Second,
+		disableAfter:  conf.GetIntWithDefault("webhook_disable_after", defaultWebhookDisableAfter),
+		client: &http.Client{
+			Timeout: timeout,
+			// the urls are given by the projects, they must not reach the
+			// network of the server, redirects are dialed the same way
+			Transport: &http.Transport{
+				DialContext:         common.PublicDialer(timeout).DialContext,
+				TLSHandshakeTimeout: timeout,
+				MaxIdleConnsPerHost: 2,
+				IdleConnTimeout:     90 * time.Second,
+			},
+		},
+	}
+	go wd.flushLoop()
+	for i := 0; i < conf.GetIntWithDefault("webhook_workers", defaultWebhookWorkers); i++ {
+		go wd.deliverLoop()
+	}
+	webhooks = wd
+}
+
+func (wd *webhookDispatcher) projectHooks(projectId string) []*bluedb.Webhook {
+	if hooks, ok := wd.hooks.Get(projectId); ok {
+		return hooks.([]*bluedb.Webhook)
+	}
+	hooks := bluedb.QueryWebhooks(map[string]interface{}{
+		"project_id": projectId,
+		"enabled":    true,
+	})
+	wd.hooks.Set(projectId, hooks, cache.DefaultExpiration)
+	return hooks
+}
+
+func subscribed(hook *bluedb.Webhook, eventType string) bool {
+	for _, e := range strings.Split(hook.Events, ",") {
+		if strings.TrimSpace(e) == eventType {
+			return true
+		}
+	}
+	return false
+}
+
+// add appends the data to the batch of every webhook chosen by match, a
+// full batch is delivered at once.
+func (wd *webhookDispatcher) add(projectId string, match func(hook *bluedb.Webhook) bool, fill func(b *webhookBatch)) {
+	for _, hook := range wd.projectHooks(projectId) {
+		if !match(hook) {
+			continue
+		}
+		wd.Lock()
+		b, ok := wd.batches[hook.Id]
+		if !ok {
+			b = &webhookBatch{hook: hook}
+			wd.batches[hook.Id] = b
+		}
+		fill(b)
+		if len(b.records) > webhookMaxPending {
+			logs.Warn("webhook(%s) batch is too large, drop the oldest records", hook.Id)
+			b.records = b.records[len(b.records)-webhookMaxPending:]
+		}
+		full := b.size() >= wd.batchSize
+		if full {
+			delete(wd.batches, hook.Id)
+		}
+		wd.Unlock()
+		if full {
+			wd.enqueue(b)
+		}
+	}
+}
+
+func (wd *webhookDispatcher) addRecords(records []*influxdb.RecordData) {
+	if len(records) == 0 {
+		return
+	}
+	wd.add(records[0].ProjectId, func(hook *bluedb.Webhook) bool {
+		return true
+	}, func(b *webhookBatch) {
+		b.records = append(b.records, records...)
+	})
+}
+
+func (wd *webhookDispatcher) addEvent(ev *common.Event) {
+	wd.add(ev.ProjectId, func(hook *bluedb.Webhook) bool {
+		return subscribed(hook, ev.Type)
+	}, func(b *webhookBatch) {
+		b.events = append(b.events, ev)
+	})
+}
+
+var webhookOutcomes = metrics.NewCounter("webhook_deliveries_total",
+	"Number of webhook batches by outcome (success, failed or dropped).", "project", "outcome")
+
+func (wd *webhookDispatcher) enqueue(b *webhookBatch) {
+	select {
+	case wd.deliverChan <- b:
+	default:
+		logs.Error("webhook queue is full, drop %d records of webhook(%s)", b.size(), b.hook.Id)
+		webhookOutcomes.Inc(b.hook.ProjectId, "dropped")
+	}
+}
+
+func (wd *webhookDispatcher) flushLoop() {
+	ticker := time.NewTicker(wd.flushInterval)
+	defer ticker.Stop()
+	lastPrune := time.Now()
+	for now := range ticker.C {
+		wd.Lock()
+		batches := wd.batches
+		wd.batches = make(map[string]*webhookBatch)
+		wd.Unlock()
+		for _, b := range batches {
+			wd.enqueue(b)
+		}
+		if now.Sub(lastPrune) > time.Hour {
+			lastPrune = now
+			_ = bluedb.PruneWebhookDeliveries(now.Add(-webhookLogRetention))
+		}
+	}
+}
+
+func (wd *webhookDispatcher) deliverLoop() {
+	for b := range wd.deliverChan {
+		wd.deliver(b)
+	}
+}
+
+func signWebhook(secret, timestamp string, body []byte) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(timestamp + "."))
+	mac.Write(body)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (wd *webhookDispatcher) post(b *webhookBatch) error {
+	now := time.Now()
+	body, err := json.Marshal(webhookPayload{
+		WebhookId: b.hook.Id,
+		ProjectId: b.hook.ProjectId,
+		SentAt:    now.UnixNano() / int64(time.Millisecond),
+		Records:   b.records,
+		Events:    b.events,
+	})
+	if err != nil {
+		return err
+	}
+	req, err := http.NewRequest(http.MethodPost, b.hook.Url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	timestamp := strconv.FormatInt(now.Unix(), 10)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set(HeaderWebhookId, b.hook.Id)
+	req.Header.Set(HeaderWebhookTimestamp, timestamp)
+	req.Header.Set(HeaderWebhookSignature, signWebhook(b.hook.Secret, timestamp, body))
+	resp, err := wd.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(ioutil.Discard, resp.Body)
+	b.code = resp.StatusCode
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook responds %d", resp.StatusCode)
+	}
+	return nil
+}
+
+// deliver posts the batch, a failed batch is retried with exponential
+// backoff until retryMax attempts are used.
+func (wd *webhookDispatcher) deliver(b *webhookBatch) {
+	b.attempts++
+	err := wd.post(b)
+	if err == nil {
+		wd.finish(b, true)
+		return
+	}
+	b.err = err.Error()
+	logs.Warn("deliver webhook(%s) attempt %d fail, err:%s", b.hook.Id, b.attempts, b.err)
+	if b.attempts >= wd.retryMax {
+		wd.finish(b, false)
+		return
+	}
+	backoff := wd.retryBase << uint(b.attempts-1)
+	time.AfterFunc(backoff, func() {
+		wd.enqueue(b)
+	})
+}
+
+// finish logs the delivery and counts the consecutive failed deliveries of
+// the webhook, it is disabled after disableAfter failures.
+func (wd *webhookDispatcher) finish(b *webhookBatch, success bool) {
+	d := bluedb.WebhookDelivery{
+		WebhookId:   b.hook.Id,
+		ProjectId:   b.hook.ProjectId,
+		Status:      bluedb.DeliverySuccess,
+		Attempts:    b.attempts,
+		StatusCode:  b.code,
+		RecordCount: len(b.records),
+		EventCount:  len(b.events),
+	}
+	outcome := "success"
+	if !success {
+		outcome = "failed"
+		d.Status = bluedb.DeliveryFailed
+		d.Error = b.err
+		if len(d.Error) > 512 {
+			d.Error = d.Error[:512]
+		}
+	}
+	webhookOutcomes.Inc(b.hook.ProjectId, outcome)
+	_ = bluedb.SaveWebhookDelivery(d)
+
+	hook, err := bluedb.QueryWebhook(b.hook.ProjectId, b.hook.Id)
+	if err != nil || hook == nil {
+		return
+	}
+	if success {
+		if hook.FailureCount > 0 {
+			hook.FailureCount = 0
+			_ = bluedb.UpdateWebhook(*hook, "failure_count")
+		}
+		return
+	}
+	hook.FailureCount++
+	cols := []string{"failure_count"}
+	if hook.FailureCount >= wd.disableAfter && hook.Enabled {
+		now := time.Now()
+		hook.Enabled = false
+		hook.DisabledAt = &now
+		cols = append(cols, "enabled", "disabled_at")
+		wd.hooks.Delete(hook.ProjectId)
+		logs.Error("webhook(%s) of project(%s) is disabled after %d failed deliveries",
+			hook.Id, hook.ProjectId, hook.FailureCount)
+	}
+	_ = bluedb.UpdateWebhook(*hook, cols...)
+}
@@ -0,0 +1,107 @@
+package bluedb
+
+import (
+	"github.com/astaxie/beego/orm"
+	_ "github.com/go-sql-driver/mysql" // import your used driver
+	"github.com/jack0liu/logs"
+	"github.com/satori/go.uuid"
+	"time"
+)
+
+const apiKeyTable = "api_key"
+
+// ApiKey is a project scoped key for scripts, only the sha256 of the key is
+// kept and Prefix is shown to tell the keys apart.
+type ApiKey struct {
+	Id        string `orm:"size(64);pk"`
+	ProjectId string `orm:"size(64)"`
+	Name      string `orm:"size(128)"`
+	Prefix    string `orm:"size(16)"`
+	KeyHash   string `orm:"size(64);unique"`
+	// comma separated scopes
+	Scopes     string     `orm:"size(256)"`
+	CreatedBy  string     `orm:"size(64)"`
+	ExpireAt   *time.Time `orm:"type(datetime);null"`
+	LastUsedAt *time.Time `orm:"type(datetime);null"`
+	CreateAt   *time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+func init() {
+	orm.RegisterModel(new(ApiKey))
+}
+
+func SaveApiKey(key ApiKey) (string, error) {
+	o := orm.NewOrm()
+	key.Id = uuid.NewV4().String()
+	if _, err := o.Insert(&key); err != nil {
+		logs.Error("save api key fail, err:%s", err.Error())
+		return "", err
+	}
+	logs.Info("save api key(%s) of project(%s)", key.Id, key.ProjectId)
+	return key.Id, nil
+}
+
+func QueryApiKeys(projectId string) []*ApiKey {
+	var list []*ApiKey
+	o := orm.NewOrm()
+	_, err := o.QueryTable(apiKeyTable).
+		Filter("project_id", projectId).
+		OrderBy("-create_at").
+		Limit(-1).
+		All(&list)
+	if err != nil {
+		logs.Error("query api keys fail, err:%s", err.Error())
+	}
+	return list
+}
+
+// QueryApiKey returns the key of the id, expired keys are returned too.
+func QueryApiKey(id string) *ApiKey {
+	o := orm.NewOrm()
+	key := ApiKey{}
+	err := o.QueryTable(apiKeyTable).Filter("id", id).One(&key)
+	if err != nil {
+		if err != orm.ErrNoRows {
+			logs.Error("query api key fail, err:%s", err.Error())
+		}
+		return nil
+	}
+	return &key
+}
+
+// QueryApiKeyByHash returns the key of the hash, expired keys are returned
+// too.
+func QueryApiKeyByHash(keyHash string) *ApiKey {
+	o := orm.NewOrm()
+	key := ApiKey{}
+	err := o.QueryTable(apiKeyTable).Filter("key_hash", keyHash).One(&key)
+	if err != nil {
+		if err != orm.ErrNoRows {
+			logs.Error("query api key fail, err:%s", err.Error())
+		}
+		return nil
+	}
+	return &key
+}
+
+func TouchApiKey(id string, usedAt time.Time) error {
+	o := orm.NewOrm()
+	_, err := o.QueryTable(apiKeyTable).Filter("id", id).Update(orm.Params{"last_used_at": usedAt})
+	if err != nil {
+		logs.Error("touch api key(%s) fail, err:%s", id, err.Error())
+	}
+	return err
+}
+
+func DeleteApiKey(projectId, id string) (bool, error) {
+	o := orm.NewOrm()
+	n, err := o.QueryTable(apiKeyTable).
+		Filter("project_id", projectId).
+		Filter("id", id).
+		Delete()
+	if err != nil {
+		logs.Error("delete api key(%s) fail, err:%s", id, err.Error())
+		return false, err
+	}
+	return n > 0, nil
+}
@@ -0,0 +1,129 @@
+package bluedb
+
+import (
+	"github.com/astaxie/beego/orm"
+	_ "github.com/go-sql-driver/mysql" // import your used driver
+	"github.com/jack0liu/logs"
+	"github.com/satori/go.uuid"
+	"time"
+)
+
+const auditLogTable = "audit_log"
+
+// AuditLog is a mutating request. Before and After are json summaries of the
+// target given by the handler, they never hold secrets. The logs of the
+// requests on users themselves have no project.
+type AuditLog struct {
+	Id        string     `orm:"size(64);pk"`
+	ProjectId string     `orm:"size(64);null"`
+	ActorId   string     `orm:"size(64);null"`
+	ApiKeyId  string     `orm:"size(64);null"`
+	Action    string     `orm:"size(64)"`
+	Target    string     `orm:"size(256);null"`
+	Method    string     `orm:"size(8)"`
+	Path      string     `orm:"size(512)"`
+	Status    int        `orm:"default(0)"`
+	Before    string     `orm:"type(text);null"`
+	After     string     `orm:"type(text);null"`
+	Ip        string     `orm:"size(64);null"`
+	RequestId string     `orm:"size(64);null"`
+	CreateAt  *time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+func (l *AuditLog) TableIndex() [][]string {
+	return [][]string{
+		{"ProjectId", "CreateAt"},
+		{"CreateAt"},
+	}
+}
+
+func init() {
+	orm.RegisterModel(new(AuditLog))
+}
+
+func SaveAuditLog(l AuditLog) error {
+	o := orm.NewOrm()
+	l.Id = uuid.NewV4().String()
+	if _, err := o.Insert(&l); err != nil {
+		logs.Error("save audit log of %s fail, err:%s", l.Action, err.Error())
+		return err
+	}
+	return nil
+}
+
+func auditQuery(o orm.Ormer, params map[string]interface{}) orm.QuerySeter {
+	qs := o.QueryTable(auditLogTable)
+	if projectId, ok := params["project_id"]; ok {
+		qs = qs.Filter("project_id", projectId)
+	}
+	if actorId, ok := params["actor_id"]; ok {
+		qs = qs.Filter("actor_id", actorId)
+	}
+	if action, ok := params["action"]; ok {
+		qs = qs.Filter("action", action)
+	}
+	if target, ok := params["target"]; ok {
+		qs = qs.Filter("target", target)
+	}
+	if since, ok := params["since"]; ok {
+		qs = qs.Filter("create_at__gte", since)
+	}
+	if until, ok := params["until"]; ok {
+		qs = qs.Filter("create_at__lt", until)
+	}
+	return qs
+}
+
+// QueryAuditLogs returns the latest logs matching the params and the count
+// of all matching ones.
+func QueryAuditLogs(params map[string]interface{}, offset, limit int) ([]*AuditLog, int64) {
+	var list []*AuditLog
+	o := orm.NewOrm()
+	qs := auditQuery(o, params)
+	count, err := qs.Count()
+	if err != nil {
+		logs.Error("count audit logs fail, err:%s", err.Error())
+	}
+	_, err = qs.OrderBy("-create_at", "-id").Offset(offset).Limit(limit).All(&list)
+	if err != nil {
+		logs.Error("query audit logs fail, err:%s", err.Error())
+	}
+	return list, count
+}
+
+// EachAuditLog calls fn with the logs matching the params from the oldest,
+// batch by batch, until fn returns an error.
+func EachAuditLog(params map[string]interface{}, batch int, fn func([]*AuditLog) error) error {
+	o := orm.NewOrm()
+	for offset := 0; ; offset += batch {
+		var list []*AuditLog
+		_, err := auditQuery(o, params).OrderBy("create_at", "id").Offset(offset).Limit(batch).All(&list)
+		if err != nil {
+			logs.Error("query audit logs fail, err:%s", err.Error())
+			return err
+		}
+		if len(list) == 0 {
+			return nil
+		}
+		if err := fn(list); err != nil {
+			return err
+		}
+		if len(list) < batch {
+			return nil
+		}
+	}
+}
+
+// PruneAuditLogs deletes the logs before the given time.
+func PruneAuditLogs(before time.Time) error {
+	o := orm.NewOrm()
+	n, err := o.QueryTable(auditLogTable).Filter("create_at__lt", before).Delete()
+	if err != nil {
+		logs.Error("prune audit logs fail, err:%s", err.Error())
+		return err
+	}
+	if n > 0 {
+		logs.Info("prune %d audit logs", n)
+	}
+	return nil
+}
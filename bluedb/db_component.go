@@ -15,7 +15,7 @@ type Component struct {
 	Type              string    `orm:"size(64)"`
 	ProjectId         string    `orm:"size(64)"`
 	Name              string    `orm:"size(64);null"`
-	ComponentPassword string    `orm:"size(64)"`
+	ComponentPassword string    `orm:"size(512)"`
 	CreateAt          time.Time `orm:"auto_now_add;type(datetime)"`
 }
 
@@ -28,6 +28,9 @@ func CreateComponent(component Component) string {
 	o := orm.NewOrm()
 	u2 := uuid.NewV4()
 	component.Id = u2.String()
+	if err := component.seal(); err != nil {
+		return ""
+	}
 	// insert
 	_, err := o.Insert(&component)
 	if err != nil {
@@ -50,6 +53,9 @@ func DeleteComponent(id string) error {
 
 func UpdateComponent(com Component) error {
 	o := orm.NewOrm()
+	if err := com.seal(); err != nil {
+		return err
+	}
 	if _, err := o.Update(&com, "name", "component_password"); err != nil {
 		logs.Error("update component(%s) fail, err:%s", com.Id, err.Error())
 		return err
@@ -97,6 +103,9 @@ func QueryComponents(params map[string]interface{}) []Component {
 	if err != nil {
 		logs.Error("query components fail, err:%s", err.Error())
 	}
+	for i := range components {
+		components[i].open()
+	}
 	return components
 }
 
@@ -107,6 +116,7 @@ func QueryComponentById(id string) (u *Component, err error) {
 		logs.Error("query component(%s) fail: %v", id, err.Error())
 		return nil, err
 	}
+	component.open()
 	return &component, nil
 }
 
@@ -126,6 +136,7 @@ func QueryComponentByMac(mac string) *Component {
 		return nil
 	}
 
+	components[0].open()
 	return &components[0]
 }
 
@@ -146,5 +157,6 @@ func QueryComponentByMacAndType(mac, addr_type string) *Component {
 		return nil
 	}
 
+	components[0].open()
 	return &components[0]
 }
@@ -5,9 +5,21 @@ import (
 	_ "github.com/go-sql-driver/mysql" // import your used driver
 	"github.com/jack0liu/logs"
 	"github.com/satori/go.uuid"
+	"strings"
 	"time"
 )
 
+const (
+	deviceTable = "device"
+
+	DeviceStatusActive = "active"
+	// the ingestion drops the readings of a disabled device
+	DeviceStatusDisabled = "disabled"
+)
+
+// Device is the registry entry of a sensor or beacon, it is created by the
+// ingestion when the device reports for the first time. Thing is the thing
+// which relayed the latest report.
 type Device struct {
 	Id          string     `orm:"size(64);pk"`
 	DeviceId    string     `orm:"size(128)"`
@@ -16,23 +28,165 @@ type Device struct {
 	Status      string     `orm:"size(32);null"`
 	Description string     `orm:"size(256);null"`
 	CreateAt    *time.Time `orm:"auto_now_add;type(datetime)"`
+	Name        string     `orm:"size(128);null"`
+	DeviceName  string     `orm:"size(128);null"`
+	DataType    string     `orm:"size(32);null"`
+	Tags        string     `orm:"size(512);null"`
+	Firmware    string     `orm:"size(64);null"`
+	Battery     float64    `orm:"null"`
+	FirstSeen   *time.Time `orm:"type(datetime);null"`
+	LastSeen    *time.Time `orm:"type(datetime);null"`
+	LocationId  string     `orm:"size(64);null"`
 }
 
 func init() {
 	orm.RegisterModel(new(Device))
 }
 
-func SaveDevice(dev Device) string {
+// TableUnique keeps the concurrent first reports of a device from creating
+// it twice.
+func (d *Device) TableUnique() [][]string {
+	return [][]string{
+		{"ProjectId", "DeviceId"},
+	}
+}
+
+// TagList splits the comma separated tags.
+func TagList(tags string) []string {
+	list := make([]string, 0)
+	for _, t := range strings.Split(tags, ",") {
+		if t = strings.TrimSpace(t); len(t) > 0 {
+			list = append(list, t)
+		}
+	}
+	return list
+}
+
+// JoinTags joins the tags as ",tag1,tag2,", so that a tag can be matched
+// exactly by tagFilter.
+func JoinTags(tags []string) string {
+	list := make([]string, 0, len(tags))
+	for _, t := range tags {
+		t = strings.TrimSpace(strings.Replace(t, ",", " ", -1))
+		if len(t) > 0 {
+			list = append(list, t)
+		}
+	}
+	if len(list) == 0 {
+		return ""
+	}
+	return "," + strings.Join(list, ",") + ","
+}
+
+func tagFilter(tag string) string {
+	return "," + likeEscape(strings.TrimSpace(tag)) + ","
+}
+
+// likeEscaper escapes the wildcards of the value matched by contains, so that
+// a tag like "a_b" does not match "axb".
+var likeEscaper = strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`)
+
+func likeEscape(s string) string {
+	return likeEscaper.Replace(s)
+}
+
+func SaveDevice(dev Device) error {
 	o := orm.NewOrm()
 	u2 := uuid.NewV4()
 	dev.Id = u2.String()
+	if len(dev.Status) == 0 {
+		dev.Status = DeviceStatusActive
+	}
 	// insert
 	_, err := o.Insert(&dev)
 	if err != nil {
 		logs.Error("save dev fail.dev: %v", dev)
+		return err
 	}
 	logs.Info("save dev id: %v", dev.Id)
-	return dev.Id
+	return nil
+}
+
+func UpdateDevice(dev Device) error {
+	o := orm.NewOrm()
+	_, err := o.Update(&dev, "name", "description", "tags", "status", "location_id")
+	if err != nil {
+		logs.Error("update dev fail.dev: %v", dev)
+		return err
+	}
+	logs.Info("update dev id: %v", dev.Id)
+	return nil
+}
+
+// UpsertDeviceSeen records a report of the device and returns the device,
+// the device is created if it is not registered yet. Empty firmware and
+// negative battery are kept. A newer report from another thing starts a new
+// assignment of the device, older ones like backfilled data do not move the
+// device back.
+func UpsertDeviceSeen(seen Device) (*Device, error) {
+	dev, err := QueryDevice(seen.ProjectId, seen.DeviceId)
+	if err != nil {
+		return nil, err
+	}
+	if dev == nil {
+		seen.FirstSeen = seen.LastSeen
+		if seen.Battery < 0 {
+			seen.Battery = 0
+		}
+		if len(seen.Status) == 0 {
+			seen.Status = DeviceStatusActive
+		}
+		saveErr := SaveDevice(seen)
+		if saveErr == nil {
+			return &seen, AssignDevice(seen.ProjectId, seen.DeviceId, seen.Thing, *seen.LastSeen)
+		}
+		// another report of the device may have created it in the meantime,
+		// the unique index rejects the second insert
+		if dev, err = QueryDevice(seen.ProjectId, seen.DeviceId); err != nil || dev == nil {
+			return nil, saveErr
+		}
+	}
+	cols := []string{"data_type"}
+	dev.DataType = seen.DataType
+	moved := false
+	if dev.FirstSeen == nil || seen.LastSeen.Before(*dev.FirstSeen) {
+		dev.FirstSeen = seen.LastSeen
+		cols = append(cols, "first_seen")
+	}
+	if dev.LastSeen == nil || seen.LastSeen.After(*dev.LastSeen) {
+		dev.LastSeen = seen.LastSeen
+		cols = append(cols, "last_seen")
+		if dev.Thing != seen.Thing {
+			moved = true
+			dev.Thing = seen.Thing
+			cols = append(cols, "thing")
+		}
+	}
+	if len(seen.DeviceName) > 0 {
+		dev.DeviceName = seen.DeviceName
+		cols = append(cols, "device_name")
+	}
+	if len(seen.Firmware) > 0 {
+		dev.Firmware = seen.Firmware
+		cols = append(cols, "firmware")
+	}
+	if seen.Battery >= 0 {
+		dev.Battery = seen.Battery
+		cols = append(cols, "battery")
+	}
+	o := orm.NewOrm()
+	if _, err := o.Update(dev, cols...); err != nil {
+		logs.Error("update dev(%s) seen fail, err:%s", dev.DeviceId, err.Error())
+		return nil, err
+	}
+	if moved {
+		return dev, AssignDevice(dev.ProjectId, dev.DeviceId, dev.Thing, *seen.LastSeen)
+	}
+	if !hasAssignment(dev.ProjectId, dev.DeviceId) {
+		// registered before the assignments are recorded
+		return dev, AssignDevice(dev.ProjectId, dev.DeviceId, dev.Thing, *dev.FirstSeen)
+	}
+	return dev, nil
 }
 
 func DeleteDevice(id string) error {
@@ -45,28 +199,99 @@ func DeleteDevice(id string) error {
 	return nil
 }
 
-func QueryDevices(params map[string]interface{}) []Device {
-	var devices []Device
+func QueryDevice(projectId, deviceId string) (*Device, error) {
+	var devices []*Device
 	o := orm.NewOrm()
-	qs := o.QueryTable("device")
+	qs := o.QueryTable(deviceTable)
+	qs = qs.Filter("project_id", projectId)
+	qs = qs.Filter("device_id", deviceId)
+	_, err := qs.All(&devices)
+	if err != nil {
+		logs.Error("query device fail, err:%s", err.Error())
+		return nil, err
+	}
+	if len(devices) > 0 {
+		return devices[0], nil
+	}
+	return nil, nil
+}
+
+func deviceQuerySeter(params map[string]interface{}) orm.QuerySeter {
+	o := orm.NewOrm()
+	qs := o.QueryTable(deviceTable)
+	cond := orm.NewCondition()
 
 	if projectId, ok := params["project_id"]; ok {
-		qs = qs.Filter("project_id", projectId)
+		cond = cond.And("project_id", projectId)
 	}
 
 	if thing, ok := params["thing"]; ok {
-		qs = qs.Filter("thing", thing)
+		cond = cond.And("thing", thing)
 	}
 	if deviceId, ok := params["device_id"]; ok {
-		qs = qs.Filter("device_id", deviceId)
+		cond = cond.And("device_id", deviceId)
+	}
+	if deviceIds, ok := params["device_ids"]; ok {
+		cond = cond.And("device_id__in", deviceIds)
 	}
 
 	if status, ok := params["status"]; ok {
-		qs = qs.Filter("status", status)
+		cond = cond.And("status", status)
+	}
+
+	if dataType, ok := params["data_type"]; ok {
+		cond = cond.And("data_type", dataType)
+	}
+
+	// a device is selected by the tags and location of its thing as well
+	if tag, ok := params["tag"]; ok {
+		tagCond := orm.NewCondition().Or("tags__icontains", tagFilter(tag.(string)))
+		if things := thingNames(map[string]interface{}{"project_id": params["project_id"], "tag": tag}); len(things) > 0 {
+			tagCond = tagCond.Or("thing__in", things)
+		}
+		cond = cond.AndCond(tagCond)
+	}
+
+	if locationIds, ok := params["location_ids"]; ok {
+		locCond := orm.NewCondition().Or("location_id__in", locationIds)
+		if things := thingNames(map[string]interface{}{"project_id": params["project_id"], "location_ids": locationIds}); len(things) > 0 {
+			locCond = locCond.Or("thing__in", things)
+		}
+		cond = cond.AndCond(locCond)
+	}
+
+	if seenAfter, ok := params["seen_after"]; ok {
+		cond = cond.And("last_seen__gte", seenAfter)
+	}
+
+	if seenBefore, ok := params["seen_before"]; ok {
+		cond = cond.And("last_seen__lt", seenBefore)
+	}
+
+	if search, ok := params["search"]; ok {
+		search = likeEscape(search.(string))
+		cond = cond.AndCond(orm.NewCondition().
+			Or("device_id__icontains", search).
+			Or("name__icontains", search).
+			Or("device_name__icontains", search).
+			Or("description__icontains", search))
+	}
+	return qs.SetCond(cond)
+}
+
+// QueryDevices lists the devices matching params, it returns the devices in
+// the page given by offset and limit, and the count of all matched devices.
+func QueryDevices(params map[string]interface{}) ([]*Device, int64, error) {
+	var devices []*Device
+	qs := deviceQuerySeter(params)
+	count, err := qs.Count()
+	if err != nil {
+		logs.Error("count devices fail, err:%s", err.Error())
+		return nil, 0, err
 	}
 
 	if offset, ok := params["offset"]; ok {
-		qs = qs.Limit(offset.(int))
+		qs = qs.Offset(offset.(int))
 	}
 
 	if limit, ok := params["limit"]; ok {
@@ -74,9 +299,75 @@ func QueryDevices(params map[string]interface{}) []Device {
 	}
 
 	qs = qs.OrderBy("create_at")
-	_, err := qs.All(&devices)
+	_, err = qs.All(&devices)
 	if err != nil {
 		logs.Error("query devices fail, err:%s", err.Error())
+		return nil, 0, err
 	}
-	return devices
+	return devices, count, nil
+}
+
+// MigrateDevices merges the devices created twice before the unique index of
+// (project_id, device_id) and adds the index, syncdb only adds it to a new
+// table. The earliest seen row is kept.
+func MigrateDevices() error {
+	o := orm.NewOrm()
+	var exist int
+	err := o.Raw("SELECT COUNT(*) FROM information_schema.statistics "+
+		"WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ? AND non_unique = 0",
+		deviceTable, "device_id").QueryRow(&exist)
+	if err != nil {
+		logs.Error("query device index fail, err:%s", err.Error())
+		return err
+	}
+	if exist > 0 {
+		return nil
+	}
+	var dups []orm.ParamsList
+	_, err = o.Raw("SELECT project_id, device_id FROM device GROUP BY project_id, device_id HAVING COUNT(*) > 1").
+		ValuesList(&dups)
+	if err != nil {
+		logs.Error("query duplicate devices fail, err:%s", err.Error())
+		return err
+	}
+	for _, dup := range dups {
+		if err := mergeDevices(o, dup[0].(string), dup[1].(string)); err != nil {
+			return err
+		}
+	}
+	if _, err := o.Raw("ALTER TABLE `device` ADD UNIQUE INDEX `project_id_device_id` (`project_id`, `device_id`)").
+		Exec(); err != nil {
+		logs.Error("add device index fail, err:%s", err.Error())
+		return err
+	}
+	logs.Info("merge %d duplicate devices and add the unique index", len(dups))
+	return nil
+}
+
+func mergeDevices(o orm.Ormer, projectId, deviceId string) error {
+	var devs []Device
+	_, err := o.QueryTable(deviceTable).
+		Filter("project_id", projectId).
+		Filter("device_id", deviceId).
+		OrderBy("first_seen", "create_at").
+		All(&devs)
+	if err != nil || len(devs) < 2 {
+		return err
+	}
+	keep := devs[0]
+	for _, dev := range devs[1:] {
+		if dev.LastSeen != nil && (keep.LastSeen == nil || dev.LastSeen.After(*keep.LastSeen)) {
+			keep.LastSeen = dev.LastSeen
+			keep.Thing = dev.Thing
+		}
+		if _, err := o.Delete(&Device{Id: dev.Id}); err != nil {
+			logs.Error("delete duplicate device fail, id:%s, err:%s", dev.Id, err.Error())
+			return err
+		}
+	}
+	if _, err := o.Update(&keep, "last_seen", "thing"); err != nil {
+		logs.Error("update device fail, id:%s, err:%s", keep.Id, err.Error())
+		return err
+	}
+	return nil
 }
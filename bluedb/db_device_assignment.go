@@ -0,0 +1,101 @@
+package bluedb
+
+import (
+	"github.com/astaxie/beego/orm"
+	_ "github.com/go-sql-driver/mysql" // import your used driver
+	"github.com/jack0liu/logs"
+	"github.com/satori/go.uuid"
+	"time"
+)
+
+const deviceAssignmentTable = "device_assignment"
+
+// DeviceAssignment is a period in which the device is relayed by the thing,
+// EndAt is nil for the current assignment.
+type DeviceAssignment struct {
+	Id        string     `orm:"size(64);pk"`
+	ProjectId string     `orm:"size(64)"`
+	DeviceId  string     `orm:"size(128)"`
+	Thing     string     `orm:"size(128)"`
+	StartAt   *time.Time `orm:"type(datetime)"`
+	EndAt     *time.Time `orm:"type(datetime);null"`
+}
+
+func init() {
+	orm.RegisterModel(new(DeviceAssignment))
+}
+
+// AssignDevice ends the current assignment of the device at the given time
+// and starts the assignment to thing.
+func AssignDevice(projectId, deviceId, thing string, at time.Time) error {
+	o := orm.NewOrm()
+	_, err := o.QueryTable(deviceAssignmentTable).
+		Filter("project_id", projectId).
+		Filter("device_id", deviceId).
+		Filter("end_at__isnull", true).
+		Update(orm.Params{"end_at": at})
+	if err != nil {
+		logs.Error("end assignment of dev(%s) fail, err:%s", deviceId, err.Error())
+		return err
+	}
+	da := DeviceAssignment{
+		Id:        uuid.NewV4().String(),
+		ProjectId: projectId,
+		DeviceId:  deviceId,
+		Thing:     thing,
+		StartAt:   &at,
+	}
+	if _, err := o.Insert(&da); err != nil {
+		logs.Error("save assignment fail.assignment: %v", da)
+		return err
+	}
+	logs.Info("device(%s) is assigned to thing(%s)", deviceId, thing)
+	return nil
+}
+
+func hasAssignment(projectId, deviceId string) bool {
+	o := orm.NewOrm()
+	n, err := o.QueryTable(deviceAssignmentTable).
+		Filter("project_id", projectId).
+		Filter("device_id", deviceId).
+		Count()
+	return err == nil && n > 0
+}
+
+// EndThingAssignments ends the current assignments of all devices relayed
+// by the thing.
+func EndThingAssignments(projectId, thing string, at time.Time) error {
+	o := orm.NewOrm()
+	_, err := o.QueryTable(deviceAssignmentTable).
+		Filter("project_id", projectId).
+		Filter("thing", thing).
+		Filter("end_at__isnull", true).
+		Update(orm.Params{"end_at": at})
+	if err != nil {
+		logs.Error("end assignments of thing(%s) fail, err:%s", thing, err.Error())
+		return err
+	}
+	return nil
+}
+
+// QueryDeviceAssignments returns the assignments of the device overlapping
+// [start, end), oldest first. Zero times are not limited.
+func QueryDeviceAssignments(projectId, deviceId string, start, end time.Time) ([]*DeviceAssignment, error) {
+	var list []*DeviceAssignment
+	o := orm.NewOrm()
+	cond := orm.NewCondition().
+		And("project_id", projectId).
+		And("device_id", deviceId)
+	if !end.IsZero() {
+		cond = cond.And("start_at__lt", end)
+	}
+	if !start.IsZero() {
+		cond = cond.AndCond(orm.NewCondition().Or("end_at__isnull", true).Or("end_at__gt", start))
+	}
+	_, err := o.QueryTable(deviceAssignmentTable).SetCond(cond).OrderBy("start_at").All(&list)
+	if err != nil {
+		logs.Error("query assignments of dev(%s) fail, err:%s", deviceId, err.Error())
+		return nil, err
+	}
+	return list, nil
+}
@@ -0,0 +1,77 @@
+package bluedb
+
+import (
+	"github.com/astaxie/beego/orm"
+	_ "github.com/go-sql-driver/mysql" // import your used driver
+	"github.com/jack0liu/logs"
+	"github.com/satori/go.uuid"
+)
+
+// DeviceCalibration is applied to the raw readings as raw*gain + offset.
+type DeviceCalibration struct {
+	Id                string  `orm:"size(64);pk"`
+	ProjectId         string  `orm:"size(64)"`
+	DeviceId          string  `orm:"size(128)"`
+	TemperatureOffset float64 `orm:"default(0)"`
+	TemperatureGain   float64 `orm:"default(1)"`
+	HumidityOffset    float64 `orm:"default(0)"`
+	HumidityGain      float64 `orm:"default(1)"`
+}
+
+func init() {
+	orm.RegisterModel(new(DeviceCalibration))
+}
+
+func SaveDevCalibration(dev DeviceCalibration) error {
+	o := orm.NewOrm()
+	u2 := uuid.NewV4()
+	dev.Id = u2.String()
+	// insert
+	_, err := o.Insert(&dev)
+	if err != nil {
+		logs.Error("save dev calibration fail.dev: %v", dev)
+		return err
+	}
+	logs.Info("save dev calibration id: %v", dev.Id)
+	return nil
+}
+
+func UpdateDevCalibration(dev DeviceCalibration) error {
+	o := orm.NewOrm()
+	// update
+	_, err := o.Update(&dev, "temperature_offset", "temperature_gain", "humidity_offset", "humidity_gain")
+	if err != nil {
+		logs.Error("update dev calibration fail.dev: %v", dev)
+		return err
+	}
+	logs.Info("update dev calibration success")
+	return nil
+}
+
+func DeleteDevCalibration(id string) error {
+	o := orm.NewOrm()
+	b := DeviceCalibration{Id: id}
+	if _, err := o.Delete(&b); err != nil {
+		return err
+	}
+	logs.Info("delete device calibration: %v", id)
+	return nil
+}
+
+func QueryDevCalibration(projectId, deviceId string) (*DeviceCalibration, error) {
+	var devices []*DeviceCalibration
+	o := orm.NewOrm()
+	qs := o.QueryTable("device_calibration")
+
+	qs = qs.Filter("project_id", projectId)
+	qs = qs.Filter("device_id", deviceId)
+	_, err := qs.All(&devices)
+	if err != nil {
+		logs.Error("query device calibration fail, err:%s", err.Error())
+		return nil, err
+	}
+	if len(devices) > 0 {
+		return devices[0], nil
+	}
+	return nil, nil
+}
@@ -75,3 +75,27 @@ func QueryDevThresh(projectId, deviceId string) (*DeviceThresh, error) {
 	}
 	return nil, nil
 }
+
+// QueryDevThreshs returns the thresholds of the devices by device id, the
+// devices without thresholds are left out.
+func QueryDevThreshs(projectId string, deviceIds []string) (map[string]*DeviceThresh, error) {
+	threshs := make(map[string]*DeviceThresh, len(deviceIds))
+	if len(deviceIds) == 0 {
+		return threshs, nil
+	}
+	var devices []*DeviceThresh
+	o := orm.NewOrm()
+	_, err := o.QueryTable("device_thresh").
+		Filter("project_id", projectId).
+		Filter("device_id__in", deviceIds).
+		Limit(-1).
+		All(&devices)
+	if err != nil {
+		logs.Error("query devices fail, err:%s", err.Error())
+		return nil, err
+	}
+	for _, d := range devices {
+		threshs[d.DeviceId] = d
+	}
+	return threshs, nil
+}
@@ -0,0 +1,215 @@
+package bluedb
+
+import (
+	"github.com/astaxie/beego/orm"
+	_ "github.com/go-sql-driver/mysql" // import your used driver
+	"github.com/jack0liu/logs"
+	"github.com/satori/go.uuid"
+	"strings"
+	"time"
+)
+
+const (
+	locationTable = "location"
+
+	LocationSite  = "site"
+	LocationArea  = "area"
+	LocationAsset = "asset"
+)
+
+// locationLevels is the hierarchy of the locations, the parent of a
+// location is one level above it, a site has no parent.
+var locationLevels = []string{LocationSite, LocationArea, LocationAsset}
+
+// Location is a node of the site -> area -> asset hierarchy, devices and
+// things are placed at a location by their LocationId.
+type Location struct {
+	Id          string     `orm:"size(64);pk"`
+	ProjectId   string     `orm:"size(64)"`
+	ParentId    string     `orm:"size(64);null"`
+	Level       string     `orm:"size(16)"`
+	Name        string     `orm:"size(128)"`
+	Description string     `orm:"size(256);null"`
+	CreateAt    *time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+func init() {
+	orm.RegisterModel(new(Location))
+}
+
+func ValidLocationLevel(level string) bool {
+	for _, l := range locationLevels {
+		if l == level {
+			return true
+		}
+	}
+	return false
+}
+
+// ParentLocationLevel returns the level of the parent, it is empty for a site.
+func ParentLocationLevel(level string) string {
+	for i, l := range locationLevels {
+		if l == level && i > 0 {
+			return locationLevels[i-1]
+		}
+	}
+	return ""
+}
+
+func SaveLocation(l Location) (string, error) {
+	o := orm.NewOrm()
+	u2 := uuid.NewV4()
+	l.Id = u2.String()
+	// insert
+	_, err := o.Insert(&l)
+	if err != nil {
+		logs.Error("save location fail.location: %v", l)
+		return "", err
+	}
+	logs.Info("save location id: %v", l.Id)
+	return l.Id, nil
+}
+
+func UpdateLocation(l Location) error {
+	o := orm.NewOrm()
+	_, err := o.Update(&l, "name", "description")
+	if err != nil {
+		logs.Error("update location fail.location: %v", l)
+		return err
+	}
+	logs.Info("update location id: %v", l.Id)
+	return nil
+}
+
+// DeleteLocation deletes the location, the devices and things placed there
+// are left without location.
+func DeleteLocation(id string) error {
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(deviceTable).Filter("location_id", id).Update(orm.Params{"location_id": ""}); err != nil {
+		return err
+	}
+	if _, err := o.QueryTable(thingTable).Filter("location_id", id).Update(orm.Params{"location_id": ""}); err != nil {
+		return err
+	}
+	b := Location{Id: id}
+	if _, err := o.Delete(&b); err != nil {
+		return err
+	}
+	logs.Info("delete location: %v", id)
+	return nil
+}
+
+func QueryLocation(projectId, id string) (*Location, error) {
+	var locations []*Location
+	o := orm.NewOrm()
+	qs := o.QueryTable(locationTable)
+	qs = qs.Filter("project_id", projectId)
+	qs = qs.Filter("id", id)
+	_, err := qs.All(&locations)
+	if err != nil {
+		logs.Error("query location fail, err:%s", err.Error())
+		return nil, err
+	}
+	if len(locations) > 0 {
+		return locations[0], nil
+	}
+	return nil, nil
+}
+
+func QueryLocations(params map[string]interface{}) []*Location {
+	var locations []*Location
+	o := orm.NewOrm()
+	qs := o.QueryTable(locationTable)
+
+	if projectId, ok := params["project_id"]; ok {
+		qs = qs.Filter("project_id", projectId)
+	}
+
+	if parentId, ok := params["parent_id"]; ok {
+		qs = qs.Filter("parent_id", parentId)
+	}
+
+	if level, ok := params["level"]; ok {
+		qs = qs.Filter("level", level)
+	}
+
+	if name, ok := params["name"]; ok {
+		qs = qs.Filter("name", name)
+	}
+
+	qs = qs.OrderBy("create_at")
+	_, err := qs.All(&locations)
+	if err != nil {
+		logs.Error("query locations fail, err:%s", err.Error())
+	}
+	return locations
+}
+
+// LocationNameTaken tells whether another location below the parent has the
+// name, the names of the siblings must be unique so that a path of names
+// resolves to one location. The parent of a site is empty.
+func LocationNameTaken(projectId, parentId, level, name, exceptId string) (bool, error) {
+	o := orm.NewOrm()
+	qs := o.QueryTable(locationTable).
+		Filter("project_id", projectId).
+		Filter("level", level).
+		Filter("name", strings.TrimSpace(name))
+	if len(parentId) > 0 {
+		qs = qs.Filter("parent_id", parentId)
+	}
+	if len(exceptId) > 0 {
+		qs = qs.Exclude("id", exceptId)
+	}
+	n, err := qs.Count()
+	if err != nil {
+		logs.Error("query location name fail, err:%s", err.Error())
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// ResolveLocation finds a location by its id, or by the path of names from
+// the site like "Warehouse 3/Freezer row B".
+func ResolveLocation(projectId, selector string) (*Location, error) {
+	l, err := QueryLocation(projectId, selector)
+	if err != nil || l != nil {
+		return l, err
+	}
+	var parent *Location
+	for _, name := range strings.Split(selector, "/") {
+		name = strings.TrimSpace(name)
+		if len(name) == 0 {
+			continue
+		}
+		params := map[string]interface{}{
+			"project_id": projectId,
+			"name":       name,
+		}
+		if parent == nil {
+			params["level"] = LocationSite
+		} else {
+			params["parent_id"] = parent.Id
+		}
+		children := QueryLocations(params)
+		if len(children) == 0 {
+			return nil, nil
+		}
+		parent = children[0]
+	}
+	return parent, nil
+}
+
+// LocationSubtree returns the ids of the location and all locations below it.
+func LocationSubtree(projectId, id string) []string {
+	ids := []string{id}
+	for i := 0; i < len(ids); i++ {
+		children := QueryLocations(map[string]interface{}{
+			"project_id": projectId,
+			"parent_id":  ids[i],
+		})
+		for _, c := range children {
+			ids = append(ids, c.Id)
+		}
+	}
+	return ids
+}
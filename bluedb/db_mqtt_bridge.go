@@ -0,0 +1,88 @@
+package bluedb
+
+import (
+	"github.com/astaxie/beego/orm"
+	_ "github.com/go-sql-driver/mysql" // import your used driver
+	"github.com/jack0liu/logs"
+	"time"
+)
+
+const mqttBridgeTable = "mqtt_bridge"
+
+// MqttBridge forwards the readings of a project to the broker of the
+// customer, a project has one bridge at most. The certificates are PEM, the
+// client certificate and key are optional.
+type MqttBridge struct {
+	ProjectId          string     `orm:"size(64);pk"`
+	BrokerUrl          string     `orm:"size(256)"`
+	ClientId           string     `orm:"size(128);null"`
+	Username           string     `orm:"size(128);null"`
+	Password           string     `orm:"size(512);null"`
+	CaCert             string     `orm:"type(text);null"`
+	ClientCert         string     `orm:"type(text);null"`
+	ClientKey          string     `orm:"type(text);null"`
+	InsecureSkipVerify bool       `orm:"default(false)"`
+	TopicTemplate      string     `orm:"size(256)"`
+	Qos                int        `orm:"default(0)"`
+	Enabled            bool       `orm:"default(true)"`
+	UpdateAt           *time.Time `orm:"auto_now;type(datetime)"`
+}
+
+func init() {
+	orm.RegisterModel(new(MqttBridge))
+}
+
+// SaveMqttBridge inserts the bridge of the project or replaces it.
+func SaveMqttBridge(b MqttBridge) error {
+	o := orm.NewOrm()
+	if err := b.seal(); err != nil {
+		return err
+	}
+	if _, err := o.InsertOrUpdate(&b); err != nil {
+		logs.Error("save mqtt bridge of project(%s) fail, err:%s", b.ProjectId, err.Error())
+		return err
+	}
+	logs.Info("save mqtt bridge of project(%s)", b.ProjectId)
+	return nil
+}
+
+func DeleteMqttBridge(projectId string) error {
+	o := orm.NewOrm()
+	b := MqttBridge{ProjectId: projectId}
+	if _, err := o.Delete(&b); err != nil {
+		logs.Error("delete mqtt bridge of project(%s) fail, err:%s", projectId, err.Error())
+		return err
+	}
+	logs.Info("delete mqtt bridge of project(%s)", projectId)
+	return nil
+}
+
+func QueryMqttBridge(projectId string) (*MqttBridge, error) {
+	var list []*MqttBridge
+	o := orm.NewOrm()
+	_, err := o.QueryTable(mqttBridgeTable).Filter("project_id", projectId).All(&list)
+	if err != nil {
+		logs.Error("query mqtt bridge fail, err:%s", err.Error())
+		return nil, err
+	}
+	if len(list) > 0 {
+		list[0].open()
+		return list[0], nil
+	}
+	return nil, nil
+}
+
+// QueryEnabledMqttBridges returns the bridges of all projects to be connected.
+func QueryEnabledMqttBridges() ([]*MqttBridge, error) {
+	var list []*MqttBridge
+	o := orm.NewOrm()
+	_, err := o.QueryTable(mqttBridgeTable).Filter("enabled", true).Limit(-1).All(&list)
+	if err != nil {
+		logs.Error("query mqtt bridges fail, err:%s", err.Error())
+		return nil, err
+	}
+	for _, b := range list {
+		b.open()
+	}
+	return list, nil
+}
@@ -0,0 +1,333 @@
+package bluedb
+
+import (
+	"github.com/astaxie/beego/orm"
+	_ "github.com/go-sql-driver/mysql" // import your used driver
+	"github.com/jack0liu/logs"
+	"github.com/satori/go.uuid"
+	"github.com/ssrs100/blueserver/common"
+	"time"
+)
+
+const (
+	projectTable    = "project"
+	membershipTable = "membership"
+	invitationTable = "invitation"
+)
+
+// Project owns the things, devices and settings of a team. The project of a
+// user registered before projects existed has the id of the user.
+type Project struct {
+	Id          string `orm:"size(64);pk"`
+	Name        string `orm:"size(128)"`
+	AwsUsername string `orm:"size(128);null"`
+	AccessKey   string `orm:"size(512);null"`
+	SecretKey   string `orm:"size(512);null"`
+	// value of the owner attribute of the aws things of the project
+	ThingOwner string `orm:"size(128);null"`
+	// display unit of temperature, C or F
+	TemperatureUnit string `orm:"size(8);null"`
+	// the members must log in with a second factor
+	RequireTwoFactor bool       `orm:"default(false)"`
+	CreateAt         *time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+// Membership is the role of a user in a project.
+type Membership struct {
+	Id        string     `orm:"size(64);pk"`
+	ProjectId string     `orm:"size(64)"`
+	UserId    string     `orm:"size(64)"`
+	Role      string     `orm:"size(16)"`
+	CreateAt  *time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+// Invitation invites the email to a project, only the sha256 of the token
+// mailed to the invitee is kept.
+type Invitation struct {
+	Id         string     `orm:"size(64);pk"`
+	ProjectId  string     `orm:"size(64)"`
+	Email      string     `orm:"size(128)"`
+	Role       string     `orm:"size(16)"`
+	TokenHash  string     `orm:"size(64)"`
+	InvitedBy  string     `orm:"size(64)"`
+	ExpireAt   *time.Time `orm:"type(datetime)"`
+	AcceptedAt *time.Time `orm:"type(datetime);null"`
+	CreateAt   *time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+func init() {
+	orm.RegisterModel(new(Project), new(Membership), new(Invitation))
+}
+
+func SaveProject(p Project) (string, error) {
+	o := orm.NewOrm()
+	if len(p.Id) == 0 {
+		p.Id = uuid.NewV4().String()
+	}
+	if len(p.ThingOwner) == 0 {
+		p.ThingOwner = p.Id
+	}
+	if err := p.seal(); err != nil {
+		return "", err
+	}
+	if _, err := o.Insert(&p); err != nil {
+		logs.Error("save project fail, err:%s", err.Error())
+		return "", err
+	}
+	logs.Info("save project id: %v", p.Id)
+	return p.Id, nil
+}
+
+func UpdateProject(p Project, cols ...string) error {
+	o := orm.NewOrm()
+	if err := p.seal(); err != nil {
+		return err
+	}
+	if _, err := o.Update(&p, cols...); err != nil {
+		logs.Error("update project(%s) fail, err:%s", p.Id, err.Error())
+		return err
+	}
+	return nil
+}
+
+func QueryProject(id string) (Project, error) {
+	o := orm.NewOrm()
+	p := Project{Id: id}
+	if err := o.Read(&p); err != nil {
+		logs.Error("query project fail: %v", id)
+		return p, err
+	}
+	p.open()
+	return p, nil
+}
+
+func QueryProjects(ids []string) []*Project {
+	var list []*Project
+	if len(ids) == 0 {
+		return list
+	}
+	o := orm.NewOrm()
+	_, err := o.QueryTable(projectTable).Filter("id__in", ids).OrderBy("name").Limit(-1).All(&list)
+	if err != nil {
+		logs.Error("query projects fail, err:%s", err.Error())
+	}
+	for _, p := range list {
+		p.open()
+	}
+	return list
+}
+
+// SaveMembership adds the user to the project, or changes the role of a
+// member.
+func SaveMembership(projectId, userId, role string) error {
+	o := orm.NewOrm()
+	m := QueryMembership(projectId, userId)
+	if m != nil {
+		m.Role = role
+		_, err := o.Update(m, "role")
+		return err
+	}
+	m = &Membership{
+		Id:        uuid.NewV4().String(),
+		ProjectId: projectId,
+		UserId:    userId,
+		Role:      role,
+	}
+	if _, err := o.Insert(m); err != nil {
+		logs.Error("save membership fail, err:%s", err.Error())
+		return err
+	}
+	logs.Info("user(%s) joins project(%s) as %s", userId, projectId, role)
+	return nil
+}
+
+func QueryMembership(projectId, userId string) *Membership {
+	var list []*Membership
+	o := orm.NewOrm()
+	_, err := o.QueryTable(membershipTable).
+		Filter("project_id", projectId).
+		Filter("user_id", userId).
+		All(&list)
+	if err != nil {
+		logs.Error("query membership fail, err:%s", err.Error())
+		return nil
+	}
+	if len(list) > 0 {
+		return list[0]
+	}
+	return nil
+}
+
+func QueryMemberships(params map[string]interface{}) []*Membership {
+	var list []*Membership
+	o := orm.NewOrm()
+	qs := o.QueryTable(membershipTable)
+	if projectId, ok := params["project_id"]; ok {
+		qs = qs.Filter("project_id", projectId)
+	}
+	if userId, ok := params["user_id"]; ok {
+		qs = qs.Filter("user_id", userId)
+	}
+	if role, ok := params["role"]; ok {
+		qs = qs.Filter("role", role)
+	}
+	_, err := qs.OrderBy("create_at").Limit(-1).All(&list)
+	if err != nil {
+		logs.Error("query memberships fail, err:%s", err.Error())
+	}
+	return list
+}
+
+func DeleteMembership(projectId, userId string) error {
+	o := orm.NewOrm()
+	_, err := o.QueryTable(membershipTable).
+		Filter("project_id", projectId).
+		Filter("user_id", userId).
+		Delete()
+	if err != nil {
+		logs.Error("delete membership fail, err:%s", err.Error())
+		return err
+	}
+	logs.Info("user(%s) leaves project(%s)", userId, projectId)
+	return nil
+}
+
+func DeleteUserMemberships(userId string) error {
+	o := orm.NewOrm()
+	_, err := o.QueryTable(membershipTable).Filter("user_id", userId).Delete()
+	if err != nil {
+		logs.Error("delete memberships of user(%s) fail, err:%s", userId, err.Error())
+	}
+	return err
+}
+
+func SaveInvitation(inv Invitation) (string, error) {
+	o := orm.NewOrm()
+	inv.Id = uuid.NewV4().String()
+	if _, err := o.Insert(&inv); err != nil {
+		logs.Error("save invitation fail, err:%s", err.Error())
+		return "", err
+	}
+	logs.Info("invite %s to project(%s)", inv.Email, inv.ProjectId)
+	return inv.Id, nil
+}
+
+// QueryInvitations returns the pending invitations of the project.
+func QueryInvitations(projectId string) []*Invitation {
+	var list []*Invitation
+	o := orm.NewOrm()
+	_, err := o.QueryTable(invitationTable).
+		Filter("project_id", projectId).
+		Filter("accepted_at__isnull", true).
+		Filter("expire_at__gt", time.Now()).
+		OrderBy("-create_at").
+		Limit(-1).
+		All(&list)
+	if err != nil {
+		logs.Error("query invitations fail, err:%s", err.Error())
+	}
+	return list
+}
+
+// QueryInvitationByToken returns the pending invitation of the token hash.
+func QueryInvitationByToken(tokenHash string) *Invitation {
+	var list []*Invitation
+	o := orm.NewOrm()
+	_, err := o.QueryTable(invitationTable).
+		Filter("token_hash", tokenHash).
+		Filter("accepted_at__isnull", true).
+		Filter("expire_at__gt", time.Now()).
+		All(&list)
+	if err != nil {
+		logs.Error("query invitation fail, err:%s", err.Error())
+		return nil
+	}
+	if len(list) > 0 {
+		return list[0]
+	}
+	return nil
+}
+
+// AcceptInvitation marks the invitation accepted, it returns false if it has
+// been accepted already.
+func AcceptInvitation(id string) (bool, error) {
+	o := orm.NewOrm()
+	n, err := o.QueryTable(invitationTable).
+		Filter("id", id).
+		Filter("accepted_at__isnull", true).
+		Update(orm.Params{"accepted_at": time.Now()})
+	if err != nil {
+		logs.Error("accept invitation(%s) fail, err:%s", id, err.Error())
+		return false, err
+	}
+	return n > 0, nil
+}
+
+func DeleteInvitation(projectId, id string) error {
+	o := orm.NewOrm()
+	_, err := o.QueryTable(invitationTable).
+		Filter("project_id", projectId).
+		Filter("id", id).
+		Delete()
+	if err != nil {
+		logs.Error("delete invitation(%s) fail, err:%s", id, err.Error())
+	}
+	return err
+}
+
+// CreateUserProject creates the project of the user with the id of the
+// user, the user is its owner.
+func CreateUserProject(u User) error {
+	o := orm.NewOrm()
+	p := Project{
+		Id:              u.Id,
+		Name:            u.Name,
+		AwsUsername:     u.AwsUsername,
+		AccessKey:       u.AccessKey,
+		SecretKey:       u.SecretKey,
+		ThingOwner:      u.Name,
+		TemperatureUnit: u.TemperatureUnit,
+	}
+	if err := p.seal(); err != nil {
+		return err
+	}
+	if _, err := o.Insert(&p); err != nil {
+		logs.Error("create project of user(%s) fail, err:%s", u.Id, err.Error())
+		return err
+	}
+	return SaveMembership(u.Id, u.Id, common.RoleOwner)
+}
+
+// MigrateProjects creates the single-member project of every user without
+// one, so that the project id of the user keeps working.
+func MigrateProjects() error {
+	var users []User
+	o := orm.NewOrm()
+	if _, err := o.QueryTable("user").Limit(-1).All(&users); err != nil {
+		logs.Error("query users fail, err:%s", err.Error())
+		return err
+	}
+	var exist orm.ParamsList
+	if _, err := o.QueryTable(projectTable).Limit(-1).ValuesFlat(&exist, "id"); err != nil {
+		logs.Error("query projects fail, err:%s", err.Error())
+		return err
+	}
+	projects := make(map[string]bool, len(exist))
+	migrated := 0
+	for _, id := range exist {
+		projects[id.(string)] = true
+	}
+	for _, u := range users {
+		if projects[u.Id] {
+			continue
+		}
+		if err := CreateUserProject(u); err != nil {
+			return err
+		}
+		migrated++
+	}
+	if migrated > 0 {
+		logs.Info("migrate %d users to projects", migrated)
+	}
+	return nil
+}
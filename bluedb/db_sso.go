@@ -0,0 +1,150 @@
+package bluedb
+
+import (
+	"github.com/astaxie/beego/orm"
+	_ "github.com/go-sql-driver/mysql" // import your used driver
+	"github.com/jack0liu/logs"
+	"github.com/satori/go.uuid"
+	"strings"
+	"time"
+)
+
+const (
+	ssoConfigTable   = "sso_config"
+	ssoIdentityTable = "sso_identity"
+)
+
+// SsoConfig is the OpenID Connect login of a project. Users with an email of
+// EmailDomain log in by the issuer of the project instead of a password.
+type SsoConfig struct {
+	ProjectId    string `orm:"size(64);pk"`
+	Issuer       string `orm:"size(256)"`
+	ClientId     string `orm:"size(256)"`
+	ClientSecret string `orm:"size(512);null"`
+	// extra scopes besides openid, space separated
+	Scopes      string `orm:"size(256);null"`
+	EmailDomain string `orm:"size(128);null"`
+	// claim of the groups of the user, groups by default
+	GroupsClaim string `orm:"size(64);null"`
+	// json object of group to project role
+	RoleMapping string `orm:"type(text);null"`
+	// role of the users in no mapped group, empty to deny them
+	DefaultRole string     `orm:"size(16);null"`
+	Enabled     bool       `orm:"default(true)"`
+	UpdateAt    *time.Time `orm:"auto_now;type(datetime)"`
+}
+
+// SsoIdentity links the subject of an issuer to a user.
+type SsoIdentity struct {
+	Id       string     `orm:"size(64);pk"`
+	Issuer   string     `orm:"size(256)"`
+	Subject  string     `orm:"size(256)"`
+	UserId   string     `orm:"size(64)"`
+	CreateAt *time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+func (i *SsoIdentity) TableUnique() [][]string {
+	return [][]string{
+		{"Issuer", "Subject"},
+	}
+}
+
+func init() {
+	orm.RegisterModel(new(SsoConfig), new(SsoIdentity))
+}
+
+func SaveSsoConfig(c SsoConfig) error {
+	o := orm.NewOrm()
+	c.EmailDomain = strings.ToLower(c.EmailDomain)
+	if err := c.seal(); err != nil {
+		return err
+	}
+	if _, err := o.InsertOrUpdate(&c); err != nil {
+		logs.Error("save sso config of project(%s) fail, err:%s", c.ProjectId, err.Error())
+		return err
+	}
+	return nil
+}
+
+func QuerySsoConfig(projectId string) *SsoConfig {
+	o := orm.NewOrm()
+	c := SsoConfig{ProjectId: projectId}
+	if err := o.Read(&c); err != nil {
+		if err != orm.ErrNoRows {
+			logs.Error("query sso config of project(%s) fail, err:%s", projectId, err.Error())
+		}
+		return nil
+	}
+	c.open()
+	return &c
+}
+
+// QuerySsoConfigByDomain returns the enabled config of the email domain.
+func QuerySsoConfigByDomain(domain string) *SsoConfig {
+	if len(domain) == 0 {
+		return nil
+	}
+	o := orm.NewOrm()
+	c := SsoConfig{}
+	err := o.QueryTable(ssoConfigTable).
+		Filter("email_domain", strings.ToLower(domain)).
+		Filter("enabled", true).
+		One(&c)
+	if err != nil {
+		if err != orm.ErrNoRows {
+			logs.Error("query sso config of domain(%s) fail, err:%s", domain, err.Error())
+		}
+		return nil
+	}
+	c.open()
+	return &c
+}
+
+func DeleteSsoConfig(projectId string) error {
+	o := orm.NewOrm()
+	_, err := o.QueryTable(ssoConfigTable).Filter("project_id", projectId).Delete()
+	if err != nil {
+		logs.Error("delete sso config of project(%s) fail, err:%s", projectId, err.Error())
+	}
+	return err
+}
+
+func QuerySsoIdentity(issuer, subject string) *SsoIdentity {
+	o := orm.NewOrm()
+	i := SsoIdentity{}
+	err := o.QueryTable(ssoIdentityTable).
+		Filter("issuer", issuer).
+		Filter("subject", subject).
+		One(&i)
+	if err != nil {
+		if err != orm.ErrNoRows {
+			logs.Error("query sso identity fail, err:%s", err.Error())
+		}
+		return nil
+	}
+	return &i
+}
+
+func SaveSsoIdentity(issuer, subject, userId string) error {
+	o := orm.NewOrm()
+	i := SsoIdentity{
+		Id:      uuid.NewV4().String(),
+		Issuer:  issuer,
+		Subject: subject,
+		UserId:  userId,
+	}
+	if _, err := o.Insert(&i); err != nil {
+		logs.Error("save sso identity of user(%s) fail, err:%s", userId, err.Error())
+		return err
+	}
+	return nil
+}
+
+func DeleteUserSsoIdentities(userId string) error {
+	o := orm.NewOrm()
+	_, err := o.QueryTable(ssoIdentityTable).Filter("user_id", userId).Delete()
+	if err != nil {
+		logs.Error("delete sso identities of user(%s) fail, err:%s", userId, err.Error())
+	}
+	return err
+}
@@ -8,7 +8,7 @@ import (
 
 type Sys struct {
 	Name        string `orm:"size(128);pk"`
-	Value       string `orm:"size(128)"`
+	Value       string `orm:"size(512)"`
 	Description string `orm:"size(256);null"`
 }
 
@@ -24,5 +24,6 @@ func GetSys(key string) string {
 		logs.Error("get sys err:%s", err.Error())
 		return ""
 	}
+	openSecrets(&sys.Value)
 	return sys.Value
 }
@@ -23,6 +23,8 @@ type Thing struct {
 	EtherAddr   string     `orm:"size(128)"`
 	Description string     `orm:"size(128)"`
 	CreateAt    *time.Time `orm:"auto_now_add;type(datetime)"`
+	Tags        string     `orm:"size(512);null"`
+	LocationId  string     `orm:"size(64);null"`
 }
 
 func init() {
@@ -45,7 +47,7 @@ func SaveThing(t Thing) error {
 
 func UpdateThing(t Thing) error {
 	o := orm.NewOrm()
-	_, err := o.Update(&t, "description")
+	_, err := o.Update(&t, "description", "tags", "location_id")
 	if err != nil {
 		logs.Error("update thing fail.thing: %v", t)
 		return err
@@ -124,8 +126,16 @@ func QueryThings(params map[string]interface{}) []*Thing {
 		qs = qs.Filter("name", name)
 	}
 
+	if tag, ok := params["tag"]; ok {
+		qs = qs.Filter("tags__icontains", tagFilter(tag.(string)))
+	}
+
+	if locationIds, ok := params["location_ids"]; ok {
+		qs = qs.Filter("location_id__in", locationIds)
+	}
+
 	if offset, ok := params["offset"]; ok {
-		qs = qs.Limit(offset.(int))
+		qs = qs.Offset(offset.(int))
 	}
 
 	if limit, ok := params["limit"]; ok {
@@ -139,3 +149,11 @@ func QueryThings(params map[string]interface{}) []*Thing {
 	}
 	return things
 }
+
+func thingNames(params map[string]interface{}) []string {
+	names := make([]string, 0)
+	for _, t := range QueryThings(params) {
+		names = append(names, t.Name)
+	}
+	return names
+}
@@ -0,0 +1,105 @@
+package bluedb
+
+import (
+	"encoding/json"
+	"github.com/astaxie/beego/orm"
+	_ "github.com/go-sql-driver/mysql" // import your used driver
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/common"
+	"time"
+)
+
+const twoFactorTable = "two_factor"
+
+// TwoFactor is the TOTP of a user, it is enabled once the first code is
+// verified. RecoveryCodes is a json list of the hashes of the unused codes.
+type TwoFactor struct {
+	UserId        string     `orm:"size(64);pk"`
+	Secret        string     `orm:"size(512)"`
+	Enabled       bool       `orm:"default(false)"`
+	RecoveryCodes string     `orm:"type(text);null"`
+	UpdateAt      *time.Time `orm:"auto_now;type(datetime)"`
+}
+
+func init() {
+	orm.RegisterModel(new(TwoFactor))
+}
+
+func (t *TwoFactor) RecoveryHashes() []string {
+	var hashes []string
+	if len(t.RecoveryCodes) > 0 {
+		_ = json.Unmarshal([]byte(t.RecoveryCodes), &hashes)
+	}
+	return hashes
+}
+
+func (t *TwoFactor) SetRecoveryHashes(hashes []string) {
+	b, _ := json.Marshal(hashes)
+	t.RecoveryCodes = string(b)
+}
+
+func SaveTwoFactor(t TwoFactor) error {
+	o := orm.NewOrm()
+	if err := t.seal(); err != nil {
+		return err
+	}
+	if _, err := o.InsertOrUpdate(&t); err != nil {
+		logs.Error("save two factor of user(%s) fail, err:%s", t.UserId, err.Error())
+		return err
+	}
+	return nil
+}
+
+func QueryTwoFactor(userId string) *TwoFactor {
+	o := orm.NewOrm()
+	t := TwoFactor{UserId: userId}
+	if err := o.Read(&t); err != nil {
+		if err != orm.ErrNoRows {
+			logs.Error("query two factor of user(%s) fail, err:%s", userId, err.Error())
+		}
+		return nil
+	}
+	t.open()
+	return &t
+}
+
+// UseRecoveryCode removes the hash from the unused codes, it returns false if
+// the code is not an unused one.
+func UseRecoveryCode(userId, hash string) bool {
+	o := orm.NewOrm()
+	if err := o.Begin(); err != nil {
+		logs.Error("begin tx fail, err:%s", err.Error())
+		return false
+	}
+	t := TwoFactor{UserId: userId}
+	if err := o.ReadForUpdate(&t); err != nil {
+		_ = o.Rollback()
+		return false
+	}
+	left, found := common.UseRecoveryHash(t.RecoveryHashes(), hash)
+	if !found {
+		_ = o.Rollback()
+		return false
+	}
+	t.SetRecoveryHashes(left)
+	if _, err := o.Update(&t, "recovery_codes"); err != nil {
+		logs.Error("use recovery code of user(%s) fail, err:%s", userId, err.Error())
+		_ = o.Rollback()
+		return false
+	}
+	if err := o.Commit(); err != nil {
+		logs.Error("commit tx fail, err:%s", err.Error())
+		return false
+	}
+	logs.Info("user(%s) used a recovery code, %d left", userId, len(left))
+	return true
+}
+
+func DeleteTwoFactor(userId string) error {
+	o := orm.NewOrm()
+	_, err := o.QueryTable(twoFactorTable).Filter("user_id", userId).Delete()
+	if err != nil {
+		logs.Error("delete two factor of user(%s) fail, err:%s", userId, err.Error())
+	}
+	return err
+}
@@ -16,8 +16,12 @@ type User struct {
 	Mobile      string `orm:"size(128);null"`
 	Address     string `orm:"size(512);null"`
 	AwsUsername string `orm:"size(128);null"`
-	AccessKey   string `orm:"size(128);null"`
-	SecretKey   string `orm:"size(128);null"`
+	AccessKey   string `orm:"size(512);null"`
+	SecretKey   string `orm:"size(512);null"`
+	// display unit of temperature, C or F
+	TemperatureUnit string `orm:"size(8);null"`
+	// platform role, admin or empty
+	Role string `orm:"size(16);null"`
 }
 
 func init() {
@@ -28,18 +32,24 @@ func CreateUser(user User) string {
 	o := orm.NewOrm()
 	u2 := uuid.NewV4()
 	user.Id = u2.String()
+	if err := user.seal(); err != nil {
+		return ""
+	}
 	// insert
 	id, err := o.Insert(&user)
 	if err != nil {
 		logs.Error("create user fail, err:%s", err.Error())
 	}
 	logs.Info("create user id: %v", id)
-	logs.Info("create user: %v", user)
+	logs.Info("create user: %s", user.Name)
 	return user.Id
 }
 
 func UpdateUser(user User) error {
 	o := orm.NewOrm()
+	if err := user.seal(); err != nil {
+		return err
+	}
 	_, err := o.Update(&user)
 	return err
 }
@@ -100,7 +110,9 @@ func QueryUsers(params map[string]interface{}) []User {
 	} else {
 		o.Raw(sql).QueryRows(&users)
 	}
-
+	for i := range users {
+		users[i].open()
+	}
 	return users
 }
 
@@ -111,6 +123,7 @@ func QueryUserById(id string) (u User, err error) {
 		logs.Error("query user fail: %v", id)
 		return user, err
 	}
+	user.open()
 	return user, nil
 }
 
@@ -134,6 +147,7 @@ func QueryUserByEmail(email string) *User {
 	o := orm.NewOrm()
 	o.Raw(sql, email).QueryRows(&users)
 	if len(users) > 0 {
+		users[0].open()
 		return &users[0]
 	} else {
 		return nil
@@ -158,6 +172,7 @@ func QueryUserByName(name string) (*User, error) {
 	o := orm.NewOrm()
 	o.Raw(sql, name).QueryRows(&users)
 	if len(users) > 0 {
+		users[0].open()
 		return &users[0], nil
 	} else {
 		return nil, nil
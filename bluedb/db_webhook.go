@@ -0,0 +1,176 @@
+package bluedb
+
+import (
+	"github.com/astaxie/beego/orm"
+	_ "github.com/go-sql-driver/mysql" // import your used driver
+	"github.com/jack0liu/logs"
+	"github.com/satori/go.uuid"
+	"time"
+)
+
+const (
+	webhookTable         = "webhook"
+	webhookDeliveryTable = "webhook_delivery"
+
+	DeliverySuccess = "success"
+	DeliveryFailed  = "failed"
+)
+
+// Webhook is a subscription of a project, Events is the comma separated
+// event types pushed to Url besides the readings.
+type Webhook struct {
+	Id           string     `orm:"size(64);pk"`
+	ProjectId    string     `orm:"size(64)"`
+	Url          string     `orm:"size(512)"`
+	Secret       string     `orm:"size(512)"`
+	Events       string     `orm:"size(128);null"`
+	Enabled      bool       `orm:"default(true)"`
+	FailureCount int        `orm:"default(0)"`
+	DisabledAt   *time.Time `orm:"type(datetime);null"`
+	CreateAt     *time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+// WebhookDelivery is the result of a batch pushed to a webhook.
+type WebhookDelivery struct {
+	Id          string     `orm:"size(64);pk"`
+	WebhookId   string     `orm:"size(64)"`
+	ProjectId   string     `orm:"size(64)"`
+	Status      string     `orm:"size(16)"`
+	Attempts    int        `orm:"default(0)"`
+	StatusCode  int        `orm:"default(0)"`
+	Error       string     `orm:"size(512);null"`
+	RecordCount int        `orm:"default(0)"`
+	EventCount  int        `orm:"default(0)"`
+	CreateAt    *time.Time `orm:"auto_now_add;type(datetime)"`
+}
+
+func init() {
+	orm.RegisterModel(new(Webhook), new(WebhookDelivery))
+}
+
+func SaveWebhook(wh Webhook) (string, error) {
+	o := orm.NewOrm()
+	u2 := uuid.NewV4()
+	wh.Id = u2.String()
+	if err := wh.seal(); err != nil {
+		return "", err
+	}
+	// insert
+	_, err := o.Insert(&wh)
+	if err != nil {
+		logs.Error("save webhook fail, err:%s", err.Error())
+		return "", err
+	}
+	logs.Info("save webhook id: %v", wh.Id)
+	return wh.Id, nil
+}
+
+func UpdateWebhook(wh Webhook, cols ...string) error {
+	o := orm.NewOrm()
+	if err := wh.seal(); err != nil {
+		return err
+	}
+	_, err := o.Update(&wh, cols...)
+	if err != nil {
+		logs.Error("update webhook(%s) fail, err:%s", wh.Id, err.Error())
+		return err
+	}
+	return nil
+}
+
+// DeleteWebhook deletes the webhook and its delivery log.
+func DeleteWebhook(id string) error {
+	o := orm.NewOrm()
+	if _, err := o.QueryTable(webhookDeliveryTable).Filter("webhook_id", id).Delete(); err != nil {
+		return err
+	}
+	b := Webhook{Id: id}
+	if _, err := o.Delete(&b); err != nil {
+		return err
+	}
+	logs.Info("delete webhook: %v", id)
+	return nil
+}
+
+func QueryWebhook(projectId, id string) (*Webhook, error) {
+	var list []*Webhook
+	o := orm.NewOrm()
+	qs := o.QueryTable(webhookTable)
+	qs = qs.Filter("project_id", projectId)
+	qs = qs.Filter("id", id)
+	_, err := qs.All(&list)
+	if err != nil {
+		logs.Error("query webhook fail, err:%s", err.Error())
+		return nil, err
+	}
+	if len(list) > 0 {
+		list[0].open()
+		return list[0], nil
+	}
+	return nil, nil
+}
+
+func QueryWebhooks(params map[string]interface{}) []*Webhook {
+	var list []*Webhook
+	o := orm.NewOrm()
+	qs := o.QueryTable(webhookTable)
+
+	if projectId, ok := params["project_id"]; ok {
+		qs = qs.Filter("project_id", projectId)
+	}
+
+	if enabled, ok := params["enabled"]; ok {
+		qs = qs.Filter("enabled", enabled)
+	}
+
+	qs = qs.OrderBy("create_at")
+	_, err := qs.All(&list)
+	if err != nil {
+		logs.Error("query webhooks fail, err:%s", err.Error())
+	}
+	for _, wh := range list {
+		wh.open()
+	}
+	return list
+}
+
+func SaveWebhookDelivery(d WebhookDelivery) error {
+	o := orm.NewOrm()
+	u2 := uuid.NewV4()
+	d.Id = u2.String()
+	if _, err := o.Insert(&d); err != nil {
+		logs.Error("save webhook delivery fail, err:%s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// QueryWebhookDeliveries returns the latest deliveries of the webhook.
+func QueryWebhookDeliveries(webhookId string, offset, limit int) ([]*WebhookDelivery, int64) {
+	var list []*WebhookDelivery
+	o := orm.NewOrm()
+	qs := o.QueryTable(webhookDeliveryTable).Filter("webhook_id", webhookId)
+	count, err := qs.Count()
+	if err != nil {
+		logs.Error("count webhook deliveries fail, err:%s", err.Error())
+	}
+	_, err = qs.OrderBy("-create_at").Offset(offset).Limit(limit).All(&list)
+	if err != nil {
+		logs.Error("query webhook deliveries fail, err:%s", err.Error())
+	}
+	return list, count
+}
+
+// PruneWebhookDeliveries deletes the delivery log before the given time.
+func PruneWebhookDeliveries(before time.Time) error {
+	o := orm.NewOrm()
+	n, err := o.QueryTable(webhookDeliveryTable).Filter("create_at__lt", before).Delete()
+	if err != nil {
+		logs.Error("prune webhook deliveries fail, err:%s", err.Error())
+		return err
+	}
+	if n > 0 {
+		logs.Info("prune %d webhook deliveries", n)
+	}
+	return nil
+}
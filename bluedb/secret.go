@@ -0,0 +1,216 @@
+package bluedb
+
+import (
+	"fmt"
+	"github.com/astaxie/beego/orm"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/common"
+	"strings"
+)
+
+// size of the varchar columns holding sealed secrets
+const secretColumnSize = 512
+
+// the sys values that are secrets
+var sysSecrets = []string{"sysEmailPwd"}
+
+// sealSecrets encrypts the fields in place before they are saved.
+func sealSecrets(fields ...*string) error {
+	for _, f := range fields {
+		v, err := common.EncryptSecret(*f)
+		if err != nil {
+			logs.Error("seal secret fail, err:%s", err.Error())
+			return err
+		}
+		*f = v
+	}
+	return nil
+}
+
+// openSecrets decrypts the fields in place after they are read, a field that
+// can not be opened is cleared.
+func openSecrets(fields ...*string) {
+	for _, f := range fields {
+		v, err := common.DecryptSecret(*f)
+		if err != nil {
+			logs.Error("open secret fail, err:%s", err.Error())
+			v = ""
+		}
+		*f = v
+	}
+}
+
+func (u *User) seal() error {
+	return sealSecrets(&u.AccessKey, &u.SecretKey)
+}
+
+func (u *User) open() {
+	openSecrets(&u.AccessKey, &u.SecretKey)
+}
+
+func (p *Project) seal() error {
+	return sealSecrets(&p.AccessKey, &p.SecretKey)
+}
+
+func (p *Project) open() {
+	openSecrets(&p.AccessKey, &p.SecretKey)
+}
+
+func (b *MqttBridge) seal() error {
+	return sealSecrets(&b.Password, &b.ClientKey)
+}
+
+func (b *MqttBridge) open() {
+	openSecrets(&b.Password, &b.ClientKey)
+}
+
+func (wh *Webhook) seal() error {
+	return sealSecrets(&wh.Secret)
+}
+
+func (wh *Webhook) open() {
+	openSecrets(&wh.Secret)
+}
+
+func (c *SsoConfig) seal() error {
+	return sealSecrets(&c.ClientSecret)
+}
+
+func (c *SsoConfig) open() {
+	openSecrets(&c.ClientSecret)
+}
+
+func (t *TwoFactor) seal() error {
+	return sealSecrets(&t.Secret)
+}
+
+func (t *TwoFactor) open() {
+	openSecrets(&t.Secret)
+}
+
+func (c *Component) seal() error {
+	return sealSecrets(&c.ComponentPassword)
+}
+
+func (c *Component) open() {
+	openSecrets(&c.ComponentPassword)
+}
+
+// secretColumn is a column of secrets, Text columns need no widening.
+type secretColumn struct {
+	Table  string
+	Pk     string
+	Column string
+	Null   bool
+	Text   bool
+}
+
+var secretColumns = []secretColumn{
+	{Table: "user", Pk: "id", Column: "access_key", Null: true},
+	{Table: "user", Pk: "id", Column: "secret_key", Null: true},
+	{Table: projectTable, Pk: "id", Column: "access_key", Null: true},
+	{Table: projectTable, Pk: "id", Column: "secret_key", Null: true},
+	{Table: mqttBridgeTable, Pk: "project_id", Column: "password", Null: true},
+	{Table: mqttBridgeTable, Pk: "project_id", Column: "client_key", Null: true, Text: true},
+	{Table: webhookTable, Pk: "id", Column: "secret"},
+	{Table: ssoConfigTable, Pk: "project_id", Column: "client_secret", Null: true},
+	{Table: twoFactorTable, Pk: "user_id", Column: "secret"},
+	{Table: "component", Pk: "id", Column: "component_password"},
+	{Table: "sys", Pk: "name", Column: "value"},
+}
+
+// widenSecretColumn makes room for the sealed values, syncdb does not alter
+// the columns created before.
+func widenSecretColumn(o orm.Ormer, c secretColumn) error {
+	var size int
+	err := o.Raw("SELECT CHARACTER_MAXIMUM_LENGTH FROM information_schema.columns "+
+		"WHERE table_schema = DATABASE() AND table_name = ? AND column_name = ?",
+		c.Table, c.Column).QueryRow(&size)
+	if err != nil {
+		return err
+	}
+	if size >= secretColumnSize {
+		return nil
+	}
+	null := "NOT NULL DEFAULT ''"
+	if c.Null {
+		null = "NULL"
+	}
+	sql := fmt.Sprintf("ALTER TABLE `%s` MODIFY `%s` varchar(%d) %s",
+		c.Table, c.Column, secretColumnSize, null)
+	if _, err := o.Raw(sql).Exec(); err != nil {
+		return err
+	}
+	logs.Info("widen column %s.%s to %d", c.Table, c.Column, secretColumnSize)
+	return nil
+}
+
+// migrateSecretColumn seals the values saved in plaintext and wraps the data
+// keys of the values sealed by a retired master key with the active one.
+func migrateSecretColumn(o orm.Ormer, c secretColumn) (int, error) {
+	sql := fmt.Sprintf("SELECT `%s`, `%s` FROM `%s` WHERE `%s` IS NOT NULL AND `%s` <> ''",
+		c.Pk, c.Column, c.Table, c.Column, c.Column)
+	var args []interface{}
+	if c.Table == "sys" {
+		sql += " AND name IN (?" + strings.Repeat(", ?", len(sysSecrets)-1) + ")"
+		for _, name := range sysSecrets {
+			args = append(args, name)
+		}
+	}
+	var rows []orm.ParamsList
+	if _, err := o.Raw(sql, args...).ValuesList(&rows); err != nil {
+		return 0, err
+	}
+	update := fmt.Sprintf("UPDATE `%s` SET `%s` = ? WHERE `%s` = ? AND `%s` = ?",
+		c.Table, c.Column, c.Pk, c.Column)
+	migrated := 0
+	for _, row := range rows {
+		pk, _ := row[0].(string)
+		value, _ := row[1].(string)
+		rotated, changed, err := common.RotateSecret(value)
+		if err != nil {
+			// the key of the row is logged, never the value
+			logs.Error("rotate secret %s.%s of %s fail, err:%s", c.Table, c.Column, pk, err.Error())
+			return migrated, err
+		}
+		if !changed {
+			continue
+		}
+		// the row is left alone if it has been saved meanwhile
+		if _, err := o.Raw(update, rotated, pk, value).Exec(); err != nil {
+			return migrated, err
+		}
+		migrated++
+	}
+	return migrated, nil
+}
+
+// MigrateSecrets widens the secret columns, then seals the plaintext
+// secrets and the secrets of retired master keys with the active key. It is
+// run at startup, a master key can be retired once it has run.
+func MigrateSecrets() error {
+	o := orm.NewOrm()
+	for _, c := range secretColumns {
+		if c.Text {
+			continue
+		}
+		if err := widenSecretColumn(o, c); err != nil {
+			logs.Error("widen column %s.%s fail, err:%s", c.Table, c.Column, err.Error())
+			return err
+		}
+	}
+	if !common.SecretsEnabled() {
+		return nil
+	}
+	for _, c := range secretColumns {
+		n, err := migrateSecretColumn(o, c)
+		if err != nil {
+			logs.Error("migrate secrets of %s.%s fail, err:%s", c.Table, c.Column, err.Error())
+			return err
+		}
+		if n > 0 {
+			logs.Info("seal %d secrets of %s.%s with master key %s", n, c.Table, c.Column, common.ActiveSecretKey())
+		}
+	}
+	return nil
+}
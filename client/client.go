@@ -0,0 +1,110 @@
+// Package client is the Go client of the blueserver api. The types and the
+// operations in api.gen.go are generated from api/openapi.json by
+// cmd/openapi.go, this file is the transport they share.
+package client
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client calls the api at BaseUrl, authorized by the session Token or by
+// the ApiKey of a project.
+type Client struct {
+	BaseUrl    string
+	Token      string
+	ApiKey     string
+	HttpClient *http.Client
+}
+
+func New(baseUrl string) *Client {
+	return &Client{
+		BaseUrl:    strings.TrimRight(baseUrl, "/"),
+		HttpClient: http.DefaultClient,
+	}
+}
+
+// ApiError is returned for the responses which are not successful, Body is
+// the error sent by the server.
+type ApiError struct {
+	Status int
+	Body   Error
+}
+
+func (e *ApiError) Error() string {
+	if len(e.Body.Code) == 0 {
+		return fmt.Sprintf("status %d", e.Status)
+	}
+	return fmt.Sprintf("status %d, %s: %s", e.Status, e.Body.Code, e.Body.Message)
+}
+
+// send sends the request with body as json, a response which is not
+// successful is returned as an ApiError.
+func (c *Client) send(ctx context.Context, method, path string, query url.Values, body interface{}) (*http.Response, error) {
+	u := c.BaseUrl + path
+	if len(query) > 0 {
+		u += "?" + query.Encode()
+	}
+	var reader io.Reader
+	if body != nil {
+		b, err := json.Marshal(body)
+		if err != nil {
+			return nil, err
+		}
+		reader = bytes.NewReader(b)
+	}
+	req, err := http.NewRequest(method, u, reader)
+	if err != nil {
+		return nil, err
+	}
+	req = req.WithContext(ctx)
+	if body != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	req.Header.Set("Accept", "application/json")
+	if len(c.Token) > 0 {
+		req.Header.Set("X-Auth-B", c.Token)
+	}
+	if len(c.ApiKey) > 0 {
+		req.Header.Set("X-Api-Key", c.ApiKey)
+	}
+	httpClient := c.HttpClient
+	if httpClient == nil {
+		httpClient = http.DefaultClient
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	if resp.StatusCode >= http.StatusBadRequest {
+		defer resp.Body.Close()
+		apiErr := &ApiError{Status: resp.StatusCode}
+		if b, err := ioutil.ReadAll(resp.Body); err == nil {
+			_ = json.Unmarshal(b, &apiErr.Body)
+		}
+		return nil, apiErr
+	}
+	return resp, nil
+}
+
+// do sends the request and decodes the json response into out, out is nil
+// for the operations without a response body.
+func (c *Client) do(ctx context.Context, method, path string, query url.Values, body, out interface{}) error {
+	resp, err := c.send(ctx, method, path, query, body)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if out == nil {
+		_, _ = io.Copy(ioutil.Discard, resp.Body)
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
@@ -8,7 +8,9 @@ import (
 	"github.com/jack0liu/utils"
 	"github.com/ssrs100/blueserver/awsmqtt"
 	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
 	"github.com/ssrs100/blueserver/influxdb"
+	"github.com/ssrs100/blueserver/metrics"
 	"net/http"
 	"os"
 	"os/signal"
@@ -30,7 +32,13 @@ func main() {
 		logs.Error("%s", err.Error())
 		os.Exit(1)
 	}
+	if err := common.InitSecrets(); err != nil {
+		logs.Error("Can not load master keys %s.", err.Error())
+		os.Exit(1)
+	}
 	influxdb.InitFlux()
+	influxdb.StartWriter()
+	influxdb.StartMktRollup()
 	err := bluedb.InitDB(conf.GetString("db_host"), conf.GetInt("db_port"))
 	if err != nil {
 		errStr := fmt.Sprintf("Can not init db %s.", err.Error())
@@ -38,8 +46,22 @@ func main() {
 		os.Exit(1)
 	}
 	go signalHandle()
+	go shutdownHandle()
 	go startHttp()
 	awsmqtt.InitAwsClient()
+
+	// the clients are stopped, flush the points they left in the writer
+	influxdb.StopMktRollup()
+	influxdb.StopWriter()
+	logs.Info("awsiot stopped")
+}
+
+func shutdownHandle() {
+	ch := make(chan os.Signal, 1)
+	signal.Notify(ch, syscall.SIGTERM)
+	<-ch
+	logs.Info("shutting down awsiot...")
+	awsmqtt.Stop()
 }
 
 func signalHandle() {
@@ -68,7 +90,9 @@ func startHttp() {
 
 	// Set the routes for the application.
 	// Route for health check
-	router.POST("/v1/things/:thingName/start", awsmqtt.StartThing)
+	router.POST("/v1/things/:thingName/start", metrics.Instrument(http.MethodPost, "/v1/things/:thingName/start", awsmqtt.StartThing))
+	// Route for prometheus
+	router.GET("/metrics", metrics.Serve)
 
 	host := conf.GetString("host")
 	port := conf.GetInt("http_port")
@@ -7,8 +7,11 @@ import (
 	"github.com/jack0liu/logs"
 	"github.com/jack0liu/utils"
 	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
 	"github.com/ssrs100/blueserver/controller"
+	"github.com/ssrs100/blueserver/controller/middleware"
 	"github.com/ssrs100/blueserver/influxdb"
+	"github.com/ssrs100/blueserver/metrics"
 	"github.com/ssrs100/blueserver/mqttclient"
 	"github.com/ssrs100/blueserver/sesscache"
 	"net/http"
@@ -21,6 +24,8 @@ var (
 	server_config = "blueserver.json"
 )
 
+const defaultMetricsPort = 9464
+
 // Config struct provides configuration fields for the server.
 type Server struct {
 }
@@ -51,13 +56,34 @@ func Start() error {
 		logs.Error("appConfig %s not found", appConfig)
 		os.Exit(1)
 	}
+	if err := common.InitSecrets(); err != nil {
+		logs.Error("Can not load master keys %s.", err.Error())
+		os.Exit(1)
+	}
 	err := bluedb.InitDB(conf.GetString("db_host"), conf.GetInt("db_port"))
 	if err != nil {
 		errStr := fmt.Sprintf("Can not init db %s.", err.Error())
 		logs.Error(errStr)
 		os.Exit(1)
 	}
+	if err := bluedb.MigrateProjects(); err != nil {
+		errStr := fmt.Sprintf("Can not migrate projects %s.", err.Error())
+		logs.Error(errStr)
+		os.Exit(1)
+	}
+	if err := bluedb.MigrateSecrets(); err != nil {
+		errStr := fmt.Sprintf("Can not migrate secrets %s.", err.Error())
+		logs.Error(errStr)
+		os.Exit(1)
+	}
+	if err := bluedb.MigrateDevices(); err != nil {
+		errStr := fmt.Sprintf("Can not migrate devices %s.", err.Error())
+		logs.Error(errStr)
+		os.Exit(1)
+	}
 	sesscache.InitRedis()
+	middleware.StartCacheSync()
+	controller.StartAuditPrune()
 
 	if len(conf.GetString("mqtt_broker")) > 0 {
 		mc := mqttclient.InitClient()
@@ -67,6 +93,7 @@ func Start() error {
 	influxdb.InitFlux()
 
 	router := s.RegisterRoutes()
+	go startMetrics()
 	host := conf.GetString("host")
 	port := conf.GetInt("port")
 	server := &http.Server{Addr: host + ":" + strconv.Itoa(port), Handler: router}
@@ -84,6 +111,23 @@ func Start() error {
 	return nil
 }
 
+// startMetrics serves /metrics on its own plain http port, so that
+// prometheus scrapes it without the certificate and the api limits.
+func startMetrics() {
+	router := httptreemux.New()
+	router.GET("/metrics", metrics.Serve)
+
+	host := conf.GetString("host")
+	port := conf.GetIntWithDefault("metrics_port", defaultMetricsPort)
+	server := &http.Server{Addr: host + ":" + strconv.Itoa(port), Handler: router}
+
+	logs.Debug("Starting metrics server on port %d", port)
+
+	if err := server.ListenAndServe(); err != nil {
+		logs.Error("metrics ListenAndServe err:%s", err.Error())
+	}
+}
+
 func main() {
 	Start()
 }
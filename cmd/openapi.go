@@ -0,0 +1,73 @@
+// openapi writes the openapi document of the routes to api/openapi.json and
+// the Go client generated from it to client/api.gen.go. Run it from the
+// root of the repository after changing a route or a request or response
+// type:
+//
+//	go run cmd/openapi.go
+//
+// With -check nothing is written, it fails if the committed files are not
+// those the code gives, so that the document and the client can not drift
+// from the handlers.
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"github.com/ssrs100/blueserver/controller"
+	"github.com/ssrs100/blueserver/controller/openapi"
+	"io/ioutil"
+	"os"
+)
+
+func main() {
+	check := flag.Bool("check", false, "fail if the files are not up to date instead of writing them")
+	specFile := flag.String("spec", "api/openapi.json", "the openapi document")
+	clientFile := flag.String("client", "client/api.gen.go", "the generated client")
+	flag.Parse()
+
+	spec, err := controller.ApiDoc()
+	if err != nil {
+		fail("build openapi document fail, err:%s", err.Error())
+	}
+	var doc openapi.Document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		fail("read openapi document fail, err:%s", err.Error())
+	}
+	client, err := openapi.GoClient(&doc, "client", *specFile)
+	if err != nil {
+		fail("generate client fail, err:%s", err.Error())
+	}
+
+	stale := false
+	for _, f := range []struct {
+		name string
+		body []byte
+	}{
+		{*specFile, spec},
+		{*clientFile, client},
+	} {
+		old, _ := ioutil.ReadFile(f.name)
+		if bytes.Equal(old, f.body) {
+			continue
+		}
+		if *check {
+			fmt.Fprintf(os.Stderr, "%s is not up to date, run go run cmd/openapi.go\n", f.name)
+			stale = true
+			continue
+		}
+		if err := ioutil.WriteFile(f.name, f.body, 0644); err != nil {
+			fail("write %s fail, err:%s", f.name, err.Error())
+		}
+		fmt.Printf("wrote %s\n", f.name)
+	}
+	if stale {
+		os.Exit(1)
+	}
+}
+
+func fail(format string, args ...interface{}) {
+	fmt.Fprintf(os.Stderr, format+"\n", args...)
+	os.Exit(1)
+}
@@ -82,13 +82,21 @@ func main() {
 		t := time.Now().Unix()
 		d.Timestamp = t * 1000
 	}
-	data, err := json.Marshal(&rpData)
+	// encoding is json, cbor or proto, binary reports are published with the
+	// encoding suffix, or with the header byte if use_header is set
+	encoding := rpConfig.GetStringWithDefault("encoding", influxdb.EncodingJson)
+	useHeader := rpConfig.GetBoolWithDefault("use_header", false)
+	data, err := influxdb.MarshalReport(encoding, &rpData, useHeader)
 	if err != nil {
 		log.Fatal("marshal fail, err:", err.Error())
 	}
+	pubTopic := topic
+	if encoding != influxdb.EncodingJson && !useHeader {
+		pubTopic = topic + "/" + encoding
+	}
 	
 	// listen
-	reportChan, err := subscribeForThingReport(topic, cli)
+	reportChan, err := subscribeForThingReport(pubTopic, cli)
 	if err != nil {
 		log.Fatal("subscribe thing fail, err:", err.Error())
 	}
@@ -103,7 +111,7 @@ func main() {
 	wg.Add(2)
 	go listen(reportChan, &wg)
 	go listen(echoChan, &wg)
-	res := cli.Publish(topic, 0, false, data)
+	res := cli.Publish(pubTopic, 0, false, data)
 	if res.WaitTimeout(time.Second*5) && res.Error() != nil {
 		log.Fatal("no report.json found", res.Error())
 	}
@@ -118,7 +126,7 @@ func subscribeForThingReport(topic string, cli mqtt.Client) (chan *awsmqtt.Shado
 		0,
 		func(client mqtt.Client, msg mqtt.Message) {
 			tpc := msg.Topic()
-			thing := tpc[len("$aws/things/") : len(tpc)-len("/reports")]
+			thing := strings.Split(tpc, "/")[2]
 			s := awsmqtt.Shadow{
 				Msg:   msg.Payload(),
 				Thing: thing,
@@ -0,0 +1,100 @@
+package common
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// ErrInternalAddress is returned when a webhook or a bridge of a project
+// targets an address of the network of the server.
+var ErrInternalAddress = errors.New("internal address is not allowed")
+
+var internalNets []*net.IPNet
+
+func init() {
+	for _, cidr := range []string{
+		"0.0.0.0/8",
+		"10.0.0.0/8",
+		"100.64.0.0/10",
+		"127.0.0.0/8",
+		"169.254.0.0/16",
+		"172.16.0.0/12",
+		"192.168.0.0/16",
+		"::/128",
+		"::1/128",
+		"fc00::/7",
+		"fe80::/10",
+	} {
+		_, n, _ := net.ParseCIDR(cidr)
+		internalNets = append(internalNets, n)
+	}
+}
+
+// PublicIp tells whether the ip is outside the loopback, link-local and
+// private networks.
+func PublicIp(ip net.IP) bool {
+	if ip.IsMulticast() || ip.IsUnspecified() {
+		return false
+	}
+	for _, n := range internalNets {
+		if n.Contains(ip) {
+			return false
+		}
+	}
+	return true
+}
+
+// CheckPublicHost rejects the host names and ip literals which are internal
+// for sure, the names are checked again by the ips they resolve to when the
+// connection is made.
+func CheckPublicHost(host string) error {
+	host = strings.ToLower(strings.TrimSuffix(host, "."))
+	if host == "localhost" || strings.HasSuffix(host, ".localhost") {
+		return fmt.Errorf("%w: %s", ErrInternalAddress, host)
+	}
+	if ip := net.ParseIP(strings.Trim(host, "[]")); ip != nil && !PublicIp(ip) {
+		return fmt.Errorf("%w: %s", ErrInternalAddress, host)
+	}
+	return nil
+}
+
+// ResolvePublicIp resolves the host and returns its first ip, all ips of the
+// host must be public.
+func ResolvePublicIp(host string) (net.IP, error) {
+	ips, err := net.LookupIP(host)
+	if err != nil {
+		return nil, err
+	}
+	if len(ips) == 0 {
+		return nil, fmt.Errorf("no ip of host(%s)", host)
+	}
+	for _, ip := range ips {
+		if !PublicIp(ip) {
+			return nil, fmt.Errorf("%w: %s resolves to %s", ErrInternalAddress, host, ip)
+		}
+	}
+	return ips[0], nil
+}
+
+// PublicDialer returns a dialer which only connects to public ips. The ip is
+// checked when the socket connects, after the name is resolved, so that a
+// name can not be rebound to an internal address after a check.
+func PublicDialer(timeout time.Duration) *net.Dialer {
+	return &net.Dialer{
+		Timeout: timeout,
+		Control: func(network, address string, c syscall.RawConn) error {
+			host, _, err := net.SplitHostPort(address)
+			if err != nil {
+				return err
+			}
+			if ip := net.ParseIP(host); ip == nil || !PublicIp(ip) {
+				return fmt.Errorf("%w: %s", ErrInternalAddress, address)
+			}
+			return nil
+		},
+	}
+}
@@ -0,0 +1,59 @@
+package common
+
+import (
+	"errors"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestPublicIp(t *testing.T) {
+	for ip, want := range map[string]bool{
+		"8.8.8.8":          true,
+		"2606:4700::1111":  true,
+		"127.0.0.1":        false,
+		"10.1.2.3":         false,
+		"172.20.0.1":       false,
+		"192.168.1.1":      false,
+		"169.254.169.254":  false,
+		"100.64.0.1":       false,
+		"0.0.0.0":          false,
+		"::1":              false,
+		"fe80::1":          false,
+		"fd00::1":          false,
+		"::ffff:127.0.0.1": false,
+	} {
+		if got := PublicIp(net.ParseIP(ip)); got != want {
+			t.Errorf("PublicIp(%s) = %v, want %v", ip, got, want)
+		}
+	}
+}
+
+func TestCheckPublicHost(t *testing.T) {
+	for _, host := range []string{"localhost", "api.localhost", "127.0.0.1", "[::1]", "169.254.169.254"} {
+		if err := CheckPublicHost(host); !errors.Is(err, ErrInternalAddress) {
+			t.Errorf("host %s is accepted", host)
+		}
+	}
+	for _, host := range []string{"example.com", "8.8.8.8"} {
+		if err := CheckPublicHost(host); err != nil {
+			t.Errorf("host %s is rejected, err:%s", host, err.Error())
+		}
+	}
+}
+
+func TestPublicDialerRejectsLoopback(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Skipf("listen fail, err:%s", err.Error())
+	}
+	defer l.Close()
+	conn, err := PublicDialer(time.Second).Dial("tcp", l.Addr().String())
+	if err == nil {
+		conn.Close()
+		t.Fatal("loopback is dialed")
+	}
+	if !errors.Is(err, ErrInternalAddress) {
+		t.Errorf("dial err:%s", err.Error())
+	}
+}
@@ -11,6 +11,7 @@ const (
 	CookieSessionId = "X-SessionID-B"
 
 	XAuthB = "X-Auth-B"
+	XApiKey = "X-Api-Key"
 
 	TestThing = "only_for_test_bs"
 
@@ -25,6 +26,31 @@ const (
 	DataTypeSensor = "sensor"
 )
 
+// fields of the per-thing data completeness counters
+const (
+	CompleteReceived   = "received"
+	CompleteMissing    = "missing"
+	CompleteBackfilled = "backfilled"
+	CompleteLost       = "lost"
+	CompleteUnsaved    = "unsaved" // points received but not written to influxdb
+)
+
+// roles of a user in a project, RoleAdmin is the platform admin which has
+// every permission in every project
+const (
+	RoleViewer   = "viewer"
+	RoleOperator = "operator"
+	RoleOwner    = "owner"
+	RoleAdmin    = "admin"
+)
+
+// scopes of the project api keys
+const (
+	ScopeReadData     = "read-data"
+	ScopeManageThings = "manage-things"
+	ScopeManageAlerts = "manage-alerts"
+)
+
 func GenToken(id, passwd string) string {
 	hash := sha512.New()
 	return string(hash.Sum([]byte(id + passwd)))
@@ -41,3 +67,13 @@ func NoticeKey(projectId, key string) string {
 func SessionKey(thing, sessionId string) string {
 	return "session_" + thing + "_" + sessionId
 }
+
+// LossGapsKey is the hash of the outstanding sequence gaps of the things of
+// a project, by session.
+func LossGapsKey(projectId string) string {
+	return "loss_gaps_" + projectId
+}
+
+func CompletenessKey(thing string) string {
+	return "complete_" + thing
+}
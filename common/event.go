@@ -0,0 +1,29 @@
+package common
+
+const (
+	EventReading = "reading"
+	EventStatus  = "status"
+	EventAlert   = "alert"
+
+	MetricTemperature = "temperature"
+	MetricHumidity    = "humidity"
+)
+
+// Event is published by the ingestion service to the redis channel of the
+// project, see EventChannel.
+type Event struct {
+	Type      string                 `json:"type"`
+	ProjectId string                 `json:"project_id"`
+	Thing     string                 `json:"thing,omitempty"`
+	Device    string                 `json:"device,omitempty"`
+	Metric    string                 `json:"metric,omitempty"` // the metric of an alert
+	Timestamp int64                  `json:"timestamp"`
+	Data      map[string]interface{} `json:"data,omitempty"`
+}
+
+func EventChannel(projectId string) string {
+	return "events_" + projectId
+}
+
+// EventChannelPattern matches the event channels of all projects.
+const EventChannelPattern = "events_*"
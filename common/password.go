@@ -0,0 +1,59 @@
+package common
+
+import (
+	"crypto/subtle"
+	"golang.org/x/crypto/bcrypt"
+	"strings"
+	"sync"
+)
+
+const (
+	PasswdMinLen = 7
+	PasswdMaxLen = 120
+
+	passwdCost = 12
+)
+
+var (
+	dummyHash     []byte
+	dummyHashOnce sync.Once
+)
+
+// HashPasswd returns the bcrypt hash of the password.
+func HashPasswd(passwd string) (string, error) {
+	hash, err := bcrypt.GenerateFromPassword([]byte(passwd), passwdCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hash), nil
+}
+
+func isPasswdHash(stored string) bool {
+	return strings.HasPrefix(stored, "$2a$") || strings.HasPrefix(stored, "$2b$") ||
+		strings.HasPrefix(stored, "$2y$")
+}
+
+// CheckPasswd compares the password with the stored one, rehash is true if
+// the stored password is plaintext or hashed with a lower cost, the caller
+// then saves the new hash.
+func CheckPasswd(stored, passwd string) (ok bool, rehash bool) {
+	if !isPasswdHash(stored) {
+		// passwords saved before hashing was introduced
+		ok = len(stored) > 0 && subtle.ConstantTimeCompare([]byte(stored), []byte(passwd)) == 1
+		return ok, ok
+	}
+	if bcrypt.CompareHashAndPassword([]byte(stored), []byte(passwd)) != nil {
+		return false, false
+	}
+	cost, err := bcrypt.Cost([]byte(stored))
+	return true, err == nil && cost < passwdCost
+}
+
+// CheckNoPasswd spends the time of a password check for a login of an unknown
+// user, so that the response time does not tell whether the user exists.
+func CheckNoPasswd(passwd string) {
+	dummyHashOnce.Do(func() {
+		dummyHash, _ = bcrypt.GenerateFromPassword([]byte("no user"), passwdCost)
+	})
+	_ = bcrypt.CompareHashAndPassword(dummyHash, []byte(passwd))
+}
@@ -0,0 +1,253 @@
+package common
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"io/ioutil"
+	"os"
+	"strings"
+)
+
+// Secrets are sealed with a random data key per value, the data key is
+// wrapped by a master key of the keyring. The master keys are read from the
+// env BLUE_SECRET_KEYS, or from the file of the conf secret_keys_file, as
+// lines or comma separated items of kid=base64 of 32 bytes. The first key
+// seals new values, the others only open the values sealed before a
+// rotation.
+const (
+	SecretKeysEnv = "BLUE_SECRET_KEYS"
+
+	secretPrefix = "enc:v1:"
+	secretKeyLen = 32
+)
+
+var (
+	ErrSecretFormat = errors.New("malformed sealed secret")
+
+	secretEncoding = base64.RawStdEncoding
+
+	secretKeys   map[string]cipher.AEAD
+	activeKeyId  string
+	secretKeySet bool
+)
+
+func parseSecretKeys(text string) (map[string]cipher.AEAD, string, error) {
+	keys := make(map[string]cipher.AEAD)
+	active := ""
+	items := strings.FieldsFunc(text, func(r rune) bool {
+		return r == '\n' || r == '\r' || r == ','
+	})
+	for _, item := range items {
+		item = strings.TrimSpace(item)
+		if len(item) == 0 || strings.HasPrefix(item, "#") {
+			continue
+		}
+		kv := strings.SplitN(item, "=", 2)
+		if len(kv) != 2 || len(kv[0]) == 0 || strings.Contains(kv[0], ":") {
+			return nil, "", errors.New("master key must be kid=base64")
+		}
+		kid := strings.TrimSpace(kv[0])
+		raw, err := base64.StdEncoding.DecodeString(strings.TrimSpace(kv[1]))
+		if err != nil || len(raw) != secretKeyLen {
+			return nil, "", fmt.Errorf("master key %s must be base64 of %d bytes", kid, secretKeyLen)
+		}
+		if _, ok := keys[kid]; ok {
+			return nil, "", fmt.Errorf("master key %s duplicated", kid)
+		}
+		aead, err := newAead(raw)
+		if err != nil {
+			return nil, "", err
+		}
+		keys[kid] = aead
+		if len(active) == 0 {
+			active = kid
+		}
+	}
+	return keys, active, nil
+}
+
+// InitSecrets loads the master keys, secrets are kept as they are if no key
+// is configured.
+func InitSecrets() error {
+	text := os.Getenv(SecretKeysEnv)
+	if len(text) == 0 {
+		if file := conf.GetString("secret_keys_file"); len(file) > 0 {
+			b, err := ioutil.ReadFile(file)
+			if err != nil {
+				return err
+			}
+			text = string(b)
+		}
+	}
+	keys, active, err := parseSecretKeys(text)
+	if err != nil {
+		return err
+	}
+	secretKeys, activeKeyId, secretKeySet = keys, active, len(active) > 0
+	if !secretKeySet {
+		logs.Warn("no master key configured, secrets are stored unencrypted")
+		return nil
+	}
+	logs.Info("load %d master keys, active key %s", len(keys), active)
+	return nil
+}
+
+// SecretsEnabled tells whether new secrets are sealed.
+func SecretsEnabled() bool {
+	return secretKeySet
+}
+
+// ActiveSecretKey is the id of the master key sealing new secrets.
+func ActiveSecretKey() string {
+	return activeKeyId
+}
+
+func newAead(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+func aeadSeal(aead cipher.AEAD, plain, data []byte) ([]byte, error) {
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return nil, err
+	}
+	return aead.Seal(nonce, nonce, plain, data), nil
+}
+
+func aeadOpen(aead cipher.AEAD, sealed, data []byte) ([]byte, error) {
+	if len(sealed) < aead.NonceSize() {
+		return nil, ErrSecretFormat
+	}
+	n := aead.NonceSize()
+	return aead.Open(nil, sealed[:n], sealed[n:], data)
+}
+
+// IsSealedSecret tells whether the value is sealed by EncryptSecret.
+func IsSealedSecret(value string) bool {
+	return strings.HasPrefix(value, secretPrefix)
+}
+
+// sealedParts splits enc:v1:<kid>:<wrapped data key>:<ciphertext>.
+func sealedParts(value string) (kid string, wrapped, ct []byte, err error) {
+	parts := strings.Split(strings.TrimPrefix(value, secretPrefix), ":")
+	if len(parts) != 3 {
+		return "", nil, nil, ErrSecretFormat
+	}
+	if wrapped, err = secretEncoding.DecodeString(parts[1]); err != nil {
+		return "", nil, nil, ErrSecretFormat
+	}
+	if ct, err = secretEncoding.DecodeString(parts[2]); err != nil {
+		return "", nil, nil, ErrSecretFormat
+	}
+	return parts[0], wrapped, ct, nil
+}
+
+func joinSealed(kid string, wrapped, ct []byte) string {
+	return secretPrefix + kid + ":" + secretEncoding.EncodeToString(wrapped) + ":" +
+		secretEncoding.EncodeToString(ct)
+}
+
+// the kid is bound to the wrapped key so that it can not be swapped
+func wrapDataKey(kid string, dataKey []byte) ([]byte, error) {
+	return aeadSeal(secretKeys[kid], dataKey, []byte(kid))
+}
+
+func unwrapDataKey(kid string, wrapped []byte) ([]byte, error) {
+	kek, ok := secretKeys[kid]
+	if !ok {
+		return nil, fmt.Errorf("unknown master key %s", kid)
+	}
+	dataKey, err := aeadOpen(kek, wrapped, []byte(kid))
+	if err != nil {
+		return nil, fmt.Errorf("unwrap data key with master key %s fail", kid)
+	}
+	return dataKey, nil
+}
+
+// EncryptSecret seals the value with the active master key. Empty and sealed
+// values are returned as they are, so are all values if no key is
+// configured.
+func EncryptSecret(value string) (string, error) {
+	if len(value) == 0 || IsSealedSecret(value) || !secretKeySet {
+		return value, nil
+	}
+	dataKey := make([]byte, secretKeyLen)
+	if _, err := rand.Read(dataKey); err != nil {
+		return "", err
+	}
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return "", err
+	}
+	ct, err := aeadSeal(aead, []byte(value), nil)
+	if err != nil {
+		return "", err
+	}
+	wrapped, err := wrapDataKey(activeKeyId, dataKey)
+	if err != nil {
+		return "", err
+	}
+	return joinSealed(activeKeyId, wrapped, ct), nil
+}
+
+// DecryptSecret opens a sealed value, values saved before encryption was
+// enabled are returned as they are.
+func DecryptSecret(value string) (string, error) {
+	if !IsSealedSecret(value) {
+		return value, nil
+	}
+	kid, wrapped, ct, err := sealedParts(value)
+	if err != nil {
+		return "", err
+	}
+	dataKey, err := unwrapDataKey(kid, wrapped)
+	if err != nil {
+		return "", err
+	}
+	aead, err := newAead(dataKey)
+	if err != nil {
+		return "", err
+	}
+	plain, err := aeadOpen(aead, ct, nil)
+	if err != nil {
+		return "", ErrSecretFormat
+	}
+	return string(plain), nil
+}
+
+// RotateSecret returns the value sealed with the active master key, only the
+// data key is wrapped again. changed is false if nothing is to be done.
+func RotateSecret(value string) (rotated string, changed bool, err error) {
+	if len(value) == 0 || !secretKeySet {
+		return value, false, nil
+	}
+	if !IsSealedSecret(value) {
+		rotated, err = EncryptSecret(value)
+		return rotated, err == nil, err
+	}
+	kid, wrapped, ct, err := sealedParts(value)
+	if err != nil {
+		return "", false, err
+	}
+	if kid == activeKeyId {
+		return value, false, nil
+	}
+	dataKey, err := unwrapDataKey(kid, wrapped)
+	if err != nil {
+		return "", false, err
+	}
+	if wrapped, err = wrapDataKey(activeKeyId, dataKey); err != nil {
+		return "", false, err
+	}
+	return joinSealed(activeKeyId, wrapped, ct), true, nil
+}
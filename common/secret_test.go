@@ -0,0 +1,164 @@
+package common
+
+import (
+	"encoding/base64"
+	"strings"
+	"testing"
+)
+
+func testMasterKey(b byte) string {
+	return base64.StdEncoding.EncodeToString([]byte(strings.Repeat(string([]byte{b}), secretKeyLen)))
+}
+
+// useSecretKeys loads the keyring of the text until the test ends.
+func useSecretKeys(t *testing.T, text string) {
+	keys, active, err := parseSecretKeys(text)
+	if err != nil {
+		t.Fatalf("parse master keys fail, err:%s", err.Error())
+	}
+	oldKeys, oldActive, oldSet := secretKeys, activeKeyId, secretKeySet
+	secretKeys, activeKeyId, secretKeySet = keys, active, len(active) > 0
+	t.Cleanup(func() {
+		secretKeys, activeKeyId, secretKeySet = oldKeys, oldActive, oldSet
+	})
+}
+
+func mustEncrypt(t *testing.T, value string) string {
+	sealed, err := EncryptSecret(value)
+	if err != nil {
+		t.Fatalf("encrypt fail, err:%s", err.Error())
+	}
+	return sealed
+}
+
+func TestSecretRoundTrip(t *testing.T) {
+	useSecretKeys(t, "k1="+testMasterKey(1))
+	sealed := mustEncrypt(t, "aws-secret")
+	if !IsSealedSecret(sealed) || strings.Contains(sealed, "aws-secret") {
+		t.Fatalf("value is not sealed: %s", sealed)
+	}
+	if !strings.HasPrefix(sealed, secretPrefix+"k1:") {
+		t.Errorf("value is not sealed by the active key: %s", sealed)
+	}
+	if again := mustEncrypt(t, "aws-secret"); again == sealed {
+		t.Errorf("same value is sealed to the same text")
+	}
+	if plain, err := DecryptSecret(sealed); err != nil || plain != "aws-secret" {
+		t.Errorf("decrypt = %q, %v", plain, err)
+	}
+	if v := mustEncrypt(t, sealed); v != sealed {
+		t.Errorf("sealed value is sealed again")
+	}
+	if v := mustEncrypt(t, ""); v != "" {
+		t.Errorf("empty value is sealed to %q", v)
+	}
+}
+
+func TestSecretRotation(t *testing.T) {
+	useSecretKeys(t, "old="+testMasterKey(1))
+	sealed := mustEncrypt(t, "aws-secret")
+
+	useSecretKeys(t, "new="+testMasterKey(2)+"\nold="+testMasterKey(1))
+	if plain, err := DecryptSecret(sealed); err != nil || plain != "aws-secret" {
+		t.Fatalf("decrypt after rotation = %q, %v", plain, err)
+	}
+	rotated, changed, err := RotateSecret(sealed)
+	if err != nil || !changed {
+		t.Fatalf("rotate = %v, %v", changed, err)
+	}
+	if !strings.HasPrefix(rotated, secretPrefix+"new:") {
+		t.Errorf("rotated value is not sealed by the active key: %s", rotated)
+	}
+	// only the data key is wrapped again
+	_, _, ct, _ := sealedParts(sealed)
+	_, _, rotatedCt, _ := sealedParts(rotated)
+	if string(ct) != string(rotatedCt) {
+		t.Errorf("rotation changes the ciphertext")
+	}
+	if again, changed, err := RotateSecret(rotated); err != nil || changed || again != rotated {
+		t.Errorf("rotate of an active value = %v, %v", changed, err)
+	}
+
+	// the old key can be retired once the values are rotated
+	useSecretKeys(t, "new="+testMasterKey(2))
+	if plain, err := DecryptSecret(rotated); err != nil || plain != "aws-secret" {
+		t.Errorf("decrypt of the rotated value = %q, %v", plain, err)
+	}
+}
+
+func TestSecretUnknownKey(t *testing.T) {
+	useSecretKeys(t, "k1="+testMasterKey(1))
+	sealed := mustEncrypt(t, "aws-secret")
+
+	useSecretKeys(t, "k2="+testMasterKey(2))
+	if _, err := DecryptSecret(sealed); err == nil {
+		t.Errorf("value of an unknown master key is opened")
+	}
+	if _, _, err := RotateSecret(sealed); err == nil {
+		t.Errorf("value of an unknown master key is rotated")
+	}
+}
+
+func TestSecretTampered(t *testing.T) {
+	useSecretKeys(t, "k1="+testMasterKey(1)+",k2="+testMasterKey(2))
+	sealed := mustEncrypt(t, "aws-secret")
+	kid, wrapped, ct, err := sealedParts(sealed)
+	if err != nil || kid != "k1" {
+		t.Fatalf("split sealed value = %s, %v", kid, err)
+	}
+
+	flipped := append([]byte(nil), ct...)
+	flipped[len(flipped)-1] ^= 1
+	if _, err := DecryptSecret(joinSealed(kid, wrapped, flipped)); err == nil {
+		t.Errorf("tampered ciphertext is opened")
+	}
+	// the wrapped key is bound to its kid
+	if _, err := DecryptSecret(joinSealed("k2", wrapped, ct)); err == nil {
+		t.Errorf("value with a swapped kid is opened")
+	}
+	for _, v := range []string{secretPrefix + "k1", secretPrefix + "k1:!:!", sealed + ":x"} {
+		if _, err := DecryptSecret(v); err == nil {
+			t.Errorf("malformed value %q is opened", v)
+		}
+	}
+}
+
+func TestSecretLegacyPlaintext(t *testing.T) {
+	useSecretKeys(t, "")
+	if v := mustEncrypt(t, "aws-secret"); v != "aws-secret" {
+		t.Errorf("value is sealed without a master key: %s", v)
+	}
+
+	useSecretKeys(t, "k1="+testMasterKey(1))
+	if IsSealedSecret("aws-secret") {
+		t.Errorf("plaintext is taken as sealed")
+	}
+	if plain, err := DecryptSecret("aws-secret"); err != nil || plain != "aws-secret" {
+		t.Errorf("decrypt of plaintext = %q, %v", plain, err)
+	}
+	rotated, changed, err := RotateSecret("aws-secret")
+	if err != nil || !changed || !IsSealedSecret(rotated) {
+		t.Fatalf("rotate of plaintext = %q, %v, %v", rotated, changed, err)
+	}
+	if plain, err := DecryptSecret(rotated); err != nil || plain != "aws-secret" {
+		t.Errorf("decrypt of the sealed plaintext = %q, %v", plain, err)
+	}
+}
+
+func TestParseSecretKeys(t *testing.T) {
+	keys, active, err := parseSecretKeys("# keyring\nk2=" + testMasterKey(2) + "\n\nk1=" + testMasterKey(1))
+	if err != nil || len(keys) != 2 || active != "k2" {
+		t.Errorf("parse keyring = %d keys, active %s, %v", len(keys), active, err)
+	}
+	for _, text := range []string{
+		"k1",
+		"=" + testMasterKey(1),
+		"k:1=" + testMasterKey(1),
+		"k1=" + base64.StdEncoding.EncodeToString([]byte("short")),
+		"k1=" + testMasterKey(1) + ",k1=" + testMasterKey(2),
+	} {
+		if _, _, err := parseSecretKeys(text); err == nil {
+			t.Errorf("keyring %q is accepted", text)
+		}
+	}
+}
@@ -0,0 +1,86 @@
+package common
+
+import (
+	"testing"
+	"time"
+)
+
+// base32 of the ascii key 12345678901234567890 of rfc 6238
+const rfcTotpSecret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+
+func TestTotpRfcVectors(t *testing.T) {
+	// the sha1 vectors of rfc 6238, the last 6 of the 8 digits
+	for unix, code := range map[int64]string{
+		59:          "287082",
+		1111111109:  "081804",
+		1111111111:  "050471",
+		1234567890:  "005924",
+		2000000000:  "279037",
+		20000000000: "353130",
+	} {
+		step, ok := CheckTotp(rfcTotpSecret, code, time.Unix(unix, 0))
+		if !ok || step != unix/totpPeriod {
+			t.Errorf("code %s at %d = step %d, %v", code, unix, step, ok)
+		}
+	}
+	if _, ok := CheckTotp(rfcTotpSecret, "287083", time.Unix(59, 0)); ok {
+		t.Errorf("wrong code is accepted")
+	}
+	if _, ok := CheckTotp(rfcTotpSecret, "28708", time.Unix(59, 0)); ok {
+		t.Errorf("short code is accepted")
+	}
+	if _, ok := CheckTotp("not base32!", "287082", time.Unix(59, 0)); ok {
+		t.Errorf("code of an invalid secret is accepted")
+	}
+}
+
+func TestTotpSkew(t *testing.T) {
+	// the code of step 37037036 is 081804
+	at := time.Unix(1111111109, 0)
+	for _, c := range []struct {
+		offset time.Duration
+		ok     bool
+	}{
+		{0, true},
+		{-totpPeriod * time.Second, true},
+		{totpPeriod * time.Second, true},
+		{-2 * totpPeriod * time.Second, false},
+		{2 * totpPeriod * time.Second, false},
+	} {
+		step, ok := CheckTotp(rfcTotpSecret, "081804", at.Add(c.offset))
+		if ok != c.ok || (ok && step != at.Unix()/totpPeriod) {
+			t.Errorf("code checked %s off = step %d, %v, want %v", c.offset, step, ok, c.ok)
+		}
+	}
+}
+
+func TestRecoveryCodes(t *testing.T) {
+	codes, hashes, err := NewRecoveryCodes()
+	if err != nil || len(codes) != RecoveryCodeCount || len(hashes) != RecoveryCodeCount {
+		t.Fatalf("new recovery codes = %d codes, %d hashes, %v", len(codes), len(hashes), err)
+	}
+	seen := make(map[string]bool)
+	for i, code := range codes {
+		if hashes[i] != HashRecoveryCode(code) || seen[code] {
+			t.Errorf("recovery code %d is duplicated or not hashed", i)
+		}
+		seen[code] = true
+	}
+	if HashRecoveryCode("abcde-12345") != HashRecoveryCode(" ABCDE12345 ") {
+		t.Errorf("hash depends on case, spaces or dashes")
+	}
+	if HashRecoveryCode("abcde-12345") == HashRecoveryCode("abcde-12346") {
+		t.Errorf("different codes have the same hash")
+	}
+
+	left, ok := UseRecoveryHash(hashes, HashRecoveryCode(codes[3]))
+	if !ok || len(left) != RecoveryCodeCount-1 {
+		t.Fatalf("use recovery code = %d left, %v", len(left), ok)
+	}
+	if _, ok := UseRecoveryHash(left, HashRecoveryCode(codes[3])); ok {
+		t.Errorf("recovery code is used twice")
+	}
+	if _, ok := UseRecoveryHash(left, HashRecoveryCode("00000-00000")); ok {
+		t.Errorf("unknown recovery code is used")
+	}
+}
@@ -0,0 +1,207 @@
+package controller
+
+import (
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/controller/aws"
+	"github.com/ssrs100/blueserver/controller/openapi"
+	"github.com/ssrs100/blueserver/influxdb"
+	"github.com/ssrs100/blueserver/model"
+	"net/http"
+	"sync"
+)
+
+const (
+	apiTitle   = "blueserver"
+	apiVersion = "1.0"
+)
+
+// the query parameters shared by the routes
+var (
+	pageQuery     = []string{"limit", "offset"}
+	formatQuery   = []string{"type", "unit", "raw"}
+	rangeQuery    = []string{"startAt", "endAt", "type", "unit", "raw"}
+	groupQuery    = []string{"startAt", "endAt", "interval", "measurement", "unit", "raw"}
+	selectorQuery = []string{"group", "tag", "deviceAddrs"}
+	streamQuery   = []string{"type", "thing", "device", "metric", "unit", "raw", "token"}
+	auditQuery    = []string{"actor_id", "action", "target", "since", "until"}
+)
+
+func withQuery(lists ...[]string) []string {
+	var query []string
+	for _, l := range lists {
+		query = append(query, l...)
+	}
+	return query
+}
+
+// routeDocs is the operation of every route in the openapi document, the
+// request and the response are values of the types the handler decodes and
+// sends. A route without an entry can not be registered, so the document
+// and the routes do not drift apart; cmd/openapi.go -check tells when the
+// committed document is behind the types.
+var routeDocs = map[string]openapi.Route{
+	"GET /v1/heart":                                   {Id: "health", Summary: "Check the server is up"},
+	"GET /v1/openapi.json":                            {Id: "getOpenApi", Summary: "Get this document", Response: map[string]interface{}{}},
+	"GET /active":                                     {Id: "activateUser", Summary: "Activate the user by the token of the activation mail", Query: []string{"token"}, Status: http.StatusSeeOther},
+	"POST /v1/users/verify":                           {Id: "sendVerifyMail", Summary: "Send the verification mail again", Request: Verify{}},
+	"POST /v1/users/password/forgot":                  {Id: "forgotPassword", Summary: "Send the mail to reset the password", Request: Verify{}},
+	"POST /v1/users/password/reset":                   {Id: "resetPassword", Summary: "Reset the password by the token of the mail", Request: ResetPassword{}},
+	"PUT /v1/users/:projectId/password":               {Id: "changePassword", Summary: "Change the password of the user", Request: ChangePassword{}},
+	"GET /v1/users":                                   {Id: "listUsers", Summary: "List the users", Query: withQuery(pageQuery, []string{"name"}), Response: []User{}},
+	"GET /v1/users/:projectId":                        {Id: "getUser", Summary: "Get the user", Response: User{}},
+	"POST /v1/users":                                  {Id: "createUser", Summary: "Sign up, the user is activated by the mail", Request: User{}},
+	"POST /v1/users/login":                            {Id: "login", Summary: "Log in with the password", Request: User{}, Response: UserLoginResponse{}},
+	"POST /v1/users/token/refresh":                    {Id: "refreshToken", Summary: "Exchange the refresh token for a new token pair", Request: RefreshTokenReq{}, Response: UserLoginResponse{}},
+	"POST /v1/users/logout":                           {Id: "logout", Summary: "Revoke the session of the token"},
+	"GET /v1/users/:projectId/sessions":               {Id: "listSessions", Summary: "List the sessions of the user", Response: SessionsWrap{}},
+	"DELETE /v1/users/:projectId/sessions":            {Id: "revokeSessions", Summary: "Revoke the other sessions of the user"},
+	"DELETE /v1/users/:projectId/sessions/:sessionId": {Id: "revokeSession", Summary: "Revoke a session of the user"},
+	"DELETE /v1/users/:projectId":                     {Id: "deleteUser", Summary: "Delete the user"},
+	"POST /v1/users/:projectId":                       {Id: "bindAwsUser", Summary: "Bind the aws account of the project", Request: BindAwsUserReq{}},
+	"POST /v1/users/login/2fa":                        {Id: "loginTwoFactor", Summary: "Finish the login with the second factor", Request: TwoFactorReq{}, Response: UserLoginResponse{}},
+	"GET /v1/users/:projectId/2fa":                    {Id: "getTwoFactor", Summary: "Get the two factor state of the user", Response: TwoFactorInfo{}},
+	"POST /v1/users/:projectId/2fa":                   {Id: "enrollTwoFactor", Summary: "Start the two factor enrollment", Response: TwoFactorEnrollResp{}},
+	"POST /v1/users/:projectId/2fa/activate":          {Id: "activateTwoFactor", Summary: "Activate two factor login with a code", Request: TwoFactorReq{}, Response: RecoveryCodesResp{}},
+	"DELETE /v1/users/:projectId/2fa":                 {Id: "disableTwoFactor", Summary: "Disable two factor login", Request: TwoFactorReq{}},
+	"POST /v1/users/:projectId/2fa/recovery-codes":    {Id: "regenerateRecoveryCodes", Summary: "Replace the recovery codes", Request: TwoFactorReq{}, Response: RecoveryCodesResp{}},
+
+	// projects
+	"GET /v1/projects":                                         {Id: "listProjects", Summary: "List the projects of the user", Response: []*ProjectInfo{}},
+	"POST /v1/projects":                                        {Id: "createProject", Summary: "Create a project owned by the user", Request: ProjectReq{}, Response: ProjectInfo{}, Status: http.StatusCreated},
+	"GET /v1/projects/:projectId":                              {Id: "getProject", Summary: "Get the project", Response: ProjectInfo{}},
+	"PUT /v1/projects/:projectId":                              {Id: "updateProject", Summary: "Update the project", Request: ProjectReq{}},
+	"GET /v1/projects/:projectId/members":                      {Id: "listMembers", Summary: "List the members of the project", Response: []*Member{}},
+	"PUT /v1/projects/:projectId/members/:userId":              {Id: "updateMember", Summary: "Change the role of a member", Request: MemberReq{}},
+	"DELETE /v1/projects/:projectId/members/:userId":           {Id: "removeMember", Summary: "Remove a member from the project"},
+	"GET /v1/projects/:projectId/invitations":                  {Id: "listInvitations", Summary: "List the pending invitations", Response: []*InvitationInfo{}},
+	"POST /v1/projects/:projectId/invitations":                 {Id: "inviteMember", Summary: "Invite a user by mail", Request: InvitationReq{}, Response: map[string]string{}, Status: http.StatusCreated},
+	"DELETE /v1/projects/:projectId/invitations/:invitationId": {Id: "revokeInvitation", Summary: "Revoke an invitation"},
+	"POST /v1/invitations/accept":                              {Id: "acceptInvitation", Summary: "Join the project of the invitation", Request: AcceptInvitationReq{}},
+	"GET /v1/projects/:projectId/api-keys":                     {Id: "listApiKeys", Summary: "List the api keys of the project", Response: []*ApiKeyInfo{}},
+	"POST /v1/projects/:projectId/api-keys":                    {Id: "createApiKey", Summary: "Create an api key, the key is only returned here", Request: ApiKeyReq{}, Response: ApiKeyInfo{}, Status: http.StatusCreated},
+	"DELETE /v1/projects/:projectId/api-keys/:keyId":           {Id: "revokeApiKey", Summary: "Revoke an api key"},
+	"GET /v1/projects/:projectId/sso":                          {Id: "getSsoConfig", Summary: "Get the single sign on config", Response: SsoConfigReq{}},
+	"PUT /v1/projects/:projectId/sso":                          {Id: "putSsoConfig", Summary: "Set the single sign on config", Request: SsoConfigReq{}},
+	"DELETE /v1/projects/:projectId/sso":                       {Id: "deleteSsoConfig", Summary: "Remove the single sign on config"},
+	"GET /v1/projects/:projectId/audit-logs":                   {Id: "listAuditLogs", Summary: "List the audit log of the project", Query: withQuery(auditQuery, pageQuery), Response: AuditLogsWrap{}},
+	"GET /v1/projects/:projectId/audit-logs/export":            {Id: "exportAuditLogs", Summary: "Export the audit log of the project as csv or json lines", Query: withQuery(auditQuery, []string{"format"}), ContentType: "text/csv"},
+	"GET /v1/audit-logs":                                       {Id: "listAllAuditLogs", Summary: "List the audit log of all projects", Query: withQuery([]string{"project_id"}, auditQuery, pageQuery), Response: AuditLogsWrap{}},
+	"GET /v1/audit-logs/export":                                {Id: "exportAllAuditLogs", Summary: "Export the audit log of all projects as csv or json lines", Query: withQuery([]string{"project_id"}, auditQuery, []string{"format"}), ContentType: "text/csv"},
+	"GET /v1/sso/discover":                                     {Id: "ssoDiscover", Summary: "Find the single sign on of the domain of the email", Query: []string{"email"}, Response: SsoDiscoverResp{}},
+	"GET /v1/sso/:projectId/login":                             {Id: "ssoLogin", Summary: "Redirect to the identity provider of the project", Status: http.StatusFound},
+	"GET /v1/sso/callback":                                     {Id: "ssoCallback", Summary: "Finish the single sign on and redirect to the web", Query: []string{"code", "state"}, Status: http.StatusFound},
+
+	// beacons, attachments and components
+	"POST /proximity/v1/:projectId/beacons":                                        {Id: "registerBeacon", Summary: "Register a beacon", Request: Beacon{}, Response: CreateBeaconResponse{}},
+	"GET /proximity/v1/:projectId/beacons":                                         {Id: "listBeacons", Summary: "List the beacons", Query: pageQuery, Response: []Beacon{}},
+	"DELETE /proximity/v1/:projectId/beacons/:beaconId":                            {Id: "deleteBeacon", Summary: "Delete a beacon"},
+	"PUT /proximity/v1/:projectId/beacons/:beaconId":                               {Id: "updateBeacon", Summary: "Update a beacon", Request: Beacon{}},
+	"POST /proximity/v1/:projectId/beacons/:beaconId/active":                       {Id: "activateBeacon", Summary: "Activate a beacon"},
+	"POST /proximity/v1/:projectId/beacons/:beaconId/deactive":                     {Id: "deactivateBeacon", Summary: "Deactivate a beacon"},
+	"POST /proximity/v1/:projectId/beacons/:beaconId/attachments":                  {Id: "createAttachment", Summary: "Attach data to a beacon", Request: Attachment{}, Response: CreateAttachmentResponse{}},
+	"DELETE /proximity/v1/:projectId/beacons/:beaconId/attachments/:attachmentId":  {Id: "deleteAttachment", Summary: "Delete an attachment"},
+	"DELETE /proximity/v1/:projectId/beacons/:beaconId/attachments":                {Id: "deleteBeaconAttachments", Summary: "Delete the attachments of a beacon"},
+	"GET /proximity/v1/:projectId/beacons/:beaconId/attachments":                   {Id: "listBeaconAttachments", Summary: "List the attachments of a beacon", Response: []Attachment{}},
+	"POST /proximity/v1/:projectId/getforobserved":                                 {Id: "getForObserved", Summary: "Get the attachments of the observed beacons", Request: HybridRequest{}, Response: HybridResponse{}},
+	"POST /equipment/v1/:projectId/components":                                     {Id: "registerComponent", Summary: "Register a component", Request: Component{}, Response: CreateComponentResponse{}},
+	"GET /equipment/v1/:projectId/components":                                      {Id: "listComponents", Summary: "List the components", Query: withQuery(pageQuery, []string{"name"}), Response: []Component{}},
+	"DELETE /equipment/v1/:projectId/components/:componentId":                      {Id: "deleteComponent", Summary: "Delete a component"},
+	"PUT /equipment/v1/:projectId/components/:componentId":                         {Id: "updateComponent", Summary: "Update a component", Request: Component{}},
+	"GET /equipment/v1/:projectId/components/:componentId/collections":             {Id: "listCollections", Summary: "List the data collected by a component", Query: []string{"start_time", "end_time"}, Response: []Collection{}},
+	"GET /equipment/v1/:projectId/components/:componentId/detail":                  {Id: "getComponentDetail", Summary: "Get the detail of a component", Response: model.ComponentDetail{}},
+	"PUT /equipment/v1/:projectId/components/:componentId/detail":                  {Id: "updateComponentDetail", Summary: "Send a new detail to a component", Request: model.ComponentDetail{}},
+	"PUT /equipment/v1/:projectId/components/:componentId/detail/cancel-modifying": {Id: "cancelComponentDetail", Summary: "Cancel the pending detail of a component"},
+	"PUT /equipment/v1/:projectId/components/:componentId/detail/sync":             {Id: "syncComponentDetail", Summary: "Ask a component for its detail"},
+
+	// aws
+	"GET /aws/v1/:projectId/things":                         {Id: "listAwsThings", Summary: "List the things of the aws account", Query: []string{"maxResults", "nextToken", "thingTypeName"}, Response: map[string]interface{}{}},
+	"POST /aws/v1/:projectId/things":                        {Id: "registerThing", Summary: "Create a thing", Request: aws.RegisterThingReq{}},
+	"DELETE /aws/v1/:projectId/things/:thingName":           {Id: "removeThing", Summary: "Delete a thing"},
+	"PUT /aws/v1/:projectId/things/:thingName":              {Id: "updateThing", Summary: "Update a thing", Request: aws.UpdateThingReq{}},
+	"GET /aws/v2/:projectId/things":                         {Id: "listThings", Summary: "List the things of the project", Query: withQuery(pageQuery, []string{"group", "tag"}), Response: aws.ThingsWrap{}},
+	"GET /aws/v1/:projectId/things/:thingName/latest":       {Id: "getThingLatestData", Summary: "Get the latest reading relayed by a thing", Query: withQuery([]string{"device"}, formatQuery), Response: influxdb.OutData{}},
+	"GET /aws/v1/:projectId/things/:thingName/range-data":   {Id: "getThingData", Summary: "Get the readings relayed by a thing in a time range", Query: withQuery(rangeQuery, []string{"device", "follow"}), Response: influxdb.OutDataList{}},
+	"GET /aws/v1/:projectId/things/:thingName/device":       {Id: "getThingDevices", Summary: "List the devices seen by a thing", Query: []string{"history"}, Response: influxdb.DeviceList{}},
+	"GET /aws/v1/:projectId/things/:thingName/completeness": {Id: "getThingCompleteness", Summary: "Get the delivery counters of a thing", Response: aws.ThingCompleteness{}},
+	"GET /aws/v1/:projectId/events/stream":                  {Id: "streamEvents", Summary: "Stream the events of the project as server sent events", Query: streamQuery, ContentType: "text/event-stream"},
+	"GET /aws/v1/:projectId/events/ws":                      {Id: "streamEventsWs", Summary: "Stream the events of the project over a websocket", Query: streamQuery, Status: http.StatusSwitchingProtocols},
+	"GET /aws/v1/:projectId/locations":                      {Id: "listLocations", Summary: "List the locations", Query: []string{"parent", "level"}, Response: aws.LocationsWrap{}},
+	"POST /aws/v1/:projectId/locations":                     {Id: "createLocation", Summary: "Create a location", Request: aws.Location{}, Response: map[string]string{}, Status: http.StatusCreated},
+	"GET /aws/v1/:projectId/locations/:locationId":          {Id: "getLocation", Summary: "Get a location", Response: aws.Location{}},
+	"PUT /aws/v1/:projectId/locations/:locationId":          {Id: "updateLocation", Summary: "Rename a location", Request: aws.Location{}},
+	"DELETE /aws/v1/:projectId/locations/:locationId":       {Id: "deleteLocation", Summary: "Delete a location without children"},
+	"GET /aws/v1/:projectId/devices":                        {Id: "listDevices", Summary: "List the devices", Query: withQuery([]string{"thing", "status", "search", "type", "group", "tag", "seenAfter", "seenBefore"}, pageQuery), Response: aws.DeviceInfoList{}},
+	"POST /aws/v1/:projectId/devices":                       {Id: "createDevice", Summary: "Register a device", Request: aws.DeviceReq{}, Status: http.StatusCreated},
+	"GET /aws/v1/:projectId/devices/:device":                {Id: "getDevice", Summary: "Get a device", Response: aws.DeviceInfo{}},
+	"PUT /aws/v1/:projectId/devices/:device":                {Id: "updateDevice", Summary: "Update a device", Request: aws.DeviceReq{}},
+	"DELETE /aws/v1/:projectId/devices/:device":             {Id: "deleteDevice", Summary: "Delete a device"},
+	"GET /aws/v1/:projectId/devices/:device/latest":         {Id: "getDeviceLatestData", Summary: "Get the latest reading of a device", Query: formatQuery, Response: influxdb.OutData{}},
+	"GET /aws/v1/:projectId/devices/latest":                 {Id: "getDevicesLatestData", Summary: "Get the latest readings of the selected devices", Query: withQuery(selectorQuery, formatQuery), Response: []*aws.DeviceData{}},
+	"GET /aws/v1/:projectId/devices/range-data":             {Id: "getDevicesData", Summary: "Get the readings of the selected devices in a time range", Query: withQuery(selectorQuery, rangeQuery), Response: influxdb.OutDataList{}},
+	"GET /aws/v1/:projectId/devices/group-data":             {Id: "getDevicesGroupData", Summary: "Get the aggregated readings of the selected devices", Query: withQuery(selectorQuery, groupQuery), Response: aws.GroupData{}},
+	"GET /aws/v1/:projectId/devices/:device/range-data":     {Id: "getDeviceData", Summary: "Get the readings of a device in a time range", Query: rangeQuery, Response: influxdb.OutDataList{}},
+	"GET /aws/v1/:projectId/devices/:device/group-data":     {Id: "getDeviceGroupData", Summary: "Get the aggregated readings of a device", Query: groupQuery, Response: aws.GroupData{}},
+	"GET /aws/v1/:projectId/devices/:device/mkt":            {Id: "getDeviceMkt", Summary: "Get the mean kinetic temperature of a device", Query: []string{"startAt", "endAt", "unit"}, Response: influxdb.Mkt{}},
+	"GET /aws/v1/:projectId/devices/:device/assignments":    {Id: "getDeviceAssignments", Summary: "List the locations a device was assigned to", Query: []string{"startAt", "endAt"}, Response: aws.DeviceAssignmentList{}},
+	"GET /aws/v1/:projectId/devices/:device/thresh":         {Id: "getDeviceThresh", Summary: "Get the alarm thresholds of a device", Response: aws.DevThresh{}},
+	"PUT /aws/v1/:projectId/devices/:device/thresh":         {Id: "putDeviceThresh", Summary: "Set the alarm thresholds of a device", Request: aws.DevThresh{}},
+	"GET /aws/v1/:projectId/devices/:device/calibration":    {Id: "getDeviceCalibration", Summary: "Get the calibration of a device", Response: aws.DevCalibration{}},
+	"PUT /aws/v1/:projectId/devices/:device/calibration":    {Id: "putDeviceCalibration", Summary: "Set the calibration of a device", Request: aws.DevCalibration{}},
+	"DELETE /aws/v1/:projectId/devices/:device/calibration": {Id: "deleteDeviceCalibration", Summary: "Reset the calibration of a device"},
+	"GET /aws/v1/:projectId/preference":                     {Id: "getPreference", Summary: "Get the preference of the project", Response: aws.Preference{}},
+	"PUT /aws/v1/:projectId/preference":                     {Id: "putPreference", Summary: "Set the preference of the project", Request: aws.Preference{}},
+	"GET /aws/v1/:projectId/notify":                         {Id: "getNotify", Summary: "List the alarm subscriptions", Response: aws.UserNotify{}},
+	"PUT /aws/v1/:projectId/notify":                         {Id: "addNotify", Summary: "Subscribe an email or a mobile to the alarms", Request: aws.UserNotifyReq{}},
+	"DELETE /aws/v1/:projectId/notify/:subscribeId":         {Id: "removeNotify", Summary: "Remove an alarm subscription"},
+	"GET /aws/v1/:projectId/webhooks":                       {Id: "listWebhooks", Summary: "List the webhooks", Response: aws.WebhooksWrap{}},
+	"POST /aws/v1/:projectId/webhooks":                      {Id: "createWebhook", Summary: "Create a webhook, the secret is only returned here", Request: aws.WebhookReq{}, Response: aws.Webhook{}, Status: http.StatusCreated},
+	"GET /aws/v1/:projectId/webhooks/:webhookId":            {Id: "getWebhook", Summary: "Get a webhook", Response: aws.Webhook{}},
+	"PUT /aws/v1/:projectId/webhooks/:webhookId":            {Id: "updateWebhook", Summary: "Update a webhook", Request: aws.WebhookReq{}, Response: aws.Webhook{}},
+	"DELETE /aws/v1/:projectId/webhooks/:webhookId":         {Id: "deleteWebhook", Summary: "Delete a webhook"},
+	"GET /aws/v1/:projectId/webhooks/:webhookId/deliveries": {Id: "listWebhookDeliveries", Summary: "List the deliveries of a webhook, latest first", Query: pageQuery, Response: aws.WebhookDeliveriesWrap{}},
+	"GET /aws/v1/:projectId/mqtt-bridge":                    {Id: "getMqttBridge", Summary: "Get the mqtt bridge of the project", Response: aws.MqttBridge{}},
+	"PUT /aws/v1/:projectId/mqtt-bridge":                    {Id: "putMqttBridge", Summary: "Set the mqtt bridge of the project", Request: aws.MqttBridgeReq{}},
+	"DELETE /aws/v1/:projectId/mqtt-bridge":                 {Id: "deleteMqttBridge", Summary: "Remove the mqtt bridge of the project"},
+	"POST /aws/v1/:projectId/certificate":                   {Id: "updateThingCert", Summary: "Rotate the certificate of the things", Request: aws.UpdateCertReq{}},
+	"POST /app/v1/:projectId/register/dev-token":            {Id: "registerDevToken", Summary: "Register the push token of a phone", Request: RegisterDevTokenReq{}},
+	"GET /app/resource":                                     {Id: "getAdPicture", Summary: "Get the advertisement picture of the app", Response: AppRes{}},
+}
+
+var (
+	apiDocMu sync.Mutex
+	apiDoc   []byte
+)
+
+// ApiDoc is the json of the openapi document of the routes, as served by
+// /v1/openapi.json.
+func ApiDoc() ([]byte, error) {
+	apiDocMu.Lock()
+	defer apiDocMu.Unlock()
+	if apiDoc != nil {
+		return apiDoc, nil
+	}
+	router := newApiRouter()
+	registerRoutes(router)
+	doc, err := openapi.Build(apiTitle, apiVersion, router.routes)
+	if err != nil {
+		return nil, err
+	}
+	body, err := doc.Marshal()
+	if err != nil {
+		return nil, err
+	}
+	apiDoc = body
+	return apiDoc, nil
+}
+
+func GetOpenApi(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	body, err := ApiDoc()
+	if err != nil {
+		logs.Error("build openapi document fail, err:%s", err.Error())
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", openapi.JsonType)
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
@@ -4,7 +4,6 @@ import (
 	"github.com/dimfeld/httptreemux"
 	"github.com/jack0liu/logs"
 	"github.com/ssrs100/blueserver/controller/aws"
-	"github.com/ssrs100/blueserver/controller/middleware"
 	"net/http"
 	"runtime"
 )
@@ -16,33 +15,92 @@ func Health(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 func panicHandler(w http.ResponseWriter, r *http.Request, err interface{}) {
 	var buf [4096]byte
 	n := runtime.Stack(buf[:], false)
+	logs.Error("panic of %s %s: %v", r.Method, r.URL.Path, err)
 	logs.Debug("==> %s\n", string(buf[:n]))
+	DefaultHandler.ServeHTTP(w, r, nil, http.StatusInternalServerError)
+}
+
+func notFoundHandler(w http.ResponseWriter, r *http.Request) {
+	DefaultHandler.ServeHTTP(w, r, nil, http.StatusNotFound)
+}
+
+func methodNotAllowedHandler(w http.ResponseWriter, r *http.Request, methods map[string]httptreemux.HandlerFunc) {
+	for m := range methods {
+		w.Header().Add("Allow", m)
+	}
+	DefaultHandler.ServeHTTP(w, r, nil, http.StatusMethodNotAllowed)
 }
 
 func LoadApi() *httptreemux.TreeMux {
-	// Set the router.
-	router := httptreemux.New()
+	// Set the router, every route is registered with its permission.
+	router := newApiRouter()
 
 	// Set router options.
 	router.PanicHandler = panicHandler
+	router.NotFoundHandler = notFoundHandler
+	router.MethodNotAllowedHandler = methodNotAllowedHandler
 	router.RedirectTrailingSlash = true
 
-	// Set the routes for the application.
-	s := middleware.NewStack()
-	s.Use(middleware.Auth)
+	registerRoutes(router)
+	return router.TreeMux
+}
+
+// registerRoutes sets the routes for the application, the openapi document
+// is built from the same routes.
+func registerRoutes(router *apiRouter) {
 	// Route for health check
 	router.GET("/v1/heart", Health)
+	router.GET("/v1/openapi.json", GetOpenApi)
 
 	// Routes for users
 	router.GET("/active", ActiveUser)
-	router.POST("/v1/users/verify", SendVerifyCode)
+	router.POST("/v1/users/verify", ForgotPwd)
+	router.POST("/v1/users/password/forgot", ForgotPwd)
 	router.POST("/v1/users/password/reset", ResetPwd)
-	router.GET("/v1/users", s.Wrap(GetUsers))
-	router.GET("/v1/users/:projectId", s.Wrap(GetUser))
+	router.PUT("/v1/users/:projectId/password", ChangePwd)
+	router.GET("/v1/users", GetUsers)
+	router.GET("/v1/users/:projectId", GetUser)
 	router.POST("/v1/users", CreateUser)
 	router.POST("/v1/users/login", UserLogin)
-	router.DELETE("/v1/users/:projectId", s.Wrap(DeleteUser))
-	router.POST("/v1/users/:projectId", s.Wrap(BindAwsUser))
+	router.POST("/v1/users/token/refresh", RefreshToken)
+	router.POST("/v1/users/logout", Logout)
+	router.GET("/v1/users/:projectId/sessions", ListSessions)
+	router.DELETE("/v1/users/:projectId/sessions", RevokeSessions)
+	router.DELETE("/v1/users/:projectId/sessions/:sessionId", RevokeSession)
+	router.DELETE("/v1/users/:projectId", DeleteUser)
+	router.POST("/v1/users/:projectId", BindAwsUser)
+	router.POST("/v1/users/login/2fa", LoginTwoFactor)
+	router.GET("/v1/users/:projectId/2fa", GetTwoFactor)
+	router.POST("/v1/users/:projectId/2fa", EnrollTwoFactor)
+	router.POST("/v1/users/:projectId/2fa/activate", ActivateTwoFactor)
+	router.DELETE("/v1/users/:projectId/2fa", DisableTwoFactor)
+	router.POST("/v1/users/:projectId/2fa/recovery-codes", RegenerateRecoveryCodes)
+
+	// Routes for projects
+	router.GET("/v1/projects", ListProjects)
+	router.POST("/v1/projects", CreateProject)
+	router.GET("/v1/projects/:projectId", GetProject)
+	router.PUT("/v1/projects/:projectId", UpdateProject)
+	router.GET("/v1/projects/:projectId/members", ListMembers)
+	router.PUT("/v1/projects/:projectId/members/:userId", UpdateMember)
+	router.DELETE("/v1/projects/:projectId/members/:userId", RemoveMember)
+	router.GET("/v1/projects/:projectId/invitations", ListInvitations)
+	router.POST("/v1/projects/:projectId/invitations", InviteMember)
+	router.DELETE("/v1/projects/:projectId/invitations/:invitationId", RevokeInvitation)
+	router.POST("/v1/invitations/accept", AcceptInvitation)
+	router.GET("/v1/projects/:projectId/api-keys", ListApiKeys)
+	router.POST("/v1/projects/:projectId/api-keys", CreateApiKey)
+	router.DELETE("/v1/projects/:projectId/api-keys/:keyId", RevokeApiKey)
+	router.GET("/v1/projects/:projectId/sso", GetSsoConfig)
+	router.PUT("/v1/projects/:projectId/sso", PutSsoConfig)
+	router.DELETE("/v1/projects/:projectId/sso", DeleteSsoConfig)
+	router.GET("/v1/projects/:projectId/audit-logs", ListAuditLogs)
+	router.GET("/v1/projects/:projectId/audit-logs/export", ExportAuditLogs)
+	router.GET("/v1/audit-logs", ListAllAuditLogs)
+	router.GET("/v1/audit-logs/export", ExportAllAuditLogs)
+	router.GET("/v1/sso/discover", SsoDiscover)
+	router.GET("/v1/sso/:projectId/login", SsoLogin)
+	router.GET("/v1/sso/callback", SsoCallback)
 
 	// Routes for beacons
 	router.POST("/proximity/v1/:projectId/beacons", RegisterBeacon)
@@ -75,33 +133,65 @@ func LoadApi() *httptreemux.TreeMux {
 	router.PUT("/equipment/v1/:projectId/components/:componentId/detail/sync", SyncComponentDetail)
 
 	// AWS
-	router.GET("/aws/v1/:projectId/things", s.Wrap(aws.ListThings))
-	router.POST("/aws/v1/:projectId/things", s.Wrap(aws.RegisterThing))
-	router.DELETE("/aws/v1/:projectId/things/:thingName", s.Wrap(aws.RemoveThing))
-	router.PUT("/aws/v1/:projectId/things/:thingName", s.Wrap(aws.UpdateThing))
-	router.GET("/aws/v2/:projectId/things", s.Wrap(aws.ListThingsV2))
-	router.GET("/aws/v1/:projectId/things/:thingName/latest", s.Wrap(aws.GetThingLatestData))
-	router.GET("/aws/v1/:projectId/things/:thingName/range-data", s.Wrap(aws.GetThingData))
-	router.GET("/aws/v1/:projectId/things/:thingName/device", s.Wrap(aws.GetThingDevice))
-
-	router.GET("/aws/v1/:projectId/devices", s.Wrap(aws.ListDevices))
-	router.GET("/aws/v1/:projectId/devices/:device/latest", s.Wrap(aws.GetDeviceLatestData))
-	router.GET("/aws/v1/:projectId/devices/latest", s.Wrap(aws.GetMultiDeviceLatestData))
-	router.GET("/aws/v1/:projectId/devices/:device/range-data", s.Wrap(aws.GetDeviceData))
-	router.GET("/aws/v1/:projectId/devices/:device/group-data", s.Wrap(aws.GetGroupData))
-
-	router.GET("/aws/v1/:projectId/devices/:device/thresh", s.Wrap(aws.GetDeviceThresh))
-	router.PUT("/aws/v1/:projectId/devices/:device/thresh", s.Wrap(aws.PutDeviceThresh))
-
-	router.GET("/aws/v1/:projectId/notify", s.Wrap(aws.GetUserNotify))
-	router.PUT("/aws/v1/:projectId/notify", s.Wrap(aws.AddUserNotify))
-	router.DELETE("/aws/v1/:projectId/notify/:subscribeId", s.Wrap(aws.RmvUserNotify))
+	router.GET("/aws/v1/:projectId/things", aws.ListThings)
+	router.POST("/aws/v1/:projectId/things", aws.RegisterThing)
+	router.DELETE("/aws/v1/:projectId/things/:thingName", aws.RemoveThing)
+	router.PUT("/aws/v1/:projectId/things/:thingName", aws.UpdateThing)
+	router.GET("/aws/v2/:projectId/things", aws.ListThingsV2)
+	router.GET("/aws/v1/:projectId/things/:thingName/latest", aws.GetThingLatestData)
+	router.GET("/aws/v1/:projectId/things/:thingName/range-data", aws.GetThingData)
+	router.GET("/aws/v1/:projectId/things/:thingName/device", aws.GetThingDevice)
+	router.GET("/aws/v1/:projectId/things/:thingName/completeness", aws.GetThingCompleteness)
+
+	router.GET("/aws/v1/:projectId/events/stream", aws.StreamEvents)
+	router.GET("/aws/v1/:projectId/events/ws", aws.StreamEventsWs)
+	router.GET("/aws/v1/:projectId/locations", aws.ListLocations)
+	router.POST("/aws/v1/:projectId/locations", aws.CreateLocation)
+	router.GET("/aws/v1/:projectId/locations/:locationId", aws.GetLocation)
+	router.PUT("/aws/v1/:projectId/locations/:locationId", aws.UpdateLocation)
+	router.DELETE("/aws/v1/:projectId/locations/:locationId", aws.DeleteLocation)
+	router.GET("/aws/v1/:projectId/devices", aws.ListDevices)
+	router.POST("/aws/v1/:projectId/devices", aws.CreateDevice)
+	router.GET("/aws/v1/:projectId/devices/:device", aws.GetDevice)
+	router.PUT("/aws/v1/:projectId/devices/:device", aws.UpdateDevice)
+	router.DELETE("/aws/v1/:projectId/devices/:device", aws.DeleteDevice)
+	router.GET("/aws/v1/:projectId/devices/:device/latest", aws.GetDeviceLatestData)
+	router.GET("/aws/v1/:projectId/devices/latest", aws.GetMultiDeviceLatestData)
+	router.GET("/aws/v1/:projectId/devices/range-data", aws.GetMultiDeviceData)
+	router.GET("/aws/v1/:projectId/devices/group-data", aws.GetMultiGroupData)
+	router.GET("/aws/v1/:projectId/devices/:device/range-data", aws.GetDeviceData)
+	router.GET("/aws/v1/:projectId/devices/:device/group-data", aws.GetGroupData)
+	router.GET("/aws/v1/:projectId/devices/:device/mkt", aws.GetDeviceMkt)
+	router.GET("/aws/v1/:projectId/devices/:device/assignments", aws.GetDeviceAssignments)
+
+	router.GET("/aws/v1/:projectId/devices/:device/thresh", aws.GetDeviceThresh)
+	router.PUT("/aws/v1/:projectId/devices/:device/thresh", aws.PutDeviceThresh)
+
+	router.GET("/aws/v1/:projectId/devices/:device/calibration", aws.GetDeviceCalibration)
+	router.PUT("/aws/v1/:projectId/devices/:device/calibration", aws.PutDeviceCalibration)
+	router.DELETE("/aws/v1/:projectId/devices/:device/calibration", aws.DeleteDeviceCalibration)
+
+	router.GET("/aws/v1/:projectId/preference", aws.GetPreference)
+	router.PUT("/aws/v1/:projectId/preference", aws.PutPreference)
+
+	router.GET("/aws/v1/:projectId/notify", aws.GetUserNotify)
+	router.PUT("/aws/v1/:projectId/notify", aws.AddUserNotify)
+	router.DELETE("/aws/v1/:projectId/notify/:subscribeId", aws.RmvUserNotify)
+	router.GET("/aws/v1/:projectId/webhooks", aws.ListWebhooks)
+	router.POST("/aws/v1/:projectId/webhooks", aws.CreateWebhook)
+	router.GET("/aws/v1/:projectId/webhooks/:webhookId", aws.GetWebhook)
+	router.PUT("/aws/v1/:projectId/webhooks/:webhookId", aws.UpdateWebhook)
+	router.DELETE("/aws/v1/:projectId/webhooks/:webhookId", aws.DeleteWebhook)
+	router.GET("/aws/v1/:projectId/webhooks/:webhookId/deliveries", aws.ListWebhookDeliveries)
+	router.GET("/aws/v1/:projectId/mqtt-bridge", aws.GetMqttBridge)
+	router.PUT("/aws/v1/:projectId/mqtt-bridge", aws.PutMqttBridge)
+	router.DELETE("/aws/v1/:projectId/mqtt-bridge", aws.DeleteMqttBridge)
 	// cert
-	router.POST("/aws/v1/:projectId/certificate", s.Wrap(aws.UpdateThingCert))
+	router.POST("/aws/v1/:projectId/certificate", aws.UpdateThingCert)
 
 	// Routes for attachments
-	router.POST("/app/v1/:projectId/register/dev-token", s.Wrap(RegisterDevToken))
+	router.POST("/app/v1/:projectId/register/dev-token", RegisterDevToken)
 
 	router.GET("/app/resource", GetAdPic)
-	return router
+	router.checkPermissions()
 }
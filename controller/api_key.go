@@ -0,0 +1,111 @@
+package controller
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"net/http"
+	"strings"
+	"time"
+)
+
+type ApiKeyReq struct {
+	Name   string   `json:"name"`
+	Scopes []string `json:"scopes" valid:"Required;MaxSize(32);Scope"`
+	// seconds the key is valid, 0 for never expiring
+	ExpiresIn int64 `json:"expires_in" valid:"Min(0)"`
+}
+
+type ApiKeyInfo struct {
+	Id         string     `json:"id"`
+	Name       string     `json:"name"`
+	Prefix     string     `json:"prefix"`
+	Scopes     []string   `json:"scopes"`
+	CreatedBy  string     `json:"created_by"`
+	ExpireAt   *time.Time `json:"expire_at,omitempty"`
+	LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+	CreateAt   *time.Time `json:"create_at,omitempty"`
+	// only returned on creation
+	Key string `json:"key,omitempty"`
+}
+
+func apiKeyInfo(k *bluedb.ApiKey) *ApiKeyInfo {
+	return &ApiKeyInfo{
+		Id:         k.Id,
+		Name:       k.Name,
+		Prefix:     k.Prefix,
+		Scopes:     strings.Split(k.Scopes, ","),
+		CreatedBy:  k.CreatedBy,
+		ExpireAt:   k.ExpireAt,
+		LastUsedAt: k.LastUsedAt,
+		CreateAt:   k.CreateAt,
+	}
+}
+
+func ListApiKeys(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	list := make([]*ApiKeyInfo, 0)
+	for _, k := range bluedb.QueryApiKeys(ps["projectId"]) {
+		list = append(list, apiKeyInfo(k))
+	}
+	writeJsonResp(w, http.StatusOK, list)
+}
+
+// CreateApiKey creates a key of the project for the current user, the key
+// acts with the role of the user and is returned only once.
+func CreateApiKey(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	userId, ok := currentUserId(w, req)
+	if !ok {
+		return
+	}
+	var keyReq ApiKeyReq
+	if !readJsonBody(w, req, &keyReq) {
+		return
+	}
+	name := strings.TrimSpace(keyReq.Name)
+	if len(name) == 0 || len(name) >= 60 {
+		DefaultHandler.ServeHTTP(w, req, fmt.Errorf("Name(%s) is empty or exceed 60 bytes.", name), http.StatusBadRequest)
+		return
+	}
+	key, prefix, hash, err := middleware.NewApiKey()
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	apiKey := bluedb.ApiKey{
+		ProjectId: ps["projectId"],
+		Name:      name,
+		Prefix:    prefix,
+		KeyHash:   hash,
+		Scopes:    strings.Join(keyReq.Scopes, ","),
+		CreatedBy: userId,
+	}
+	if keyReq.ExpiresIn > 0 {
+		expireAt := time.Now().Add(time.Duration(keyReq.ExpiresIn) * time.Second)
+		apiKey.ExpireAt = &expireAt
+	}
+	apiKey.Id, err = bluedb.SaveApiKey(apiKey)
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	info := apiKeyInfo(&apiKey)
+	middleware.AuditTarget(req, "keyId="+apiKey.Id)
+	middleware.AuditChange(req, nil, info)
+	info.Key = key
+	writeJsonResp(w, http.StatusCreated, info)
+}
+
+func RevokeApiKey(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	deleted, err := bluedb.DeleteApiKey(ps["projectId"], ps["keyId"])
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	if !deleted {
+		DefaultHandler.ServeHTTP(w, req, errors.New("api key not found"), http.StatusNotFound)
+		return
+	}
+	middleware.ForgetApiKey(ps["keyId"])
+	w.WriteHeader(http.StatusOK)
+}
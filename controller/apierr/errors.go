@@ -0,0 +1,155 @@
+package apierr
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jack0liu/logs"
+	"net"
+	"net/http"
+)
+
+// Code is the stable machine readable kind of an error, clients switch on it
+// rather than on the message.
+type Code string
+
+const (
+	CodeInvalidRequest   Code = "invalid_request"
+	CodeValidationFailed Code = "validation_failed"
+	CodeUnauthorized     Code = "unauthorized"
+	CodeForbidden        Code = "forbidden"
+	CodeMfaRequired      Code = "mfa_required"
+	CodeNotFound         Code = "not_found"
+	CodeMethodNotAllowed Code = "method_not_allowed"
+	CodeConflict         Code = "conflict"
+	CodeRateLimited      Code = "rate_limited"
+	CodeInternal         Code = "internal_error"
+	CodeNotImplemented   Code = "not_implemented"
+	CodeUpstream         Code = "upstream_error"
+	CodeUpstreamTimeout  Code = "upstream_timeout"
+)
+
+// the response header of the request id, see middleware.RequestId
+const requestIdHeader = "X-Request-Id"
+
+// FieldError is a field of the request body that failed validation, the
+// field is named as in the json.
+type FieldError struct {
+	Field   string `json:"field"`
+	Code    string `json:"code"`
+	Message string `json:"message"`
+}
+
+// Error is the body of every error response.
+type Error struct {
+	Status    int          `json:"-"`
+	Code      Code         `json:"code"`
+	Message   string       `json:"message"`
+	Fields    []FieldError `json:"fields,omitempty"`
+	RequestId string       `json:"request_id,omitempty"`
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+// CodeForStatus is the code of the errors without one.
+func CodeForStatus(status int) Code {
+	switch status {
+	case http.StatusUnauthorized:
+		return CodeUnauthorized
+	case http.StatusForbidden:
+		return CodeForbidden
+	case http.StatusNotFound:
+		return CodeNotFound
+	case http.StatusMethodNotAllowed:
+		return CodeMethodNotAllowed
+	case http.StatusConflict:
+		return CodeConflict
+	case http.StatusUnprocessableEntity:
+		return CodeValidationFailed
+	case http.StatusTooManyRequests:
+		return CodeRateLimited
+	case http.StatusNotImplemented:
+		return CodeNotImplemented
+	case http.StatusBadGateway:
+		return CodeUpstream
+	case http.StatusGatewayTimeout:
+		return CodeUpstreamTimeout
+	}
+	if status >= http.StatusInternalServerError {
+		return CodeInternal
+	}
+	return CodeInvalidRequest
+}
+
+// New is an error of the status with the code of the status.
+func New(status int, message string) *Error {
+	return &Error{Status: status, Code: CodeForStatus(status), Message: message}
+}
+
+// Newf is New with a formatted message.
+func Newf(status int, format string, args ...interface{}) *Error {
+	return New(status, fmt.Sprintf(format, args...))
+}
+
+// WithCode is an error of the status with a more specific code.
+func WithCode(status int, code Code, message string) *Error {
+	return &Error{Status: status, Code: code, Message: message}
+}
+
+// Validation is a validation_failed error of the fields, the message tells
+// the first of them for the clients reading only the message.
+func Validation(fields []FieldError) *Error {
+	e := &Error{
+		Status:  http.StatusBadRequest,
+		Code:    CodeValidationFailed,
+		Message: "request validation failed",
+		Fields:  fields,
+	}
+	if len(fields) > 0 {
+		e.Message = fmt.Sprintf("invalid %s: %s", fields[0].Field, fields[0].Message)
+	}
+	return e
+}
+
+// From turns err into an Error. An Error keeps its own status, network
+// errors of the upstream services are 502 or 504 whatever the status. A bare
+// io.EOF may as well be an empty request body, it keeps the status.
+func From(err error, status int) *Error {
+	if e, ok := err.(*Error); ok {
+		return e
+	}
+	if e, ok := err.(net.Error); ok {
+		if e.Timeout() {
+			return New(http.StatusGatewayTimeout, e.Error())
+		}
+		return New(http.StatusBadGateway, e.Error())
+	}
+	if err == nil {
+		return New(status, http.StatusText(status))
+	}
+	return New(status, err.Error())
+}
+
+// Write sends err as the json error body.
+func Write(w http.ResponseWriter, err error, status int) {
+	e := *From(err, status)
+	if e.Status == 0 {
+		e.Status = status
+	}
+	if len(e.Code) == 0 {
+		e.Code = CodeForStatus(e.Status)
+	}
+	e.RequestId = w.Header().Get(requestIdHeader)
+	// the headers can not be changed once the status is written
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(e.Status)
+	if err := json.NewEncoder(w).Encode(&e); err != nil {
+		logs.Error("encode error message fail, err:%s", err.Error())
+	}
+}
+
+// WriteMessage sends an error of the status with the message.
+func WriteMessage(w http.ResponseWriter, status int, message string) {
+	Write(w, New(status, message), status)
+}
@@ -0,0 +1,72 @@
+package apierr
+
+import (
+	"encoding/json"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+// truncatedBody ends before the length the client announced.
+type truncatedBody struct{ io.Reader }
+
+func (b truncatedBody) Read(p []byte) (int, error) {
+	n, err := b.Reader.Read(p)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+	return n, err
+}
+
+func (truncatedBody) Close() error { return nil }
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "i/o timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func TestFromStatus(t *testing.T) {
+	for _, c := range []struct {
+		err    error
+		status int
+		want   int
+	}{
+		{io.EOF, http.StatusBadRequest, http.StatusBadRequest},
+		{io.ErrUnexpectedEOF, http.StatusBadRequest, http.StatusBadRequest},
+		{errors.New("bad"), http.StatusConflict, http.StatusConflict},
+		{New(http.StatusNotFound, "gone"), http.StatusBadRequest, http.StatusNotFound},
+		{&net.OpError{Op: "dial", Err: errors.New("refused")}, http.StatusBadRequest, http.StatusBadGateway},
+		{timeoutError{}, http.StatusBadRequest, http.StatusGatewayTimeout},
+	} {
+		if got := From(c.err, c.status).Status; got != c.want {
+			t.Errorf("From(%v, %d) is %d, want %d", c.err, c.status, got, c.want)
+		}
+	}
+}
+
+func TestDecodeBadBody(t *testing.T) {
+	var v struct {
+		Name string `json:"name" valid:"Required"`
+	}
+	for name, body := range map[string]io.ReadCloser{
+		"empty":     ioutil.NopCloser(strings.NewReader("")),
+		"truncated": truncatedBody{strings.NewReader(`{"name":`)},
+		"partial":   ioutil.NopCloser(strings.NewReader(`{"name":`)),
+	} {
+		req := httptest.NewRequest(http.MethodPost, "/v1/projects", nil)
+		req.Body = body
+		err := DecodeJson(req, &v)
+		w := httptest.NewRecorder()
+		Write(w, err, http.StatusBadRequest)
+		var e Error
+		if w.Code != http.StatusBadRequest || json.Unmarshal(w.Body.Bytes(), &e) != nil || e.Code != CodeInvalidRequest {
+			t.Errorf("%s body is %d %s", name, w.Code, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,189 @@
+package apierr
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/astaxie/beego/validation"
+	"github.com/jack0liu/logs"
+	"io/ioutil"
+	"net/http"
+	"reflect"
+	"strings"
+	"unicode"
+)
+
+// Request bodies are validated by the beego valid tags of their fields, such
+// as `valid:"Required;MaxSize(64)"`. The checks other than Required are
+// skipped for empty fields, so optional fields are checked only when set.
+// The rules the tags can not tell, such as ranges of floats, are given by a
+// Valid(*validation.Validation) method adding errors keyed by the field name.
+func init() {
+	for _, name := range []string{"Min", "Max", "Range", "MinSize", "MaxSize", "Length", "Alpha",
+		"Numeric", "AlphaNumeric", "AlphaDash", "Match", "NoMatch", "Base64"} {
+		(&validation.Validation{}).CanSkipAlso(name)
+	}
+}
+
+// checkedStrings are the strings a check of a string field applies to, all
+// the items of a list are checked.
+func checkedStrings(obj interface{}) []string {
+	switch o := obj.(type) {
+	case string:
+		return []string{o}
+	case *string:
+		if o != nil {
+			return []string{*o}
+		}
+	case []string:
+		return o
+	case *[]string:
+		if o != nil {
+			return *o
+		}
+	}
+	return nil
+}
+
+// RegisterCheck adds the check name to the valid tags, it fails the string
+// fields or the items of the string lists not ok.
+func RegisterCheck(name, message string, ok func(string) bool) {
+	err := validation.AddCustomFunc(name, func(v *validation.Validation, obj interface{}, key string) {
+		for _, s := range checkedStrings(obj) {
+			if !ok(s) {
+				v.AddError(key, message)
+				return
+			}
+		}
+	})
+	if err != nil {
+		panic(err)
+	}
+	(&validation.Validation{}).CanSkipAlso(name)
+}
+
+// jsonName is the name of the struct field in the json.
+func jsonName(t reflect.Type, field string) string {
+	f, ok := t.FieldByName(field)
+	if !ok {
+		return field
+	}
+	name := strings.Split(f.Tag.Get("json"), ",")[0]
+	if len(name) == 0 || name == "-" {
+		return f.Name
+	}
+	return name
+}
+
+// snakeCase turns the names of the checks into codes, MaxSize is max_size.
+func snakeCase(name string) string {
+	var b strings.Builder
+	for i, r := range name {
+		if unicode.IsUpper(r) {
+			if i > 0 {
+				b.WriteByte('_')
+			}
+			r = unicode.ToLower(r)
+		}
+		b.WriteRune(r)
+	}
+	return b.String()
+}
+
+// fieldPath is the path in the json of the field of the value at path.
+func fieldPath(path, name string) string {
+	if len(path) == 0 {
+		return name
+	}
+	return path + "." + name
+}
+
+// fieldErrors runs the checks of the struct, then those of the structs it
+// holds. The fields are named by their path in the json.
+func fieldErrors(v reflect.Value, path string) ([]FieldError, error) {
+	for v.Kind() == reflect.Ptr || v.Kind() == reflect.Interface {
+		if v.IsNil() {
+			return nil, nil
+		}
+		v = v.Elem()
+	}
+	var fields []FieldError
+	switch v.Kind() {
+	case reflect.Slice, reflect.Array:
+		for i := 0; i < v.Len(); i++ {
+			fe, err := fieldErrors(v.Index(i), fmt.Sprintf("%s[%d]", path, i))
+			if err != nil {
+				return nil, err
+			}
+			fields = append(fields, fe...)
+		}
+		return fields, nil
+	case reflect.Struct:
+	default:
+		return nil, nil
+	}
+
+	t := v.Type()
+	valid := validation.Validation{RequiredFirst: true}
+	ptr := reflect.New(t)
+	ptr.Elem().Set(v)
+	if _, err := valid.Valid(ptr.Interface()); err != nil {
+		return nil, err
+	}
+	for _, e := range valid.Errors {
+		parts := strings.Split(e.Key, ".")
+		code := "invalid"
+		if len(parts) > 1 && len(parts[1]) > 0 {
+			code = snakeCase(parts[1])
+		}
+		fields = append(fields, FieldError{
+			Field:   fieldPath(path, jsonName(t, parts[0])),
+			Code:    code,
+			Message: e.Message,
+		})
+	}
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+		fe, err := fieldErrors(v.Field(i), fieldPath(path, jsonName(t, f.Name)))
+		if err != nil {
+			return nil, err
+		}
+		fields = append(fields, fe...)
+	}
+	return fields, nil
+}
+
+// Validate checks v by its valid tags, the failed fields are returned as a
+// validation_failed Error.
+func Validate(v interface{}) error {
+	fields, err := fieldErrors(reflect.ValueOf(v), "")
+	if err != nil {
+		// a malformed tag is a bug of the server
+		logs.Error("validate %T fail, err:%s", v, err.Error())
+		return New(http.StatusInternalServerError, "validate request fail")
+	}
+	if len(fields) > 0 {
+		return Validation(fields)
+	}
+	return nil
+}
+
+// DecodeJson reads the json body of the request into v and validates it.
+func DecodeJson(req *http.Request, v interface{}) error {
+	body, err := ioutil.ReadAll(req.Body)
+	if err != nil {
+		logs.Error("Receive body failed: %v", err.Error())
+		return New(http.StatusBadRequest, err.Error())
+	}
+	defer req.Body.Close()
+	if len(body) == 0 {
+		return New(http.StatusBadRequest, "request body is empty")
+	}
+	if err = json.Unmarshal(body, v); err != nil {
+		logs.Error("Invalid body. err:%s", err.Error())
+		return New(http.StatusBadRequest, err.Error())
+	}
+	return Validate(v)
+}
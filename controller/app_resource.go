@@ -2,6 +2,7 @@ package controller
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/jack0liu/logs"
 	"github.com/ssrs100/blueserver/bluedb"
@@ -21,7 +22,7 @@ type AppRes struct {
 func GetAdPic(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 	res := bluedb.QueryResByType(AdvertisementType)
 	if res == nil {
-		w.WriteHeader(http.StatusNotFound)
+		DefaultHandler.ServeHTTP(w, req, errors.New("ad picture not found"), http.StatusNotFound)
 		return
 	}
 	app := AppRes {
@@ -29,13 +30,13 @@ func GetAdPic(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 		Url: fmt.Sprintf("%s%s%s", res.Endpoint, res.Uri, res.ResourceName),
 		Type: res.Type,
 	}
-	w.WriteHeader(http.StatusOK)
-	w.Header().Add("Content-Type", "application/json")
 	body, err := json.Marshal(&app)
 	if err != nil {
 		logs.Error("%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
 		return
 	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write(body)
 }
@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/jack0liu/logs"
 	"github.com/ssrs100/blueserver/bluedb"
-	"io/ioutil"
 	"net/http"
 	"time"
 )
@@ -14,9 +13,9 @@ import (
 type Attachment struct {
 	Id             string `json:"id"`
 	BeaconId       string `json:"beacon_id"`
-	AttachmentName string `json:"attachment_name"`
-	AttachmentType string `json:"attachment_type"`
-	Data           string `json:"data"`
+	AttachmentName string `json:"attachment_name" valid:"Required;MaxSize(64)"`
+	AttachmentType string `json:"attachment_type" valid:"Required;MaxSize(64)"`
+	Data           string `json:"data" valid:"Required"`
 }
 
 func (a *Attachment) dbObjectTrans(attachment bluedb.Attachment) Attachment {
@@ -43,19 +42,8 @@ type CreateAttachmentResponse struct {
 }
 
 func CreateAttachment(w http.ResponseWriter, req *http.Request, ps map[string]string) {
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
-	}
-	defer req.Body.Close()
-
 	var attachmentReq = &Attachment{}
-	err = json.Unmarshal(body, attachmentReq)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	if !readJsonBody(w, req, attachmentReq) {
 		return
 	}
 	// check beacon info
@@ -65,16 +53,7 @@ func CreateAttachment(w http.ResponseWriter, req *http.Request, ps map[string]st
 	if bean == nil {
 		strErr := fmt.Sprintf("Beacon(%s) not exist.", beaconId)
 		logs.Error(strErr)
-		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusBadRequest)
-		return
-	}
-
-	if len(attachmentReq.AttachmentName) == 0 ||
-		len(attachmentReq.AttachmentType) == 0 ||
-		len(attachmentReq.Data) == 0 {
-		strErr := fmt.Sprintf("Invalid attachment, name/type/data should be fully set.")
-		logs.Error(strErr)
-		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusBadRequest)
+		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusNotFound)
 		return
 	}
 
@@ -93,11 +72,9 @@ func CreateAttachment(w http.ResponseWriter, req *http.Request, ps map[string]st
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(CreateAttachmentResponse{
+	writeJsonResp(w, http.StatusOK, CreateAttachmentResponse{
 		AttachmentId: attachmentId,
 	})
-	w.WriteHeader(http.StatusOK)
 }
 
 func DeleteAttachment(w http.ResponseWriter, req *http.Request, ps map[string]string) {
@@ -130,7 +107,7 @@ func GetAttachmentByBeacon(w http.ResponseWriter, req *http.Request, ps map[stri
 	attachments := bluedb.QueryAttachments(param)
 	logs.Debug("list beancons:%v", attachments)
 	var a = Attachment{}
-	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(a.dbListObjectTrans(attachments))
 }
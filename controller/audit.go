@@ -0,0 +1,226 @@
+package controller
+
+import (
+	"encoding/csv"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/bluedb"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAuditLimit = 50
+	maxAuditLimit     = 500
+	auditExportBatch  = 1000
+
+	defaultAuditRetentionDays = 365
+)
+
+type AuditLogInfo struct {
+	Id        string          `json:"id"`
+	ProjectId string          `json:"project_id,omitempty"`
+	ActorId   string          `json:"actor_id,omitempty"`
+	ApiKeyId  string          `json:"api_key_id,omitempty"`
+	Action    string          `json:"action"`
+	Target    string          `json:"target,omitempty"`
+	Method    string          `json:"method"`
+	Path      string          `json:"path"`
+	Status    int             `json:"status"`
+	Before    json.RawMessage `json:"before,omitempty"`
+	After     json.RawMessage `json:"after,omitempty"`
+	Ip        string          `json:"ip,omitempty"`
+	RequestId string          `json:"request_id,omitempty"`
+	CreateAt  *time.Time      `json:"create_at"`
+}
+
+type AuditLogsWrap struct {
+	AuditLogs []*AuditLogInfo `json:"audit_logs"`
+	Count     int64           `json:"count"`
+}
+
+// csvCell keeps spreadsheets from running the value as a formula.
+func csvCell(v string) string {
+	if len(v) > 0 && strings.ContainsRune("=+-@\t\r", rune(v[0])) {
+		return "'" + v
+	}
+	return v
+}
+
+func auditLogInfo(l *bluedb.AuditLog) *AuditLogInfo {
+	info := &AuditLogInfo{
+		Id:        l.Id,
+		ProjectId: l.ProjectId,
+		ActorId:   l.ActorId,
+		ApiKeyId:  l.ApiKeyId,
+		Action:    l.Action,
+		Target:    l.Target,
+		Method:    l.Method,
+		Path:      l.Path,
+		Status:    l.Status,
+		Ip:        l.Ip,
+		RequestId: l.RequestId,
+		CreateAt:  l.CreateAt,
+	}
+	// summaries cut at the max length are not valid json any more
+	if len(l.Before) > 0 && json.Valid([]byte(l.Before)) {
+		info.Before = json.RawMessage(l.Before)
+	}
+	if len(l.After) > 0 && json.Valid([]byte(l.After)) {
+		info.After = json.RawMessage(l.After)
+	}
+	return info
+}
+
+// auditParams reads the filters of the query, since and until are RFC3339.
+func auditParams(req *http.Request, projectId string) (map[string]interface{}, error) {
+	query := req.URL.Query()
+	params := make(map[string]interface{})
+	if len(projectId) > 0 {
+		params["project_id"] = projectId
+	} else if p := query.Get("project_id"); len(p) > 0 {
+		params["project_id"] = p
+	}
+	for _, k := range []string{"actor_id", "action", "target"} {
+		if v := query.Get(k); len(v) > 0 {
+			params[k] = v
+		}
+	}
+	for _, k := range []string{"since", "until"} {
+		v := query.Get(k)
+		if len(v) == 0 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s, RFC3339 is required", k)
+		}
+		params[k] = t
+	}
+	return params, nil
+}
+
+func listAuditLogs(w http.ResponseWriter, req *http.Request, projectId string) {
+	params, err := auditParams(req, projectId)
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+		return
+	}
+	query := req.URL.Query()
+	limit := defaultAuditLimit
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	if limit > maxAuditLimit {
+		limit = maxAuditLimit
+	}
+	offset := 0
+	if o, err := strconv.Atoi(query.Get("offset")); err == nil && o > 0 {
+		offset = o
+	}
+	logList, count := bluedb.QueryAuditLogs(params, offset, limit)
+	list := AuditLogsWrap{
+		AuditLogs: make([]*AuditLogInfo, 0, len(logList)),
+		Count:     count,
+	}
+	for _, l := range logList {
+		list.AuditLogs = append(list.AuditLogs, auditLogInfo(l))
+	}
+	writeJsonResp(w, http.StatusOK, list)
+}
+
+// exportAuditLogs streams the matching logs from the oldest as csv, or as
+// json lines with format=json.
+func exportAuditLogs(w http.ResponseWriter, req *http.Request, projectId string) {
+	params, err := auditParams(req, projectId)
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+		return
+	}
+	format := req.URL.Query().Get("format")
+	if len(format) == 0 {
+		format = "csv"
+	}
+	if format != "csv" && format != "json" {
+		DefaultHandler.ServeHTTP(w, req, errors.New("format must be csv or json"), http.StatusBadRequest)
+		return
+	}
+	name := "audit-logs-" + time.Now().UTC().Format("20060102T150405Z")
+	var write func([]*bluedb.AuditLog) error
+	if format == "csv" {
+		w.Header().Set("Content-Type", "text/csv")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.csv"`)
+		cw := csv.NewWriter(w)
+		_ = cw.Write([]string{"id", "create_at", "project_id", "actor_id", "api_key_id", "action",
+			"target", "method", "path", "status", "ip", "request_id", "before", "after"})
+		write = func(list []*bluedb.AuditLog) error {
+			for _, l := range list {
+				createAt := ""
+				if l.CreateAt != nil {
+					createAt = l.CreateAt.UTC().Format(time.RFC3339)
+				}
+				_ = cw.Write([]string{l.Id, createAt, l.ProjectId, l.ActorId, l.ApiKeyId, l.Action,
+					csvCell(l.Target), l.Method, csvCell(l.Path), strconv.Itoa(l.Status), l.Ip, l.RequestId,
+					l.Before, l.After})
+			}
+			cw.Flush()
+			return cw.Error()
+		}
+	} else {
+		w.Header().Set("Content-Type", "application/x-ndjson")
+		w.Header().Set("Content-Disposition", `attachment; filename="`+name+`.jsonl"`)
+		enc := json.NewEncoder(w)
+		write = func(list []*bluedb.AuditLog) error {
+			for _, l := range list {
+				if err := enc.Encode(auditLogInfo(l)); err != nil {
+					return err
+				}
+			}
+			return nil
+		}
+	}
+	w.WriteHeader(http.StatusOK)
+	if err := bluedb.EachAuditLog(params, auditExportBatch, write); err != nil {
+		logs.Error("export audit logs err:%s", err.Error())
+	}
+}
+
+func ListAuditLogs(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	listAuditLogs(w, req, ps["projectId"])
+}
+
+func ExportAuditLogs(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	exportAuditLogs(w, req, ps["projectId"])
+}
+
+// ListAllAuditLogs lists the logs of all projects and of the users for
+// platform admins, project_id filters them.
+func ListAllAuditLogs(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	listAuditLogs(w, req, "")
+}
+
+func ExportAllAuditLogs(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	exportAuditLogs(w, req, "")
+}
+
+// StartAuditPrune deletes the logs older than the conf
+// audit_retention_days once a day, 0 keeps them forever.
+func StartAuditPrune() {
+	days := conf.GetIntWithDefault("audit_retention_days", defaultAuditRetentionDays)
+	if days <= 0 {
+		return
+	}
+	go func() {
+		ticker := time.NewTicker(24 * time.Hour)
+		defer ticker.Stop()
+		for {
+			_ = bluedb.PruneAuditLogs(time.Now().AddDate(0, 0, -days))
+			<-ticker.C
+		}
+	}()
+}
@@ -1,52 +1,38 @@
 package aws
 
 import (
-	"encoding/json"
 	"github.com/aws/aws-sdk-go/aws"
 	"github.com/aws/aws-sdk-go/aws/credentials"
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/iot"
 	"github.com/jack0liu/logs"
 	"github.com/ssrs100/blueserver/bluedb"
-	"io/ioutil"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"github.com/ssrs100/blueserver/controller/middleware"
 	"net/http"
 	"strings"
 )
 
 type UpdateCertReq struct {
-	ThingName []string `json:"thing_names"`
-	Cert      string   `json:"cert"`
+	ThingName []string `json:"thing_names" valid:"MaxSize(100)"`
+	Cert      string   `json:"cert" valid:"MaxSize(16384)"`
 }
 
 func UpdateThingCert(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 	projectId := ps["projectId"]
-	u, err := bluedb.QueryUserById(projectId)
+	u, err := bluedb.QueryProject(projectId)
 	if err != nil {
 		logs.Error("Invalid projectId. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("project id not found"))
+		apierr.WriteMessage(w, http.StatusBadRequest, "project id not found")
 		return
 	}
 	if len(u.AccessKey) == 0 || len(u.SecretKey) == 0 {
 		logs.Info("%s ak/sk is empty, ready to create", u.Name)
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusBadRequest)
 		return
 	}
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
-		return
-	}
-	defer req.Body.Close()
-
 	var certReq = UpdateCertReq{}
-	if err = json.Unmarshal(body, &certReq); err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+	if !readJsonBody(w, req, &certReq) {
 		return
 	}
 
@@ -76,8 +62,7 @@ func UpdateThingCert(w http.ResponseWriter, req *http.Request, ps map[string]str
 	out, err := svc.DescribeCertificate(&descReq)
 	if err != nil {
 		logs.Error("describe principal err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
 	logs.Info("start cert====>")
@@ -91,15 +76,11 @@ func UpdateThingCert(w http.ResponseWriter, req *http.Request, ps map[string]str
 	outC, err := svc.CreateKeysAndCertificate(&createCertReq)
 	if err != nil {
 		logs.Error("create cert err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
-	logs.Info("create cert pem====>")
-	logs.Info(*outC.CertificatePem)
-	logs.Info("create cert private====>")
-	logs.Info(*outC.KeyPair.PrivateKey)
-	logs.Info("create cert public====>")
-	logs.Info(*outC.KeyPair.PublicKey)
+	logs.Info("create cert(%s)", *outC.CertificateId)
+	middleware.AuditChange(req, map[string]string{"certificate_id": certId},
+		map[string]string{"certificate_id": *outC.CertificateId})
 	w.WriteHeader(http.StatusOK)
 }
@@ -2,12 +2,14 @@ package aws
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/bluedb"
 	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/apierr"
 	"github.com/ssrs100/blueserver/influxdb"
 	"net/http"
-	"strings"
 	"time"
 )
 
@@ -35,7 +37,15 @@ type DeviceData struct {
 	DeviceName  string       `json:"device_name"`
 	Power       string       `json:"power"`
 	Data        *string      `json:"data,omitempty"`
+	TimeSource  string       `json:"time_source,omitempty"`
 	Thresh      InnerThresh  `json:"thresh"`
+
+	RawTemperature *json.Number `json:"raw_temperature,omitempty"`
+	RawHumidity    *json.Number `json:"raw_humidity,omitempty"`
+
+	DewPoint         *json.Number `json:"dew_point,omitempty"`
+	AbsoluteHumidity *json.Number `json:"absolute_humidity,omitempty"`
+	HeatIndex        *json.Number `json:"heat_index,omitempty"`
 }
 
 func getDataType(req *http.Request) string {
@@ -47,48 +57,67 @@ func getDataType(req *http.Request) string {
 	}
 }
 
+// getFormatOption returns the display unit of the project, it can be
+// overridden by the unit query, raw=true adds the values before calibration.
+func getFormatOption(req *http.Request, projectId string) influxdb.FormatOption {
+	opt := influxdb.FormatOption{
+		Unit: influxdb.UnitCelsius,
+		Raw:  req.URL.Query().Get("raw") == "true",
+	}
+	if u, err := bluedb.QueryProject(projectId); err == nil && influxdb.ValidUnit(u.TemperatureUnit) {
+		opt.Unit = u.TemperatureUnit
+	}
+	if unit := req.URL.Query().Get("unit"); influxdb.ValidUnit(unit) {
+		opt.Unit = unit
+	}
+	return opt
+}
+
 func GetDeviceLatestData(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 	projectId := ps["projectId"]
 	device := ps["device"]
-	data, err := influxdb.GetLatest(getDataType(req), "", device, projectId)
+	data, err := influxdb.GetLatest(getDataType(req), "", device, projectId, getFormatOption(req, projectId))
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("thing id not found"))
+		apierr.WriteMessage(w, http.StatusBadRequest, "thing id not found")
 		return
 	}
 	body, err := json.Marshal(data)
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
-	_, _ = w.Write(body)
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
 }
 
 func GetMultiDeviceLatestData(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 	projectId := ps["projectId"]
-	deviceAddrs := req.URL.Query().Get("deviceAddrs")
-	if len(deviceAddrs) == 0 {
-		logs.Error("deviceAddrs is empty")
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("deviceAddrs is empty"))
+	deviceList, err := selectDevices(req, projectId)
+	if err != nil {
+		logs.Error("select devices err:%s", err.Error())
+		apierr.Write(w, err, http.StatusBadRequest)
 		return
 	}
-	logs.Debug("device addr:%s", deviceAddrs)
+	logs.Debug("devices:%v", deviceList)
 	datas := make([]*DeviceData, 0)
-	deviceList := strings.Split(deviceAddrs, ";")
 	typ := getDataType(req)
+	opt := getFormatOption(req, projectId)
+	latest, err := influxdb.GetLatestOfDevices(typ, deviceList, projectId, opt)
+	if err != nil {
+		logs.Error("get devices data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	threshs := getDevThreshs(projectId, deviceList)
 	for _, device := range deviceList {
-		data, err := influxdb.GetLatest(typ, "", device, projectId)
-		if err != nil {
-			logs.Error("get device(%s) data. err:%s", device, err.Error())
+		data, ok := latest[device]
+		if !ok {
 			continue
 		}
-		thresh := getDevThresh(projectId, device)
+		thresh := threshs[device].inUnit(opt.Unit)
 		dd := &DeviceData{
 			ProjectId:   data.ProjectId,
 			Thing:       data.Thing,
@@ -100,25 +129,31 @@ func GetMultiDeviceLatestData(w http.ResponseWriter, req *http.Request, ps map[s
 			DeviceName:  data.DeviceName,
 			Power:       data.Power,
 			Data:        data.Data,
+			TimeSource:  data.TimeSource,
 			Thresh: InnerThresh{
 				TemperatureMin: thresh.TemperatureMin,
 				TemperatureMax: thresh.TemperatureMax,
 				HumidityMin:    thresh.HumidityMin,
 				HumidityMax:    thresh.HumidityMax,
 			},
+			RawTemperature: data.RawTemperature,
+			RawHumidity:    data.RawHumidity,
+
+			DewPoint:         data.DewPoint,
+			AbsoluteHumidity: data.AbsoluteHumidity,
+			HeatIndex:        data.HeatIndex,
 		}
 		datas = append(datas, dd)
 	}
 	body, err := json.Marshal(datas)
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
-	_, _ = w.Write(body)
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
 }
 
 func GetDeviceData(w http.ResponseWriter, req *http.Request, ps map[string]string) {
@@ -135,31 +170,37 @@ func GetDeviceData(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	if err != nil || tEnd.Before(tStart) {
 		strErr := fmt.Sprintf("Invalid time params, startAt:%s, endAt:%s.", startAt, endAt)
 		logs.Error(strErr)
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(strErr))
+		apierr.WriteMessage(w, http.StatusBadRequest, strErr)
 		return
 	}
-	datas, err := influxdb.GetDataByTime(getDataType(req), "", startAt, endAt, device, projectId)
+	typ := getDataType(req)
+	opt := getFormatOption(req, projectId)
+	datas, err := influxdb.GetDataByTime(typ, "", startAt, endAt, device, projectId, opt)
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusBadRequest)
 		return
 	}
 	list := influxdb.OutDataList{
 		Datas: datas,
 		Count: len(datas),
 	}
+	if typ == influxdb.TableTemperature {
+		if mkt, err := influxdb.GetMkt(device, projectId, tStart, tEnd, opt); err != nil {
+			logs.Error("get device(%s) mkt err:%s", device, err.Error())
+		} else {
+			list.Mkt = mkt.Mkt
+		}
+	}
 	body, err := json.Marshal(list)
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
-	_, _ = w.Write(body)
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
 }
 
 func GetGroupData(w http.ResponseWriter, req *http.Request, ps map[string]string) {
@@ -178,15 +219,13 @@ func GetGroupData(w http.ResponseWriter, req *http.Request, ps map[string]string
 	if err != nil || tEnd.Before(tStart) {
 		strErr := fmt.Sprintf("Invalid time params, startAt:%s, endAt:%s.", startAt, endAt)
 		logs.Error(strErr)
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(strErr))
+		apierr.WriteMessage(w, http.StatusBadRequest, strErr)
 		return
 	}
-	datas, err := influxdb.GetGroupDataByTime(measurement, "", startAt, endAt, device, projectId, interval)
+	datas, err := influxdb.GetGroupDataByTime(measurement, "", startAt, endAt, []string{device}, projectId, interval, getFormatOption(req, projectId))
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusBadRequest)
 		return
 	}
 	list := GroupData{
@@ -197,35 +236,139 @@ func GetGroupData(w http.ResponseWriter, req *http.Request, ps map[string]string
 	body, err := json.Marshal(list)
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write(body)
+}
+
+// GetDeviceMkt returns the mean kinetic temperature of the device in
+// [startAt, endAt), any window is allowed.
+func GetDeviceMkt(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	device := ps["device"]
+	projectId := ps["projectId"]
+	startAt := req.URL.Query().Get("startAt")
+	endAt := req.URL.Query().Get("endAt")
+	var tEnd time.Time
+	tStart, err := time.Parse(time.RFC3339, startAt)
+	if err == nil {
+		tEnd, err = time.Parse(time.RFC3339, endAt)
+	}
+	if err != nil || !tStart.Before(tEnd) {
+		strErr := fmt.Sprintf("Invalid time params, startAt:%s, endAt:%s.", startAt, endAt)
+		logs.Error(strErr)
+		apierr.WriteMessage(w, http.StatusBadRequest, strErr)
+		return
+	}
+	mkt, err := influxdb.GetMkt(device, projectId, tStart, tEnd, getFormatOption(req, projectId))
+	if err != nil {
+		logs.Error("get device(%s) mkt err:%s", device, err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	body, err := json.Marshal(mkt)
+	if err != nil {
+		logs.Error("Invalid data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
 }
 
-func ListDevices(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+// GetMultiDeviceData returns the readings of the devices selected by
+// deviceAddrs, group or tag.
+func GetMultiDeviceData(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 	projectId := ps["projectId"]
-	devices, err := influxdb.GetDevicesByThing(getDataType(req), "", projectId)
+	startAt := req.URL.Query().Get("startAt")
+	endAt := req.URL.Query().Get("endAt")
+	var tEnd time.Time
+	tStart, err := time.Parse(time.RFC3339, startAt)
+	if err == nil {
+		tEnd, err = time.Parse(time.RFC3339, endAt)
+	}
+	if err != nil || tEnd.Before(tStart) {
+		strErr := fmt.Sprintf("Invalid time params, startAt:%s, endAt:%s.", startAt, endAt)
+		logs.Error(strErr)
+		apierr.WriteMessage(w, http.StatusBadRequest, strErr)
+		return
+	}
+	devices, err := selectDevices(req, projectId)
+	if err != nil {
+		logs.Error("select devices err:%s", err.Error())
+		apierr.Write(w, err, http.StatusBadRequest)
+		return
+	}
+	datas, err := influxdb.GetMultiDataByTime(getDataType(req), startAt, endAt, devices, projectId, getFormatOption(req, projectId))
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("thing id not found"))
+		apierr.Write(w, err, http.StatusBadRequest)
 		return
 	}
-	list := influxdb.DeviceList{
-		Devices: devices,
+	list := influxdb.OutDataList{
+		Datas: datas,
+		Count: len(datas),
 	}
 	body, err := json.Marshal(list)
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write(body)
+}
+
+// GetMultiGroupData aggregates the measurement over all the devices selected
+// by deviceAddrs, group or tag.
+func GetMultiGroupData(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	startAt := req.URL.Query().Get("startAt")
+	endAt := req.URL.Query().Get("endAt")
+	interval := req.URL.Query().Get("interval")
+	measurement := req.URL.Query().Get("measurement")
+	var tEnd time.Time
+	tStart, err := time.Parse(time.RFC3339, startAt)
+	if err == nil {
+		tEnd, err = time.Parse(time.RFC3339, endAt)
+	}
+	if err != nil || tEnd.Before(tStart) {
+		strErr := fmt.Sprintf("Invalid time params, startAt:%s, endAt:%s.", startAt, endAt)
+		logs.Error(strErr)
+		apierr.WriteMessage(w, http.StatusBadRequest, strErr)
+		return
+	}
+	devices, err := selectDevices(req, projectId)
+	if err == nil && len(devices) == 0 {
+		err = errors.New("no device selected")
+	}
+	if err != nil {
+		logs.Error("select devices err:%s", err.Error())
+		apierr.Write(w, err, http.StatusBadRequest)
+		return
+	}
+	datas, err := influxdb.GetGroupDataByTime(measurement, "", startAt, endAt, devices, projectId, interval, getFormatOption(req, projectId))
+	if err != nil {
+		logs.Error("Invalid data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusBadRequest)
+		return
+	}
+	list := GroupData{
+		Values:      datas,
+		Measurement: measurement,
+		Count:       len(datas),
+	}
+	body, err := json.Marshal(list)
+	if err != nil {
+		logs.Error("Invalid data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
 }
@@ -0,0 +1,138 @@
+package aws
+
+import (
+	"encoding/json"
+	"github.com/astaxie/beego/validation"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"net/http"
+)
+
+type DevCalibration struct {
+	ProjectId         string   `json:"project_id"`
+	Device            string   `json:"device"`
+	TemperatureOffset *float64 `json:"temperature_offset"`
+	TemperatureGain   *float64 `json:"temperature_gain"`
+	HumidityOffset    *float64 `json:"humidity_offset"`
+	HumidityGain      *float64 `json:"humidity_gain"`
+}
+
+// Valid rejects the gains of 0, they would flatten the data.
+func (c *DevCalibration) Valid(v *validation.Validation) {
+	if c.TemperatureGain != nil && *c.TemperatureGain == 0 {
+		v.AddError("TemperatureGain.NotZero", "Must not be 0")
+	}
+	if c.HumidityGain != nil && *c.HumidityGain == 0 {
+		v.AddError("HumidityGain.NotZero", "Must not be 0")
+	}
+}
+
+func getDevCalibration(projectId, device string) DevCalibration {
+	devc, _ := bluedb.QueryDevCalibration(projectId, device)
+	if devc == nil {
+		devc = &bluedb.DeviceCalibration{
+			DeviceId:        device,
+			TemperatureGain: 1,
+			HumidityGain:    1,
+		}
+	}
+	data := DevCalibration{
+		ProjectId:         projectId,
+		Device:            device,
+		TemperatureOffset: &devc.TemperatureOffset,
+		TemperatureGain:   &devc.TemperatureGain,
+		HumidityOffset:    &devc.HumidityOffset,
+		HumidityGain:      &devc.HumidityGain,
+	}
+	return data
+}
+
+func GetDeviceCalibration(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	device := ps["device"]
+	data := getDevCalibration(projectId, device)
+	body, err := json.Marshal(data)
+	if err != nil {
+		logs.Error("Invalid data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusBadRequest)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func PutDeviceCalibration(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	device := ps["device"]
+	var calibReq DevCalibration
+	if !readJsonBody(w, req, &calibReq) {
+		return
+	}
+	before := getDevCalibration(projectId, device)
+	devc, err := bluedb.QueryDevCalibration(projectId, device)
+	if err != nil {
+		logs.Error("get dev calibration fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	dc := bluedb.DeviceCalibration{
+		ProjectId:       projectId,
+		DeviceId:        device,
+		TemperatureGain: 1,
+		HumidityGain:    1,
+	}
+	if devc != nil {
+		dc = *devc
+	}
+	if calibReq.TemperatureOffset != nil {
+		dc.TemperatureOffset = *calibReq.TemperatureOffset
+	}
+	if calibReq.TemperatureGain != nil {
+		dc.TemperatureGain = *calibReq.TemperatureGain
+	}
+	if calibReq.HumidityOffset != nil {
+		dc.HumidityOffset = *calibReq.HumidityOffset
+	}
+	if calibReq.HumidityGain != nil {
+		dc.HumidityGain = *calibReq.HumidityGain
+	}
+	if devc == nil {
+		logs.Info("save to (%v)", dc)
+		err = bluedb.SaveDevCalibration(dc)
+	} else {
+		logs.Info("update to (%v)", dc)
+		err = bluedb.UpdateDevCalibration(dc)
+	}
+	if err != nil {
+		logs.Error("modify dev calibration fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	middleware.AuditChange(req, before, getDevCalibration(projectId, device))
+	w.WriteHeader(http.StatusOK)
+}
+
+func DeleteDeviceCalibration(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	device := ps["device"]
+	devc, err := bluedb.QueryDevCalibration(projectId, device)
+	if err != nil {
+		logs.Error("get dev calibration fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	if devc == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	middleware.AuditChange(req, getDevCalibration(projectId, device), nil)
+	if err := bluedb.DeleteDevCalibration(devc.Id); err != nil {
+		logs.Error("delete dev calibration fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,356 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+type DeviceInfo struct {
+	Id          string     `json:"id"`
+	ProjectId   string     `json:"project_id"`
+	Device      string     `json:"device"`
+	Name        string     `json:"name"`
+	Description string     `json:"description"`
+	Tags        []string   `json:"tags"`
+	Status      string     `json:"status"`
+	DataType    string     `json:"data_type"`
+	DeviceName  string     `json:"device_name"`
+	Thing       string     `json:"thing"`
+	Firmware    string     `json:"firmware"`
+	Battery     float64    `json:"battery"`
+	FirstSeen   *time.Time `json:"first_seen,omitempty"`
+	LastSeen    *time.Time `json:"last_seen,omitempty"`
+	CreateAt    *time.Time `json:"create_at,omitempty"`
+	LocationId  string     `json:"location_id"`
+}
+
+// DeviceInfoList keeps devices as the list of device addresses for the
+// clients of the former influxdb based list.
+type DeviceInfoList struct {
+	Devices []string      `json:"devices"`
+	Items   []*DeviceInfo `json:"items"`
+	Count   int64         `json:"count"`
+}
+
+type DeviceReq struct {
+	Device      string    `json:"device" valid:"MaxSize(128)"`
+	Name        *string   `json:"name" valid:"MaxSize(128)"`
+	Description *string   `json:"description" valid:"MaxSize(256)"`
+	Tags        *[]string `json:"tags" valid:"MaxSize(32)"`
+	Status      *string   `json:"status" valid:"DeviceStatus"`
+	LocationId  *string   `json:"location_id" valid:"MaxSize(64)"`
+}
+
+func toDeviceInfo(d *bluedb.Device) *DeviceInfo {
+	return &DeviceInfo{
+		Id:          d.Id,
+		ProjectId:   d.ProjectId,
+		Device:      d.DeviceId,
+		Name:        d.Name,
+		Description: d.Description,
+		Tags:        bluedb.TagList(d.Tags),
+		Status:      d.Status,
+		DataType:    d.DataType,
+		DeviceName:  d.DeviceName,
+		Thing:       d.Thing,
+		Firmware:    d.Firmware,
+		Battery:     d.Battery,
+		FirstSeen:   d.FirstSeen,
+		LastSeen:    d.LastSeen,
+		CreateAt:    d.CreateAt,
+		LocationId:  d.LocationId,
+	}
+}
+
+// deviceSummary is the device in the audit log.
+func deviceSummary(d *bluedb.Device) map[string]string {
+	return map[string]string{
+		"device":      d.DeviceId,
+		"name":        d.Name,
+		"description": d.Description,
+		"tags":        d.Tags,
+		"status":      d.Status,
+		"location_id": d.LocationId,
+	}
+}
+
+func validDeviceStatus(status string) bool {
+	return status == bluedb.DeviceStatusActive || status == bluedb.DeviceStatusDisabled
+}
+
+func init() {
+	apierr.RegisterCheck("DeviceStatus", "Must be active or disabled", validDeviceStatus)
+}
+
+func readDeviceReq(w http.ResponseWriter, req *http.Request, projectId string) (*DeviceReq, bool) {
+	var devReq DeviceReq
+	if !readJsonBody(w, req, &devReq) {
+		return nil, false
+	}
+	if devReq.LocationId != nil && !checkLocation(w, projectId, *devReq.LocationId) {
+		return nil, false
+	}
+	return &devReq, true
+}
+
+func (dr *DeviceReq) apply(dev *bluedb.Device) {
+	if dr.Name != nil {
+		dev.Name = *dr.Name
+	}
+	if dr.Description != nil {
+		dev.Description = *dr.Description
+	}
+	if dr.Tags != nil {
+		dev.Tags = bluedb.JoinTags(*dr.Tags)
+	}
+	if dr.Status != nil {
+		dev.Status = *dr.Status
+	}
+	if dr.LocationId != nil {
+		dev.LocationId = *dr.LocationId
+	}
+}
+
+// ListDevices lists the registered devices of the project, they can be
+// filtered by thing, status, type, group, tag, last seen time and a search
+// text matched against address, names and description.
+func ListDevices(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	query := req.URL.Query()
+	params := make(map[string]interface{})
+	params["project_id"] = projectId
+	for _, key := range []string{"thing", "status", "search"} {
+		if v := query.Get(key); len(v) > 0 {
+			params[key] = v
+		}
+	}
+	if err := groupParams(req, projectId, params); err != nil {
+		logs.Error("group params err:%s", err.Error())
+		apierr.Write(w, err, http.StatusBadRequest)
+		return
+	}
+	if typ := query.Get("type"); typ == common.DataTypeBroadcast || typ == common.DataTypeSensor {
+		params["data_type"] = typ
+	}
+	for key, param := range map[string]string{"seenAfter": "seen_after", "seenBefore": "seen_before"} {
+		v := query.Get(key)
+		if len(v) == 0 {
+			continue
+		}
+		t, err := time.Parse(time.RFC3339, v)
+		if err != nil {
+			apierr.WriteMessage(w, http.StatusBadRequest, fmt.Sprintf("Invalid time params, %s:%s.", key, v))
+			return
+		}
+		params[param] = t
+	}
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil {
+		params["limit"] = l
+	}
+	if o, err := strconv.Atoi(query.Get("offset")); err == nil {
+		params["offset"] = o
+	}
+
+	devices, count, err := bluedb.QueryDevices(params)
+	if err != nil {
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	list := DeviceInfoList{
+		Devices: make([]string, 0, len(devices)),
+		Items:   make([]*DeviceInfo, 0, len(devices)),
+		Count:   count,
+	}
+	for _, d := range devices {
+		list.Devices = append(list.Devices, d.DeviceId)
+		list.Items = append(list.Items, toDeviceInfo(d))
+	}
+	body, err := json.Marshal(list)
+	if err != nil {
+		logs.Error("Invalid data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func GetDevice(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	device := ps["device"]
+	dev, err := bluedb.QueryDevice(projectId, device)
+	if err != nil {
+		logs.Error("get device fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	if dev == nil {
+		apierr.WriteMessage(w, http.StatusNotFound, "device not found")
+		return
+	}
+	body, err := json.Marshal(toDeviceInfo(dev))
+	if err != nil {
+		logs.Error("Invalid data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// CreateDevice registers a device before it reports.
+func CreateDevice(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	devReq, ok := readDeviceReq(w, req, projectId)
+	if !ok {
+		return
+	}
+	if len(devReq.Device) == 0 {
+		apierr.WriteMessage(w, http.StatusBadRequest, "device is empty")
+		return
+	}
+	dev, err := bluedb.QueryDevice(projectId, devReq.Device)
+	if err != nil {
+		logs.Error("get device fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	if dev != nil {
+		apierr.WriteMessage(w, http.StatusConflict, "device already exists")
+		return
+	}
+	newDev := bluedb.Device{
+		ProjectId: projectId,
+		DeviceId:  devReq.Device,
+	}
+	devReq.apply(&newDev)
+	if len(newDev.Status) == 0 {
+		newDev.Status = bluedb.DeviceStatusActive
+	}
+	if err := bluedb.SaveDevice(newDev); err != nil {
+		logs.Error("save device fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	middleware.AuditChange(req, nil, deviceSummary(&newDev))
+	w.WriteHeader(http.StatusCreated)
+}
+
+func UpdateDevice(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	device := ps["device"]
+	devReq, ok := readDeviceReq(w, req, projectId)
+	if !ok {
+		return
+	}
+	dev, err := bluedb.QueryDevice(projectId, device)
+	if err != nil {
+		logs.Error("get device fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	if dev == nil {
+		apierr.WriteMessage(w, http.StatusNotFound, "device not found")
+		return
+	}
+	before := deviceSummary(dev)
+	devReq.apply(dev)
+	if err := bluedb.UpdateDevice(*dev); err != nil {
+		logs.Error("update device fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	middleware.AuditChange(req, before, deviceSummary(dev))
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteDevice removes the device from the registry, its data is kept. A
+// device which still reports is registered again, disable it instead.
+func DeleteDevice(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	device := ps["device"]
+	dev, err := bluedb.QueryDevice(projectId, device)
+	if err != nil {
+		logs.Error("get device fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	if dev == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if err := bluedb.DeleteDevice(dev.Id); err != nil {
+		logs.Error("delete device fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	middleware.AuditChange(req, deviceSummary(dev), nil)
+	w.WriteHeader(http.StatusOK)
+}
+
+type DeviceAssignment struct {
+	Device  string     `json:"device"`
+	Thing   string     `json:"thing"`
+	StartAt *time.Time `json:"start_at"`
+	EndAt   *time.Time `json:"end_at,omitempty"`
+}
+
+type DeviceAssignmentList struct {
+	Assignments []*DeviceAssignment `json:"assignments"`
+}
+
+// GetDeviceAssignments returns the things which relayed the device, the
+// optional startAt and endAt limit the history to a window.
+func GetDeviceAssignments(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	device := ps["device"]
+	var tStart, tEnd time.Time
+	var err error
+	if startAt := req.URL.Query().Get("startAt"); len(startAt) > 0 {
+		tStart, err = time.Parse(time.RFC3339, startAt)
+	}
+	if endAt := req.URL.Query().Get("endAt"); err == nil && len(endAt) > 0 {
+		tEnd, err = time.Parse(time.RFC3339, endAt)
+	}
+	if err != nil {
+		strErr := fmt.Sprintf("Invalid time params, err:%s.", err.Error())
+		logs.Error(strErr)
+		apierr.WriteMessage(w, http.StatusBadRequest, strErr)
+		return
+	}
+	das, err := bluedb.QueryDeviceAssignments(projectId, device, tStart, tEnd)
+	if err != nil {
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	list := DeviceAssignmentList{
+		Assignments: make([]*DeviceAssignment, 0, len(das)),
+	}
+	for _, da := range das {
+		list.Assignments = append(list.Assignments, &DeviceAssignment{
+			Device:  da.DeviceId,
+			Thing:   da.Thing,
+			StartAt: da.StartAt,
+			EndAt:   da.EndAt,
+		})
+	}
+	body, err := json.Marshal(list)
+	if err != nil {
+		logs.Error("Invalid data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
@@ -0,0 +1,29 @@
+package aws
+
+import (
+	"github.com/ssrs100/blueserver/bluedb"
+)
+
+// deviceStore is the part of bluedb the device selectors and the device
+// lookups of the things read, the tests replace it.
+type deviceStore interface {
+	ResolveLocation(projectId, selector string) (*bluedb.Location, error)
+	LocationSubtree(projectId, id string) []string
+	QueryDevices(params map[string]interface{}) ([]*bluedb.Device, int64, error)
+}
+
+type dbDeviceStore struct{}
+
+func (dbDeviceStore) ResolveLocation(projectId, selector string) (*bluedb.Location, error) {
+	return bluedb.ResolveLocation(projectId, selector)
+}
+
+func (dbDeviceStore) LocationSubtree(projectId, id string) []string {
+	return bluedb.LocationSubtree(projectId, id)
+}
+
+func (dbDeviceStore) QueryDevices(params map[string]interface{}) ([]*bluedb.Device, int64, error) {
+	return bluedb.QueryDevices(params)
+}
+
+var deviceDb deviceStore = dbDeviceStore{}
@@ -2,10 +2,14 @@ package aws
 
 import (
 	"encoding/json"
+	"github.com/astaxie/beego/validation"
 	"github.com/jack0liu/logs"
 	"github.com/ssrs100/blueserver/bluedb"
 	"github.com/ssrs100/blueserver/common"
-	"io/ioutil"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"github.com/ssrs100/blueserver/influxdb"
+	"math"
 	"net/http"
 )
 
@@ -18,8 +22,100 @@ type DevThresh struct {
 	HumidityMax    *float64 `json:"humidity_max"`
 }
 
+const absoluteZero = -273.15
+
+// Valid checks the bounds of the humidity thresholds given, the temperatures
+// are checked in celsius and the order of min and max once they are merged
+// with the saved ones.
+func (t *DevThresh) Valid(v *validation.Validation) {
+	if t.HumidityMin != nil && (*t.HumidityMin < 0 || *t.HumidityMin > 100) {
+		v.AddError("HumidityMin.Range", "Range is 0 to 100")
+	}
+	if t.HumidityMax != nil && (*t.HumidityMax < 0 || *t.HumidityMax > 100) {
+		v.AddError("HumidityMax.Range", "Range is 0 to 100")
+	}
+}
+
+// roundThresh drops the float error of a unit conversion, so that 46.4°F
+// is saved as 8°C and shown as 46.4°F again.
+func roundThresh(v float64) float64 {
+	return math.Round(v*1e6) / 1e6
+}
+
+// inUnit returns the thresholds with the temperatures in the display unit,
+// the thresholds are saved in celsius.
+func (t DevThresh) inUnit(unit string) DevThresh {
+	convert := func(c *float64) *float64 {
+		if c == nil {
+			return nil
+		}
+		v := roundThresh(influxdb.ConvertTemperature(unit, *c))
+		return &v
+	}
+	t.TemperatureMin = convert(t.TemperatureMin)
+	t.TemperatureMax = convert(t.TemperatureMax)
+	return t
+}
+
+// toCelsius converts the temperatures given in the display unit to celsius.
+func (t *DevThresh) toCelsius(unit string) {
+	convert := func(v *float64) *float64 {
+		if v == nil {
+			return nil
+		}
+		c := roundThresh(influxdb.TemperatureToCelsius(unit, *v))
+		return &c
+	}
+	t.TemperatureMin = convert(t.TemperatureMin)
+	t.TemperatureMax = convert(t.TemperatureMax)
+}
+
+// checkThresh tells the temperatures below absolute zero and the min
+// thresholds above their max.
+func checkThresh(dt *bluedb.DeviceThresh) error {
+	var fields []apierr.FieldError
+	if dt.TemperatureMin < absoluteZero {
+		fields = append(fields, apierr.FieldError{Field: "temperature_min", Code: "range",
+			Message: "Must not be below absolute zero"})
+	}
+	if dt.TemperatureMax < absoluteZero {
+		fields = append(fields, apierr.FieldError{Field: "temperature_max", Code: "range",
+			Message: "Must not be below absolute zero"})
+	}
+	if dt.TemperatureMin > dt.TemperatureMax {
+		fields = append(fields, apierr.FieldError{Field: "temperature_min", Code: "range",
+			Message: "Must not be above temperature_max"})
+	}
+	if dt.HumidityMin > dt.HumidityMax {
+		fields = append(fields, apierr.FieldError{Field: "humidity_min", Code: "range",
+			Message: "Must not be above humidity_max"})
+	}
+	if len(fields) > 0 {
+		return apierr.Validation(fields)
+	}
+	return nil
+}
+
 func getDevThresh(projectId, device string) DevThresh {
 	devt, _ := bluedb.QueryDevThresh(projectId, device)
+	return toDevThresh(projectId, device, devt)
+}
+
+// getDevThreshs returns the thresholds of the devices by one query.
+func getDevThreshs(projectId string, devices []string) map[string]DevThresh {
+	devts, err := bluedb.QueryDevThreshs(projectId, devices)
+	if err != nil {
+		devts = nil
+	}
+	threshs := make(map[string]DevThresh, len(devices))
+	for _, device := range devices {
+		threshs[device] = toDevThresh(projectId, device, devts[device])
+	}
+	return threshs
+}
+
+// toDevThresh falls back to the default thresholds if the device has none.
+func toDevThresh(projectId, device string, devt *bluedb.DeviceThresh) DevThresh {
 	if devt == nil {
 		logs.Warn("not found thresh data.")
 		devt = &bluedb.DeviceThresh{
@@ -44,12 +140,11 @@ func getDevThresh(projectId, device string) DevThresh {
 func GetDeviceThresh(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 	projectId := ps["projectId"]
 	device := ps["device"]
-	data := getDevThresh(projectId, device)
+	data := getDevThresh(projectId, device).inUnit(getFormatOption(req, projectId).Unit)
 	body, err := json.Marshal(data)
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusBadRequest)
 		return
 	}
 	w.Header().Add("Content-Type", "application/json")
@@ -60,29 +155,17 @@ func GetDeviceThresh(w http.ResponseWriter, req *http.Request, ps map[string]str
 func PutDeviceThresh(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 	projectId := ps["projectId"]
 	device := ps["device"]
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
-		return
-	}
-	defer req.Body.Close()
-	logs.Info("body:%s", string(body))
 	var devThreshReq DevThresh
-	err = json.Unmarshal(body, &devThreshReq)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+	if !readJsonBody(w, req, &devThreshReq) {
 		return
 	}
+	devThreshReq.toCelsius(getFormatOption(req, projectId).Unit)
 	logs.Info("devThreshReq:%v", devThreshReq)
+	before := getDevThresh(projectId, device)
 	devt, err := bluedb.QueryDevThresh(projectId, device)
 	if err != nil {
 		logs.Error("get dev thresh fail. err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
 	dt := bluedb.DeviceThresh{
@@ -91,71 +174,75 @@ func PutDeviceThresh(w http.ResponseWriter, req *http.Request, ps map[string]str
 	}
 	if devt == nil {
 		if devThreshReq.TemperatureMin != nil {
-			logs.Info("TemperatureMin:%d", *devThreshReq.TemperatureMin)
+			logs.Info("TemperatureMin:%v", *devThreshReq.TemperatureMin)
 			dt.TemperatureMin = *devThreshReq.TemperatureMin
 		} else {
 			dt.TemperatureMin = common.MinTemp
 		}
 		if devThreshReq.TemperatureMax != nil {
-			logs.Info("TemperatureMax:%d", *devThreshReq.TemperatureMax)
+			logs.Info("TemperatureMax:%v", *devThreshReq.TemperatureMax)
 			dt.TemperatureMax = *devThreshReq.TemperatureMax
 		} else {
 			dt.TemperatureMax = common.MaxTemp
 		}
 		// humidity
 		if devThreshReq.HumidityMin != nil {
-			logs.Info("HumidityMin:%d", *devThreshReq.HumidityMin)
+			logs.Info("HumidityMin:%v", *devThreshReq.HumidityMin)
 			dt.HumidityMin = *devThreshReq.HumidityMin
 		} else {
 			dt.HumidityMin = common.MinHumi
 		}
 		if devThreshReq.HumidityMax != nil {
-			logs.Info("HumidityMax:%d", *devThreshReq.HumidityMax)
+			logs.Info("HumidityMax:%v", *devThreshReq.HumidityMax)
 			dt.HumidityMax = *devThreshReq.HumidityMax
 		} else {
 			dt.HumidityMax = common.MaxHumi
 		}
-
-		if dt.TemperatureMin > dt.TemperatureMax {
-
+		if err := checkThresh(&dt); err != nil {
+			apierr.Write(w, err, http.StatusBadRequest)
+			return
 		}
 		logs.Info("save to (%v)", dt)
 		err = bluedb.SaveDevThresh(dt)
 	} else {
 		dt.Id = devt.Id
 		if devThreshReq.TemperatureMin != nil {
-			logs.Info("TemperatureMin:%d", *devThreshReq.TemperatureMin)
+			logs.Info("TemperatureMin:%v", *devThreshReq.TemperatureMin)
 			dt.TemperatureMin = *devThreshReq.TemperatureMin
 		} else {
 			dt.TemperatureMin = devt.TemperatureMin
 		}
 		if devThreshReq.TemperatureMax != nil {
-			logs.Info("TemperatureMax:%d", *devThreshReq.TemperatureMax)
+			logs.Info("TemperatureMax:%v", *devThreshReq.TemperatureMax)
 			dt.TemperatureMax = *devThreshReq.TemperatureMax
 		} else {
 			dt.TemperatureMax = devt.TemperatureMax
 		}
 		// humidity
 		if devThreshReq.HumidityMin != nil {
-			logs.Info("HumidityMin:%d", *devThreshReq.HumidityMin)
+			logs.Info("HumidityMin:%v", *devThreshReq.HumidityMin)
 			dt.HumidityMin = *devThreshReq.HumidityMin
 		} else {
 			dt.HumidityMin = devt.HumidityMin
 		}
 		if devThreshReq.HumidityMax != nil {
-			logs.Info("HumidityMax:%d", *devThreshReq.HumidityMax)
+			logs.Info("HumidityMax:%v", *devThreshReq.HumidityMax)
 			dt.HumidityMax = *devThreshReq.HumidityMax
 		} else {
 			dt.HumidityMax = devt.HumidityMax
 		}
+		if err := checkThresh(&dt); err != nil {
+			apierr.Write(w, err, http.StatusBadRequest)
+			return
+		}
 		logs.Info("update to (%v)", dt)
 		err = bluedb.UpdateDevThresh(dt)
 	}
 	if err != nil {
 		logs.Error("modify dev thresh fail. err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
+	middleware.AuditChange(req, before, getDevThresh(projectId, device))
 	w.WriteHeader(http.StatusOK)
 }
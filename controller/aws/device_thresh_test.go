@@ -0,0 +1,66 @@
+package aws
+
+import (
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/influxdb"
+	"testing"
+)
+
+func float(v float64) *float64 {
+	return &v
+}
+
+func TestThreshUnit(t *testing.T) {
+	req := DevThresh{TemperatureMin: float(35.6), TemperatureMax: float(46.4), HumidityMax: float(60)}
+	req.toCelsius(influxdb.UnitFahrenheit)
+	if req.TemperatureMin == nil || *req.TemperatureMin != 2 || req.TemperatureMax == nil || *req.TemperatureMax != 8 {
+		t.Fatalf("35.6°F to 46.4°F is saved as %v to %v", *req.TemperatureMin, *req.TemperatureMax)
+	}
+	if *req.HumidityMax != 60 || req.HumidityMin != nil {
+		t.Errorf("humidity is converted")
+	}
+
+	shown := req.inUnit(influxdb.UnitFahrenheit)
+	if *shown.TemperatureMin != 35.6 || *shown.TemperatureMax != 46.4 {
+		t.Errorf("thresholds are shown as %v to %v°F", *shown.TemperatureMin, *shown.TemperatureMax)
+	}
+	// the saved thresholds are kept in celsius
+	if *req.TemperatureMin != 2 {
+		t.Errorf("showing the thresholds changes them")
+	}
+	if shown := req.inUnit(influxdb.UnitCelsius); *shown.TemperatureMin != 2 || *shown.TemperatureMax != 8 {
+		t.Errorf("thresholds are shown as %v to %v°C", *shown.TemperatureMin, *shown.TemperatureMax)
+	}
+
+	partial := DevThresh{TemperatureMax: float(50)}
+	partial.toCelsius(influxdb.UnitFahrenheit)
+	if partial.TemperatureMin != nil || *partial.TemperatureMax != 10 {
+		t.Errorf("partial thresholds are converted to %v", partial)
+	}
+}
+
+func TestCheckThresh(t *testing.T) {
+	for _, c := range []struct {
+		unit     string
+		min, max float64
+		valid    bool
+	}{
+		{influxdb.UnitCelsius, 2, 8, true},
+		{influxdb.UnitCelsius, 8, 2, false},
+		{influxdb.UnitCelsius, -280, 8, false},
+		// below -273.15 in fahrenheit but above absolute zero
+		{influxdb.UnitFahrenheit, -300, 46.4, true},
+		{influxdb.UnitFahrenheit, -460, 46.4, false},
+	} {
+		req := DevThresh{TemperatureMin: float(c.min), TemperatureMax: float(c.max)}
+		req.toCelsius(c.unit)
+		dt := bluedb.DeviceThresh{
+			TemperatureMin: *req.TemperatureMin,
+			TemperatureMax: *req.TemperatureMax,
+			HumidityMax:    100,
+		}
+		if err := checkThresh(&dt); (err == nil) != c.valid {
+			t.Errorf("thresholds %v to %v°%s: err %v", c.min, c.max, c.unit, err)
+		}
+	}
+}
@@ -0,0 +1,237 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"net/http"
+	"time"
+)
+
+type Location struct {
+	Id          string     `json:"id"`
+	ProjectId   string     `json:"project_id"`
+	ParentId    string     `json:"parent_id"`
+	Level       string     `json:"level"`
+	Name        string     `json:"name" valid:"Required;MaxSize(128)"`
+	Description string     `json:"description" valid:"MaxSize(256)"`
+	CreateAt    *time.Time `json:"create_at,omitempty"`
+}
+
+type LocationsWrap struct {
+	Locations []*Location `json:"locations"`
+}
+
+func toLocation(l *bluedb.Location) *Location {
+	return &Location{
+		Id:          l.Id,
+		ProjectId:   l.ProjectId,
+		ParentId:    l.ParentId,
+		Level:       l.Level,
+		Name:        l.Name,
+		Description: l.Description,
+		CreateAt:    l.CreateAt,
+	}
+}
+
+// checkLocation writes the error response if the location is not in the
+// project, an empty id clears the location.
+func checkLocation(w http.ResponseWriter, projectId, id string) bool {
+	if len(id) == 0 {
+		return true
+	}
+	l, err := bluedb.QueryLocation(projectId, id)
+	if err != nil {
+		logs.Error("get location fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return false
+	}
+	if l == nil {
+		apierr.WriteMessage(w, http.StatusNotFound, fmt.Sprintf("location(%s) not found", id))
+		return false
+	}
+	return true
+}
+
+// checkLocationName writes the error response if a sibling of the location
+// has the name already.
+func checkLocationName(w http.ResponseWriter, projectId, parentId, level, name, exceptId string) bool {
+	taken, err := bluedb.LocationNameTaken(projectId, parentId, level, name, exceptId)
+	if err != nil {
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return false
+	}
+	if taken {
+		apierr.WriteMessage(w, http.StatusConflict, fmt.Sprintf("location(%s) already exists", name))
+		return false
+	}
+	return true
+}
+
+func readLocationReq(w http.ResponseWriter, req *http.Request) (*Location, bool) {
+	var l Location
+	if !readJsonBody(w, req, &l) {
+		return nil, false
+	}
+	return &l, true
+}
+
+// ListLocations lists the locations of the project, filtered by parent
+// and level.
+func ListLocations(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	params := make(map[string]interface{})
+	params["project_id"] = projectId
+	if parent := req.URL.Query().Get("parent"); len(parent) > 0 {
+		params["parent_id"] = parent
+	}
+	if level := req.URL.Query().Get("level"); len(level) > 0 {
+		params["level"] = level
+	}
+	list := LocationsWrap{
+		Locations: make([]*Location, 0),
+	}
+	for _, l := range bluedb.QueryLocations(params) {
+		list.Locations = append(list.Locations, toLocation(l))
+	}
+	body, err := json.Marshal(list)
+	if err != nil {
+		logs.Error("Invalid data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func GetLocation(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	l, err := bluedb.QueryLocation(projectId, ps["locationId"])
+	if err != nil {
+		logs.Error("get location fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	if l == nil {
+		apierr.WriteMessage(w, http.StatusNotFound, "location not found")
+		return
+	}
+	body, err := json.Marshal(toLocation(l))
+	if err != nil {
+		logs.Error("Invalid data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+// CreateLocation adds a site, or an area or asset below its parent.
+func CreateLocation(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	l, ok := readLocationReq(w, req)
+	if !ok {
+		return
+	}
+	if !bluedb.ValidLocationLevel(l.Level) {
+		apierr.WriteMessage(w, http.StatusBadRequest, fmt.Sprintf("invalid level(%s)", l.Level))
+		return
+	}
+	parentLevel := bluedb.ParentLocationLevel(l.Level)
+	if len(parentLevel) == 0 {
+		l.ParentId = ""
+	} else {
+		parent, err := bluedb.QueryLocation(projectId, l.ParentId)
+		if err != nil {
+			logs.Error("get location fail. err:%s", err.Error())
+			apierr.Write(w, err, http.StatusInternalServerError)
+			return
+		}
+		if parent == nil || parent.Level != parentLevel {
+			apierr.WriteMessage(w, http.StatusBadRequest, fmt.Sprintf("parent of %s must be a %s", l.Level, parentLevel))
+			return
+		}
+	}
+	if !checkLocationName(w, projectId, l.ParentId, l.Level, l.Name, "") {
+		return
+	}
+	id, err := bluedb.SaveLocation(bluedb.Location{
+		ProjectId:   projectId,
+		ParentId:    l.ParentId,
+		Level:       l.Level,
+		Name:        l.Name,
+		Description: l.Description,
+	})
+	if err != nil {
+		logs.Error("save location fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	body, _ := json.Marshal(map[string]string{"id": id})
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusCreated)
+	_, _ = w.Write(body)
+}
+
+// UpdateLocation renames the location, it can not be moved.
+func UpdateLocation(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	update, ok := readLocationReq(w, req)
+	if !ok {
+		return
+	}
+	l, err := bluedb.QueryLocation(projectId, ps["locationId"])
+	if err != nil {
+		logs.Error("get location fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	if l == nil {
+		apierr.WriteMessage(w, http.StatusNotFound, "location not found")
+		return
+	}
+	if !checkLocationName(w, projectId, l.ParentId, l.Level, update.Name, l.Id) {
+		return
+	}
+	l.Name = update.Name
+	l.Description = update.Description
+	if err := bluedb.UpdateLocation(*l); err != nil {
+		logs.Error("update location fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// DeleteLocation deletes a location without children.
+func DeleteLocation(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	l, err := bluedb.QueryLocation(projectId, ps["locationId"])
+	if err != nil {
+		logs.Error("get location fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	if l == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	children := bluedb.QueryLocations(map[string]interface{}{
+		"project_id": projectId,
+		"parent_id":  l.Id,
+	})
+	if len(children) > 0 {
+		apierr.WriteMessage(w, http.StatusConflict, "location has children")
+		return
+	}
+	if err := bluedb.DeleteLocation(l.Id); err != nil {
+		logs.Error("delete location fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,175 @@
+package aws
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// MqttBridge is the bridge config of a project, the password and the client
+// key are never returned.
+type MqttBridge struct {
+	BrokerUrl          string     `json:"broker_url"`
+	ClientId           string     `json:"client_id,omitempty"`
+	Username           string     `json:"username,omitempty"`
+	CaCert             string     `json:"ca_cert,omitempty"`
+	ClientCert         string     `json:"client_cert,omitempty"`
+	InsecureSkipVerify bool       `json:"insecure_skip_verify"`
+	TopicTemplate      string     `json:"topic_template"`
+	Qos                int        `json:"qos"`
+	Enabled            bool       `json:"enabled"`
+	UpdateAt           *time.Time `json:"update_at,omitempty"`
+}
+
+// MqttBridgeReq replaces the bridge config, a nil password or client key
+// keeps the saved one.
+type MqttBridgeReq struct {
+	BrokerUrl          string  `json:"broker_url" valid:"Required;MaxSize(256)"`
+	ClientId           string  `json:"client_id" valid:"MaxSize(128)"`
+	Username           string  `json:"username" valid:"MaxSize(128)"`
+	Password           *string `json:"password" valid:"MaxSize(256)"`
+	CaCert             string  `json:"ca_cert"`
+	ClientCert         string  `json:"client_cert"`
+	ClientKey          *string `json:"client_key"`
+	InsecureSkipVerify bool    `json:"insecure_skip_verify"`
+	TopicTemplate      string  `json:"topic_template" valid:"MaxSize(256)"`
+	Qos                int     `json:"qos" valid:"Range(0,2)"`
+	Enabled            *bool   `json:"enabled"`
+}
+
+var bridgeSchemes = map[string]bool{"tcp": true, "ssl": true, "tls": true, "ws": true, "wss": true}
+
+func checkMqttBridge(b *bluedb.MqttBridge) error {
+	u, err := url.Parse(b.BrokerUrl)
+	if err != nil {
+		return err
+	}
+	if !bridgeSchemes[u.Scheme] || len(u.Host) == 0 {
+		return fmt.Errorf("invalid broker url(%s)", b.BrokerUrl)
+	}
+	if err := common.CheckPublicHost(u.Hostname()); err != nil {
+		return err
+	}
+	if len(b.TopicTemplate) == 0 {
+		return errors.New("topic template is empty")
+	}
+	if strings.ContainsAny(b.TopicTemplate, "+#") {
+		return errors.New("topic template can not contain wildcards")
+	}
+	if b.Qos < 0 || b.Qos > 2 {
+		return fmt.Errorf("invalid qos(%d)", b.Qos)
+	}
+	if len(b.CaCert) > 0 && !x509.NewCertPool().AppendCertsFromPEM([]byte(b.CaCert)) {
+		return errors.New("invalid ca cert")
+	}
+	if len(b.ClientCert) > 0 {
+		if _, err := tls.X509KeyPair([]byte(b.ClientCert), []byte(b.ClientKey)); err != nil {
+			return fmt.Errorf("invalid client cert, err:%s", err.Error())
+		}
+	}
+	return nil
+}
+
+func GetMqttBridge(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	b, err := bluedb.QueryMqttBridge(ps["projectId"])
+	if err != nil {
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	if b == nil {
+		apierr.WriteMessage(w, http.StatusNotFound, "mqtt bridge not found")
+		return
+	}
+	writeJson(w, http.StatusOK, toMqttBridge(b))
+}
+
+// the password and the client key are never returned
+func toMqttBridge(b *bluedb.MqttBridge) *MqttBridge {
+	return &MqttBridge{
+		BrokerUrl:          b.BrokerUrl,
+		ClientId:           b.ClientId,
+		Username:           b.Username,
+		CaCert:             b.CaCert,
+		ClientCert:         b.ClientCert,
+		InsecureSkipVerify: b.InsecureSkipVerify,
+		TopicTemplate:      b.TopicTemplate,
+		Qos:                b.Qos,
+		Enabled:            b.Enabled,
+		UpdateAt:           b.UpdateAt,
+	}
+}
+
+// PutMqttBridge creates or replaces the bridge of the project, the topic
+// template can use {project_id}, {thing}, {device}, {device_name} and
+// {data_type}. The ingestion picks the change up within a minute.
+func PutMqttBridge(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	var r MqttBridgeReq
+	if !readJsonBody(w, req, &r) {
+		return
+	}
+	old, err := bluedb.QueryMqttBridge(projectId)
+	if err != nil {
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	b := bluedb.MqttBridge{
+		ProjectId:          projectId,
+		BrokerUrl:          r.BrokerUrl,
+		ClientId:           r.ClientId,
+		Username:           r.Username,
+		CaCert:             r.CaCert,
+		ClientCert:         r.ClientCert,
+		InsecureSkipVerify: r.InsecureSkipVerify,
+		TopicTemplate:      r.TopicTemplate,
+		Qos:                r.Qos,
+		Enabled:            true,
+	}
+	if old != nil {
+		b.Password = old.Password
+		b.ClientKey = old.ClientKey
+	}
+	if r.Password != nil {
+		b.Password = *r.Password
+	}
+	if r.ClientKey != nil {
+		b.ClientKey = *r.ClientKey
+	}
+	if r.Enabled != nil {
+		b.Enabled = *r.Enabled
+	}
+	if err := checkMqttBridge(&b); err != nil {
+		apierr.Write(w, err, http.StatusBadRequest)
+		return
+	}
+	if err := bluedb.SaveMqttBridge(b); err != nil {
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	var before *MqttBridge
+	if old != nil {
+		before = toMqttBridge(old)
+	}
+	middleware.AuditChange(req, before, toMqttBridge(&b))
+	w.WriteHeader(http.StatusOK)
+}
+
+func DeleteMqttBridge(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	if old, _ := bluedb.QueryMqttBridge(ps["projectId"]); old != nil {
+		middleware.AuditChange(req, toMqttBridge(old), nil)
+	}
+	if err := bluedb.DeleteMqttBridge(ps["projectId"]); err != nil {
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,73 @@
+package aws
+
+import (
+	"encoding/json"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"github.com/ssrs100/blueserver/influxdb"
+	"net/http"
+)
+
+type Preference struct {
+	TemperatureUnit string `json:"temperature_unit" valid:"Required;TemperatureUnit"`
+}
+
+func init() {
+	apierr.RegisterCheck("TemperatureUnit", "Must be C or F", influxdb.ValidUnit)
+}
+
+// projectPreference returns the preference of the project, the unit is
+// celsius if none is set.
+func projectPreference(u bluedb.Project) Preference {
+	pref := Preference{
+		TemperatureUnit: influxdb.UnitCelsius,
+	}
+	if influxdb.ValidUnit(u.TemperatureUnit) {
+		pref.TemperatureUnit = u.TemperatureUnit
+	}
+	return pref
+}
+
+func GetPreference(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	u, err := bluedb.QueryProject(projectId)
+	if err != nil {
+		logs.Error("err:%s", err.Error())
+		apierr.WriteMessage(w, http.StatusBadRequest, "project id not found")
+		return
+	}
+	body, err := json.Marshal(projectPreference(u))
+	if err != nil {
+		logs.Error("Invalid data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
+}
+
+func PutPreference(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	u, err := bluedb.QueryProject(projectId)
+	if err != nil {
+		logs.Error("err:%s", err.Error())
+		apierr.WriteMessage(w, http.StatusBadRequest, "project id not found")
+		return
+	}
+	var pref Preference
+	if !readJsonBody(w, req, &pref) {
+		return
+	}
+	before := projectPreference(u)
+	u.TemperatureUnit = pref.TemperatureUnit
+	if err := bluedb.UpdateProject(u, "temperature_unit"); err != nil {
+		logs.Error("update project(%s) fail, err:%s", u.Id, err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	middleware.AuditChange(req, before, projectPreference(u))
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,69 @@
+package aws
+
+import (
+	"errors"
+	"fmt"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"net/http"
+	"strings"
+)
+
+// groupParams adds the group and tag selectors of the request to the db
+// query params. A group is a location id or a path of location names like
+// "Warehouse 3/Freezer row B", the locations below it are included.
+func groupParams(req *http.Request, projectId string, params map[string]interface{}) error {
+	if group := req.URL.Query().Get("group"); len(group) > 0 {
+		l, err := deviceDb.ResolveLocation(projectId, group)
+		if err != nil {
+			return apierr.From(err, http.StatusInternalServerError)
+		}
+		if l == nil {
+			return fmt.Errorf("group(%s) not found", group)
+		}
+		params["location_ids"] = deviceDb.LocationSubtree(projectId, l.Id)
+	}
+	if tag := req.URL.Query().Get("tag"); len(tag) > 0 {
+		params["tag"] = tag
+	}
+	return nil
+}
+
+func hasGroupSelector(req *http.Request) bool {
+	return len(req.URL.Query().Get("group")) > 0 || len(req.URL.Query().Get("tag")) > 0
+}
+
+// selectDevices returns the devices given by the deviceAddrs list, or by the
+// group and tag selectors. When both are given only the listed devices
+// matching the selectors are returned. The errors of the db keep their 500
+// status through the 400 the handlers write the invalid selectors with.
+func selectDevices(req *http.Request, projectId string) ([]string, error) {
+	var listed []string
+	if deviceAddrs := req.URL.Query().Get("deviceAddrs"); len(deviceAddrs) > 0 {
+		listed = strings.Split(deviceAddrs, ";")
+	}
+	if !hasGroupSelector(req) {
+		if len(listed) == 0 {
+			return nil, errors.New("deviceAddrs is empty")
+		}
+		return listed, nil
+	}
+	params := make(map[string]interface{})
+	params["project_id"] = projectId
+	if err := groupParams(req, projectId, params); err != nil {
+		return nil, err
+	}
+	if len(listed) > 0 {
+		params["device_ids"] = listed
+	}
+	// no limit
+	params["limit"] = -1
+	devs, _, err := deviceDb.QueryDevices(params)
+	if err != nil {
+		return nil, apierr.From(err, http.StatusInternalServerError)
+	}
+	devices := make([]string, 0, len(devs))
+	for _, d := range devs {
+		devices = append(devices, d.DeviceId)
+	}
+	return devices, nil
+}
@@ -0,0 +1,81 @@
+package aws
+
+import (
+	"errors"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"testing"
+)
+
+func selectOf(t *testing.T, query string) ([]string, error) {
+	req := httptest.NewRequest(http.MethodGet, "/v1/x?"+query, nil)
+	devices, err := selectDevices(req, "p-1")
+	sort.Strings(devices)
+	return devices, err
+}
+
+func TestSelectDevicesByGroup(t *testing.T) {
+	defer useDevices(&fakeDevices{
+		locations: []*bluedb.Location{
+			{Id: "site", ProjectId: "p-1", Level: bluedb.LocationSite, Name: "Warehouse 3"},
+			{Id: "room", ProjectId: "p-1", ParentId: "site", Name: "Freezer row B"},
+			{Id: "other", ProjectId: "p-1", Name: "Office"},
+		},
+		devices: []*bluedb.Device{
+			{ProjectId: "p-1", DeviceId: "dev-1", LocationId: "site", Tags: "cold"},
+			{ProjectId: "p-1", DeviceId: "dev-2", LocationId: "room", Tags: "cold,pharma"},
+			{ProjectId: "p-1", DeviceId: "dev-3", LocationId: "other", Tags: "pharma"},
+			{ProjectId: "p-2", DeviceId: "dev-4", LocationId: "room", Tags: "cold"},
+		},
+	})()
+	for _, c := range []struct {
+		query   string
+		devices []string
+	}{
+		{"deviceAddrs=dev-1%3Bdev-9", []string{"dev-1", "dev-9"}},
+		// the locations below the group are included
+		{"group=site", []string{"dev-1", "dev-2"}},
+		{"group=Freezer%20row%20B", []string{"dev-2"}},
+		{"tag=pharma", []string{"dev-2", "dev-3"}},
+		{"group=site&tag=pharma", []string{"dev-2"}},
+		// only the listed devices matching the selectors
+		{"group=site&deviceAddrs=dev-2%3Bdev-3", []string{"dev-2"}},
+		{"tag=none", []string{}},
+	} {
+		devices, err := selectOf(t, c.query)
+		if err != nil {
+			t.Errorf("select %s fail, err:%s", c.query, err.Error())
+			continue
+		}
+		if !reflect.DeepEqual(devices, c.devices) {
+			t.Errorf("select %s returns %v, want %v", c.query, devices, c.devices)
+		}
+	}
+	for _, query := range []string{"", "group=unknown"} {
+		if _, err := selectOf(t, query); err == nil || apierr.From(err, http.StatusBadRequest).Status != http.StatusBadRequest {
+			t.Errorf("select %q returns %v, want an invalid request", query, err)
+		}
+	}
+}
+
+func TestSelectDevicesDbError(t *testing.T) {
+	defer useDevices(&fakeDevices{err: errors.New("db is down")})()
+	for _, query := range []string{"group=site", "tag=cold", "tag=cold&deviceAddrs=dev-1"} {
+		_, err := selectOf(t, query)
+		if err == nil {
+			t.Errorf("select %s without the db returns no error", query)
+			continue
+		}
+		if status := apierr.From(err, http.StatusBadRequest).Status; status != http.StatusInternalServerError {
+			t.Errorf("select %s without the db is %d, want %d", query, status, http.StatusInternalServerError)
+		}
+	}
+	// the listed devices alone need no db
+	if devices, err := selectOf(t, "deviceAddrs=dev-1"); err != nil || len(devices) != 1 {
+		t.Errorf("select listed devices returns %v, %v", devices, err)
+	}
+}
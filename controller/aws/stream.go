@@ -0,0 +1,334 @@
+package aws
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/gorilla/websocket"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"github.com/ssrs100/blueserver/influxdb"
+	"github.com/ssrs100/blueserver/sesscache"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	streamBufferSize    = 256
+	streamHeartbeat     = 15 * time.Second
+	streamWriteDeadline = 10 * time.Second
+	hubRestartDelay     = 5 * time.Second
+)
+
+// eventFilter selects the events of a stream, an empty list selects all.
+type eventFilter struct {
+	types   map[string]bool
+	things  map[string]bool
+	devices map[string]bool
+	metrics map[string]bool
+	opt     influxdb.FormatOption
+}
+
+func toSet(val string) map[string]bool {
+	set := make(map[string]bool)
+	for _, v := range strings.FieldsFunc(val, func(r rune) bool { return r == ',' || r == ';' }) {
+		if v = strings.TrimSpace(v); len(v) > 0 {
+			set[v] = true
+		}
+	}
+	return set
+}
+
+func newEventFilter(req *http.Request, projectId string) *eventFilter {
+	query := req.URL.Query()
+	return &eventFilter{
+		types:   toSet(query.Get("type")),
+		things:  toSet(query.Get("thing")),
+		devices: toSet(query.Get("device")),
+		metrics: toSet(query.Get("metric")),
+		opt:     getFormatOption(req, projectId),
+	}
+}
+
+// apply returns the event as it is sent to the stream, or nil if the event
+// is not selected. Readings keep only the selected metrics, temperatures are
+// converted to the display unit.
+func (f *eventFilter) apply(ev *common.Event) *common.Event {
+	if len(f.types) > 0 && !f.types[ev.Type] {
+		return nil
+	}
+	if len(f.things) > 0 && !f.things[ev.Thing] {
+		return nil
+	}
+	// status events have no device, they are selected by thing only
+	if len(f.devices) > 0 && len(ev.Device) > 0 && !f.devices[ev.Device] {
+		return nil
+	}
+	out := *ev
+	switch ev.Type {
+	case common.EventReading:
+		out.Data = make(map[string]interface{}, len(ev.Data))
+		found := len(f.metrics) == 0
+		for k, v := range ev.Data {
+			if isMetric(k) {
+				if len(f.metrics) > 0 && !f.metrics[k] {
+					continue
+				}
+				found = true
+				if k == common.MetricTemperature {
+					v = f.convert(v)
+				}
+			}
+			out.Data[k] = v
+		}
+		if !found {
+			return nil
+		}
+	case common.EventAlert:
+		if len(f.metrics) > 0 && !f.metrics[ev.Metric] {
+			return nil
+		}
+		if ev.Metric == common.MetricTemperature {
+			out.Data = make(map[string]interface{}, len(ev.Data))
+			for k, v := range ev.Data {
+				out.Data[k] = v
+			}
+			out.Data["value"] = f.convert(ev.Data["value"])
+		}
+	}
+	return &out
+}
+
+func (f *eventFilter) convert(v interface{}) interface{} {
+	if c, ok := v.(float64); ok {
+		return influxdb.ConvertTemperature(f.opt.Unit, c)
+	}
+	return v
+}
+
+func isMetric(key string) bool {
+	return key == common.MetricTemperature || key == common.MetricHumidity
+}
+
+type eventSub struct {
+	projectId string
+	filter    *eventFilter
+	ch        chan *common.Event
+}
+
+// eventHub subscribes the event channels of all projects once, and fans the
+// events out to the streams of this server. The subscription is renewed if
+// it ends.
+type eventHub struct {
+	sync.Mutex
+	subs map[*eventSub]bool
+	once sync.Once
+}
+
+var hub = &eventHub{
+	subs: make(map[*eventSub]bool),
+}
+
+func (h *eventHub) run() {
+	for {
+		h.receive()
+		logs.Warn("event hub stopped, restart in %s", hubRestartDelay)
+		time.Sleep(hubRestartDelay)
+	}
+}
+
+func (h *eventHub) receive() {
+	ps := sesscache.PSubscribe(common.EventChannelPattern)
+	defer ps.Close()
+	if _, err := ps.Receive(); err != nil {
+		logs.Error("subscribe events err:%s", err.Error())
+		return
+	}
+	logs.Info("event hub started")
+	for msg := range ps.Channel() {
+		var ev common.Event
+		if err := json.Unmarshal([]byte(msg.Payload), &ev); err != nil {
+			logs.Error("invalid event:%s, err:%s", msg.Payload, err.Error())
+			continue
+		}
+		h.dispatch(&ev)
+	}
+}
+
+func (h *eventHub) dispatch(ev *common.Event) {
+	h.Lock()
+	defer h.Unlock()
+	for sub := range h.subs {
+		if sub.projectId != ev.ProjectId {
+			continue
+		}
+		out := sub.filter.apply(ev)
+		if out == nil {
+			continue
+		}
+		select {
+		case sub.ch <- out:
+		default:
+			logs.Warn("stream of project(%s) is slow, drop %s event", sub.projectId, ev.Type)
+		}
+	}
+}
+
+func (h *eventHub) subscribe(projectId string, filter *eventFilter) *eventSub {
+	h.once.Do(func() {
+		go h.run()
+	})
+	sub := &eventSub{
+		projectId: projectId,
+		filter:    filter,
+		ch:        make(chan *common.Event, streamBufferSize),
+	}
+	h.Lock()
+	h.subs[sub] = true
+	h.Unlock()
+	return sub
+}
+
+func (h *eventHub) unsubscribe(sub *eventSub) {
+	h.Lock()
+	delete(h.subs, sub)
+	h.Unlock()
+}
+
+// StreamEvents pushes the events of the project as Server-Sent Events, they
+// are filtered by the type, thing, device and metric queries.
+func StreamEvents(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		apierr.WriteMessage(w, http.StatusInternalServerError, "streaming is not supported")
+		return
+	}
+	us := middleware.CurrentSession(req)
+	sub := hub.subscribe(projectId, newEventFilter(req, projectId))
+	defer hub.unsubscribe(sub)
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set("Connection", "keep-alive")
+	w.Header().Set("X-Accel-Buffering", "no")
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	ticker := time.NewTicker(streamHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-sub.ch:
+			body, err := json.Marshal(ev)
+			if err != nil {
+				continue
+			}
+			if _, err := fmt.Fprintf(w, "event: %s\ndata: %s\n\n", ev.Type, body); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-ticker.C:
+			if !middleware.SessionAlive(us) {
+				logs.Info("session of sse stream of project(%s) ended", projectId)
+				_, _ = fmt.Fprint(w, "event: close\ndata: {}\n\n")
+				flusher.Flush()
+				return
+			}
+			if _, err := fmt.Fprint(w, ": ping\n\n"); err != nil {
+				return
+			}
+			flusher.Flush()
+		case <-req.Context().Done():
+			logs.Debug("sse stream of project(%s) closed", projectId)
+			return
+		}
+	}
+}
+
+var upgrader = websocket.Upgrader{
+	ReadBufferSize:  1024,
+	WriteBufferSize: 4096,
+	CheckOrigin:     checkStreamOrigin,
+}
+
+// checkStreamOrigin accepts the websockets opened by the pages of this server
+// and of the origins in ws_allowed_origins, a comma separated list like
+// "https://console.example.com". Clients other than browsers send no origin.
+func checkStreamOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if len(origin) == 0 {
+		return true
+	}
+	u, err := url.Parse(origin)
+	if err != nil {
+		return false
+	}
+	if strings.EqualFold(u.Host, r.Host) {
+		return true
+	}
+	for _, allowed := range strings.Split(conf.GetString("ws_allowed_origins"), ",") {
+		if strings.EqualFold(strings.TrimRight(strings.TrimSpace(allowed), "/"), origin) {
+			return true
+		}
+	}
+	logs.Warn("websocket from origin(%s) is rejected", origin)
+	return false
+}
+
+// StreamEventsWs pushes the same events as StreamEvents over a WebSocket.
+func StreamEventsWs(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	filter := newEventFilter(req, projectId)
+	conn, err := upgrader.Upgrade(w, req, nil)
+	if err != nil {
+		logs.Error("upgrade websocket err:%s", err.Error())
+		return
+	}
+	defer conn.Close()
+	us := middleware.CurrentSession(req)
+	sub := hub.subscribe(projectId, filter)
+	defer hub.unsubscribe(sub)
+
+	// read until the client closes, the messages of the client are ignored
+	closed := make(chan interface{})
+	go func() {
+		defer close(closed)
+		for {
+			if _, _, err := conn.NextReader(); err != nil {
+				return
+			}
+		}
+	}()
+
+	ticker := time.NewTicker(streamHeartbeat)
+	defer ticker.Stop()
+	for {
+		select {
+		case ev := <-sub.ch:
+			_ = conn.SetWriteDeadline(time.Now().Add(streamWriteDeadline))
+			if err := conn.WriteJSON(ev); err != nil {
+				logs.Debug("write websocket err:%s", err.Error())
+				return
+			}
+		case <-ticker.C:
+			if !middleware.SessionAlive(us) {
+				logs.Info("session of websocket stream of project(%s) ended", projectId)
+				msg := websocket.FormatCloseMessage(websocket.ClosePolicyViolation, "session ended")
+				_ = conn.WriteControl(websocket.CloseMessage, msg, time.Now().Add(streamWriteDeadline))
+				return
+			}
+			if err := conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(streamWriteDeadline)); err != nil {
+				return
+			}
+		case <-closed:
+			logs.Debug("websocket stream of project(%s) closed", projectId)
+			return
+		}
+	}
+}
@@ -11,8 +11,11 @@ import (
 	"github.com/aws/aws-sdk-go/service/iot"
 	"github.com/jack0liu/logs"
 	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"github.com/ssrs100/blueserver/controller/middleware"
 	"github.com/ssrs100/blueserver/influxdb"
-	"io/ioutil"
+	"github.com/ssrs100/blueserver/sesscache"
 	"net/http"
 	"strconv"
 	"strings"
@@ -26,14 +29,16 @@ var (
 )
 
 type RegisterThingReq struct {
-	Name        string `json:"name"`
-	WifiAddr    string `json:"wifi_addr"`
-	EtherAddr   string `json:"ether_addr"`
-	Description string `json:"description"`
+	Name        string `json:"name" valid:"Required;MaxSize(128);Match(/^[^:]+$/)"`
+	WifiAddr    string `json:"wifi_addr" valid:"MaxSize(128)"`
+	EtherAddr   string `json:"ether_addr" valid:"MaxSize(128)"`
+	Description string `json:"description" valid:"MaxSize(128)"`
 }
 
 type UpdateThingReq struct {
-	Description string `json:"description"`
+	Description *string   `json:"description" valid:"MaxSize(128)"`
+	Tags        *[]string `json:"tags" valid:"MaxSize(32)"`
+	LocationId  *string   `json:"location_id" valid:"MaxSize(64)"`
 }
 
 type Thing struct {
@@ -47,18 +52,31 @@ type Thing struct {
 	EtherAddr   string     `json:"ether_addr"`
 	Description string     `json:"description"`
 	CreateAt    *time.Time `json:"create_at"`
+	Tags        []string   `json:"tags"`
+	LocationId  string     `json:"location_id"`
 }
 
 type ThingsWrap struct {
 	Things []*Thing `json:"things"`
 }
 
+type ThingCompleteness struct {
+	Thing        string  `json:"thing"`
+	Expected     int64   `json:"expected"`
+	Received     int64   `json:"received"`
+	Missing      int64   `json:"missing"`
+	Backfilled   int64   `json:"backfilled"`
+	Lost         int64   `json:"lost"`
+	Unsaved      int64   `json:"unsaved_points"`
+	Completeness float64 `json:"completeness"`
+}
+
 func awsTingName(name, projectId string) string {
 	return name
 }
 
-func checkProject(projectId string) (*bluedb.User, error) {
-	u, err := bluedb.QueryUserById(projectId)
+func checkProject(projectId string) (*bluedb.Project, error) {
+	u, err := bluedb.QueryProject(projectId)
 	if err != nil {
 		logs.Error("err:%s", err.Error())
 		return nil, errors.New("project id not found")
@@ -79,45 +97,23 @@ func RegisterThing(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	u, err := checkProject(projectId)
 	if err != nil {
 		logs.Error("checkProject err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
-		return
-	}
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusBadRequest)
 		return
 	}
-	defer req.Body.Close()
-
 	var register = RegisterThingReq{}
-	if err = json.Unmarshal(body, &register); err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
-		return
-	}
-
-	// check thing name
-	if len(register.Name) == 0 || strings.Contains(register.Name, ":") {
-		errStr := fmt.Sprintf("invalid name:%s.", register.Name)
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(errStr))
+	if !readJsonBody(w, req, &register) {
 		return
 	}
 	existThing := bluedb.GetThingByName(register.Name)
 	if existThing != nil {
 		errStr := fmt.Sprintf("thing(%s) has been used.", register.Name)
 		logs.Error("%s has been used.", register.Name)
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(errStr))
+		apierr.WriteMessage(w, http.StatusConflict, errStr)
 		return
 	}
 
 	// create thing
-	logs.Info("create thing with ak(%s) sk(%s)", u.AccessKey, u.SecretKey)
+	logs.Info("create thing with the aws key of user(%s)", u.Name)
 	sess := session.Must(session.NewSession())
 	creds := credentials.NewStaticCredentials(
 		u.AccessKey,
@@ -128,7 +124,7 @@ func RegisterThing(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	svc := iot.New(sess, &aws.Config{Credentials: creds, Region: aws.String(region)})
 
 	attr := make(map[string]*string)
-	attr["owner"] = &u.Name
+	attr["owner"] = &u.ThingOwner
 	attr["wifi_addr"] = &register.WifiAddr
 	attr["ether_addr"] = &register.EtherAddr
 	//attr["description"] = &register.Description
@@ -144,8 +140,7 @@ func RegisterThing(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	thingOut, err := svc.CreateThing(&awsReq)
 	if err != nil {
 		logs.Error("create thing err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -157,8 +152,7 @@ func RegisterThing(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	_, err = svc.AttachThingPrincipal(&attachReq)
 	if err != nil {
 		logs.Error("attach principal err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -173,15 +167,14 @@ func RegisterThing(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	}
 	if err := bluedb.SaveThing(t); err != nil {
 		logs.Error("save thing err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
 
 	w.WriteHeader(http.StatusOK)
 }
 
-func bindAwsUser(user bluedb.User) (bluedb.User, error) {
+func bindAwsUser(user bluedb.Project) (bluedb.Project, error) {
 	admin, err := bluedb.QueryUserByName("admin")
 	if err != nil {
 		logs.Error("get admin err:%s", err.Error())
@@ -260,8 +253,8 @@ func bindAwsUser(user bluedb.User) (bluedb.User, error) {
 	user.AwsUsername = admin.AwsUsername
 	user.AccessKey = admin.AccessKey
 	user.SecretKey = admin.SecretKey
-	if err := bluedb.UpdateUser(user); err != nil {
-		logs.Error("update user(%s) fail, err:%s", user.Name, err.Error())
+	if err := bluedb.UpdateProject(user, "aws_username", "access_key", "secret_key"); err != nil {
+		logs.Error("update project(%s) fail, err:%s", user.Id, err.Error())
 		return user, err
 	}
 	return user, nil
@@ -277,20 +270,8 @@ func UpdateThing(w http.ResponseWriter, req *http.Request, ps map[string]string)
 	//	_, _ = w.Write([]byte(err.Error()))
 	//	return
 	//}
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
-		return
-	}
-	defer req.Body.Close()
-
 	var update = UpdateThingReq{}
-	if err = json.Unmarshal(body, &update); err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+	if !readJsonBody(w, req, &update) {
 		return
 	}
 
@@ -298,8 +279,7 @@ func UpdateThing(w http.ResponseWriter, req *http.Request, ps map[string]string)
 	if existThing == nil {
 		errStr := fmt.Sprintf("%s not exist.", thingName)
 		logs.Error("%s not exist.", thingName)
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(errStr))
+		apierr.WriteMessage(w, http.StatusBadRequest, errStr)
 		return
 	}
 
@@ -333,11 +313,21 @@ func UpdateThing(w http.ResponseWriter, req *http.Request, ps map[string]string)
 	//	return
 	//}
 
-	existThing.Description = update.Description
+	if update.LocationId != nil && !checkLocation(w, projectId, *update.LocationId) {
+		return
+	}
+	if update.Description != nil {
+		existThing.Description = *update.Description
+	}
+	if update.Tags != nil {
+		existThing.Tags = bluedb.JoinTags(*update.Tags)
+	}
+	if update.LocationId != nil {
+		existThing.LocationId = *update.LocationId
+	}
 	if err := bluedb.UpdateThing(*existThing); err != nil {
 		logs.Error("update db thing err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -347,11 +337,10 @@ func UpdateThing(w http.ResponseWriter, req *http.Request, ps map[string]string)
 func RemoveThing(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 	projectId := ps["projectId"]
 	thingName := ps["thingName"]
-	u, err := bluedb.QueryUserById(projectId)
+	u, err := bluedb.QueryProject(projectId)
 	if err != nil {
 		logs.Error("Invalid body. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("project id not found"))
+		apierr.WriteMessage(w, http.StatusBadRequest, "project id not found")
 		return
 	}
 
@@ -365,8 +354,7 @@ func RemoveThing(w http.ResponseWriter, req *http.Request, ps map[string]string)
 
 	if len(u.AccessKey) == 0 || len(u.SecretKey) == 0 {
 		logs.Info("%s ak/sk is empty", u.Name)
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("ak/sk is empty"))
+		apierr.WriteMessage(w, http.StatusBadRequest, "ak/sk is empty")
 		return
 	}
 
@@ -390,8 +378,7 @@ func RemoveThing(w http.ResponseWriter, req *http.Request, ps map[string]string)
 	_, err = svc.DetachThingPrincipal(&detachReq)
 	if err != nil && !isNotFound(err) {
 		logs.Error("detach principal err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
 
@@ -401,27 +388,90 @@ func RemoveThing(w http.ResponseWriter, req *http.Request, ps map[string]string)
 	_, err = svc.DeleteThing(&awsReq)
 	if err != nil && !isNotFound(err) {
 		logs.Error("remove thing err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
 
 	if err := bluedb.DeleteThing(existThing.Id); err != nil {
 		logs.Error("remove db thing err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
-	if err := influxdb.DeleteData(influxdb.TableTemperature, thingName, projectId); err != nil {
-		logs.Error(err.Error())
+	for _, table := range []string{influxdb.TableTemperature, influxdb.TableBroadcast} {
+		if err := deleteThingData(table, thingName, projectId); err != nil {
+			logs.Error("delete %s data of thing(%s) fail, err:%s", table, thingName, err.Error())
+		}
 	}
-	if err := influxdb.DeleteData(influxdb.TableBroadcast, thingName, projectId); err != nil {
+	if err := bluedb.EndThingAssignments(projectId, thingName, time.Now()); err != nil {
 		logs.Error(err.Error())
 	}
+	sesscache.Del(common.CompletenessKey(thingName))
+	middleware.AuditChange(req, thingSummary(existThing), nil)
 
 	w.WriteHeader(http.StatusOK)
 }
 
+// thingSummary is the thing in the audit log.
+func thingSummary(t *bluedb.Thing) map[string]string {
+	return map[string]string{
+		"name":        t.Name,
+		"aws_name":    t.AwsName,
+		"description": t.Description,
+		"location_id": t.LocationId,
+		"tags":        t.Tags,
+	}
+}
+
+// movedDevices returns the devices which are assigned to other things now.
+func movedDevices(projectId, thingName string, devices []string) (map[string]bool, error) {
+	moved := make(map[string]bool)
+	if len(devices) == 0 {
+		return moved, nil
+	}
+	devs, _, err := deviceDb.QueryDevices(map[string]interface{}{
+		"project_id": projectId,
+		"device_ids": devices,
+		"limit":      -1,
+	})
+	if err != nil {
+		return nil, err
+	}
+	for _, d := range devs {
+		if len(d.Thing) > 0 && d.Thing != thingName {
+			moved[d.DeviceId] = true
+		}
+	}
+	return moved, nil
+}
+
+// deleteThingData deletes the data relayed by the thing, the data of the
+// devices which have moved to other things is kept as their history. Nothing
+// is deleted if the moved devices can not be told.
+func deleteThingData(table, thingName, projectId string) error {
+	devices, err := influxdb.GetDevicesByThing(table, thingName, projectId)
+	if err != nil {
+		return err
+	}
+	moved, err := movedDevices(projectId, thingName, devices)
+	if err != nil {
+		return err
+	}
+	if len(moved) == 0 {
+		return influxdb.DeleteData(table, thingName, projectId, nil)
+	}
+	remain := make([]string, 0, len(devices))
+	for _, d := range devices {
+		if !moved[d] {
+			remain = append(remain, d)
+		}
+	}
+	logs.Info("thing(%s) keeps data of %d moved devices", thingName, len(moved))
+	if len(remain) == 0 {
+		return nil
+	}
+	return influxdb.DeleteData(table, thingName, projectId, remain)
+}
+
 func isNotFound(e error) bool {
 	if strings.Contains(e.Error(), iot.ErrCodeResourceNotFoundException) {
 		return true
@@ -435,28 +485,25 @@ func GetThingLatestData(w http.ResponseWriter, req *http.Request, ps map[string]
 	existThing := bluedb.GetThing(projectId, thingName)
 	if existThing == nil {
 		logs.Error("not found thing %s", thingName)
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("thing name not found"))
+		apierr.WriteMessage(w, http.StatusNotFound, "thing name not found")
 		return
 	}
 	device := req.URL.Query().Get("device")
-	data, err := influxdb.GetLatest(getDataType(req), thingName, device, projectId)
+	data, err := influxdb.GetLatest(getDataType(req), thingName, device, projectId, getFormatOption(req, projectId))
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("thing id not found"))
+		apierr.WriteMessage(w, http.StatusBadRequest, "thing id not found")
 		return
 	}
 	body, err := json.Marshal(data)
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
-	_, _ = w.Write(body)
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
 }
 
 func GetThingData(w http.ResponseWriter, req *http.Request, ps map[string]string) {
@@ -465,8 +512,7 @@ func GetThingData(w http.ResponseWriter, req *http.Request, ps map[string]string
 	existThing := bluedb.GetThing(projectId, thingName)
 	if existThing == nil {
 		logs.Error("not found thing %s", thingName)
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("thing name not found"))
+		apierr.WriteMessage(w, http.StatusNotFound, "thing name not found")
 		return
 	}
 	startAt := req.URL.Query().Get("startAt")
@@ -481,15 +527,18 @@ func GetThingData(w http.ResponseWriter, req *http.Request, ps map[string]string
 	if err != nil || tEnd.Before(tStart) {
 		strErr := fmt.Sprintf("Invalid time params, startAt:%s, endAt:%s.", startAt, endAt)
 		logs.Error(strErr)
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(strErr))
+		apierr.WriteMessage(w, http.StatusBadRequest, strErr)
 		return
 	}
-	datas, err := influxdb.GetDataByTime(getDataType(req), thingName, startAt, endAt, device, projectId)
+	// follow=true returns the data of the device relayed by any thing
+	dataThing := thingName
+	if len(device) > 0 && req.URL.Query().Get("follow") == "true" {
+		dataThing = ""
+	}
+	datas, err := influxdb.GetDataByTime(getDataType(req), dataThing, startAt, endAt, device, projectId, getFormatOption(req, projectId))
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("thing id not found"))
+		apierr.WriteMessage(w, http.StatusBadRequest, "thing id not found")
 		return
 	}
 	list := influxdb.OutDataList{
@@ -499,13 +548,12 @@ func GetThingData(w http.ResponseWriter, req *http.Request, ps map[string]string
 	body, err := json.Marshal(list)
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
-	_, _ = w.Write(body)
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
 }
 
 func GetThingDevice(w http.ResponseWriter, req *http.Request, ps map[string]string) {
@@ -514,30 +562,82 @@ func GetThingDevice(w http.ResponseWriter, req *http.Request, ps map[string]stri
 	existThing := bluedb.GetThing(projectId, thingName)
 	if existThing == nil {
 		logs.Error("not found thing %s", thingName)
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("thing name not found"))
+		apierr.WriteMessage(w, http.StatusNotFound, "thing name not found")
 		return
 	}
 	devices, err := influxdb.GetDevicesByThing(getDataType(req), thingName, projectId)
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("thing id not found"))
+		apierr.WriteMessage(w, http.StatusBadRequest, "thing id not found")
 		return
 	}
+	// devices moved to other things are listed with history=true
+	if req.URL.Query().Get("history") != "true" {
+		moved, err := movedDevices(projectId, thingName, devices)
+		if err != nil {
+			logs.Error("query devices of thing(%s) fail, err:%s", thingName, err.Error())
+			apierr.Write(w, err, http.StatusInternalServerError)
+			return
+		}
+		current := make([]string, 0, len(devices))
+		for _, d := range devices {
+			if !moved[d] {
+				current = append(current, d)
+			}
+		}
+		devices = current
+	}
 	list := influxdb.DeviceList{
 		Devices: devices,
 	}
 	body, err := json.Marshal(list)
 	if err != nil {
 		logs.Error("Invalid data. err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	_, _ = w.Write(body)
+}
+
+func GetThingCompleteness(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	thingName := ps["thingName"]
+	projectId := ps["projectId"]
+	existThing := bluedb.GetThing(projectId, thingName)
+	if existThing == nil {
+		logs.Error("not found thing %s", thingName)
+		apierr.WriteMessage(w, http.StatusNotFound, "thing name not found")
+		return
+	}
+	counters := sesscache.HGetAll(common.CompletenessKey(thingName))
+	getCounter := func(field string) int64 {
+		v, _ := strconv.ParseInt(counters[field], 10, 64)
+		return v
+	}
+	c := ThingCompleteness{
+		Thing:      thingName,
+		Received:   getCounter(common.CompleteReceived),
+		Missing:    getCounter(common.CompleteMissing),
+		Backfilled: getCounter(common.CompleteBackfilled),
+		Lost:       getCounter(common.CompleteLost),
+		Unsaved:    getCounter(common.CompleteUnsaved),
+	}
+	// backfilled sequences are counted both in received and missing
+	c.Expected = c.Received - c.Backfilled + c.Missing
+	c.Completeness = 1
+	if c.Expected > 0 {
+		c.Completeness = float64(c.Received) / float64(c.Expected)
+	}
+	body, err := json.Marshal(c)
+	if err != nil {
+		logs.Error("Invalid data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
 	w.Header().Add("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
+	_, _ = w.Write(body)
 }
 
 func ListThingsV2(w http.ResponseWriter, req *http.Request, ps map[string]string) {
@@ -553,6 +653,11 @@ func ListThingsV2(w http.ResponseWriter, req *http.Request, ps map[string]string
 	if o, err := strconv.Atoi(offset); err == nil {
 		params["offset"] = o
 	}
+	if err := groupParams(req, projectId, params); err != nil {
+		logs.Error("group params err:%s", err.Error())
+		apierr.Write(w, err, http.StatusBadRequest)
+		return
+	}
 
 	things := bluedb.QueryThings(params)
 	ret := make([]*Thing, 0)
@@ -568,6 +673,8 @@ func ListThingsV2(w http.ResponseWriter, req *http.Request, ps map[string]string
 			EtherAddr:   t.EtherAddr,
 			Description: t.Description,
 			CreateAt:    t.CreateAt,
+			Tags:        bluedb.TagList(t.Tags),
+			LocationId:  t.LocationId,
 		}
 		ret = append(ret, &o)
 	}
@@ -576,9 +683,8 @@ func ListThingsV2(w http.ResponseWriter, req *http.Request, ps map[string]string
 	}
 	outBytes, err := json.Marshal(list)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		errStr := fmt.Sprintf("build json err:%s", err.Error())
-		_, _ = w.Write([]byte(errStr))
+		apierr.WriteMessage(w, http.StatusInternalServerError, errStr)
 		return
 	}
 	w.Header().Add("Content-Type", "application/json")
@@ -588,17 +694,15 @@ func ListThingsV2(w http.ResponseWriter, req *http.Request, ps map[string]string
 
 func ListThings(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 	projectId := ps["projectId"]
-	u, err := bluedb.QueryUserById(projectId)
+	u, err := bluedb.QueryProject(projectId)
 	if err != nil {
 		logs.Error("Invalid body. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("project id not found"))
+		apierr.WriteMessage(w, http.StatusBadRequest, "project id not found")
 		return
 	}
 
 	if len(u.AccessKey) == 0 || len(u.SecretKey) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte("user project id not bind aws"))
+		apierr.WriteMessage(w, http.StatusBadRequest, "user project id not bind aws")
 		return
 	}
 
@@ -614,15 +718,14 @@ func ListThings(w http.ResponseWriter, req *http.Request, ps map[string]string)
 	svc := iot.New(sess, &aws.Config{Credentials: creds, Region: aws.String(region)})
 	awsReq := iot.ListThingsInput{
 		AttributeName:  &owner,
-		AttributeValue: &u.Name,
+		AttributeValue: &u.ThingOwner,
 		NextToken:      nil,
 	}
 	if len(limit) > 0 {
 		limitInt, err := strconv.Atoi(limit)
 		if err != nil {
 			logs.Error("limit is invalid")
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write([]byte("limit is invalid"))
+			apierr.WriteMessage(w, http.StatusBadRequest, "limit is invalid")
 			return
 		}
 		limit64 := int64(limitInt)
@@ -638,17 +741,15 @@ func ListThings(w http.ResponseWriter, req *http.Request, ps map[string]string)
 
 	rsp, err := listThings(svc, &awsReq)
 	if err != nil {
-		w.WriteHeader(http.StatusBadGateway)
 		errStr := fmt.Sprintf("aws return err:%s", err.Error())
-		_, _ = w.Write([]byte(errStr))
+		apierr.WriteMessage(w, http.StatusBadGateway, errStr)
 		return
 	}
 	outBytes, err := jsonutil.BuildJSON(rsp)
 
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
 		errStr := fmt.Sprintf("aws build json err:%s, rsp:%s", err.Error(), rsp.String())
-		_, _ = w.Write([]byte(errStr))
+		apierr.WriteMessage(w, http.StatusInternalServerError, errStr)
 		return
 	}
 	w.Header().Add("Content-Type", "application/json")
@@ -0,0 +1,109 @@
+package aws
+
+import (
+	"errors"
+	"github.com/ssrs100/blueserver/bluedb"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeDevices is a deviceStore of the listed locations and devices, it
+// fails every query when err is set. A location is resolved by its id or
+// by its name.
+type fakeDevices struct {
+	locations []*bluedb.Location
+	devices   []*bluedb.Device
+	err       error
+}
+
+func (f *fakeDevices) ResolveLocation(projectId, selector string) (*bluedb.Location, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	for _, l := range f.locations {
+		if l.ProjectId == projectId && (l.Id == selector || l.Name == selector) {
+			return l, nil
+		}
+	}
+	return nil, nil
+}
+
+func (f *fakeDevices) LocationSubtree(projectId, id string) []string {
+	ids := []string{id}
+	for i := 0; i < len(ids); i++ {
+		for _, l := range f.locations {
+			if l.ProjectId == projectId && l.ParentId == ids[i] {
+				ids = append(ids, l.Id)
+			}
+		}
+	}
+	return ids
+}
+
+func (f *fakeDevices) QueryDevices(params map[string]interface{}) ([]*bluedb.Device, int64, error) {
+	if f.err != nil {
+		return nil, 0, f.err
+	}
+	var devs []*bluedb.Device
+	for _, d := range f.devices {
+		if d.ProjectId != params["project_id"] {
+			continue
+		}
+		if ids, ok := params["device_ids"].([]string); ok && !contains(ids, d.DeviceId) {
+			continue
+		}
+		if ids, ok := params["location_ids"].([]string); ok && !contains(ids, d.LocationId) {
+			continue
+		}
+		if tag, ok := params["tag"].(string); ok && !contains(strings.Split(d.Tags, ","), tag) {
+			continue
+		}
+		devs = append(devs, d)
+	}
+	return devs, int64(len(devs)), nil
+}
+
+func contains(list []string, s string) bool {
+	for _, v := range list {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
+
+// useDevices replaces the device store, the returned func restores it.
+func useDevices(store deviceStore) func() {
+	old := deviceDb
+	deviceDb = store
+	return func() { deviceDb = old }
+}
+
+func TestMovedDevices(t *testing.T) {
+	defer useDevices(&fakeDevices{devices: []*bluedb.Device{
+		{ProjectId: "p-1", DeviceId: "dev-1", Thing: "thing-1"},
+		{ProjectId: "p-1", DeviceId: "dev-2", Thing: "thing-2"},
+		{ProjectId: "p-1", DeviceId: "dev-3"},
+		{ProjectId: "p-2", DeviceId: "dev-4", Thing: "thing-2"},
+	}})()
+	moved, err := movedDevices("p-1", "thing-1", []string{"dev-1", "dev-2", "dev-3", "dev-4"})
+	if err != nil {
+		t.Fatalf("moved devices fail, err:%s", err.Error())
+	}
+	if want := map[string]bool{"dev-2": true}; !reflect.DeepEqual(moved, want) {
+		t.Errorf("moved devices %v, want %v", moved, want)
+	}
+}
+
+func TestMovedDevicesError(t *testing.T) {
+	defer useDevices(&fakeDevices{err: errors.New("db is down")})()
+	// a device which can not be told moved would lose its history with
+	// the thing
+	if moved, err := movedDevices("p-1", "thing-1", []string{"dev-1"}); err == nil {
+		t.Errorf("moved devices %v without the db", moved)
+	}
+	if _, err := movedDevices("p-1", "thing-1", nil); err != nil {
+		t.Errorf("thing without devices fails, err:%s", err.Error())
+	}
+}
@@ -8,7 +8,8 @@ import (
 	"github.com/aws/aws-sdk-go/aws/session"
 	"github.com/aws/aws-sdk-go/service/sns"
 	"github.com/jack0liu/logs"
-	"io/ioutil"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"github.com/ssrs100/blueserver/controller/middleware"
 	"net/http"
 	"strings"
 )
@@ -18,8 +19,8 @@ type UserNotifyRmvReq struct {
 }
 
 type UserNotifyReq struct {
-	Email  *string `json:"email"`
-	Mobile *string `json:"mobile"`
+	Email  *string `json:"email" valid:"Email;MaxSize(128)"`
+	Mobile *string `json:"mobile" valid:"MaxSize(32)"`
 }
 
 type NotifyInfo struct {
@@ -59,8 +60,7 @@ func GetUserNotify(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	u, err := checkProject(projectId)
 	if err != nil {
 		logs.Error("check project err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusBadRequest)
 		return
 	}
 	sess := session.Must(session.NewSession())
@@ -83,14 +83,12 @@ func GetUserNotify(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	if err != nil {
 		if strings.Contains(err.Error(), sns.ErrCodeNotFoundException) {
 			if err := createTopic(svc, projectId); err != nil {
-				w.WriteHeader(http.StatusInternalServerError)
-				_, _ = w.Write([]byte(err.Error()))
+				apierr.Write(w, err, http.StatusInternalServerError)
 				return
 			}
 		} else {
 			logs.Error("get topic err:%s", err.Error())
-			w.WriteHeader(http.StatusBadRequest)
-			_, _ = w.Write([]byte(err.Error()))
+			apierr.Write(w, err, http.StatusBadRequest)
 			return
 		}
 	}
@@ -101,8 +99,7 @@ func GetUserNotify(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	subs, err := svc.ListSubscriptionsByTopic(&listSub)
 	if err != nil {
 		logs.Error("list subscription err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusBadRequest)
 		return
 	}
 	userNotifies := UserNotify{}
@@ -129,8 +126,7 @@ func GetUserNotify(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	ret, err := json.Marshal(&userNotifies)
 	if err != nil {
 		logs.Error("unmarshal err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusBadRequest)
 		return
 	}
 	w.Header().Add("Content-Type", "application/json")
@@ -143,8 +139,7 @@ func AddUserNotify(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	u, err := checkProject(projectId)
 	if err != nil {
 		logs.Error("check project err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusBadRequest)
 		return
 	}
 	sess := session.Must(session.NewSession())
@@ -158,20 +153,8 @@ func AddUserNotify(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	tpc := fmt.Sprintf("arn:aws:sns:us-west-2:415890359503:%s", name)
 	svc := sns.New(sess, &aws.Config{Credentials: creds, Region: aws.String(region)})
 
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
-		return
-	}
-	defer req.Body.Close()
 	var addReq UserNotifyReq
-	err = json.Unmarshal(body, &addReq)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+	if !readJsonBody(w, req, &addReq) {
 		return
 	}
 	logs.Info("add notify:%v", addReq)
@@ -189,8 +172,7 @@ func AddUserNotify(w http.ResponseWriter, req *http.Request, ps map[string]strin
 		_, err = svc.Subscribe(subIn)
 		if err != nil {
 			logs.Error("add notify fail. err:%s", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(err.Error()))
+			apierr.Write(w, err, http.StatusInternalServerError)
 			return
 		}
 	}
@@ -211,14 +193,13 @@ func AddUserNotify(w http.ResponseWriter, req *http.Request, ps map[string]strin
 		_, err = svc.Subscribe(subIn)
 		if err != nil {
 			logs.Error("add notify fail. err:%s", err.Error())
-			w.WriteHeader(http.StatusInternalServerError)
-			_, _ = w.Write([]byte(err.Error()))
+			apierr.Write(w, err, http.StatusInternalServerError)
 			return
 		}
 	}
 
-	w.WriteHeader(http.StatusOK)
-	_, _ = w.Write([]byte(fmt.Sprintf("please confirm the subscribe(%s)", endpointstr)))
+	middleware.AuditChange(req, nil, addReq)
+	apierr.WriteMessage(w, http.StatusOK, fmt.Sprintf("please confirm the subscribe(%s)", endpointstr))
 }
 
 func RmvUserNotify(w http.ResponseWriter, req *http.Request, ps map[string]string) {
@@ -226,8 +207,7 @@ func RmvUserNotify(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	u, err := checkProject(projectId)
 	if err != nil {
 		logs.Error("check project err:%s", err.Error())
-		w.WriteHeader(http.StatusBadRequest)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusBadRequest)
 		return
 	}
 	subscribeId := ps["subscribeId"]
@@ -245,14 +225,22 @@ func RmvUserNotify(w http.ResponseWriter, req *http.Request, ps map[string]strin
 	if strings.Contains(subscribeId, "-") {
 		arn = fmt.Sprintf("arn:aws:sns:us-west-2:415890359503:%s:%s", projectId, subscribeId)
 	}
+	// the endpoint is audited, it is gone after the unsubscribe
+	if attrs, err := svc.GetSubscriptionAttributes(&sns.GetSubscriptionAttributesInput{
+		SubscriptionArn: &arn,
+	}); err == nil {
+		middleware.AuditChange(req, map[string]string{
+			"protocol": aws.StringValue(attrs.Attributes["Protocol"]),
+			"endpoint": aws.StringValue(attrs.Attributes["Endpoint"]),
+		}, nil)
+	}
 	subDel := sns.UnsubscribeInput{
 		SubscriptionArn: &arn,
 	}
 	_, err = svc.Unsubscribe(&subDel)
 	if err != nil {
 		logs.Error("remove notify fail. err:%s", err.Error())
-		w.WriteHeader(http.StatusInternalServerError)
-		_, _ = w.Write([]byte(err.Error()))
+		apierr.Write(w, err, http.StatusInternalServerError)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
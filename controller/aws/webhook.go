@@ -0,0 +1,331 @@
+package aws
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultDeliveryLimit = 50
+
+type Webhook struct {
+	Id           string     `json:"id"`
+	ProjectId    string     `json:"project_id"`
+	Url          string     `json:"url"`
+	Secret       string     `json:"secret,omitempty"`
+	Events       []string   `json:"events"`
+	Enabled      bool       `json:"enabled"`
+	FailureCount int        `json:"failure_count"`
+	DisabledAt   *time.Time `json:"disabled_at,omitempty"`
+	CreateAt     *time.Time `json:"create_at,omitempty"`
+}
+
+type WebhooksWrap struct {
+	Webhooks []*Webhook `json:"webhooks"`
+}
+
+// WebhookReq creates or updates a webhook, nil fields are not updated.
+type WebhookReq struct {
+	Url     *string   `json:"url" valid:"MaxSize(512)"`
+	Secret  *string   `json:"secret" valid:"MaxSize(256)"`
+	Events  *[]string `json:"events" valid:"MaxSize(16)"`
+	Enabled *bool     `json:"enabled"`
+}
+
+type WebhookDelivery struct {
+	Id          string     `json:"id"`
+	Status      string     `json:"status"`
+	Attempts    int        `json:"attempts"`
+	StatusCode  int        `json:"status_code"`
+	Error       string     `json:"error,omitempty"`
+	RecordCount int        `json:"record_count"`
+	EventCount  int        `json:"event_count"`
+	CreateAt    *time.Time `json:"create_at,omitempty"`
+}
+
+type WebhookDeliveriesWrap struct {
+	Deliveries []*WebhookDelivery `json:"deliveries"`
+	Count      int64              `json:"count"`
+}
+
+// the secret is only returned when the webhook is created
+func toWebhook(wh *bluedb.Webhook) *Webhook {
+	events := make([]string, 0)
+	for _, e := range strings.Split(wh.Events, ",") {
+		if len(e) > 0 {
+			events = append(events, e)
+		}
+	}
+	return &Webhook{
+		Id:           wh.Id,
+		ProjectId:    wh.ProjectId,
+		Url:          wh.Url,
+		Events:       events,
+		Enabled:      wh.Enabled,
+		FailureCount: wh.FailureCount,
+		DisabledAt:   wh.DisabledAt,
+		CreateAt:     wh.CreateAt,
+	}
+}
+
+func checkWebhookUrl(raw string) error {
+	u, err := url.Parse(raw)
+	if err != nil {
+		return err
+	}
+	if (u.Scheme != "http" && u.Scheme != "https") || len(u.Host) == 0 {
+		return fmt.Errorf("invalid url(%s)", raw)
+	}
+	return common.CheckPublicHost(u.Hostname())
+}
+
+// checkWebhookEvents returns the events joined by comma, only alert and
+// status events can be subscribed, the readings are always pushed.
+func checkWebhookEvents(events []string) (string, error) {
+	for _, e := range events {
+		if e != common.EventAlert && e != common.EventStatus {
+			return "", fmt.Errorf("invalid event(%s)", e)
+		}
+	}
+	return strings.Join(events, ","), nil
+}
+
+func newWebhookSecret() (string, error) {
+	b := make([]byte, 24)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func readWebhookReq(w http.ResponseWriter, req *http.Request) (*WebhookReq, bool) {
+	var r WebhookReq
+	if !readJsonBody(w, req, &r) {
+		return nil, false
+	}
+	if r.Url != nil {
+		if err := checkWebhookUrl(*r.Url); err != nil {
+			apierr.Write(w, err, http.StatusBadRequest)
+			return nil, false
+		}
+	}
+	if r.Secret != nil && len(*r.Secret) < 16 {
+		apierr.WriteMessage(w, http.StatusBadRequest, "secret is shorter than 16")
+		return nil, false
+	}
+	return &r, true
+}
+
+func queryWebhook(w http.ResponseWriter, projectId, id string) (*bluedb.Webhook, bool) {
+	wh, err := bluedb.QueryWebhook(projectId, id)
+	if err != nil {
+		logs.Error("get webhook fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return nil, false
+	}
+	if wh == nil {
+		apierr.WriteMessage(w, http.StatusNotFound, "webhook not found")
+		return nil, false
+	}
+	return wh, true
+}
+
+// readJsonBody decodes the body into v and checks it by its valid tags.
+func readJsonBody(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	if err := apierr.DecodeJson(req, v); err != nil {
+		apierr.Write(w, err, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJson(w http.ResponseWriter, code int, v interface{}) {
+	body, err := json.Marshal(v)
+	if err != nil {
+		logs.Error("Invalid data. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(code)
+	_, _ = w.Write(body)
+}
+
+func ListWebhooks(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	list := WebhooksWrap{
+		Webhooks: make([]*Webhook, 0),
+	}
+	for _, wh := range bluedb.QueryWebhooks(map[string]interface{}{"project_id": projectId}) {
+		list.Webhooks = append(list.Webhooks, toWebhook(wh))
+	}
+	writeJson(w, http.StatusOK, list)
+}
+
+func GetWebhook(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	wh, ok := queryWebhook(w, ps["projectId"], ps["webhookId"])
+	if !ok {
+		return
+	}
+	writeJson(w, http.StatusOK, toWebhook(wh))
+}
+
+// CreateWebhook subscribes the url to the new readings of the project, the
+// secret signing the deliveries is generated if it is not given.
+func CreateWebhook(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	r, ok := readWebhookReq(w, req)
+	if !ok {
+		return
+	}
+	if r.Url == nil {
+		apierr.WriteMessage(w, http.StatusBadRequest, "url is empty")
+		return
+	}
+	wh := bluedb.Webhook{
+		ProjectId: projectId,
+		Url:       *r.Url,
+		Enabled:   true,
+	}
+	if r.Events != nil {
+		events, err := checkWebhookEvents(*r.Events)
+		if err != nil {
+			apierr.Write(w, err, http.StatusBadRequest)
+			return
+		}
+		wh.Events = events
+	}
+	if r.Enabled != nil {
+		wh.Enabled = *r.Enabled
+	}
+	if r.Secret != nil {
+		wh.Secret = *r.Secret
+	} else {
+		secret, err := newWebhookSecret()
+		if err != nil {
+			logs.Error("generate secret fail. err:%s", err.Error())
+			apierr.Write(w, err, http.StatusInternalServerError)
+			return
+		}
+		wh.Secret = secret
+	}
+	id, err := bluedb.SaveWebhook(wh)
+	if err != nil {
+		logs.Error("save webhook fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	wh.Id = id
+	out := toWebhook(&wh)
+	middleware.AuditTarget(req, "webhookId="+id)
+	middleware.AuditChange(req, nil, out)
+	out.Secret = wh.Secret
+	writeJson(w, http.StatusCreated, out)
+}
+
+// UpdateWebhook updates the given fields, enabling the webhook resets its
+// failure count.
+func UpdateWebhook(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	r, ok := readWebhookReq(w, req)
+	if !ok {
+		return
+	}
+	wh, ok := queryWebhook(w, ps["projectId"], ps["webhookId"])
+	if !ok {
+		return
+	}
+	before := toWebhook(wh)
+	if r.Url != nil {
+		wh.Url = *r.Url
+	}
+	if r.Secret != nil {
+		wh.Secret = *r.Secret
+	}
+	if r.Events != nil {
+		events, err := checkWebhookEvents(*r.Events)
+		if err != nil {
+			apierr.Write(w, err, http.StatusBadRequest)
+			return
+		}
+		wh.Events = events
+	}
+	if r.Enabled != nil {
+		if *r.Enabled && !wh.Enabled {
+			wh.FailureCount = 0
+			wh.DisabledAt = nil
+		}
+		wh.Enabled = *r.Enabled
+	}
+	if err := bluedb.UpdateWebhook(*wh, "url", "secret", "events", "enabled", "failure_count", "disabled_at"); err != nil {
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	middleware.AuditChange(req, before, toWebhook(wh))
+	writeJson(w, http.StatusOK, toWebhook(wh))
+}
+
+func DeleteWebhook(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	wh, err := bluedb.QueryWebhook(ps["projectId"], ps["webhookId"])
+	if err != nil {
+		logs.Error("get webhook fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	if wh == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	middleware.AuditChange(req, toWebhook(wh), nil)
+	if err := bluedb.DeleteWebhook(wh.Id); err != nil {
+		logs.Error("delete webhook fail. err:%s", err.Error())
+		apierr.Write(w, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// ListWebhookDeliveries returns the delivery log of the webhook, latest first.
+func ListWebhookDeliveries(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	wh, ok := queryWebhook(w, ps["projectId"], ps["webhookId"])
+	if !ok {
+		return
+	}
+	query := req.URL.Query()
+	limit := defaultDeliveryLimit
+	if l, err := strconv.Atoi(query.Get("limit")); err == nil && l > 0 {
+		limit = l
+	}
+	offset := 0
+	if o, err := strconv.Atoi(query.Get("offset")); err == nil && o > 0 {
+		offset = o
+	}
+	deliveries, count := bluedb.QueryWebhookDeliveries(wh.Id, offset, limit)
+	list := WebhookDeliveriesWrap{
+		Deliveries: make([]*WebhookDelivery, 0, len(deliveries)),
+		Count:      count,
+	}
+	for _, d := range deliveries {
+		list.Deliveries = append(list.Deliveries, &WebhookDelivery{
+			Id:          d.Id,
+			Status:      d.Status,
+			Attempts:    d.Attempts,
+			StatusCode:  d.StatusCode,
+			Error:       d.Error,
+			RecordCount: d.RecordCount,
+			EventCount:  d.EventCount,
+			CreateAt:    d.CreateAt,
+		})
+	}
+	writeJson(w, http.StatusOK, list)
+}
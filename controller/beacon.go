@@ -6,7 +6,6 @@ import (
 	"fmt"
 	"github.com/jack0liu/logs"
 	"github.com/ssrs100/blueserver/bluedb"
-	"io/ioutil"
 	"net/http"
 	"strconv"
 	"strings"
@@ -24,11 +23,11 @@ func init() {
 
 type Beacon struct {
 	Id          string `json:"id"`
-	DeviceId    string `json:"device_id"`
-	Type        string `json:"type"`
+	DeviceId    string `json:"device_id" valid:"MaxSize(128)"`
+	Type        string `json:"type" valid:"MaxSize(64)"`
 	ProjectId   string `json:"project_id"`
-	Status      string `json:"status"`
-	Description string `json:"description"`
+	Status      string `json:"status" valid:"MaxSize(32)"`
+	Description string `json:"description" valid:"MaxSize(256)"`
 }
 
 const (
@@ -61,19 +60,8 @@ type CreateBeaconResponse struct {
 }
 
 func RegisterBeacon(w http.ResponseWriter, req *http.Request, ps map[string]string) {
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
-	}
-	defer req.Body.Close()
-
 	var beanReq = &Beacon{}
-	err = json.Unmarshal(body, beanReq)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	if !readJsonBody(w, req, beanReq) {
 		return
 	}
 	// check beacon info
@@ -107,7 +95,7 @@ func RegisterBeacon(w http.ResponseWriter, req *http.Request, ps map[string]stri
 	if len(bean) > 0 {
 		strErr := fmt.Sprintf("Beacon(%s) has been registed.", beanReq.DeviceId)
 		logs.Error(strErr)
-		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusBadRequest)
+		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusConflict)
 		return
 	}
 
@@ -129,11 +117,9 @@ func RegisterBeacon(w http.ResponseWriter, req *http.Request, ps map[string]stri
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(CreateBeaconResponse{
+	writeJsonResp(w, http.StatusOK, CreateBeaconResponse{
 		BeanId: beanId,
 	})
-	w.WriteHeader(http.StatusOK)
 }
 
 func getType(t string) string {
@@ -180,25 +166,14 @@ func ListBeacons(w http.ResponseWriter, req *http.Request, ps map[string]string)
 
 	logs.Debug("list beancons:%v", beancons)
 	var b = Beacon{}
-	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(b.dbListObjectTrans(beancons))
 }
 
 func UpdateBeacon(w http.ResponseWriter, req *http.Request, ps map[string]string) {
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
-	}
-	defer req.Body.Close()
-
 	var beanReq = &Beacon{}
-	err = json.Unmarshal(body, beanReq)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	if !readJsonBody(w, req, beanReq) {
 		return
 	}
 	// check
@@ -215,7 +190,7 @@ func UpdateBeacon(w http.ResponseWriter, req *http.Request, ps map[string]string
 		Id:          id,
 		Description: beanReq.Description,
 	}
-	err = bluedb.UpdateBeacon(beancon)
+	err := bluedb.UpdateBeacon(beancon)
 	if err != nil {
 		logs.Error("Delete beacon failed: %v", err.Error())
 		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
@@ -80,7 +80,7 @@ func ListCollections(w http.ResponseWriter, req *http.Request, ps map[string]str
 
 	logs.Debug("list collections:%v", collections)
 	var b = Collection{}
-	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(b.dbListObjectTrans(collections))
 }
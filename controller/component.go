@@ -8,7 +8,6 @@ import (
 	"github.com/ssrs100/blueserver/bluedb"
 	"github.com/ssrs100/blueserver/common"
 	"github.com/ssrs100/blueserver/mqttclient"
-	"io/ioutil"
 	"net/http"
 	"strconv"
 	"time"
@@ -16,12 +15,12 @@ import (
 
 type Component struct {
 	Id                string `json:"id"`
-	MacAddr           string `json:"mac_addr"`
-	GWMacAddr         string `json:"gw_mac_addr"`
-	Type              string `json:"type"`
+	MacAddr           string `json:"mac_addr" valid:"MaxSize(64)"`
+	GWMacAddr         string `json:"gw_mac_addr" valid:"MaxSize(64)"`
+	Type              string `json:"type" valid:"MaxSize(64)"`
 	ProjectId         string `json:"project_id"`
-	Name              string `json:"name"`
-	ComponentPassword string `json:"component_password"`
+	Name              string `json:"name" valid:"MaxSize(64)"`
+	ComponentPassword string `json:"component_password" valid:"MaxSize(256)"`
 }
 
 func (b *Component) dbObjectTrans(component bluedb.Component) Component {
@@ -50,19 +49,8 @@ type CreateComponentResponse struct {
 }
 
 func RegisterComponent(w http.ResponseWriter, req *http.Request, ps map[string]string) {
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
-	}
-	defer req.Body.Close()
-
 	var componentReq = &Component{}
-	err = json.Unmarshal(body, componentReq)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	if !readJsonBody(w, req, componentReq) {
 		return
 	}
 	// check component info
@@ -81,8 +69,8 @@ func RegisterComponent(w http.ResponseWriter, req *http.Request, ps map[string]s
 	}
 
 	if len(componentReq.Name) <= 0 || len(componentReq.MacAddr) < 10 {
-		strErr := fmt.Sprintf("Invalid name(%s) or mac(%s) or password(%s).",
-			componentReq.Name, componentReq.MacAddr, componentReq.ComponentPassword)
+		strErr := fmt.Sprintf("Invalid name(%s) or mac(%s).",
+			componentReq.Name, componentReq.MacAddr)
 		logs.Error(strErr)
 		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusBadRequest)
 		return
@@ -105,7 +93,7 @@ func RegisterComponent(w http.ResponseWriter, req *http.Request, ps map[string]s
 		mqttclient.Client.Subscribe(componentReq.MacAddr)
 	}
 
-	logs.Info("register component:%v", componentReq)
+	logs.Info("register component:%s", componentReq.MacAddr)
 	componentDb := bluedb.Component{
 		Id:                "",
 		MacAddr:           componentReq.MacAddr,
@@ -124,11 +112,9 @@ func RegisterComponent(w http.ResponseWriter, req *http.Request, ps map[string]s
 		return
 	}
 
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(CreateComponentResponse{
+	writeJsonResp(w, http.StatusOK, CreateComponentResponse{
 		ComponentId: componentId,
 	})
-	w.WriteHeader(http.StatusOK)
 }
 
 func DeleteComponent(w http.ResponseWriter, req *http.Request, ps map[string]string) {
@@ -169,27 +155,16 @@ func ListComponents(w http.ResponseWriter, req *http.Request, ps map[string]stri
 
 	components := bluedb.QueryComponents(params)
 
-	logs.Debug("list components:%v", components)
+	logs.Debug("list %d components", len(components))
 	var b = Component{}
-	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(b.dbListObjectTrans(components))
 }
 
 func UpdateComponent(w http.ResponseWriter, req *http.Request, ps map[string]string) {
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
-	}
-	defer req.Body.Close()
-
 	var comReq = &Component{}
-	err = json.Unmarshal(body, comReq)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	if !readJsonBody(w, req, comReq) {
 		return
 	}
 	// check
@@ -204,7 +179,7 @@ func UpdateComponent(w http.ResponseWriter, req *http.Request, ps map[string]str
 	comdb, err := bluedb.QueryComponentById(id)
 	if err != nil {
 		logs.Error("update component fail, id not found.")
-		DefaultHandler.ServeHTTP(w, req, errors.New("Component id not found."), http.StatusBadRequest)
+		DefaultHandler.ServeHTTP(w, req, errors.New("Component id not found."), http.StatusNotFound)
 		return
 	}
 
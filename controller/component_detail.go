@@ -7,7 +7,6 @@ import (
 	"github.com/ssrs100/blueserver/common"
 	"github.com/ssrs100/blueserver/model"
 	"github.com/ssrs100/blueserver/mqttclient"
-	"io/ioutil"
 	"net/http"
 )
 
@@ -20,19 +19,8 @@ type ComponentModifyMsg struct {
 }
 
 func UpdateComponentDetail(w http.ResponseWriter, req *http.Request, ps map[string]string) {
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
-	}
-	defer req.Body.Close()
-
 	var detailReq = &model.ComponentDetail{}
-	err = json.Unmarshal(body, detailReq)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	if !readJsonBody(w, req, detailReq) {
 		return
 	}
 
@@ -96,7 +84,7 @@ func UpdateComponentDetail(w http.ResponseWriter, req *http.Request, ps map[stri
 		if err != nil {
 			logs.Error("marshal err:%s", err.Error())
 		}
-		logs.Debug("publish body:%s", string(body))
+		logs.Debug("publish %s to %s", msgType, c.MacAddr)
 		mqttclient.Client.PublishModify(c.GwMacAddr, body)
 	} else {
 		logs.Error("mqtt client is nil, not notify")
@@ -116,8 +104,8 @@ func GetComponentDetail(w http.ResponseWriter, req *http.Request, ps map[string]
 	}
 
 	var b = model.ComponentDetail{}
-	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(b.DbObjectTrans(*com))
 
 }
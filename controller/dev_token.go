@@ -1,10 +1,8 @@
 package controller
 
 import (
-	"encoding/json"
 	"github.com/jack0liu/logs"
 	"github.com/ssrs100/blueserver/bluedb"
-	"io/ioutil"
 	"net/http"
 	"time"
 )
@@ -18,30 +16,14 @@ type DevToken struct {
 }
 
 type RegisterDevTokenReq struct {
-	DevId       string `json:"dev_id"`
-	DeviceToken string `json:"device_token"`
+	DevId       string `json:"dev_id" valid:"Required;MaxSize(128)"`
+	DeviceToken string `json:"device_token" valid:"Required;MaxSize(128)"`
 }
 
 func RegisterDevToken(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 	projectId := ps["projectId"]
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
-	}
-	defer req.Body.Close()
-
 	reqBody := RegisterDevTokenReq{}
-	err = json.Unmarshal(body, &reqBody)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
-	}
-	if len(reqBody.DevId) == 0 || len(reqBody.DeviceToken) == 0 {
-		logs.Error("Invalid body. err:%v", reqBody)
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	if !readJsonBody(w, req, &reqBody) {
 		return
 	}
 	devToken := bluedb.DevToken{
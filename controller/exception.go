@@ -1,10 +1,7 @@
 package controller
 
 import (
-	"encoding/json"
-	"github.com/jack0liu/logs"
-	"io"
-	"net"
+	"github.com/ssrs100/blueserver/controller/apierr"
 	"net/http"
 )
 
@@ -17,35 +14,8 @@ var DefaultHandler ErrorHandler = &StdHandler{}
 type StdHandler struct {
 }
 
-type ErrorMessage struct {
-	Message string `json:"message"`
-}
-
+// StdHandler writes the errors as apierr.Error, the message is kept in the
+// "message" key as before.
 func (e *StdHandler) ServeHTTP(w http.ResponseWriter, req *http.Request, err error, code int) {
-	statusCode := code
-	if e, ok := err.(net.Error); ok {
-		if e.Timeout() {
-			statusCode = http.StatusGatewayTimeout
-		} else {
-			statusCode = http.StatusBadGateway
-		}
-	} else if err == io.EOF {
-		statusCode = http.StatusBadGateway
-	}
-	w.WriteHeader(statusCode)
-	w.Header().Add("Content-Type", "application/json")
-
-	var message string
-	if err != nil {
-		message = err.Error()
-	} else {
-		message = http.StatusText(code)
-	}
-	errMessage := ErrorMessage {
-		Message : message,
-	}
-	if err := json.NewEncoder(w).Encode(errMessage); err != nil {
-		logs.Error("Encoder message fail.")
-	}
-
+	apierr.Write(w, err, code)
 }
@@ -4,18 +4,17 @@ import (
 	"encoding/json"
 	"github.com/jack0liu/logs"
 	"github.com/ssrs100/blueserver/bluedb"
-	"io/ioutil"
 	"net/http"
 )
 
 type DeviceIdentity struct {
-	Type     string `json:"type"`
-	DeviceId string `json:"device_id"`
+	Type     string `json:"type" valid:"Required;MaxSize(64)"`
+	DeviceId string `json:"device_id" valid:"Required;MaxSize(128)"`
 }
 
 type HybridRequest struct {
-	Observations    []DeviceIdentity `json:"observations"`
-	AttachmentTypes []string         `json:"attachment_types"`
+	Observations    []DeviceIdentity `json:"observations" valid:"Required;MaxSize(100)"`
+	AttachmentTypes []string         `json:"attachment_types" valid:"MaxSize(16)"`
 }
 
 type AttachmentResponse struct {
@@ -35,19 +34,8 @@ type HybridResponse struct {
 }
 
 func GetForObserved(w http.ResponseWriter, req *http.Request, ps map[string]string) {
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
-	}
-	defer req.Body.Close()
-
 	var hybridReq = &HybridRequest{}
-	err = json.Unmarshal(body, hybridReq)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	if !readJsonBody(w, req, hybridReq) {
 		return
 	}
 
@@ -87,8 +75,8 @@ func GetForObserved(w http.ResponseWriter, req *http.Request, ps map[string]stri
 		hybrids = append(hybrids, h)
 	}
 
-	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(HybridResponse {
 		Beacons: hybrids,
 	})
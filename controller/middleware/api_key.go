@@ -0,0 +1,109 @@
+package middleware
+
+import (
+	"github.com/patrickmn/go-cache"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ApiKeyPrefix starts every api key, so that a key passed in the token header
+// is told apart from a session token.
+const ApiKeyPrefix = "bk_"
+
+var (
+	// apiKeyCache caches the keys by hash, a nil key is cached for unknown
+	// hashes too.
+	apiKeyCache = cache.New(30*time.Second, time.Minute)
+	// apiKeyTouched throttles the updates of the last used time
+	apiKeyTouched = cache.New(time.Minute, 2*time.Minute)
+)
+
+// NewApiKey generates a key, the key is shown once and only its hash is kept.
+func NewApiKey() (key, prefix, hash string, err error) {
+	token, err := randomToken()
+	if err != nil {
+		return "", "", "", err
+	}
+	key = ApiKeyPrefix + token
+	return key, key[:len(ApiKeyPrefix)+6], hashToken(key), nil
+}
+
+// HashApiKey returns the hash the key is saved with.
+func HashApiKey(key string) string {
+	return hashToken(key)
+}
+
+// ValidScope tells whether the scope can be given to an api key.
+func ValidScope(scope string) bool {
+	switch scope {
+	case common.ScopeReadData, common.ScopeManageThings, common.ScopeManageAlerts:
+		return true
+	}
+	return false
+}
+
+// ForgetApiKey drops the cached key after it is revoked, on all servers.
+func ForgetApiKey(id string) {
+	forgetApiKeyLocal(id)
+	publishForget(forgetApiKey, id)
+}
+
+func forgetApiKeyLocal(id string) {
+	for hash, item := range apiKeyCache.Items() {
+		if key, ok := item.Object.(*bluedb.ApiKey); ok && key != nil && key.Id == id {
+			apiKeyCache.Delete(hash)
+		}
+	}
+}
+
+func requestApiKey(r *http.Request) string {
+	if key := r.Header.Get(common.XApiKey); len(key) > 0 {
+		return key
+	}
+	if token := r.Header.Get(common.XAuthB); strings.HasPrefix(token, ApiKeyPrefix) {
+		return token
+	}
+	return ""
+}
+
+// apiKeySession returns the session of the key, it acts as the user created
+// the key within the project and the scopes of the key.
+func apiKeySession(k string) *UserSession {
+	hash := hashToken(k)
+	var key *bluedb.ApiKey
+	if cached, ok := apiKeyCache.Get(hash); ok {
+		key = cached.(*bluedb.ApiKey)
+	} else {
+		key = bluedb.QueryApiKeyByHash(hash)
+		apiKeyCache.Set(hash, key, cache.DefaultExpiration)
+	}
+	if key == nil {
+		return nil
+	}
+	now := time.Now()
+	if key.ExpireAt != nil && now.After(*key.ExpireAt) {
+		return nil
+	}
+	if _, ok := apiKeyTouched.Get(key.Id); !ok {
+		apiKeyTouched.Set(key.Id, true, cache.DefaultExpiration)
+		go bluedb.TouchApiKey(key.Id, now)
+	}
+	return &UserSession{
+		UserId:    key.CreatedBy,
+		ApiKeyId:  key.Id,
+		ProjectId: key.ProjectId,
+		Scopes:    strings.Split(key.Scopes, ","),
+	}
+}
+
+func hasScope(us *UserSession, scope string) bool {
+	for _, s := range us.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/dimfeld/httptreemux"
+	"github.com/jack0liu/logs"
+	"github.com/satori/go.uuid"
+	"github.com/ssrs100/blueserver/bluedb"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	XRequestId = "X-Request-Id"
+
+	// longer summaries are cut
+	auditSummaryMax = 4096
+)
+
+var requestIdPattern = regexp.MustCompile(`^[A-Za-z0-9._-]{1,64}$`)
+
+type requestIdCtxKey struct{}
+
+type auditCtxKey struct{}
+
+// auditRecord is filled by the handler through the Audit hooks.
+type auditRecord struct {
+	projectId  *string
+	actorId    string
+	target     string
+	before     interface{}
+	after      interface{}
+	hasChanges bool
+}
+
+// RequestId takes the request id of the proxy or gives the request a new
+// one, it is sent back in X-Request-Id.
+func RequestId(fn httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+		id := r.Header.Get(XRequestId)
+		if !requestIdPattern.MatchString(id) {
+			id = uuid.NewV4().String()
+		}
+		w.Header().Set(XRequestId, id)
+		fn(w, r.WithContext(context.WithValue(r.Context(), requestIdCtxKey{}, id)), ps)
+	}
+}
+
+// CurrentRequestId returns the id given by RequestId.
+func CurrentRequestId(r *http.Request) string {
+	id, _ := r.Context().Value(requestIdCtxKey{}).(string)
+	return id
+}
+
+func currentAudit(r *http.Request) *auditRecord {
+	rec, _ := r.Context().Value(auditCtxKey{}).(*auditRecord)
+	return rec
+}
+
+// AuditTarget names what the request changed, the path params name it by
+// default.
+func AuditTarget(r *http.Request, target string) {
+	if rec := currentAudit(r); rec != nil {
+		rec.target = target
+	}
+}
+
+// AuditChange records the summaries of the target before and after the
+// request, either may be nil. They must not hold secrets.
+func AuditChange(r *http.Request, before, after interface{}) {
+	if rec := currentAudit(r); rec != nil {
+		rec.before, rec.after, rec.hasChanges = before, after, true
+	}
+}
+
+// AuditProject sets the project of a request without one in the path, such
+// as the creation of a project.
+func AuditProject(r *http.Request, projectId string) {
+	if rec := currentAudit(r); rec != nil {
+		rec.projectId = &projectId
+	}
+}
+
+// AuditActor sets the actor of a request without a session, such as a
+// login.
+func AuditActor(r *http.Request, userId string) {
+	if rec := currentAudit(r); rec != nil {
+		rec.actorId = userId
+	}
+}
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+func auditSummary(v interface{}) string {
+	if v == nil {
+		return ""
+	}
+	b, err := json.Marshal(v)
+	if err != nil {
+		logs.Error("marshal audit summary err:%s", err.Error())
+		return ""
+	}
+	if string(b) == "null" {
+		return ""
+	}
+	if len(b) > auditSummaryMax {
+		b = b[:auditSummaryMax]
+	}
+	return string(b)
+}
+
+// pathTarget is the path params besides the project, sorted by name. The
+// projectId of user routes is named userId.
+func pathTarget(ps map[string]string, userRoute bool) string {
+	var parts []string
+	for k, v := range ps {
+		if k == "projectId" {
+			if !userRoute {
+				continue
+			}
+			k = "userId"
+		}
+		parts = append(parts, k+"="+v)
+	}
+	sort.Strings(parts)
+	return strings.Join(parts, ",")
+}
+
+// Audit logs the request as the action once it is handled. The projectId in
+// the path of user routes is the user, their logs have no project.
+func Audit(action string, userRoute bool) Middleware {
+	return func(fn httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+			rec := &auditRecord{}
+			sw := &statusWriter{ResponseWriter: w}
+			fn(sw, r.WithContext(context.WithValue(r.Context(), auditCtxKey{}, rec)), ps)
+
+			l := bluedb.AuditLog{
+				ActorId:   rec.actorId,
+				Action:    action,
+				Target:    rec.target,
+				Method:    r.Method,
+				Path:      r.URL.Path,
+				Status:    sw.status,
+				Ip:        clientIp(r),
+				RequestId: CurrentRequestId(r),
+			}
+			if l.Status == 0 {
+				l.Status = http.StatusOK
+			}
+			if !userRoute {
+				l.ProjectId = ps["projectId"]
+			}
+			if us := CurrentSession(r); us != nil {
+				l.ActorId, l.ApiKeyId = us.UserId, us.ApiKeyId
+				if len(l.ProjectId) == 0 {
+					l.ProjectId = us.ProjectId
+				}
+			}
+			if rec.projectId != nil {
+				l.ProjectId = *rec.projectId
+			}
+			if len(l.Target) == 0 {
+				l.Target = pathTarget(ps, userRoute)
+			}
+			if len(l.Target) > 256 {
+				l.Target = l.Target[:256]
+			}
+			if len(l.Path) > 512 {
+				l.Path = l.Path[:512]
+			}
+			if rec.hasChanges {
+				l.Before, l.After = auditSummary(rec.before), auditSummary(rec.after)
+			}
+			_ = bluedb.SaveAuditLog(l)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package middleware
+
+import (
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/sesscache"
+	"strings"
+	"time"
+)
+
+const (
+	// cacheChannel carries the entries to drop from the caches of all
+	// servers, as kind:id
+	cacheChannel = "cache_forget"
+
+	forgetApiKey    = "api_key"
+	forgetRole      = "role"
+	forgetRoles     = "roles"
+	forgetTwoFactor = "two_factor"
+
+	cacheSyncRestartDelay = 5 * time.Second
+)
+
+// publishForget asks the other servers to drop the cached entry, it is
+// dropped here by the caller already. If redis is not reachable the entry
+// lives on the other servers until it expires.
+func publishForget(kind, id string) {
+	if err := sesscache.Publish(cacheChannel, kind+":"+id); err != nil {
+		logs.Error("publish forget %s(%s) err:%s", kind, id, err.Error())
+	}
+}
+
+func forgetLocal(msg string) {
+	kind, id := msg, ""
+	if i := strings.Index(msg, ":"); i >= 0 {
+		kind, id = msg[:i], msg[i+1:]
+	}
+	switch kind {
+	case forgetApiKey:
+		forgetApiKeyLocal(id)
+	case forgetRole:
+		roleCache.Delete(id)
+	case forgetRoles:
+		forgetRolesLocal(id)
+	case forgetTwoFactor:
+		twoFactorCache.Delete(id)
+	default:
+		logs.Warn("unknown cache entry to forget:%s", msg)
+	}
+}
+
+// StartCacheSync drops the cached api keys, roles and settings changed on the
+// other servers, so that a revoked key or a removed member is rejected by
+// all servers at once.
+func StartCacheSync() {
+	go func() {
+		for {
+			receiveForget()
+			logs.Warn("cache sync stopped, restart in %s", cacheSyncRestartDelay)
+			time.Sleep(cacheSyncRestartDelay)
+		}
+	}()
+}
+
+func receiveForget() {
+	ps := sesscache.PSubscribe(cacheChannel)
+	defer ps.Close()
+	if _, err := ps.Receive(); err != nil {
+		logs.Error("subscribe %s err:%s", cacheChannel, err.Error())
+		return
+	}
+	for msg := range ps.Channel() {
+		forgetLocal(msg.Payload)
+	}
+}
@@ -0,0 +1,118 @@
+package middleware
+
+import (
+	"fmt"
+	"github.com/dimfeld/httptreemux"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"github.com/ssrs100/blueserver/sesscache"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const rateLimitPrefix = "rateLimit_"
+
+// LimitKey is what the requests are counted by.
+type LimitKey int
+
+const (
+	// the client ip
+	ByIp LimitKey = iota
+	// the user, or the api key, of the session, the client ip without one
+	ByUser
+	// the project in the path
+	ByProject
+)
+
+// Limit allows Rate requests in each Window. The rate can be changed by the
+// conf ratelimit_<Name>, and -1 there turns the limit off.
+type Limit struct {
+	Name   string
+	Rate   int
+	Window time.Duration
+	By     LimitKey
+}
+
+func (l Limit) rate() int {
+	return conf.GetIntWithDefault("ratelimit_"+l.Name, l.Rate)
+}
+
+func (l Limit) key(r *http.Request, ps map[string]string) string {
+	switch l.By {
+	case ByUser:
+		if us := CurrentSession(r); us != nil {
+			if len(us.ApiKeyId) > 0 {
+				return "key:" + us.ApiKeyId
+			}
+			return "user:" + us.UserId
+		}
+	case ByProject:
+		if projectId := ps["projectId"]; len(projectId) > 0 {
+			return "project:" + projectId
+		}
+	}
+	return "ip:" + clientIp(r)
+}
+
+// Hit counts a request of the key against the rate in the window, it returns
+// false and the time to wait if the rate is exceeded. Requests are allowed if
+// redis fails.
+func Hit(key string, rate int, window time.Duration) (bool, time.Duration) {
+	n, ttl, err := sesscache.IncrWithExpired(rateLimitPrefix+key, window)
+	if err != nil {
+		logs.Error("rate limit of %s err:%s", key, err.Error())
+		return true, 0
+	}
+	if n > int64(rate) {
+		return false, ttl
+	}
+	return true, 0
+}
+
+// Blocked tells whether the key has reached the rate of Hit, without
+// counting a request.
+func Blocked(key string, rate int) (bool, time.Duration) {
+	n, err := strconv.ParseInt(sesscache.Get(rateLimitPrefix+key), 10, 64)
+	if err != nil || n < int64(rate) {
+		return false, 0
+	}
+	return true, sesscache.TTL(rateLimitPrefix + key)
+}
+
+// ResetHits forgets the requests counted of the key.
+func ResetHits(key string) {
+	sesscache.Del(rateLimitPrefix + key)
+}
+
+// RetryAfter rejects the request with 429 and the seconds to wait.
+func RetryAfter(w http.ResponseWriter, wait time.Duration) {
+	secs := int64((wait + time.Second - 1) / time.Second)
+	if secs < 1 {
+		secs = 1
+	}
+	w.Header().Set("Retry-After", strconv.FormatInt(secs, 10))
+	apierr.WriteMessage(w, http.StatusTooManyRequests, http.StatusText(http.StatusTooManyRequests))
+}
+
+// RateLimit rejects the requests exceeding any of the limits with 429.
+func RateLimit(limits ...Limit) Middleware {
+	return func(fn httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+			for _, l := range limits {
+				rate := l.rate()
+				if rate < 0 {
+					continue
+				}
+				key := fmt.Sprintf("%s_%s", l.Name, l.key(r, ps))
+				if ok, wait := Hit(key, rate, l.Window); !ok {
+					logs.Warn("rate limit %s exceeded by %s %s", key, r.Method, r.URL.Path)
+					RetryAfter(w, wait)
+					return
+				}
+			}
+			fn(w, r, ps)
+		}
+	}
+}
@@ -0,0 +1,198 @@
+package middleware
+
+import (
+	"github.com/dimfeld/httptreemux"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/patrickmn/go-cache"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// Permission is what a route requires, each level up to PermPlatform
+// includes the ones below it.
+type Permission int
+
+const (
+	// no token is required
+	PermPublic Permission = iota
+	// any logged in user
+	PermUser
+	// viewer of the project in the path
+	PermView
+	// operator of the project in the path
+	PermOperate
+	// owner of the project in the path
+	PermManage
+	// platform admin
+	PermPlatform
+	// the user in the path itself, the path names it projectId
+	PermSelf
+)
+
+var permNames = map[Permission]string{
+	PermPublic:   "public",
+	PermUser:     "user",
+	PermView:     "view",
+	PermOperate:  "operate",
+	PermManage:   "manage",
+	PermPlatform: "platform",
+	PermSelf:     "self",
+}
+
+func (p Permission) String() string {
+	return permNames[p]
+}
+
+// rolePerms is the highest permission granted by each project role.
+var rolePerms = map[string]Permission{
+	common.RoleViewer:   PermView,
+	common.RoleOperator: PermOperate,
+	common.RoleOwner:    PermManage,
+}
+
+func hasRole(us *UserSession, role string) bool {
+	for _, r := range us.Roles {
+		if r == role {
+			return true
+		}
+	}
+	return false
+}
+
+// roleCache caches the membership roles by user and project, an empty role
+// is cached for non-members too.
+var roleCache = cache.New(30*time.Second, time.Minute)
+
+func roleKey(userId, projectId string) string {
+	return userId + "/" + projectId
+}
+
+// ProjectRole returns the role of the user in the project, it is empty if
+// the user is not a member of the project.
+func ProjectRole(userId, projectId string) string {
+	key := roleKey(userId, projectId)
+	if role, ok := roleCache.Get(key); ok {
+		return role.(string)
+	}
+	role := ""
+	if m := bluedb.QueryMembership(projectId, userId); m != nil {
+		role = m.Role
+	}
+	roleCache.Set(key, role, cache.DefaultExpiration)
+	return role
+}
+
+// ForgetRole drops the cached role after the membership is changed, on all
+// servers.
+func ForgetRole(userId, projectId string) {
+	key := roleKey(userId, projectId)
+	roleCache.Delete(key)
+	publishForget(forgetRole, key)
+}
+
+// ForgetRoles drops the cached roles of the user in all projects, on all
+// servers.
+func ForgetRoles(userId string) {
+	forgetRolesLocal(userId)
+	publishForget(forgetRoles, userId)
+}
+
+func forgetRolesLocal(userId string) {
+	for key := range roleCache.Items() {
+		if strings.HasPrefix(key, userId+"/") {
+			roleCache.Delete(key)
+		}
+	}
+}
+
+// ValidRole tells whether the role can be given to a member.
+func ValidRole(role string) bool {
+	_, ok := rolePerms[role]
+	return ok
+}
+
+// RoleRank orders the project roles by the permission they grant, it is 0
+// for an unknown role.
+func RoleRank(role string) int {
+	return int(rolePerms[role])
+}
+
+// Allowed tells whether the session has the permission, projectId is the
+// project in the path of the request. An api key session also needs the
+// scope of the route, routes without a scope are not open to api keys.
+func Allowed(us *UserSession, projectId string, perm Permission, scope string) bool {
+	if perm == PermPublic {
+		return true
+	}
+	if us == nil {
+		return false
+	}
+	if len(us.ApiKeyId) > 0 {
+		if len(scope) == 0 || !hasScope(us, scope) || projectId != us.ProjectId {
+			return false
+		}
+		// the key can not do more than its creator
+		return perm <= PermManage && rolePerms[ProjectRole(us.UserId, projectId)] >= perm
+	}
+	if hasRole(us, common.RoleAdmin) {
+		return true
+	}
+	switch perm {
+	case PermUser:
+		return true
+	case PermPlatform:
+		return false
+	case PermSelf:
+		return len(projectId) > 0 && projectId == us.UserId
+	}
+	if len(projectId) == 0 {
+		return false
+	}
+	return rolePerms[ProjectRole(us.UserId, projectId)] >= perm
+}
+
+// Authorize rejects the requests without the permission, it runs after Auth.
+func Authorize(perm Permission, scope string) Middleware {
+	return func(fn httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+		return func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+			if conf.GetInt("enable_auth") == -1 {
+				fn(w, r, ps)
+				return
+			}
+			us := CurrentSession(r)
+			if !Allowed(us, ps["projectId"], perm, scope) {
+				userId := ""
+				if us != nil {
+					userId = us.UserId
+				}
+				logs.Error("user(%s) has no %s permission of %s %s", userId, perm, r.Method, r.URL.Path)
+				apierr.WriteMessage(w, http.StatusForbidden, http.StatusText(http.StatusForbidden))
+				return
+			}
+			if !passedTwoFactor(us, ps["projectId"], perm) {
+				logs.Error("user(%s) has no second factor for %s %s", us.UserId, r.Method, r.URL.Path)
+				apierr.Write(w, apierr.WithCode(http.StatusForbidden, apierr.CodeMfaRequired,
+					"two-factor authentication required"), http.StatusForbidden)
+				return
+			}
+			fn(w, r, ps)
+		}
+	}
+}
+
+// Require wraps the handler with the authentication and the authorization
+// of the permission and the api key scope, public handlers are not wrapped.
+func Require(perm Permission, scope string, fn httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+	if perm == PermPublic {
+		return fn
+	}
+	s := NewStack()
+	s.Use(Auth)
+	s.Use(Authorize(perm, scope))
+	return s.Wrap(fn)
+}
@@ -0,0 +1,348 @@
+package middleware
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"github.com/fernet/fernet-go"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/sesscache"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	defaultAccessTokenTTL  = 3600
+	defaultRefreshTokenTTL = 7 * 24 * 3600
+
+	sessionPrefix       = "session_"
+	userSessionsPrefix  = "sessions_"
+	sessionAccessPrefix = "sessionAccess_"
+	refreshPrefix       = "refresh_"
+)
+
+var ErrInvalidRefreshToken = errors.New("invalid refresh token or expired")
+
+type sessionCtxKey struct{}
+
+// Session is a login of a user. It lives as long as its refresh token, each
+// refresh replaces both tokens and extends the session.
+type Session struct {
+	Id          string   `json:"id"`
+	UserId      string   `json:"user_id"`
+	Roles       []string `json:"roles"`
+	CreatedAt   string   `json:"created_at"`
+	ExpiredAt   string   `json:"expired_at"`
+	UserAgent   string   `json:"user_agent"`
+	Ip          string   `json:"ip"`
+	AccessToken string   `json:"access_token"`
+	// sha256 of the refresh token, the token itself is not kept
+	RefreshHash string `json:"refresh_hash"`
+	LastAccess  string `json:"last_access,omitempty"`
+	// the login passed a second factor
+	Mfa bool `json:"mfa,omitempty"`
+}
+
+// Tokens is the result of a login or a refresh.
+type Tokens struct {
+	SessionId    string
+	AccessToken  string
+	RefreshToken string
+	ExpiresIn    int64
+}
+
+func accessTokenTTL() time.Duration {
+	return time.Duration(conf.GetIntWithDefault("access_token_ttl", defaultAccessTokenTTL)) * time.Second
+}
+
+func refreshTokenTTL() time.Duration {
+	return time.Duration(conf.GetIntWithDefault("refresh_token_ttl", defaultRefreshTokenTTL)) * time.Second
+}
+
+func randomToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// trustedProxy tells whether the ip is in trusted_proxies, a comma separated
+// list of the ips and cidrs of the load balancers in front of the server.
+func trustedProxy(ip string) bool {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false
+	}
+	for _, proxy := range strings.Split(conf.GetString("trusted_proxies"), ",") {
+		proxy = strings.TrimSpace(proxy)
+		if len(proxy) == 0 {
+			continue
+		}
+		if _, n, err := net.ParseCIDR(proxy); err == nil {
+			if n.Contains(addr) {
+				return true
+			}
+		} else if p := net.ParseIP(proxy); p != nil && p.Equal(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// clientIp is the peer of the connection, or the address the trusted proxies
+// forwarded for. The X-Forwarded-For entries are read from the right, the
+// first one not added by a trusted proxy is the client, the entries left of
+// it are sent by the client and can not be trusted.
+func clientIp(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	if !trustedProxy(host) {
+		return host
+	}
+	fwd := strings.Split(strings.Join(r.Header["X-Forwarded-For"], ","), ",")
+	for i := len(fwd) - 1; i >= 0; i-- {
+		ip := strings.TrimSpace(fwd[i])
+		if net.ParseIP(ip) == nil {
+			break
+		}
+		host = ip
+		if !trustedProxy(ip) {
+			break
+		}
+	}
+	return host
+}
+
+// issueAccessToken signs the user session with a new fernet key, the key is
+// kept in redis as long as the token is valid.
+func issueAccessToken(s *Session) (string, error) {
+	now := time.Now().UTC()
+	us := UserSession{
+		UserId:    s.UserId,
+		SessionId: s.Id,
+		Roles:     s.Roles,
+		CreatedAt: now.Format(time.RFC3339),
+		ExpiredAt: now.Add(accessTokenTTL()).Format(time.RFC3339),
+	}
+	key := fernet.Key{}
+	if err := key.Generate(); err != nil {
+		return "", err
+	}
+	sId := key.Encode()
+	sess, err := json.Marshal(&us)
+	if err != nil {
+		return "", err
+	}
+	k := fernet.MustDecodeKeys(sId)
+	tok, err := fernet.EncryptAndSign(sess, k[0])
+	if err != nil {
+		return "", err
+	}
+	sesscache.SetWithExpired(string(tok), sId, accessTokenTTL())
+	return string(tok), nil
+}
+
+func loadSession(sessionId string) *Session {
+	val := sesscache.Get(sessionPrefix + sessionId)
+	if len(val) == 0 {
+		return nil
+	}
+	var s Session
+	if err := json.Unmarshal([]byte(val), &s); err != nil {
+		logs.Error("invalid session(%s), err:%s", sessionId, err.Error())
+		return nil
+	}
+	return &s
+}
+
+func saveSession(s *Session) error {
+	val, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	sesscache.SetWithExpired(sessionPrefix+s.Id, string(val), refreshTokenTTL())
+	return nil
+}
+
+// renew issues the tokens of the session and saves it.
+func renew(s *Session) (*Tokens, error) {
+	access, err := issueAccessToken(s)
+	if err != nil {
+		return nil, err
+	}
+	refresh, err := randomToken()
+	if err != nil {
+		sesscache.Del(access)
+		return nil, err
+	}
+	s.AccessToken = access
+	s.RefreshHash = hashToken(refresh)
+	s.ExpiredAt = time.Now().UTC().Add(refreshTokenTTL()).Format(time.RFC3339)
+	if err := saveSession(s); err != nil {
+		sesscache.Del(access)
+		return nil, err
+	}
+	sesscache.SetWithExpired(refreshPrefix+s.RefreshHash, s.Id, refreshTokenTTL())
+	return &Tokens{
+		SessionId:    s.Id,
+		AccessToken:  access,
+		RefreshToken: refresh,
+		ExpiresIn:    int64(accessTokenTTL() / time.Second),
+	}, nil
+}
+
+// NewSession starts a session of the user logged in by the request, roles
+// are the platform roles of the user and mfa tells whether the login passed
+// a second factor.
+func NewSession(userId string, roles []string, mfa bool, r *http.Request) (*Tokens, error) {
+	id, err := randomToken()
+	if err != nil {
+		return nil, err
+	}
+	s := &Session{
+		Id:        id[:32],
+		UserId:    userId,
+		Roles:     roles,
+		CreatedAt: time.Now().UTC().Format(time.RFC3339),
+		UserAgent: r.UserAgent(),
+		Ip:        clientIp(r),
+		Mfa:       mfa,
+	}
+	tokens, err := renew(s)
+	if err != nil {
+		return nil, err
+	}
+	sesscache.SAdd(userSessionsPrefix+userId, s.Id)
+	sesscache.HSet(sessionAccessPrefix+userId, s.Id, s.CreatedAt)
+	return tokens, nil
+}
+
+// RefreshSession replaces the tokens of the session of the refresh token,
+// a refresh token can be used once.
+func RefreshSession(refreshToken string) (*Tokens, error) {
+	if len(refreshToken) == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+	sessionId := sesscache.Take(refreshPrefix + hashToken(refreshToken))
+	if len(sessionId) == 0 {
+		return nil, ErrInvalidRefreshToken
+	}
+	s := loadSession(sessionId)
+	if s == nil {
+		return nil, ErrInvalidRefreshToken
+	}
+	sesscache.Del(s.AccessToken)
+	return renew(s)
+}
+
+// RevokeSession deletes the session and its tokens, it returns false if the
+// session is not a session of the user.
+func RevokeSession(userId, sessionId string) bool {
+	s := loadSession(sessionId)
+	if s == nil || s.UserId != userId {
+		return false
+	}
+	sesscache.Del(s.AccessToken)
+	sesscache.Del(refreshPrefix + s.RefreshHash)
+	sesscache.Del(sessionPrefix + s.Id)
+	sesscache.SRem(userSessionsPrefix+userId, s.Id)
+	sesscache.HDel(sessionAccessPrefix+userId, s.Id)
+	logs.Info("revoke session(%s) of user(%s)", s.Id, userId)
+	return true
+}
+
+// RevokeUserSessions revokes all sessions of the user but except.
+func RevokeUserSessions(userId, except string) {
+	for _, id := range sesscache.SMembers(userSessionsPrefix + userId) {
+		if id == except {
+			continue
+		}
+		if !RevokeSession(userId, id) {
+			// expired
+			sesscache.SRem(userSessionsPrefix+userId, id)
+			sesscache.HDel(sessionAccessPrefix+userId, id)
+		}
+	}
+}
+
+// ListSessions returns the active sessions of the user, the expired ones
+// are cleaned up.
+func ListSessions(userId string) []*Session {
+	access := sesscache.HGetAll(sessionAccessPrefix + userId)
+	list := make([]*Session, 0)
+	for _, id := range sesscache.SMembers(userSessionsPrefix + userId) {
+		s := loadSession(id)
+		if s == nil {
+			sesscache.SRem(userSessionsPrefix+userId, id)
+			sesscache.HDel(sessionAccessPrefix+userId, id)
+			continue
+		}
+		s.LastAccess = access[id]
+		list = append(list, s)
+	}
+	return list
+}
+
+// MarkSessionMfa marks the session as passed a second factor, after the
+// user enrolls within it.
+func MarkSessionMfa(sessionId string) {
+	s := loadSession(sessionId)
+	if s == nil || s.Mfa {
+		return
+	}
+	s.Mfa = true
+	if err := saveSession(s); err != nil {
+		logs.Error("mark session(%s) mfa err:%s", sessionId, err.Error())
+	}
+}
+
+func touchSession(us *UserSession) {
+	if len(us.SessionId) > 0 {
+		sesscache.HSet(sessionAccessPrefix+us.UserId, us.SessionId, time.Now().UTC().Format(time.RFC3339))
+	}
+}
+
+func withSession(r *http.Request, us *UserSession) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), sessionCtxKey{}, us))
+}
+
+// SessionAlive tells whether the session of a long lived request, like an
+// event stream, is still valid. It is false once the session or the api key
+// is revoked or expired.
+func SessionAlive(us *UserSession) bool {
+	if us == nil {
+		// auth is disabled
+		return true
+	}
+	if len(us.ApiKeyId) > 0 {
+		key := bluedb.QueryApiKey(us.ApiKeyId)
+		return key != nil && (key.ExpireAt == nil || time.Now().Before(*key.ExpireAt))
+	}
+	if len(us.SessionId) > 0 {
+		return loadSession(us.SessionId) != nil
+	}
+	expiredAt, err := time.Parse(time.RFC3339, us.ExpiredAt)
+	return err == nil && time.Now().Before(expiredAt)
+}
+
+// CurrentSession returns the user session of the authorized request, it is
+// nil if auth is disabled.
+func CurrentSession(r *http.Request) *UserSession {
+	us, _ := r.Context().Value(sessionCtxKey{}).(*UserSession)
+	return us
+}
@@ -0,0 +1,56 @@
+package middleware
+
+import (
+	"github.com/jack0liu/conf"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestClientIp(t *testing.T) {
+	dir, err := ioutil.TempDir("", "middleware")
+	if err != nil {
+		t.Fatalf("create temp dir fail, err:%s", err.Error())
+	}
+	defer os.RemoveAll(dir)
+	confFile := filepath.Join(dir, "blueserver.json")
+	body := `{"trusted_proxies": "10.0.0.0/8, 192.168.1.1"}`
+	if err := ioutil.WriteFile(confFile, []byte(body), 0600); err != nil {
+		t.Fatalf("write conf fail, err:%s", err.Error())
+	}
+	if err := conf.Init(confFile); err != nil {
+		t.Fatalf("init conf fail, err:%s", err.Error())
+	}
+
+	for _, c := range []struct {
+		remote string
+		fwd    []string
+		want   string
+	}{
+		// a client not behind a proxy can not choose its ip
+		{"203.0.113.7:4000", nil, "203.0.113.7"},
+		{"203.0.113.7:4000", []string{"198.51.100.1"}, "203.0.113.7"},
+		// the entry added by the trusted proxy is the client
+		{"10.1.2.3:4000", []string{"198.51.100.1"}, "198.51.100.1"},
+		{"10.1.2.3:4000", []string{"1.1.1.1, 198.51.100.1"}, "198.51.100.1"},
+		{"10.1.2.3:4000", []string{"1.1.1.1", "198.51.100.1"}, "198.51.100.1"},
+		// the hops of the trusted proxies are skipped
+		{"10.1.2.3:4000", []string{"1.1.1.1, 198.51.100.1, 192.168.1.1, 10.9.9.9"}, "198.51.100.1"},
+		{"192.168.1.1:4000", []string{"10.9.9.9"}, "10.9.9.9"},
+		// a proxy forwarding nothing or garbage is the client
+		{"10.1.2.3:4000", nil, "10.1.2.3"},
+		{"10.1.2.3:4000", []string{"not-an-ip"}, "10.1.2.3"},
+		{"[2001:db8::1]:4000", []string{"198.51.100.1"}, "2001:db8::1"},
+	} {
+		r := httptest.NewRequest("GET", "/v1/users", nil)
+		r.RemoteAddr = c.remote
+		for _, fwd := range c.fwd {
+			r.Header.Add("X-Forwarded-For", fwd)
+		}
+		if got := clientIp(r); got != c.want {
+			t.Errorf("clientIp(%s, %v) = %s, want %s", c.remote, c.fwd, got, c.want)
+		}
+	}
+}
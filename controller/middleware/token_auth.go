@@ -7,16 +7,40 @@ import (
 	"github.com/jack0liu/conf"
 	"github.com/jack0liu/logs"
 	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/apierr"
 	"github.com/ssrs100/blueserver/sesscache"
 	"net/http"
+	"strings"
 	"time"
 )
 
 type UserSession struct {
 	UserId    string   `json:"user_id"`
+	SessionId string   `json:"session_id,omitempty"`
 	Roles     []string `json:"roles"`
 	ExpiredAt string   `json:"expired_at"`
 	CreatedAt string   `json:"created_at"`
+	// set for the requests authorized by an api key
+	ApiKeyId  string   `json:"api_key_id,omitempty"`
+	ProjectId string   `json:"project_id,omitempty"`
+	Scopes    []string `json:"scopes,omitempty"`
+}
+
+// authFailLimit blocks the client ip failing too many token and api key
+// checks. It is checked before the credentials, the limits of RateLimit only
+// count the requests which pass the auth.
+var authFailLimit = Limit{Name: "auth_fail", Rate: 30, Window: 15 * time.Minute, By: ByIp}
+
+func countAuthFailure(failKey string) {
+	if rate := authFailLimit.rate(); rate >= 0 {
+		Hit(failKey, rate, authFailLimit.Window)
+	}
+}
+
+// authFailed counts the failure against authFailLimit and rejects the request.
+func authFailed(w http.ResponseWriter, failKey string) {
+	countAuthFailure(failKey)
+	apierr.WriteMessage(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
 }
 
 func Auth(fn httptreemux.HandlerFunc) httptreemux.HandlerFunc {
@@ -30,41 +54,76 @@ func Auth(fn httptreemux.HandlerFunc) httptreemux.HandlerFunc {
 			fn(w, r, ps)
 			return
 		}
+		failKey := authFailLimit.Name + "_" + authFailLimit.key(r, ps)
+		if rate := authFailLimit.rate(); rate >= 0 {
+			if blocked, wait := Blocked(failKey, rate); blocked {
+				logs.Warn("too many auth failures of %s, reject %s %s", failKey, r.Method, r.URL.Path)
+				RetryAfter(w, wait)
+				return
+			}
+		}
+		if key := requestApiKey(r); len(key) > 0 {
+			us := apiKeySession(key)
+			if us == nil {
+				authFailed(w, failKey)
+				return
+			}
+			fn(w, withSession(r, us), ps)
+			return
+		}
 		token := r.Header.Get(common.XAuthB)
-		logs.Info("session:%s", token)
+		if len(token) == 0 && queryTokenAllowed(r) {
+			// EventSource and WebSocket of browsers can not set headers
+			token = r.URL.Query().Get("token")
+		}
+		if len(token) == 0 {
+			apierr.WriteMessage(w, http.StatusUnauthorized, http.StatusText(http.StatusUnauthorized))
+			return
+		}
 		k := sesscache.Get(token)
 		if len(k) == 0 {
+			countAuthFailure(failKey)
 			redirectAddr := conf.GetString("redirect_addr")
 			http.Redirect(w, r, redirectAddr, http.StatusFound)
 			return
 		}
-		logs.Info("key:%s", k)
 		keys := fernet.MustDecodeKeys(k)
 		tokenStr := fernet.VerifyAndDecrypt([]byte(token), 0, keys)
 		if len(tokenStr) == 0 {
 			sesscache.Del(token)
-			http.Error(w, http.StatusText(401), http.StatusUnauthorized)
+			authFailed(w, failKey)
 			return
 		}
-		projectId := ps["projectId"]
-		if len(projectId) > 0 {
-			var us UserSession
-			if err := json.Unmarshal(tokenStr, &us); err != nil {
-				logs.Error("invalid user session, str:%s", tokenStr)
-				http.Error(w, http.StatusText(401), http.StatusUnauthorized)
-				return
-			}
-			if projectId != us.UserId {
-				logs.Error("invalid user session, project id(%s) not equal %s, str:%s", projectId, us.UserId, tokenStr)
-				http.Error(w, http.StatusText(401), http.StatusUnauthorized)
-				return
-			}
-
-			sesscache.SetWithNoExpired("lastAccess_"+us.UserId, time.Now().Format(time.RFC3339))
+		var us UserSession
+		if err := json.Unmarshal(tokenStr, &us); err != nil {
+			logs.Error("invalid user session")
+			authFailed(w, failKey)
+			return
+		}
+		if expiredAt, err := time.Parse(time.RFC3339, us.ExpiredAt); err != nil || time.Now().After(expiredAt) {
+			sesscache.Del(token)
+			authFailed(w, failKey)
+			return
 		}
+		// the access to the project in the path is checked by Authorize
+		sesscache.SetWithNoExpired("lastAccess_"+us.UserId, time.Now().Format(time.RFC3339))
+		touchSession(&us)
+		r = withSession(r, &us)
 
-		sesscache.TouchWithExpired(token, time.Hour * 24 * 7)
-		logs.Info("tokenStr:%s", tokenStr)
+		logs.Debug("session of user(%s)", us.UserId)
 		fn(w, r, ps)
 	}
 }
+
+// queryTokenAllowed tells whether the access token may be passed by the token
+// query, only the event streams take it so that the token is not left in the
+// logs of the other requests.
+func queryTokenAllowed(r *http.Request) bool {
+	if r.Method != http.MethodGet {
+		return false
+	}
+	// /aws/v1/:projectId/events/stream and /aws/v1/:projectId/events/ws
+	segs := strings.Split(strings.Trim(r.URL.Path, "/"), "/")
+	return len(segs) == 5 && segs[0] == "aws" && segs[1] == "v1" && segs[3] == "events" &&
+		(segs[4] == "stream" || segs[4] == "ws")
+}
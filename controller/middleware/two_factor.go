@@ -0,0 +1,51 @@
+package middleware
+
+import (
+	"github.com/patrickmn/go-cache"
+	"github.com/ssrs100/blueserver/bluedb"
+	"time"
+)
+
+// twoFactorCache caches whether the projects require a second factor.
+var twoFactorCache = cache.New(30*time.Second, time.Minute)
+
+func projectRequiresTwoFactor(projectId string) bool {
+	if v, ok := twoFactorCache.Get(projectId); ok {
+		return v.(bool)
+	}
+	required := false
+	if p, err := bluedb.QueryProject(projectId); err == nil {
+		required = p.RequireTwoFactor
+	}
+	twoFactorCache.Set(projectId, required, cache.DefaultExpiration)
+	return required
+}
+
+// ForgetTwoFactor drops the cached setting after the project is updated, on
+// all servers.
+func ForgetTwoFactor(projectId string) {
+	twoFactorCache.Delete(projectId)
+	publishForget(forgetTwoFactor, projectId)
+}
+
+// passedTwoFactor tells whether the session may access the project in the
+// path. Api keys are not interactive and are not asked for a second factor.
+func passedTwoFactor(us *UserSession, projectId string, perm Permission) bool {
+	if us == nil || len(us.ApiKeyId) > 0 || perm < PermView || perm > PermManage {
+		return true
+	}
+	if !projectRequiresTwoFactor(projectId) {
+		return true
+	}
+	return SessionPassedMfa(us)
+}
+
+// SessionPassedMfa tells whether the login of the session passed a second
+// factor.
+func SessionPassedMfa(us *UserSession) bool {
+	if us == nil {
+		return false
+	}
+	s := loadSession(us.SessionId)
+	return s != nil && s.Mfa
+}
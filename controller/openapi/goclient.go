@@ -0,0 +1,262 @@
+package openapi
+
+import (
+	"bytes"
+	"fmt"
+	"go/format"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"unicode"
+)
+
+var httpMethods = map[string]string{
+	"get":    "http.MethodGet",
+	"post":   "http.MethodPost",
+	"put":    "http.MethodPut",
+	"delete": "http.MethodDelete",
+	"patch":  "http.MethodPatch",
+}
+
+// GoClient is the source of the types and the operations of the document
+// for the client package pkg. The package gives the Client with the do and
+// send methods the operations call.
+func GoClient(doc *Document, pkg, source string) ([]byte, error) {
+	var b bytes.Buffer
+	fmt.Fprintf(&b, "// Code generated by cmd/openapi.go from %s. DO NOT EDIT.\n\n", source)
+	fmt.Fprintf(&b, "package %s\n\n", pkg)
+
+	var body bytes.Buffer
+	names := make([]string, 0, len(doc.Components.Schemas))
+	for name := range doc.Components.Schemas {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		fmt.Fprintf(&body, "type %s %s\n\n", name, goStruct(doc.Components.Schemas[name]))
+	}
+
+	paths := make([]string, 0, len(doc.Paths))
+	for path := range doc.Paths {
+		paths = append(paths, path)
+	}
+	sort.Strings(paths)
+	for _, path := range paths {
+		item := *doc.Paths[path]
+		methods := make([]string, 0, len(item))
+		for m := range item {
+			methods = append(methods, m)
+		}
+		sort.Strings(methods)
+		for _, m := range methods {
+			if err := goOperation(&body, path, m, item[m]); err != nil {
+				return nil, err
+			}
+		}
+	}
+
+	var imports []string
+	for _, imp := range []struct{ pkg, use string }{
+		{"context", "context."},
+		{"encoding/json", "json."},
+		{"net/http", "http."},
+		{"net/url", "url."},
+		{"time", "time."},
+	} {
+		if bytes.Contains(body.Bytes(), []byte(imp.use)) {
+			imports = append(imports, strconv.Quote(imp.pkg))
+		}
+	}
+	if len(imports) > 0 {
+		fmt.Fprintf(&b, "import (\n%s\n)\n\n", strings.Join(imports, "\n"))
+	}
+	b.Write(body.Bytes())
+	return format.Source(b.Bytes())
+}
+
+// goName is the exported go name of a json name, project_id is ProjectId.
+func goName(name string) string {
+	var b strings.Builder
+	upper := true
+	for _, r := range name {
+		if !unicode.IsLetter(r) && !unicode.IsDigit(r) {
+			upper = true
+			continue
+		}
+		if upper {
+			r = unicode.ToUpper(r)
+			upper = false
+		}
+		b.WriteRune(r)
+	}
+	s := b.String()
+	if len(s) == 0 || !unicode.IsLetter(rune(s[0])) {
+		s = "X" + s
+	}
+	return s
+}
+
+// goParam is the name of a parameter as a go variable.
+func goParam(name string) string {
+	n := goName(name)
+	return strings.ToLower(n[:1]) + n[1:]
+}
+
+func goStruct(s *Schema) string {
+	if s.Type != "object" || len(s.Properties) == 0 {
+		return goType(s)
+	}
+	required := make(map[string]bool)
+	for _, r := range s.Required {
+		required[r] = true
+	}
+	props := make([]string, 0, len(s.Properties))
+	for p := range s.Properties {
+		props = append(props, p)
+	}
+	sort.Strings(props)
+	var b strings.Builder
+	b.WriteString("struct {\n")
+	for _, p := range props {
+		tag := p
+		if !required[p] {
+			tag += ",omitempty"
+		}
+		fmt.Fprintf(&b, "%s %s `json:%q`\n", goName(p), goType(s.Properties[p]), tag)
+	}
+	b.WriteString("}")
+	return b.String()
+}
+
+// goType is the go type of the json of the schema, the structs are taken as
+// pointers.
+func goType(s *Schema) string {
+	if len(s.Ref) > 0 {
+		return "*" + s.Ref[strings.LastIndex(s.Ref, "/")+1:]
+	}
+	var t string
+	switch s.Type {
+	case "string":
+		t = "string"
+		if s.Format == "date-time" {
+			t = "time.Time"
+		}
+	case "integer":
+		t = "int64"
+		if s.Format == "int32" {
+			t = "int32"
+		}
+	case "number":
+		t = "float64"
+		if s.Format == "float" {
+			t = "float32"
+		}
+	case "boolean":
+		t = "bool"
+	case "array":
+		t = "[]" + strings.TrimPrefix(goType(s.Items), "*")
+	case "object":
+		switch {
+		case s.AdditionalProperties != nil:
+			t = "map[string]" + goType(s.AdditionalProperties)
+		case len(s.Properties) > 0:
+			t = goStruct(s)
+		default:
+			t = "map[string]json.RawMessage"
+		}
+	default:
+		// any json value
+		return "json.RawMessage"
+	}
+	if s.Nullable {
+		t = "*" + t
+	}
+	return t
+}
+
+func goOperation(b *bytes.Buffer, path, method string, op *Operation) error {
+	httpMethod, ok := httpMethods[method]
+	if !ok {
+		return fmt.Errorf("method %s of %s is not supported", method, path)
+	}
+	args := []string{"ctx context.Context"}
+	query := "nil"
+	for _, p := range op.Parameters {
+		switch p.In {
+		case "path":
+			args = append(args, goParam(p.Name)+" string")
+		case "query":
+			query = "query"
+		}
+	}
+	if query == "query" {
+		args = append(args, "query url.Values")
+	}
+	body := "nil"
+	if op.RequestBody != nil {
+		body = "body"
+		args = append(args, "body "+goType(op.RequestBody.Content[JsonType].Schema))
+	}
+
+	// the path with the parameters escaped
+	var expr []string
+	rest := path
+	for len(rest) > 0 {
+		i := strings.Index(rest, "{")
+		if i < 0 {
+			expr = append(expr, strconv.Quote(rest))
+			break
+		}
+		j := strings.Index(rest[i:], "}") + i
+		if i > 0 {
+			expr = append(expr, strconv.Quote(rest[:i]))
+		}
+		expr = append(expr, "url.PathEscape("+goParam(rest[i+1:j])+")")
+		rest = rest[j+1:]
+	}
+	pathExpr := strings.Join(expr, " + ")
+
+	name := goName(op.OperationId)
+	fmt.Fprintf(b, "// %s sends %s %s.\n", name, strings.ToUpper(method), path)
+	if len(op.Summary) > 0 {
+		fmt.Fprintf(b, "// %s.\n", op.Summary)
+	}
+	status, resp := successResponse(op)
+	var schema *Schema
+	raw := status < 200 || status >= 300
+	if resp != nil {
+		for ct, mt := range resp.Content {
+			if ct == JsonType {
+				schema = mt.Schema
+			} else {
+				raw = true
+			}
+		}
+	}
+	call := fmt.Sprintf("%s, %s, %s, %s", httpMethod, pathExpr, query, body)
+	switch {
+	case raw:
+		// the body is left to the caller, who closes it
+		fmt.Fprintf(b, "func (c *Client) %s(%s) (*http.Response, error) {\n", name, strings.Join(args, ", "))
+		fmt.Fprintf(b, "return c.send(ctx, %s)\n}\n\n", call)
+	case schema != nil:
+		t := goType(schema)
+		fmt.Fprintf(b, "func (c *Client) %s(%s) (%s, error) {\n", name, strings.Join(args, ", "), t)
+		fmt.Fprintf(b, "var out %s\nerr := c.do(ctx, %s, &out)\nreturn out, err\n}\n\n", t, call)
+	default:
+		fmt.Fprintf(b, "func (c *Client) %s(%s) error {\n", name, strings.Join(args, ", "))
+		fmt.Fprintf(b, "return c.do(ctx, %s, nil)\n}\n\n", call)
+	}
+	return nil
+}
+
+// successResponse is the response of the operation other than the errors.
+func successResponse(op *Operation) (int, *Response) {
+	for code, resp := range op.Responses {
+		if status, err := strconv.Atoi(code); err == nil {
+			return status, resp
+		}
+	}
+	return http.StatusOK, nil
+}
@@ -0,0 +1,482 @@
+package openapi
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"net/http"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// The document is kept to the parts of OpenAPI 3.0 the api uses, the maps
+// are marshaled with sorted keys so that the same routes give the same bytes.
+type Document struct {
+	Openapi    string               `json:"openapi"`
+	Info       Info                 `json:"info"`
+	Paths      map[string]*PathItem `json:"paths"`
+	Components Components           `json:"components"`
+}
+
+type Info struct {
+	Title   string `json:"title"`
+	Version string `json:"version"`
+}
+
+// PathItem is the operations of a path keyed by the lower case method.
+type PathItem map[string]*Operation
+
+type Operation struct {
+	OperationId string                `json:"operationId"`
+	Summary     string                `json:"summary,omitempty"`
+	Tags        []string              `json:"tags,omitempty"`
+	Parameters  []*Parameter          `json:"parameters,omitempty"`
+	RequestBody *RequestBody          `json:"requestBody,omitempty"`
+	Responses   map[string]*Response  `json:"responses"`
+	Security    []map[string][]string `json:"security,omitempty"`
+	Permission  string                `json:"x-permission"`
+	Scope       string                `json:"x-api-key-scope,omitempty"`
+}
+
+type Parameter struct {
+	Name     string  `json:"name"`
+	In       string  `json:"in"`
+	Required bool    `json:"required,omitempty"`
+	Schema   *Schema `json:"schema"`
+}
+
+type RequestBody struct {
+	Required bool                  `json:"required"`
+	Content  map[string]*MediaType `json:"content"`
+}
+
+type Response struct {
+	Description string                `json:"description"`
+	Content     map[string]*MediaType `json:"content,omitempty"`
+}
+
+type MediaType struct {
+	Schema *Schema `json:"schema,omitempty"`
+}
+
+type Components struct {
+	Schemas         map[string]*Schema         `json:"schemas"`
+	SecuritySchemes map[string]*SecurityScheme `json:"securitySchemes"`
+}
+
+type SecurityScheme struct {
+	Type string `json:"type"`
+	Name string `json:"name"`
+	In   string `json:"in"`
+}
+
+type Schema struct {
+	Ref                  string             `json:"$ref,omitempty"`
+	Type                 string             `json:"type,omitempty"`
+	Format               string             `json:"format,omitempty"`
+	Nullable             bool               `json:"nullable,omitempty"`
+	Items                *Schema            `json:"items,omitempty"`
+	Properties           map[string]*Schema `json:"properties,omitempty"`
+	Required             []string           `json:"required,omitempty"`
+	AdditionalProperties *Schema            `json:"additionalProperties,omitempty"`
+	MinLength            *int               `json:"minLength,omitempty"`
+	MaxLength            *int               `json:"maxLength,omitempty"`
+	MaxItems             *int               `json:"maxItems,omitempty"`
+	Minimum              *float64           `json:"minimum,omitempty"`
+	Maximum              *float64           `json:"maximum,omitempty"`
+	Pattern              string             `json:"pattern,omitempty"`
+}
+
+const (
+	JsonType = "application/json"
+
+	// the security schemes, a session token or an api key
+	SchemeSession = "session"
+	SchemeApiKey  = "apiKey"
+
+	schemaPrefix = "#/components/schemas/"
+)
+
+// Route is a registered route with its doc. Request and Response are values
+// of the types of the bodies, nil if there is none.
+type Route struct {
+	Method      string
+	Path        string
+	Id          string
+	Summary     string
+	Request     interface{}
+	Response    interface{}
+	Query       []string
+	Status      int
+	ContentType string
+	Permission  string
+	Public      bool
+	Scope       string
+}
+
+// builder turns the go types into schemas, the named structs go to the
+// components once and are referenced from then on.
+type builder struct {
+	schemas map[string]*Schema
+	names   map[reflect.Type]string
+}
+
+// Build is the document of the routes.
+func Build(title, version string, routes []Route) (*Document, error) {
+	b := &builder{
+		schemas: make(map[string]*Schema),
+		names:   make(map[reflect.Type]string),
+	}
+	doc := &Document{
+		Openapi: "3.0.3",
+		Info:    Info{Title: title, Version: version},
+		Paths:   make(map[string]*PathItem),
+		Components: Components{
+			Schemas: b.schemas,
+			SecuritySchemes: map[string]*SecurityScheme{
+				SchemeSession: {Type: "apiKey", Name: "X-Auth-B", In: "header"},
+				SchemeApiKey:  {Type: "apiKey", Name: "X-Api-Key", In: "header"},
+			},
+		},
+	}
+	// the components are named in the order of the routes
+	sort.Slice(routes, func(i, j int) bool {
+		if routes[i].Path != routes[j].Path {
+			return routes[i].Path < routes[j].Path
+		}
+		return routes[i].Method < routes[j].Method
+	})
+	ids := make(map[string]string)
+	for _, r := range routes {
+		key := r.Method + " " + r.Path
+		if len(r.Id) == 0 {
+			return nil, fmt.Errorf("route(%s) has no operation id", key)
+		}
+		if other, ok := ids[r.Id]; ok {
+			return nil, fmt.Errorf("operation id %s of route(%s) is used by route(%s)", r.Id, key, other)
+		}
+		ids[r.Id] = key
+		op, err := b.operation(r)
+		if err != nil {
+			return nil, fmt.Errorf("route(%s): %s", key, err.Error())
+		}
+		path, _ := pathParams(r.Path)
+		item := doc.Paths[path]
+		if item == nil {
+			item = &PathItem{}
+			doc.Paths[path] = item
+		}
+		(*item)[strings.ToLower(r.Method)] = op
+	}
+	return doc, nil
+}
+
+// pathParams turns the :name segments of the router into {name}.
+func pathParams(path string) (string, []string) {
+	segs := strings.Split(path, "/")
+	var params []string
+	for i, s := range segs {
+		if strings.HasPrefix(s, ":") {
+			params = append(params, s[1:])
+			segs[i] = "{" + s[1:] + "}"
+		}
+	}
+	return strings.Join(segs, "/"), params
+}
+
+// tagOf groups the routes by the first segment of the path which is not a
+// service, a version or a parameter.
+func tagOf(path string) string {
+	for _, s := range strings.Split(path, "/") {
+		switch {
+		case len(s) == 0, strings.HasPrefix(s, ":"):
+		case s == "proximity", s == "equipment", s == "aws", s == "app":
+		case len(s) > 1 && s[0] == 'v' && s[1] >= '0' && s[1] <= '9':
+		default:
+			return s
+		}
+	}
+	return "default"
+}
+
+func (b *builder) operation(r Route) (*Operation, error) {
+	op := &Operation{
+		OperationId: r.Id,
+		Summary:     r.Summary,
+		Tags:        []string{tagOf(r.Path)},
+		Responses:   make(map[string]*Response),
+		Permission:  r.Permission,
+		Scope:       r.Scope,
+	}
+	if !r.Public {
+		op.Security = []map[string][]string{{SchemeSession: {}}}
+		if len(r.Scope) > 0 {
+			op.Security = append(op.Security, map[string][]string{SchemeApiKey: {}})
+		}
+	}
+	_, params := pathParams(r.Path)
+	for _, p := range params {
+		op.Parameters = append(op.Parameters, &Parameter{Name: p, In: "path", Required: true, Schema: &Schema{Type: "string"}})
+	}
+	for _, q := range r.Query {
+		op.Parameters = append(op.Parameters, &Parameter{Name: q, In: "query", Schema: &Schema{Type: "string"}})
+	}
+	if r.Request != nil {
+		s, err := b.schema(reflect.TypeOf(r.Request))
+		if err != nil {
+			return nil, err
+		}
+		op.RequestBody = &RequestBody{
+			Required: true,
+			Content:  map[string]*MediaType{JsonType: {Schema: s}},
+		}
+	}
+	status := r.Status
+	if status == 0 {
+		status = http.StatusOK
+	}
+	resp := &Response{Description: http.StatusText(status)}
+	switch {
+	case r.Response != nil:
+		s, err := b.schema(reflect.TypeOf(r.Response))
+		if err != nil {
+			return nil, err
+		}
+		resp.Content = map[string]*MediaType{JsonType: {Schema: s}}
+	case len(r.ContentType) > 0:
+		resp.Content = map[string]*MediaType{r.ContentType: {Schema: &Schema{Type: "string"}}}
+	}
+	op.Responses[strconv.Itoa(status)] = resp
+	// every error is an apierr.Error
+	errSchema, err := b.schema(reflect.TypeOf(apierr.Error{}))
+	if err != nil {
+		return nil, err
+	}
+	op.Responses["default"] = &Response{
+		Description: "Error",
+		Content:     map[string]*MediaType{JsonType: {Schema: errSchema}},
+	}
+	return op, nil
+}
+
+var (
+	timeType      = reflect.TypeOf(time.Time{})
+	numberType    = reflect.TypeOf(json.Number(""))
+	rawType       = reflect.TypeOf(json.RawMessage{})
+	interfaceType = reflect.TypeOf((*interface{})(nil)).Elem()
+)
+
+// schema is the schema of the json of t, named structs are referenced.
+func (b *builder) schema(t reflect.Type) (*Schema, error) {
+	nullable := false
+	for t.Kind() == reflect.Ptr {
+		nullable = true
+		t = t.Elem()
+	}
+	var s *Schema
+	switch {
+	case t == timeType:
+		s = &Schema{Type: "string", Format: "date-time"}
+	case t == numberType:
+		s = &Schema{Type: "number"}
+	case t == rawType, t == interfaceType:
+		// any json value
+		return &Schema{}, nil
+	default:
+		switch t.Kind() {
+		case reflect.String:
+			s = &Schema{Type: "string"}
+		case reflect.Bool:
+			s = &Schema{Type: "boolean"}
+		case reflect.Int8, reflect.Int16, reflect.Int32, reflect.Uint8, reflect.Uint16:
+			s = &Schema{Type: "integer", Format: "int32"}
+		case reflect.Int, reflect.Int64, reflect.Uint, reflect.Uint32, reflect.Uint64:
+			s = &Schema{Type: "integer", Format: "int64"}
+		case reflect.Float32:
+			s = &Schema{Type: "number", Format: "float"}
+		case reflect.Float64:
+			s = &Schema{Type: "number", Format: "double"}
+		case reflect.Slice, reflect.Array:
+			items, err := b.schema(t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			s = &Schema{Type: "array", Items: items}
+		case reflect.Map:
+			if t.Key().Kind() != reflect.String {
+				return nil, fmt.Errorf("map key of %s is not a string", t)
+			}
+			values, err := b.schema(t.Elem())
+			if err != nil {
+				return nil, err
+			}
+			s = &Schema{Type: "object", AdditionalProperties: values}
+		case reflect.Struct:
+			if len(t.Name()) == 0 {
+				return b.object(t)
+			}
+			// a reference can not be nullable in 3.0, the clients take
+			// the structs as pointers anyway
+			return b.ref(t)
+		default:
+			return nil, fmt.Errorf("type %s has no json schema", t)
+		}
+	}
+	s.Nullable = nullable
+	return s, nil
+}
+
+// ref adds the struct to the components, the name is prefixed by the
+// package if another struct has taken it.
+func (b *builder) ref(t reflect.Type) (*Schema, error) {
+	if name, ok := b.names[t]; ok {
+		return &Schema{Ref: schemaPrefix + name}, nil
+	}
+	name := t.Name()
+	if _, ok := b.schemas[name]; ok {
+		pkg := t.PkgPath()
+		pkg = pkg[strings.LastIndex(pkg, "/")+1:]
+		name = strings.ToUpper(pkg[:1]) + pkg[1:] + name
+	}
+	b.names[t] = name
+	// set before the fields, so that recursive types end
+	b.schemas[name] = &Schema{}
+	s, err := b.object(t)
+	if err != nil {
+		return nil, err
+	}
+	b.schemas[name] = s
+	return &Schema{Ref: schemaPrefix + name}, nil
+}
+
+// object is the schema of the json fields of the struct, with the limits of
+// their valid tags.
+func (b *builder) object(t reflect.Type) (*Schema, error) {
+	s := &Schema{Type: "object", Properties: make(map[string]*Schema)}
+	if err := b.fields(s, t); err != nil {
+		return nil, err
+	}
+	sort.Strings(s.Required)
+	return s, nil
+}
+
+func (b *builder) fields(s *Schema, t reflect.Type) error {
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		tag := f.Tag.Get("json")
+		if tag == "-" {
+			continue
+		}
+		name := strings.Split(tag, ",")[0]
+		if f.Anonymous && len(name) == 0 {
+			ft := f.Type
+			if ft.Kind() == reflect.Ptr {
+				ft = ft.Elem()
+			}
+			if ft.Kind() == reflect.Struct {
+				if err := b.fields(s, ft); err != nil {
+					return err
+				}
+				continue
+			}
+		}
+		if len(f.PkgPath) > 0 {
+			continue
+		}
+		if len(name) == 0 {
+			name = f.Name
+		}
+		fs, err := b.schema(f.Type)
+		if err != nil {
+			return fmt.Errorf("field %s of %s: %s", f.Name, t, err.Error())
+		}
+		// the limits do not go beside a reference
+		limited := fs
+		if len(fs.Ref) > 0 {
+			limited = &Schema{}
+		}
+		if validTags(limited, f.Tag.Get("valid")) {
+			s.Required = append(s.Required, name)
+		}
+		s.Properties[name] = fs
+	}
+	return nil
+}
+
+// validTags sets the limits of the valid tag of the field which a schema
+// can tell, it returns if the field is required.
+func validTags(s *Schema, tag string) bool {
+	required := false
+	for _, check := range strings.Split(tag, ";") {
+		check = strings.TrimSpace(check)
+		name, args := check, ""
+		if i := strings.Index(check, "("); i > 0 && strings.HasSuffix(check, ")") {
+			name, args = check[:i], check[i+1:len(check)-1]
+		}
+		nums := numbers(args)
+		switch name {
+		case "Required":
+			required = true
+		case "MaxSize":
+			if len(nums) == 1 {
+				n := int(nums[0])
+				if s.Type == "array" {
+					s.MaxItems = &n
+				} else {
+					s.MaxLength = &n
+				}
+			}
+		case "MinSize":
+			if len(nums) == 1 && s.Type == "string" {
+				n := int(nums[0])
+				s.MinLength = &n
+			}
+		case "Min":
+			if len(nums) == 1 {
+				s.Minimum = &nums[0]
+			}
+		case "Max":
+			if len(nums) == 1 {
+				s.Maximum = &nums[0]
+			}
+		case "Range":
+			if len(nums) == 2 {
+				s.Minimum, s.Maximum = &nums[0], &nums[1]
+			}
+		case "Match":
+			if strings.HasPrefix(args, "/") && strings.HasSuffix(args, "/") && len(args) > 1 {
+				s.Pattern = args[1 : len(args)-1]
+			}
+		case "Email":
+			s.Format = "email"
+		}
+	}
+	return required
+}
+
+func numbers(args string) []float64 {
+	var nums []float64
+	if len(args) == 0 {
+		return nums
+	}
+	for _, a := range strings.Split(args, ",") {
+		n, err := strconv.ParseFloat(strings.TrimSpace(a), 64)
+		if err != nil {
+			return nil
+		}
+		nums = append(nums, n)
+	}
+	return nums
+}
+
+// Marshal is the json of the document as it is served and committed.
+func (d *Document) Marshal() ([]byte, error) {
+	body, err := json.MarshalIndent(d, "", "  ")
+	if err != nil {
+		return nil, err
+	}
+	return append(body, '\n'), nil
+}
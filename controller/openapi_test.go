@@ -0,0 +1,41 @@
+package controller
+
+import (
+	"bytes"
+	"encoding/json"
+	"github.com/ssrs100/blueserver/controller/openapi"
+	"io/ioutil"
+	"testing"
+)
+
+// TestApiDocUpToDate fails when a route or a request or response type is
+// changed without running go run cmd/openapi.go.
+func TestApiDocUpToDate(t *testing.T) {
+	spec, err := ApiDoc()
+	if err != nil {
+		t.Fatalf("build openapi document fail, err:%s", err.Error())
+	}
+	var doc openapi.Document
+	if err := json.Unmarshal(spec, &doc); err != nil {
+		t.Fatalf("read openapi document fail, err:%s", err.Error())
+	}
+	client, err := openapi.GoClient(&doc, "client", "api/openapi.json")
+	if err != nil {
+		t.Fatalf("generate client fail, err:%s", err.Error())
+	}
+	for _, f := range []struct {
+		name string
+		body []byte
+	}{
+		{"../api/openapi.json", spec},
+		{"../client/api.gen.go", client},
+	} {
+		old, err := ioutil.ReadFile(f.name)
+		if err != nil {
+			t.Fatalf("read %s fail, err:%s", f.name, err.Error())
+		}
+		if !bytes.Equal(old, f.body) {
+			t.Errorf("%s is not up to date, run go run cmd/openapi.go", f.name)
+		}
+	}
+}
@@ -0,0 +1,376 @@
+package controller
+
+import (
+	"fmt"
+	"github.com/dimfeld/httptreemux"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"github.com/ssrs100/blueserver/controller/openapi"
+	"github.com/ssrs100/blueserver/metrics"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// routePermissions is the permission of every route, a route without an
+// entry can not be registered.
+var routePermissions = map[string]middleware.Permission{
+	"GET /v1/heart":                                   middleware.PermPublic,
+	"GET /v1/openapi.json":                            middleware.PermPublic,
+	"GET /active":                                     middleware.PermPublic,
+	"POST /v1/users/verify":                           middleware.PermPublic,
+	"POST /v1/users/password/forgot":                  middleware.PermPublic,
+	"POST /v1/users/password/reset":                   middleware.PermPublic,
+	"PUT /v1/users/:projectId/password":               middleware.PermSelf,
+	"GET /v1/users":                                   middleware.PermPlatform,
+	"GET /v1/users/:projectId":                        middleware.PermSelf,
+	"POST /v1/users":                                  middleware.PermPublic,
+	"POST /v1/users/login":                            middleware.PermPublic,
+	"POST /v1/users/token/refresh":                    middleware.PermPublic,
+	"POST /v1/users/logout":                           middleware.PermUser,
+	"GET /v1/users/:projectId/sessions":               middleware.PermSelf,
+	"DELETE /v1/users/:projectId/sessions":            middleware.PermSelf,
+	"DELETE /v1/users/:projectId/sessions/:sessionId": middleware.PermSelf,
+	"DELETE /v1/users/:projectId":                     middleware.PermSelf,
+	"POST /v1/users/:projectId":                       middleware.PermManage,
+	"POST /v1/users/login/2fa":                        middleware.PermPublic,
+	"GET /v1/users/:projectId/2fa":                    middleware.PermSelf,
+	"POST /v1/users/:projectId/2fa":                   middleware.PermSelf,
+	"POST /v1/users/:projectId/2fa/activate":          middleware.PermSelf,
+	"DELETE /v1/users/:projectId/2fa":                 middleware.PermSelf,
+	"POST /v1/users/:projectId/2fa/recovery-codes":    middleware.PermSelf,
+
+	// projects
+	"GET /v1/projects":                                                             middleware.PermUser,
+	"POST /v1/projects":                                                            middleware.PermUser,
+	"GET /v1/projects/:projectId":                                                  middleware.PermView,
+	"PUT /v1/projects/:projectId":                                                  middleware.PermManage,
+	"GET /v1/projects/:projectId/members":                                          middleware.PermView,
+	"PUT /v1/projects/:projectId/members/:userId":                                  middleware.PermManage,
+	"DELETE /v1/projects/:projectId/members/:userId":                               middleware.PermManage,
+	"GET /v1/projects/:projectId/invitations":                                      middleware.PermManage,
+	"POST /v1/projects/:projectId/invitations":                                     middleware.PermManage,
+	"DELETE /v1/projects/:projectId/invitations/:invitationId":                     middleware.PermManage,
+	"POST /v1/invitations/accept":                                                  middleware.PermUser,
+	"GET /v1/projects/:projectId/api-keys":                                         middleware.PermManage,
+	"POST /v1/projects/:projectId/api-keys":                                        middleware.PermManage,
+	"DELETE /v1/projects/:projectId/api-keys/:keyId":                               middleware.PermManage,
+	"GET /v1/projects/:projectId/sso":                                              middleware.PermManage,
+	"PUT /v1/projects/:projectId/sso":                                              middleware.PermManage,
+	"DELETE /v1/projects/:projectId/sso":                                           middleware.PermManage,
+	"GET /v1/projects/:projectId/audit-logs":                                       middleware.PermManage,
+	"GET /v1/projects/:projectId/audit-logs/export":                                middleware.PermManage,
+	"GET /v1/audit-logs":                                                           middleware.PermPlatform,
+	"GET /v1/audit-logs/export":                                                    middleware.PermPlatform,
+	"GET /v1/sso/discover":                                                         middleware.PermPublic,
+	"GET /v1/sso/:projectId/login":                                                 middleware.PermPublic,
+	"GET /v1/sso/callback":                                                         middleware.PermPublic,
+	"POST /proximity/v1/:projectId/beacons":                                        middleware.PermOperate,
+	"GET /proximity/v1/:projectId/beacons":                                         middleware.PermView,
+	"DELETE /proximity/v1/:projectId/beacons/:beaconId":                            middleware.PermOperate,
+	"PUT /proximity/v1/:projectId/beacons/:beaconId":                               middleware.PermOperate,
+	"POST /proximity/v1/:projectId/beacons/:beaconId/active":                       middleware.PermOperate,
+	"POST /proximity/v1/:projectId/beacons/:beaconId/deactive":                     middleware.PermOperate,
+	"POST /proximity/v1/:projectId/beacons/:beaconId/attachments":                  middleware.PermOperate,
+	"DELETE /proximity/v1/:projectId/beacons/:beaconId/attachments/:attachmentId":  middleware.PermOperate,
+	"DELETE /proximity/v1/:projectId/beacons/:beaconId/attachments":                middleware.PermOperate,
+	"GET /proximity/v1/:projectId/beacons/:beaconId/attachments":                   middleware.PermView,
+	"POST /proximity/v1/:projectId/getforobserved":                                 middleware.PermView,
+	"POST /equipment/v1/:projectId/components":                                     middleware.PermOperate,
+	"GET /equipment/v1/:projectId/components":                                      middleware.PermView,
+	"DELETE /equipment/v1/:projectId/components/:componentId":                      middleware.PermOperate,
+	"PUT /equipment/v1/:projectId/components/:componentId":                         middleware.PermOperate,
+	"GET /equipment/v1/:projectId/components/:componentId/collections":             middleware.PermView,
+	"GET /equipment/v1/:projectId/components/:componentId/detail":                  middleware.PermView,
+	"PUT /equipment/v1/:projectId/components/:componentId/detail":                  middleware.PermOperate,
+	"PUT /equipment/v1/:projectId/components/:componentId/detail/cancel-modifying": middleware.PermOperate,
+	"PUT /equipment/v1/:projectId/components/:componentId/detail/sync":             middleware.PermOperate,
+	"GET /aws/v1/:projectId/things":                                                middleware.PermView,
+	"POST /aws/v1/:projectId/things":                                               middleware.PermOperate,
+	"DELETE /aws/v1/:projectId/things/:thingName":                                  middleware.PermOperate,
+	"PUT /aws/v1/:projectId/things/:thingName":                                     middleware.PermOperate,
+	"GET /aws/v2/:projectId/things":                                                middleware.PermView,
+	"GET /aws/v1/:projectId/things/:thingName/latest":                              middleware.PermView,
+	"GET /aws/v1/:projectId/things/:thingName/range-data":                          middleware.PermView,
+	"GET /aws/v1/:projectId/things/:thingName/device":                              middleware.PermView,
+	"GET /aws/v1/:projectId/things/:thingName/completeness":                        middleware.PermView,
+	"GET /aws/v1/:projectId/events/stream":                                         middleware.PermView,
+	"GET /aws/v1/:projectId/events/ws":                                             middleware.PermView,
+	"GET /aws/v1/:projectId/locations":                                             middleware.PermView,
+	"POST /aws/v1/:projectId/locations":                                            middleware.PermOperate,
+	"GET /aws/v1/:projectId/locations/:locationId":                                 middleware.PermView,
+	"PUT /aws/v1/:projectId/locations/:locationId":                                 middleware.PermOperate,
+	"DELETE /aws/v1/:projectId/locations/:locationId":                              middleware.PermOperate,
+	"GET /aws/v1/:projectId/devices":                                               middleware.PermView,
+	"POST /aws/v1/:projectId/devices":                                              middleware.PermOperate,
+	"GET /aws/v1/:projectId/devices/:device":                                       middleware.PermView,
+	"PUT /aws/v1/:projectId/devices/:device":                                       middleware.PermOperate,
+	"DELETE /aws/v1/:projectId/devices/:device":                                    middleware.PermOperate,
+	"GET /aws/v1/:projectId/devices/:device/latest":                                middleware.PermView,
+	"GET /aws/v1/:projectId/devices/latest":                                        middleware.PermView,
+	"GET /aws/v1/:projectId/devices/range-data":                                    middleware.PermView,
+	"GET /aws/v1/:projectId/devices/group-data":                                    middleware.PermView,
+	"GET /aws/v1/:projectId/devices/:device/range-data":                            middleware.PermView,
+	"GET /aws/v1/:projectId/devices/:device/group-data":                            middleware.PermView,
+	"GET /aws/v1/:projectId/devices/:device/mkt":                                   middleware.PermView,
+	"GET /aws/v1/:projectId/devices/:device/assignments":                           middleware.PermView,
+	"GET /aws/v1/:projectId/devices/:device/thresh":                                middleware.PermView,
+	"PUT /aws/v1/:projectId/devices/:device/thresh":                                middleware.PermOperate,
+	"GET /aws/v1/:projectId/devices/:device/calibration":                           middleware.PermView,
+	"PUT /aws/v1/:projectId/devices/:device/calibration":                           middleware.PermOperate,
+	"DELETE /aws/v1/:projectId/devices/:device/calibration":                        middleware.PermOperate,
+	"GET /aws/v1/:projectId/preference":                                            middleware.PermView,
+	"PUT /aws/v1/:projectId/preference":                                            middleware.PermOperate,
+	"GET /aws/v1/:projectId/notify":                                                middleware.PermView,
+	"PUT /aws/v1/:projectId/notify":                                                middleware.PermManage,
+	"DELETE /aws/v1/:projectId/notify/:subscribeId":                                middleware.PermManage,
+	"GET /aws/v1/:projectId/webhooks":                                              middleware.PermManage,
+	"POST /aws/v1/:projectId/webhooks":                                             middleware.PermManage,
+	"GET /aws/v1/:projectId/webhooks/:webhookId":                                   middleware.PermManage,
+	"PUT /aws/v1/:projectId/webhooks/:webhookId":                                   middleware.PermManage,
+	"DELETE /aws/v1/:projectId/webhooks/:webhookId":                                middleware.PermManage,
+	"GET /aws/v1/:projectId/webhooks/:webhookId/deliveries":                        middleware.PermManage,
+	"GET /aws/v1/:projectId/mqtt-bridge":                                           middleware.PermManage,
+	"PUT /aws/v1/:projectId/mqtt-bridge":                                           middleware.PermManage,
+	"DELETE /aws/v1/:projectId/mqtt-bridge":                                        middleware.PermManage,
+	"POST /aws/v1/:projectId/certificate":                                          middleware.PermManage,
+	"POST /app/v1/:projectId/register/dev-token":                                   middleware.PermView,
+	"GET /app/resource":                                                            middleware.PermPublic,
+}
+
+// routeScopes is the api key scope of the routes open to api keys, api keys
+// are rejected by the routes without an entry.
+var routeScopes = map[string]string{
+	"GET /aws/v1/:projectId/things":                         common.ScopeReadData,
+	"GET /aws/v1/:projectId/things/:thingName/latest":       common.ScopeReadData,
+	"GET /aws/v1/:projectId/things/:thingName/range-data":   common.ScopeReadData,
+	"GET /aws/v1/:projectId/things/:thingName/device":       common.ScopeReadData,
+	"GET /aws/v1/:projectId/things/:thingName/completeness": common.ScopeReadData,
+	"GET /aws/v1/:projectId/events/stream":                  common.ScopeReadData,
+	"GET /aws/v1/:projectId/events/ws":                      common.ScopeReadData,
+	"GET /aws/v1/:projectId/locations":                      common.ScopeReadData,
+	"GET /aws/v1/:projectId/locations/:locationId":          common.ScopeReadData,
+	"GET /aws/v1/:projectId/devices":                        common.ScopeReadData,
+	"GET /aws/v1/:projectId/devices/:device":                common.ScopeReadData,
+	"GET /aws/v1/:projectId/devices/:device/latest":         common.ScopeReadData,
+	"GET /aws/v1/:projectId/devices/latest":                 common.ScopeReadData,
+	"GET /aws/v1/:projectId/devices/range-data":             common.ScopeReadData,
+	"GET /aws/v1/:projectId/devices/group-data":             common.ScopeReadData,
+	"GET /aws/v1/:projectId/devices/:device/range-data":     common.ScopeReadData,
+	"GET /aws/v1/:projectId/devices/:device/group-data":     common.ScopeReadData,
+	"GET /aws/v1/:projectId/devices/:device/mkt":            common.ScopeReadData,
+	"GET /aws/v1/:projectId/devices/:device/assignments":    common.ScopeReadData,
+	"GET /aws/v1/:projectId/devices/:device/calibration":    common.ScopeReadData,
+	"GET /aws/v1/:projectId/preference":                     common.ScopeReadData,
+	"POST /aws/v1/:projectId/things":                        common.ScopeManageThings,
+	"PUT /aws/v1/:projectId/things/:thingName":              common.ScopeManageThings,
+	"DELETE /aws/v1/:projectId/things/:thingName":           common.ScopeManageThings,
+	"POST /aws/v1/:projectId/locations":                     common.ScopeManageThings,
+	"PUT /aws/v1/:projectId/locations/:locationId":          common.ScopeManageThings,
+	"DELETE /aws/v1/:projectId/locations/:locationId":       common.ScopeManageThings,
+	"POST /aws/v1/:projectId/devices":                       common.ScopeManageThings,
+	"PUT /aws/v1/:projectId/devices/:device":                common.ScopeManageThings,
+	"DELETE /aws/v1/:projectId/devices/:device":             common.ScopeManageThings,
+	"PUT /aws/v1/:projectId/devices/:device/calibration":    common.ScopeManageThings,
+	"DELETE /aws/v1/:projectId/devices/:device/calibration": common.ScopeManageThings,
+	"GET /aws/v1/:projectId/devices/:device/thresh":         common.ScopeManageAlerts,
+	"PUT /aws/v1/:projectId/devices/:device/thresh":         common.ScopeManageAlerts,
+	"GET /aws/v1/:projectId/notify":                         common.ScopeManageAlerts,
+	"PUT /aws/v1/:projectId/notify":                         common.ScopeManageAlerts,
+	"DELETE /aws/v1/:projectId/notify/:subscribeId":         common.ScopeManageAlerts,
+}
+
+var (
+	// every route is limited by apiLimit besides its own limits
+	apiLimit       = middleware.Limit{Name: "api", Rate: 1200, Window: time.Minute, By: middleware.ByUser}
+	loginLimit     = middleware.Limit{Name: "login", Rate: 10, Window: time.Minute, By: middleware.ByIp}
+	mailLimit      = middleware.Limit{Name: "mail", Rate: 5, Window: 15 * time.Minute, By: middleware.ByIp}
+	signupLimit    = middleware.Limit{Name: "signup", Rate: 5, Window: time.Hour, By: middleware.ByIp}
+	resetLimit     = middleware.Limit{Name: "reset", Rate: 10, Window: 15 * time.Minute, By: middleware.ByIp}
+	refreshLimit   = middleware.Limit{Name: "refresh", Rate: 30, Window: time.Minute, By: middleware.ByIp}
+	ssoLimit       = middleware.Limit{Name: "sso", Rate: 30, Window: time.Minute, By: middleware.ByIp}
+	inviteLimit    = middleware.Limit{Name: "invite", Rate: 20, Window: time.Hour, By: middleware.ByProject}
+	twoFactorLimit = middleware.Limit{Name: "two_factor", Rate: 10, Window: 15 * time.Minute, By: middleware.ByUser}
+)
+
+// routeLimits is the rate limits of the routes sending mails or checking
+// credentials.
+var routeLimits = map[string][]middleware.Limit{
+	"POST /v1/users/login":                         {loginLimit},
+	"POST /v1/users/login/2fa":                     {loginLimit},
+	"POST /v1/users/verify":                        {mailLimit},
+	"POST /v1/users/password/forgot":               {mailLimit},
+	"POST /v1/users/password/reset":                {resetLimit},
+	"POST /v1/users":                               {signupLimit},
+	"POST /v1/users/token/refresh":                 {refreshLimit},
+	"PUT /v1/users/:projectId/password":            {twoFactorLimit},
+	"POST /v1/users/:projectId/2fa/activate":       {twoFactorLimit},
+	"DELETE /v1/users/:projectId/2fa":              {twoFactorLimit},
+	"POST /v1/users/:projectId/2fa/recovery-codes": {twoFactorLimit},
+	"POST /v1/projects/:projectId/invitations":     {inviteLimit},
+	"GET /v1/sso/:projectId/login":                 {ssoLimit},
+	"GET /v1/sso/callback":                         {ssoLimit},
+}
+
+// routeActions is the audit action of the mutating routes, every POST, PUT
+// and DELETE route needs an entry. The routes mapped to an empty action
+// change nothing worth auditing.
+var routeActions = map[string]string{
+	"POST /v1/users/verify":                                                        "user.verify",
+	"POST /v1/users/password/forgot":                                               "user.password_forgot",
+	"POST /v1/users/password/reset":                                                "user.password_reset",
+	"PUT /v1/users/:projectId/password":                                            "user.password_change",
+	"POST /v1/users":                                                               "user.create",
+	"POST /v1/users/login":                                                         "user.login",
+	"POST /v1/users/login/2fa":                                                     "user.login_2fa",
+	"POST /v1/users/token/refresh":                                                 "",
+	"POST /v1/users/logout":                                                        "user.logout",
+	"DELETE /v1/users/:projectId/sessions":                                         "session.revoke_all",
+	"DELETE /v1/users/:projectId/sessions/:sessionId":                              "session.revoke",
+	"DELETE /v1/users/:projectId":                                                  "user.delete",
+	"POST /v1/users/:projectId":                                                    "user.bind_aws",
+	"POST /v1/users/:projectId/2fa":                                                "2fa.enroll",
+	"POST /v1/users/:projectId/2fa/activate":                                       "2fa.activate",
+	"DELETE /v1/users/:projectId/2fa":                                              "2fa.disable",
+	"POST /v1/users/:projectId/2fa/recovery-codes":                                 "2fa.recovery_codes",
+	"POST /v1/projects":                                                            "project.create",
+	"PUT /v1/projects/:projectId":                                                  "project.update",
+	"PUT /v1/projects/:projectId/members/:userId":                                  "member.update",
+	"DELETE /v1/projects/:projectId/members/:userId":                               "member.remove",
+	"POST /v1/projects/:projectId/invitations":                                     "invitation.create",
+	"DELETE /v1/projects/:projectId/invitations/:invitationId":                     "invitation.revoke",
+	"POST /v1/invitations/accept":                                                  "invitation.accept",
+	"POST /v1/projects/:projectId/api-keys":                                        "api_key.create",
+	"DELETE /v1/projects/:projectId/api-keys/:keyId":                               "api_key.revoke",
+	"PUT /v1/projects/:projectId/sso":                                              "sso.update",
+	"DELETE /v1/projects/:projectId/sso":                                           "sso.delete",
+	"POST /proximity/v1/:projectId/beacons":                                        "beacon.create",
+	"DELETE /proximity/v1/:projectId/beacons/:beaconId":                            "beacon.delete",
+	"PUT /proximity/v1/:projectId/beacons/:beaconId":                               "beacon.update",
+	"POST /proximity/v1/:projectId/beacons/:beaconId/active":                       "beacon.activate",
+	"POST /proximity/v1/:projectId/beacons/:beaconId/deactive":                     "beacon.deactivate",
+	"POST /proximity/v1/:projectId/beacons/:beaconId/attachments":                  "attachment.create",
+	"DELETE /proximity/v1/:projectId/beacons/:beaconId/attachments/:attachmentId":  "attachment.delete",
+	"DELETE /proximity/v1/:projectId/beacons/:beaconId/attachments":                "attachment.delete_all",
+	"POST /proximity/v1/:projectId/getforobserved":                                 "",
+	"POST /equipment/v1/:projectId/components":                                     "component.create",
+	"DELETE /equipment/v1/:projectId/components/:componentId":                      "component.delete",
+	"PUT /equipment/v1/:projectId/components/:componentId":                         "component.update",
+	"PUT /equipment/v1/:projectId/components/:componentId/detail":                  "component.update_detail",
+	"PUT /equipment/v1/:projectId/components/:componentId/detail/cancel-modifying": "component.cancel_detail",
+	"PUT /equipment/v1/:projectId/components/:componentId/detail/sync":             "component.sync_detail",
+	"POST /aws/v1/:projectId/things":                                               "thing.create",
+	"DELETE /aws/v1/:projectId/things/:thingName":                                  "thing.delete",
+	"PUT /aws/v1/:projectId/things/:thingName":                                     "thing.update",
+	"POST /aws/v1/:projectId/locations":                                            "location.create",
+	"PUT /aws/v1/:projectId/locations/:locationId":                                 "location.update",
+	"DELETE /aws/v1/:projectId/locations/:locationId":                              "location.delete",
+	"POST /aws/v1/:projectId/devices":                                              "device.create",
+	"PUT /aws/v1/:projectId/devices/:device":                                       "device.update",
+	"DELETE /aws/v1/:projectId/devices/:device":                                    "device.delete",
+	"PUT /aws/v1/:projectId/devices/:device/thresh":                                "threshold.update",
+	"PUT /aws/v1/:projectId/devices/:device/calibration":                           "calibration.update",
+	"DELETE /aws/v1/:projectId/devices/:device/calibration":                        "calibration.delete",
+	"PUT /aws/v1/:projectId/preference":                                            "preference.update",
+	"PUT /aws/v1/:projectId/notify":                                                "notify.subscribe",
+	"DELETE /aws/v1/:projectId/notify/:subscribeId":                                "notify.unsubscribe",
+	"POST /aws/v1/:projectId/webhooks":                                             "webhook.create",
+	"PUT /aws/v1/:projectId/webhooks/:webhookId":                                   "webhook.update",
+	"DELETE /aws/v1/:projectId/webhooks/:webhookId":                                "webhook.delete",
+	"PUT /aws/v1/:projectId/mqtt-bridge":                                           "mqtt_bridge.update",
+	"DELETE /aws/v1/:projectId/mqtt-bridge":                                        "mqtt_bridge.delete",
+	"POST /aws/v1/:projectId/certificate":                                          "certificate.rotate",
+	"POST /app/v1/:projectId/register/dev-token":                                   "dev_token.register",
+}
+
+// apiRouter registers the routes with the permissions of routePermissions,
+// the routes requiring a permission are wrapped with the auth stack.
+type apiRouter struct {
+	*httptreemux.TreeMux
+	registered map[string]bool
+	// the routes with their docs, in the order of registration
+	routes []openapi.Route
+}
+
+func newApiRouter() *apiRouter {
+	return &apiRouter{
+		TreeMux:    httptreemux.New(),
+		registered: make(map[string]bool),
+	}
+}
+
+func (r *apiRouter) handle(method, path string, fn httptreemux.HandlerFunc) {
+	key := method + " " + path
+	perm, ok := routePermissions[key]
+	if !ok {
+		panic(fmt.Sprintf("route(%s) has no permission", key))
+	}
+	doc, ok := routeDocs[key]
+	if !ok {
+		panic(fmt.Sprintf("route(%s) has no openapi doc", key))
+	}
+	doc.Method, doc.Path = method, path
+	doc.Permission, doc.Public, doc.Scope = perm.String(), perm == middleware.PermPublic, routeScopes[key]
+	r.routes = append(r.routes, doc)
+	r.registered[key] = true
+	if method != http.MethodGet {
+		action, ok := routeActions[key]
+		if !ok {
+			panic(fmt.Sprintf("route(%s) has no audit action", key))
+		}
+		if len(action) > 0 {
+			fn = middleware.Audit(action, strings.HasPrefix(path, "/v1/users/"))(fn)
+		}
+	}
+	// limited after the auth, so that the limits by user see the session, the
+	// failed auths are limited by ip in Auth
+	fn = middleware.RateLimit(append([]middleware.Limit{apiLimit}, routeLimits[key]...)...)(fn)
+	fn = middleware.RequestId(middleware.Require(perm, routeScopes[key], fn))
+	r.TreeMux.Handle(method, path, metrics.Instrument(method, path, fn))
+}
+
+func (r *apiRouter) GET(path string, fn httptreemux.HandlerFunc) {
+	r.handle(http.MethodGet, path, fn)
+}
+
+func (r *apiRouter) POST(path string, fn httptreemux.HandlerFunc) {
+	r.handle(http.MethodPost, path, fn)
+}
+
+func (r *apiRouter) PUT(path string, fn httptreemux.HandlerFunc) {
+	r.handle(http.MethodPut, path, fn)
+}
+
+func (r *apiRouter) DELETE(path string, fn httptreemux.HandlerFunc) {
+	r.handle(http.MethodDelete, path, fn)
+}
+
+// checkPermissions panics if a permission is declared for a route which is
+// not registered, so that the table and the routes do not drift apart.
+func (r *apiRouter) checkPermissions() {
+	for key := range routePermissions {
+		if !r.registered[key] {
+			panic(fmt.Sprintf("permission of unknown route(%s)", key))
+		}
+	}
+	for key := range routeScopes {
+		if !r.registered[key] {
+			panic(fmt.Sprintf("scope of unknown route(%s)", key))
+		}
+	}
+	for key := range routeLimits {
+		if !r.registered[key] {
+			panic(fmt.Sprintf("rate limit of unknown route(%s)", key))
+		}
+	}
+	for key := range routeActions {
+		if !r.registered[key] {
+			panic(fmt.Sprintf("audit action of unknown route(%s)", key))
+		}
+	}
+	for key := range routeDocs {
+		if !r.registered[key] {
+			panic(fmt.Sprintf("openapi doc of unknown route(%s)", key))
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package controller
+
+import (
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"fmt"
+	"github.com/alicebob/miniredis/v2"
+	"github.com/astaxie/beego/orm"
+	"github.com/jack0liu/conf"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"github.com/ssrs100/blueserver/sesscache"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+)
+
+const (
+	testProjectId = "p-test"
+	testUserId    = "u-test"
+)
+
+var errNoDb = errors.New("no database in tests")
+
+// noDb is a database driver failing every query, the membership lookups of
+// the authorization then find no role.
+type noDb struct{}
+
+func (noDb) Open(name string) (driver.Conn, error) { return noDbConn{}, nil }
+
+type noDbConn struct{}
+
+func (noDbConn) Prepare(query string) (driver.Stmt, error) { return noDbStmt{}, nil }
+func (noDbConn) Close() error                              { return nil }
+func (noDbConn) Begin() (driver.Tx, error)                 { return nil, errNoDb }
+
+type noDbStmt struct{}
+
+func (noDbStmt) Close() error                                    { return nil }
+func (noDbStmt) NumInput() int                                   { return -1 }
+func (noDbStmt) Exec(args []driver.Value) (driver.Result, error) { return nil, errNoDb }
+func (noDbStmt) Query(args []driver.Value) (driver.Rows, error)  { return nil, errNoDb }
+
+func TestMain(m *testing.M) {
+	os.Exit(runTests(m))
+}
+
+func runTests(m *testing.M) int {
+	mr, err := miniredis.Run()
+	if err != nil {
+		fmt.Println("start redis fail, err:" + err.Error())
+		return 1
+	}
+	defer mr.Close()
+	dir, err := ioutil.TempDir("", "controller")
+	if err != nil {
+		fmt.Println("create temp dir fail, err:" + err.Error())
+		return 1
+	}
+	defer os.RemoveAll(dir)
+	confFile := filepath.Join(dir, "blueserver.json")
+	body := fmt.Sprintf(`{"enable_auth": 1, "redis_addr": %q, "platform_admins": "ops, root"}`, mr.Addr())
+	if err := ioutil.WriteFile(confFile, []byte(body), 0600); err != nil {
+		fmt.Println("write conf fail, err:" + err.Error())
+		return 1
+	}
+	if err := conf.Init(confFile); err != nil {
+		fmt.Println("init conf fail, err:" + err.Error())
+		return 1
+	}
+	sesscache.InitRedis()
+	sql.Register("nodb", noDb{})
+	if err := orm.RegisterDriver("nodb", orm.DRMySQL); err != nil {
+		fmt.Println("register db driver fail, err:" + err.Error())
+		return 1
+	}
+	if err := orm.RegisterDataBase("default", "nodb", "nodb"); err != nil {
+		fmt.Println("register db fail, err:" + err.Error())
+		return 1
+	}
+	return m.Run()
+}
+
+// routePath fills the parameters of the route, the project is testProjectId.
+func routePath(route string) string {
+	segs := strings.Split(route, "/")
+	for i, seg := range segs {
+		switch {
+		case seg == ":projectId":
+			segs[i] = testProjectId
+		case strings.HasPrefix(seg, ":") || strings.HasPrefix(seg, "*"):
+			segs[i] = "x"
+		}
+	}
+	return strings.Join(segs, "/")
+}
+
+var testClients int32
+
+// serveRoute serves the route to a new client ip, so that the failed auths
+// of the tests do not add up to the limit.
+func serveRoute(t *testing.T, h http.Handler, key, token string) int {
+	n := atomic.AddInt32(&testClients, 1)
+	return serveRouteFrom(t, h, key, token, fmt.Sprintf("198.18.%d.%d:4000", n/250, n%250+1))
+}
+
+func serveRouteFrom(t *testing.T, h http.Handler, key, token, remote string) int {
+	parts := strings.SplitN(key, " ", 2)
+	req := httptest.NewRequest(parts[0], routePath(parts[1]), strings.NewReader("{}"))
+	req.RemoteAddr = remote
+	req.Header.Set("Content-Type", "application/json")
+	if len(token) > 0 {
+		req.Header.Set(common.XAuthB, token)
+	}
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, req)
+	return w.Code
+}
+
+func TestRoutesRequireToken(t *testing.T) {
+	h := LoadApi()
+	for key, perm := range routePermissions {
+		if perm == middleware.PermPublic {
+			continue
+		}
+		if code := serveRoute(t, h, key, ""); code != http.StatusUnauthorized {
+			t.Errorf("%s without token returns %d, want %d", key, code, http.StatusUnauthorized)
+		}
+		if code := serveRoute(t, h, key, "not-a-token"); code != http.StatusUnauthorized && code != http.StatusFound {
+			t.Errorf("%s with an unknown token returns %d", key, code)
+		}
+	}
+}
+
+func TestRoutesRequireRole(t *testing.T) {
+	h := LoadApi()
+	req := httptest.NewRequest(http.MethodPost, "/v1/users/login", nil)
+	// a user without platform roles, not a member of testProjectId
+	tokens, err := middleware.NewSession(testUserId, nil, false, req)
+	if err != nil {
+		t.Fatalf("new session fail, err:%s", err.Error())
+	}
+	for key, perm := range routePermissions {
+		if perm == middleware.PermPublic || perm == middleware.PermUser {
+			continue
+		}
+		if code := serveRoute(t, h, key, tokens.AccessToken); code != http.StatusForbidden {
+			t.Errorf("%s of %s permission returns %d to a non-member, want %d",
+				key, perm, code, http.StatusForbidden)
+		}
+	}
+}
+
+func TestPlatformAdmin(t *testing.T) {
+	for _, c := range []struct {
+		user  bluedb.User
+		admin bool
+	}{
+		{bluedb.User{Id: "u-root", Name: "root"}, true},
+		{bluedb.User{Id: "u-ops", Name: "ops"}, true},
+		{bluedb.User{Id: "u-role", Name: "someone", Role: common.RoleAdmin}, true},
+		// the name alone does not make the admin
+		{bluedb.User{Id: "u-admin", Name: "admin"}, false},
+		{bluedb.User{Id: "u-rooter", Name: "rooter"}, false},
+	} {
+		roles := platformRoles(&c.user)
+		if admin := len(roles) == 1 && roles[0] == common.RoleAdmin; admin != c.admin {
+			t.Errorf("user %s has platform roles %v", c.user.Name, roles)
+		}
+	}
+
+	h := LoadApi()
+	req := httptest.NewRequest(http.MethodPost, "/v1/users/login", nil)
+	tokens, err := middleware.NewSession("u-root", platformRoles(&bluedb.User{Id: "u-root", Name: "root"}), true, req)
+	if err != nil {
+		t.Fatalf("new session fail, err:%s", err.Error())
+	}
+	for _, key := range []string{"GET /v1/users", "GET /v1/audit-logs", "GET /v1/audit-logs/export"} {
+		if routePermissions[key] != middleware.PermPlatform {
+			t.Fatalf("%s is not a platform route", key)
+		}
+		if code := serveRoute(t, h, key, tokens.AccessToken); code == http.StatusUnauthorized || code == http.StatusForbidden {
+			t.Errorf("%s returns %d to the platform admin", key, code)
+		}
+	}
+}
+
+func TestAuthFailuresLimited(t *testing.T) {
+	h := LoadApi()
+	const route = "GET /v1/users/:projectId"
+	const guesser = "203.0.113.9:4000"
+	req := httptest.NewRequest(http.MethodPost, "/v1/users/login", nil)
+	tokens, err := middleware.NewSession(testUserId, nil, false, req)
+	if err != nil {
+		t.Fatalf("new session fail, err:%s", err.Error())
+	}
+	for i := 0; i < 30; i++ {
+		if code := serveRouteFrom(t, h, route, fmt.Sprintf("guess-%d", i), guesser); code == http.StatusTooManyRequests {
+			t.Fatalf("guess %d is limited", i)
+		}
+	}
+	// the ip is blocked before its credentials are checked, valid or not
+	if code := serveRouteFrom(t, h, route, "guess-x", guesser); code != http.StatusTooManyRequests {
+		t.Errorf("guess past the limit returns %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := serveRouteFrom(t, h, route, tokens.AccessToken, guesser); code != http.StatusTooManyRequests {
+		t.Errorf("token of a blocked ip returns %d, want %d", code, http.StatusTooManyRequests)
+	}
+	if code := serveRouteFrom(t, h, route, "guess-y", "203.0.113.10:4000"); code == http.StatusTooManyRequests {
+		t.Errorf("another ip is limited")
+	}
+}
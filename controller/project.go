@@ -0,0 +1,441 @@
+package controller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/astaxie/beego/utils"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/apierr"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const invitationExpire = 7 * 24 * time.Hour
+
+func init() {
+	apierr.RegisterCheck("Role", "Must be a project role", middleware.ValidRole)
+	apierr.RegisterCheck("Scope", "Must be an api key scope", middleware.ValidScope)
+}
+
+type ProjectInfo struct {
+	Id   string `json:"id"`
+	Name string `json:"name"`
+	// role of the current user
+	Role             string `json:"role"`
+	RequireTwoFactor bool   `json:"require_two_factor"`
+}
+
+// ProjectReq is the project to create or update, the fields not set are
+// not updated.
+type ProjectReq struct {
+	Name             string `json:"name"`
+	RequireTwoFactor *bool  `json:"require_two_factor,omitempty"`
+}
+
+type Member struct {
+	UserId   string     `json:"user_id"`
+	Name     string     `json:"name"`
+	Email    string     `json:"email"`
+	Role     string     `json:"role"`
+	JoinedAt *time.Time `json:"joined_at,omitempty"`
+}
+
+type MemberReq struct {
+	Role string `json:"role" valid:"Required;Role"`
+}
+
+type InvitationReq struct {
+	Email string `json:"email" valid:"Required;Email;MaxSize(128)"`
+	Role  string `json:"role" valid:"Required;Role"`
+}
+
+type InvitationInfo struct {
+	Id        string     `json:"id"`
+	Email     string     `json:"email"`
+	Role      string     `json:"role"`
+	InvitedBy string     `json:"invited_by"`
+	ExpireAt  *time.Time `json:"expire_at"`
+	CreateAt  *time.Time `json:"create_at,omitempty"`
+}
+
+type AcceptInvitationReq struct {
+	Token string `json:"token" valid:"Required"`
+}
+
+func userProjects(userId string) []*ProjectInfo {
+	memberships := bluedb.QueryMemberships(map[string]interface{}{"user_id": userId})
+	roles := make(map[string]string, len(memberships))
+	ids := make([]string, 0, len(memberships))
+	for _, m := range memberships {
+		roles[m.ProjectId] = m.Role
+		ids = append(ids, m.ProjectId)
+	}
+	list := make([]*ProjectInfo, 0, len(ids))
+	for _, p := range bluedb.QueryProjects(ids) {
+		list = append(list, &ProjectInfo{
+			Id:               p.Id,
+			Name:             p.Name,
+			Role:             roles[p.Id],
+			RequireTwoFactor: p.RequireTwoFactor,
+		})
+	}
+	return list
+}
+
+func currentUserId(w http.ResponseWriter, req *http.Request) (string, bool) {
+	us := middleware.CurrentSession(req)
+	if us == nil {
+		DefaultHandler.ServeHTTP(w, req, errors.New("no user session"), http.StatusUnauthorized)
+		return "", false
+	}
+	return us.UserId, true
+}
+
+// readJsonBody decodes the body into v and checks it by its valid tags.
+func readJsonBody(w http.ResponseWriter, req *http.Request, v interface{}) bool {
+	if err := apierr.DecodeJson(req, v); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+		return false
+	}
+	return true
+}
+
+func writeJsonResp(w http.ResponseWriter, code int, v interface{}) {
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(code)
+	json.NewEncoder(w).Encode(v)
+}
+
+// ListProjects lists the projects of the current user.
+func ListProjects(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	userId, ok := currentUserId(w, req)
+	if !ok {
+		return
+	}
+	writeJsonResp(w, http.StatusOK, userProjects(userId))
+}
+
+// CreateProject creates a project owned by the current user.
+func CreateProject(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	userId, ok := currentUserId(w, req)
+	if !ok {
+		return
+	}
+	var projectReq ProjectReq
+	if !readJsonBody(w, req, &projectReq) {
+		return
+	}
+	name := strings.TrimSpace(projectReq.Name)
+	if len(name) == 0 || len(name) >= 60 {
+		DefaultHandler.ServeHTTP(w, req, fmt.Errorf("Name(%s) is empty or exceed 60 bytes.", name), http.StatusBadRequest)
+		return
+	}
+	id, err := bluedb.SaveProject(bluedb.Project{Name: name})
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	if err := bluedb.SaveMembership(id, userId, common.RoleOwner); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	middleware.ForgetRole(userId, id)
+	middleware.AuditProject(req, id)
+	middleware.AuditTarget(req, "projectId="+id)
+	middleware.AuditChange(req, nil, map[string]string{"name": name})
+	writeJsonResp(w, http.StatusCreated, ProjectInfo{
+		Id:   id,
+		Name: name,
+		Role: common.RoleOwner,
+	})
+}
+
+func GetProject(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	p, err := bluedb.QueryProject(projectId)
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, errors.New("project not found"), http.StatusNotFound)
+		return
+	}
+	info := ProjectInfo{
+		Id:               p.Id,
+		Name:             p.Name,
+		RequireTwoFactor: p.RequireTwoFactor,
+	}
+	if us := middleware.CurrentSession(req); us != nil {
+		info.Role = middleware.ProjectRole(us.UserId, projectId)
+	}
+	writeJsonResp(w, http.StatusOK, info)
+}
+
+// projectSummary is the project in the audit log.
+func projectSummary(p *bluedb.Project) map[string]interface{} {
+	return map[string]interface{}{
+		"name":               p.Name,
+		"require_two_factor": p.RequireTwoFactor,
+	}
+}
+
+// UpdateProject renames the project or sets whether its members must log in
+// with a second factor. The owner turning it on must have passed one, so
+// that the owner is not locked out.
+func UpdateProject(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	var projectReq ProjectReq
+	if !readJsonBody(w, req, &projectReq) {
+		return
+	}
+	p, err := bluedb.QueryProject(projectId)
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, errors.New("project not found"), http.StatusNotFound)
+		return
+	}
+	before := projectSummary(&p)
+	cols := make([]string, 0)
+	if len(projectReq.Name) > 0 {
+		name := strings.TrimSpace(projectReq.Name)
+		if len(name) == 0 || len(name) >= 60 {
+			DefaultHandler.ServeHTTP(w, req, fmt.Errorf("Name(%s) is empty or exceed 60 bytes.", name), http.StatusBadRequest)
+			return
+		}
+		p.Name = name
+		cols = append(cols, "name")
+	}
+	if projectReq.RequireTwoFactor != nil {
+		us := middleware.CurrentSession(req)
+		if *projectReq.RequireTwoFactor && us != nil && !middleware.SessionPassedMfa(us) {
+			DefaultHandler.ServeHTTP(w, req, errors.New("enable two-factor authentication for yourself first"), http.StatusConflict)
+			return
+		}
+		p.RequireTwoFactor = *projectReq.RequireTwoFactor
+		cols = append(cols, "require_two_factor")
+	}
+	if len(cols) == 0 {
+		DefaultHandler.ServeHTTP(w, req, errors.New("nothing to update"), http.StatusBadRequest)
+		return
+	}
+	if err := bluedb.UpdateProject(p, cols...); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	middleware.ForgetTwoFactor(projectId)
+	middleware.AuditChange(req, before, projectSummary(&p))
+	w.WriteHeader(http.StatusOK)
+}
+
+func ListMembers(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	list := make([]*Member, 0)
+	for _, m := range bluedb.QueryMemberships(map[string]interface{}{"project_id": ps["projectId"]}) {
+		member := &Member{
+			UserId:   m.UserId,
+			Role:     m.Role,
+			JoinedAt: m.CreateAt,
+		}
+		if u, err := bluedb.QueryUserById(m.UserId); err == nil {
+			member.Name = u.Name
+			member.Email = u.Email
+		}
+		list = append(list, member)
+	}
+	writeJsonResp(w, http.StatusOK, list)
+}
+
+// isLastOwner tells whether the user is the only owner of the project, the
+// last owner can not be removed or demoted.
+func isLastOwner(projectId, userId string) bool {
+	owners := bluedb.QueryMemberships(map[string]interface{}{
+		"project_id": projectId,
+		"role":       common.RoleOwner,
+	})
+	return len(owners) == 1 && owners[0].UserId == userId
+}
+
+func UpdateMember(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	userId := ps["userId"]
+	var memberReq MemberReq
+	if !readJsonBody(w, req, &memberReq) {
+		return
+	}
+	m := bluedb.QueryMembership(projectId, userId)
+	if m == nil {
+		DefaultHandler.ServeHTTP(w, req, errors.New("member not found"), http.StatusNotFound)
+		return
+	}
+	if memberReq.Role != common.RoleOwner && isLastOwner(projectId, userId) {
+		DefaultHandler.ServeHTTP(w, req, errors.New("the last owner can not be demoted"), http.StatusConflict)
+		return
+	}
+	if err := bluedb.SaveMembership(projectId, userId, memberReq.Role); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	middleware.ForgetRole(userId, projectId)
+	middleware.AuditChange(req, MemberReq{Role: m.Role}, memberReq)
+	w.WriteHeader(http.StatusOK)
+}
+
+func RemoveMember(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	userId := ps["userId"]
+	if isLastOwner(projectId, userId) {
+		DefaultHandler.ServeHTTP(w, req, errors.New("the last owner can not be removed"), http.StatusConflict)
+		return
+	}
+	if m := bluedb.QueryMembership(projectId, userId); m != nil {
+		middleware.AuditChange(req, MemberReq{Role: m.Role}, nil)
+	}
+	if err := bluedb.DeleteMembership(projectId, userId); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	middleware.ForgetRole(userId, projectId)
+	w.WriteHeader(http.StatusOK)
+}
+
+func hashInvitationToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+func sendInvitationEmail(toUserEmails []string, projectName, token string) error {
+	email := bluedb.GetSys("sysEmailUser")
+	pwd := bluedb.GetSys("sysEmailPwd")
+	config := fmt.Sprintf(`{"username":"%s","password":"%s","host":"smtp.exmail.qq.com","port":25}`, email, pwd)
+	temail := utils.NewEMail(config)
+	temail.To = toUserEmails
+	temail.From = email
+	temail.Subject = "You are invited to a Feasycom project"
+
+	redirectAddr := conf.GetString("redirect_addr")
+	temail.HTML = fmt.Sprintf("You are invited to the project <b>%s</b>.<br/>", projectName) +
+		"Please sign up or log in with this email, then accept the invitation by clicking the following link:<br/>" +
+		"<href>" + redirectAddr + "/feasycom/invite?token=" + token + "</href><br/>It will expire in 7 days."
+
+	err := temail.Send()
+	if err != nil {
+		logs.Error("send email fail, err:%s", err.Error())
+		return err
+	}
+	return nil
+}
+
+// InviteMember mails an invitation to the email, the invitee joins with the
+// role once accepting it.
+func InviteMember(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	userId, ok := currentUserId(w, req)
+	if !ok {
+		return
+	}
+	var inviteReq InvitationReq
+	if !readJsonBody(w, req, &inviteReq) {
+		return
+	}
+	email := strings.TrimSpace(inviteReq.Email)
+	p, err := bluedb.QueryProject(projectId)
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, errors.New("project not found"), http.StatusNotFound)
+		return
+	}
+	token, err := generateResetToken()
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	expireAt := time.Now().Add(invitationExpire)
+	id, err := bluedb.SaveInvitation(bluedb.Invitation{
+		ProjectId: projectId,
+		Email:     email,
+		Role:      inviteReq.Role,
+		TokenHash: hashInvitationToken(token),
+		InvitedBy: userId,
+		ExpireAt:  &expireAt,
+	})
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	if err := sendInvitationEmail([]string{email}, p.Name, token); err != nil {
+		_ = bluedb.DeleteInvitation(projectId, id)
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+		return
+	}
+	writeJsonResp(w, http.StatusCreated, map[string]string{"id": id})
+}
+
+func ListInvitations(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	list := make([]*InvitationInfo, 0)
+	for _, inv := range bluedb.QueryInvitations(ps["projectId"]) {
+		list = append(list, &InvitationInfo{
+			Id:        inv.Id,
+			Email:     inv.Email,
+			Role:      inv.Role,
+			InvitedBy: inv.InvitedBy,
+			ExpireAt:  inv.ExpireAt,
+			CreateAt:  inv.CreateAt,
+		})
+	}
+	writeJsonResp(w, http.StatusOK, list)
+}
+
+func RevokeInvitation(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	if err := bluedb.DeleteInvitation(ps["projectId"], ps["invitationId"]); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// AcceptInvitation adds the current user to the project of the invitation,
+// the email of the user must be the invited one.
+func AcceptInvitation(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	userId, ok := currentUserId(w, req)
+	if !ok {
+		return
+	}
+	var acceptReq AcceptInvitationReq
+	if !readJsonBody(w, req, &acceptReq) {
+		return
+	}
+	inv := bluedb.QueryInvitationByToken(hashInvitationToken(acceptReq.Token))
+	if inv == nil {
+		DefaultHandler.ServeHTTP(w, req, errors.New("Invalid invitation or expired."), http.StatusBadRequest)
+		return
+	}
+	user, err := bluedb.QueryUserById(userId)
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+		return
+	}
+	if !strings.EqualFold(user.Email, inv.Email) {
+		DefaultHandler.ServeHTTP(w, req, errors.New("the invitation is for another email"), http.StatusForbidden)
+		return
+	}
+	accepted, err := bluedb.AcceptInvitation(inv.Id)
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	if !accepted {
+		DefaultHandler.ServeHTTP(w, req, errors.New("Invalid invitation or expired."), http.StatusBadRequest)
+		return
+	}
+	// an existing member keeps the higher role
+	if m := bluedb.QueryMembership(inv.ProjectId, userId); m == nil || middleware.RoleRank(inv.Role) > middleware.RoleRank(m.Role) {
+		if err := bluedb.SaveMembership(inv.ProjectId, userId, inv.Role); err != nil {
+			DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+			return
+		}
+	}
+	middleware.ForgetRole(userId, inv.ProjectId)
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,104 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"net/http"
+)
+
+type RefreshTokenReq struct {
+	RefreshToken string `json:"refresh_token" valid:"Required"`
+}
+
+type SessionInfo struct {
+	Id         string `json:"id"`
+	CreatedAt  string `json:"created_at"`
+	ExpiredAt  string `json:"expired_at"`
+	LastAccess string `json:"last_access"`
+	UserAgent  string `json:"user_agent"`
+	Ip         string `json:"ip"`
+	Current    bool   `json:"current"`
+}
+
+type SessionsWrap struct {
+	Sessions []*SessionInfo `json:"sessions"`
+}
+
+// RefreshToken exchanges the refresh token for a new token pair, the used
+// refresh token is invalid afterwards.
+func RefreshToken(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	var refreshReq = &RefreshTokenReq{}
+	if !readJsonBody(w, req, refreshReq) {
+		return
+	}
+	tokens, err := middleware.RefreshSession(refreshReq.RefreshToken)
+	if err == middleware.ErrInvalidRefreshToken {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusUnauthorized)
+		return
+	}
+	if err != nil {
+		logs.Error("refresh session err:%s", err.Error())
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(UserLoginResponse{
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
+	})
+}
+
+// Logout revokes the session of the token.
+func Logout(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	us := middleware.CurrentSession(req)
+	if us == nil || len(us.SessionId) == 0 {
+		DefaultHandler.ServeHTTP(w, req, errors.New("no session to logout"), http.StatusBadRequest)
+		return
+	}
+	middleware.RevokeSession(us.UserId, us.SessionId)
+	w.WriteHeader(http.StatusOK)
+}
+
+// ListSessions lists the active sessions of the user with their last access.
+func ListSessions(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	id := ps["projectId"]
+	current := ""
+	if us := middleware.CurrentSession(req); us != nil {
+		current = us.SessionId
+	}
+	list := SessionsWrap{
+		Sessions: make([]*SessionInfo, 0),
+	}
+	for _, s := range middleware.ListSessions(id) {
+		list.Sessions = append(list.Sessions, &SessionInfo{
+			Id:         s.Id,
+			CreatedAt:  s.CreatedAt,
+			ExpiredAt:  s.ExpiredAt,
+			LastAccess: s.LastAccess,
+			UserAgent:  s.UserAgent,
+			Ip:         s.Ip,
+			Current:    s.Id == current,
+		})
+	}
+	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(list)
+}
+
+func RevokeSession(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	if !middleware.RevokeSession(ps["projectId"], ps["sessionId"]) {
+		DefaultHandler.ServeHTTP(w, req, errors.New("session not found"), http.StatusNotFound)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
+
+// RevokeSessions logs out all sessions of the user, the current one too.
+func RevokeSessions(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	middleware.RevokeUserSessions(ps["projectId"], "")
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,430 @@
+package controller
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"github.com/ssrs100/blueserver/oidc"
+	"github.com/ssrs100/blueserver/sesscache"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	ssoStatePrefix = "ssoState_"
+	ssoStateExpire = 10 * time.Minute
+	ssoCallback    = "/v1/sso/callback"
+)
+
+// ssoState is kept in redis between the login redirect and the callback.
+type ssoState struct {
+	ProjectId string `json:"project_id"`
+	Nonce     string `json:"nonce"`
+	Verifier  string `json:"verifier"`
+}
+
+type SsoConfigReq struct {
+	Issuer       string            `json:"issuer" valid:"Required;MaxSize(256)"`
+	ClientId     string            `json:"client_id" valid:"Required;MaxSize(256)"`
+	ClientSecret string            `json:"client_secret,omitempty" valid:"MaxSize(256)"`
+	Scopes       []string          `json:"scopes" valid:"MaxSize(16)"`
+	EmailDomain  string            `json:"email_domain" valid:"MaxSize(128)"`
+	GroupsClaim  string            `json:"groups_claim" valid:"MaxSize(64)"`
+	RoleMapping  map[string]string `json:"role_mapping"`
+	DefaultRole  string            `json:"default_role" valid:"Role"`
+	Enabled      bool              `json:"enabled"`
+	// the secret is returned as set but never its value
+	HasSecret   bool   `json:"has_secret"`
+	RedirectUrl string `json:"redirect_url,omitempty"`
+}
+
+type SsoDiscoverResp struct {
+	ProjectId string `json:"project_id"`
+	LoginUrl  string `json:"login_url"`
+}
+
+func emailDomain(email string) string {
+	i := strings.LastIndex(email, "@")
+	if i < 0 {
+		return ""
+	}
+	return strings.ToLower(email[i+1:])
+}
+
+// ssoRedirectUrl is the callback registered at the issuer, it is the
+// sso_callback_addr conf or derived from the request.
+func ssoRedirectUrl(req *http.Request) string {
+	if addr := conf.GetString("sso_callback_addr"); len(addr) > 0 {
+		return addr
+	}
+	scheme := "http"
+	if req.TLS != nil || req.Header.Get("X-Forwarded-Proto") == "https" {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host + ssoCallback
+}
+
+func ssoClient(c *bluedb.SsoConfig, req *http.Request) *oidc.Client {
+	return &oidc.Client{
+		ClientId:     c.ClientId,
+		ClientSecret: c.ClientSecret,
+		RedirectUrl:  ssoRedirectUrl(req),
+		Scopes:       append([]string{"email", "profile"}, strings.Fields(c.Scopes)...),
+	}
+}
+
+// ssoFail sends the browser back to the login page of the dashboard.
+func ssoFail(w http.ResponseWriter, req *http.Request, reason string) {
+	addr := conf.GetString("sso_fail_addr")
+	if len(addr) == 0 {
+		addr = conf.GetString("redirect_addr") + "/feasycom/login"
+	}
+	http.Redirect(w, req, addr+"?error="+url.QueryEscape(reason), http.StatusFound)
+}
+
+// ssoRole maps the groups of the token to the project role, the highest
+// mapped role wins, and the default role is given to the users in no mapped
+// group.
+func ssoRole(c *bluedb.SsoConfig, token *oidc.IdToken) string {
+	mapping := make(map[string]string)
+	if len(c.RoleMapping) > 0 {
+		if err := json.Unmarshal([]byte(c.RoleMapping), &mapping); err != nil {
+			logs.Error("invalid role mapping of project(%s), err:%s", c.ProjectId, err.Error())
+		}
+	}
+	claim := c.GroupsClaim
+	if len(claim) == 0 {
+		claim = "groups"
+	}
+	role := ""
+	for _, group := range token.Strings(claim) {
+		if r, ok := mapping[group]; ok && middleware.RoleRank(r) > middleware.RoleRank(role) {
+			role = r
+		}
+	}
+	if len(role) == 0 {
+		role = c.DefaultRole
+	}
+	return role
+}
+
+// uniqueUserName returns the name, or the name with a random suffix if a
+// user has it already.
+func uniqueUserName(name string) (string, error) {
+	for i := 0; i < 5; i++ {
+		u, err := bluedb.QueryUserByName(name)
+		if err != nil {
+			return "", err
+		}
+		if u == nil {
+			return name, nil
+		}
+		b := make([]byte, 2)
+		if _, err := rand.Read(b); err != nil {
+			return "", err
+		}
+		name = strings.SplitN(name, "-", 2)[0] + "-" + hex.EncodeToString(b)
+	}
+	return "", errors.New("no unique user name")
+}
+
+// ssoUser returns the user of the token. It is the linked user, the user of
+// the email which is linked then, or a user provisioned just in time.
+func ssoUser(c *bluedb.SsoConfig, token *oidc.IdToken) (*bluedb.User, error) {
+	if identity := bluedb.QuerySsoIdentity(c.Issuer, token.Subject); identity != nil {
+		u, err := bluedb.QueryUserById(identity.UserId)
+		if err != nil {
+			return nil, err
+		}
+		return &u, nil
+	}
+	if len(token.Email) == 0 || !token.EmailVerified {
+		return nil, errors.New("no verified email in id token")
+	}
+	if len(c.EmailDomain) > 0 && emailDomain(token.Email) != c.EmailDomain {
+		return nil, fmt.Errorf("email(%s) out of domain(%s)", token.Email, c.EmailDomain)
+	}
+	user := bluedb.QueryUserByEmail(token.Email)
+	// only the project of the domain may take over the existing users of it
+	if user != nil && (len(c.EmailDomain) == 0 || emailDomain(user.Email) != c.EmailDomain) {
+		return nil, fmt.Errorf("email(%s) is registered without sso", token.Email)
+	}
+	if user == nil {
+		name := token.PreferredUsername
+		if len(name) == 0 || len(name) >= 60 {
+			name = strings.SplitN(token.Email, "@", 2)[0]
+		}
+		name, err := uniqueUserName(name)
+		if err != nil {
+			return nil, err
+		}
+		// no password, the user logs in by sso only
+		u := bluedb.User{
+			Name:   name,
+			Email:  token.Email,
+			Status: Confirmed,
+		}
+		u.Id = bluedb.CreateUser(u)
+		if len(u.Id) == 0 {
+			return nil, errors.New("create user fail")
+		}
+		if err := bluedb.CreateUserProject(u); err != nil {
+			return nil, err
+		}
+		logs.Info("provision user(%s) by sso of project(%s)", u.Id, c.ProjectId)
+		user = &u
+	} else if user.Status != Confirmed {
+		// the issuer verified the email
+		user.Status = Confirmed
+		if err := bluedb.UpdateUser(*user); err != nil {
+			return nil, err
+		}
+	}
+	if err := bluedb.SaveSsoIdentity(c.Issuer, token.Subject, user.Id); err != nil {
+		return nil, err
+	}
+	return user, nil
+}
+
+// SsoDiscover tells the dashboard whether the email logs in by sso.
+func SsoDiscover(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	c := bluedb.QuerySsoConfigByDomain(emailDomain(req.URL.Query().Get("email")))
+	if c == nil {
+		DefaultHandler.ServeHTTP(w, req, errors.New("no sso for the email"), http.StatusNotFound)
+		return
+	}
+	writeJsonResp(w, http.StatusOK, SsoDiscoverResp{
+		ProjectId: c.ProjectId,
+		LoginUrl:  "/v1/sso/" + c.ProjectId + "/login",
+	})
+}
+
+// SsoLogin redirects the browser to the issuer of the project.
+func SsoLogin(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	c := bluedb.QuerySsoConfig(ps["projectId"])
+	if c == nil || !c.Enabled {
+		DefaultHandler.ServeHTTP(w, req, errors.New("sso is not enabled"), http.StatusNotFound)
+		return
+	}
+	p, err := oidc.Discover(c.Issuer)
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadGateway)
+		return
+	}
+	state, err1 := oidc.RandomString()
+	nonce, err2 := oidc.RandomString()
+	verifier, err3 := oidc.RandomString()
+	if err1 != nil || err2 != nil || err3 != nil {
+		DefaultHandler.ServeHTTP(w, req, errors.New("generate sso state fail"), http.StatusInternalServerError)
+		return
+	}
+	val, _ := json.Marshal(ssoState{
+		ProjectId: c.ProjectId,
+		Nonce:     nonce,
+		Verifier:  verifier,
+	})
+	sesscache.SetWithExpired(ssoStatePrefix+state, string(val), ssoStateExpire)
+	http.Redirect(w, req, ssoClient(c, req).AuthCodeURL(p, state, nonce, verifier), http.StatusFound)
+}
+
+// SsoCallback finishes the login at the issuer, the tokens are passed to the
+// dashboard in the fragment of the url, so they do not reach any server log.
+func SsoCallback(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	q := req.URL.Query()
+	if e := q.Get("error"); len(e) > 0 {
+		logs.Error("sso login err:%s %s", e, q.Get("error_description"))
+		ssoFail(w, req, e)
+		return
+	}
+	val := sesscache.Take(ssoStatePrefix + q.Get("state"))
+	var state ssoState
+	if len(val) == 0 || json.Unmarshal([]byte(val), &state) != nil {
+		ssoFail(w, req, "invalid_state")
+		return
+	}
+	c := bluedb.QuerySsoConfig(state.ProjectId)
+	if c == nil || !c.Enabled {
+		ssoFail(w, req, "sso_disabled")
+		return
+	}
+	p, err := oidc.Discover(c.Issuer)
+	if err != nil {
+		ssoFail(w, req, "server_error")
+		return
+	}
+	token, err := ssoClient(c, req).Exchange(p, q.Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		logs.Error("sso of project(%s) err:%s", c.ProjectId, err.Error())
+		ssoFail(w, req, "invalid_token")
+		return
+	}
+	role := ssoRole(c, token)
+	if !middleware.ValidRole(role) {
+		logs.Error("subject(%s) of project(%s) has no role", token.Subject, c.ProjectId)
+		ssoFail(w, req, "access_denied")
+		return
+	}
+	user, err := ssoUser(c, token)
+	if err != nil {
+		logs.Error("sso user of project(%s) err:%s", c.ProjectId, err.Error())
+		ssoFail(w, req, "access_denied")
+		return
+	}
+	// the groups at the issuer decide the role, but the last owner is kept
+	if role != common.RoleOwner && isLastOwner(c.ProjectId, user.Id) {
+		role = common.RoleOwner
+	}
+	if err := bluedb.SaveMembership(c.ProjectId, user.Id, role); err != nil {
+		ssoFail(w, req, "server_error")
+		return
+	}
+	middleware.ForgetRole(user.Id, c.ProjectId)
+
+	// the second factor is up to the issuer
+	tokens, err := middleware.NewSession(user.Id, platformRoles(user), true, req)
+	if err != nil {
+		logs.Error("new session err:%s", err.Error())
+		ssoFail(w, req, "server_error")
+		return
+	}
+	sesscache.SetWithNoExpired("lastLogin_"+user.Id, time.Now().Format(time.RFC3339))
+	logs.Info("user(%s) login by sso of project(%s), session:%s", user.Id, c.ProjectId, tokens.SessionId)
+
+	addr := conf.GetString("sso_success_addr")
+	if len(addr) == 0 {
+		addr = conf.GetString("redirect_addr") + "/feasycom/sso"
+	}
+	fragment := url.Values{
+		"token":         {tokens.AccessToken},
+		"refresh_token": {tokens.RefreshToken},
+		"expires_in":    {strconv.FormatInt(tokens.ExpiresIn, 10)},
+		"user_id":       {user.Id},
+		"project_id":    {c.ProjectId},
+	}
+	http.Redirect(w, req, addr+"#"+fragment.Encode(), http.StatusFound)
+}
+
+// ssoConfigInfo is the config without the secret.
+func ssoConfigInfo(c *bluedb.SsoConfig) *SsoConfigReq {
+	info := &SsoConfigReq{
+		Issuer:      c.Issuer,
+		ClientId:    c.ClientId,
+		Scopes:      strings.Fields(c.Scopes),
+		EmailDomain: c.EmailDomain,
+		GroupsClaim: c.GroupsClaim,
+		DefaultRole: c.DefaultRole,
+		Enabled:     c.Enabled,
+		HasSecret:   len(c.ClientSecret) > 0,
+	}
+	if len(c.RoleMapping) > 0 {
+		_ = json.Unmarshal([]byte(c.RoleMapping), &info.RoleMapping)
+	}
+	return info
+}
+
+func GetSsoConfig(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	c := bluedb.QuerySsoConfig(ps["projectId"])
+	if c == nil {
+		DefaultHandler.ServeHTTP(w, req, errors.New("sso is not configured"), http.StatusNotFound)
+		return
+	}
+	resp := ssoConfigInfo(c)
+	resp.RedirectUrl = ssoRedirectUrl(req)
+	writeJsonResp(w, http.StatusOK, resp)
+}
+
+// PutSsoConfig sets the sso of the project, the issuer is discovered first
+// when it is enabled. An empty secret keeps the saved one.
+// claimsDomain tells whether saving the config changes who logs in the users
+// of an email domain, by setting the domain or by moving it to another issuer.
+func claimsDomain(old *bluedb.SsoConfig, issuer, domain string) bool {
+	if old == nil {
+		return len(domain) > 0
+	}
+	return old.EmailDomain != domain || (len(domain) > 0 && old.Issuer != issuer)
+}
+
+func PutSsoConfig(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	projectId := ps["projectId"]
+	var configReq SsoConfigReq
+	if !readJsonBody(w, req, &configReq) {
+		return
+	}
+	issuer := strings.TrimSuffix(strings.TrimSpace(configReq.Issuer), "/")
+	if u, err := url.Parse(issuer); err != nil || (u.Scheme != "https" && u.Scheme != "http") || len(u.Host) == 0 {
+		DefaultHandler.ServeHTTP(w, req, fmt.Errorf("invalid issuer(%s)", configReq.Issuer), http.StatusBadRequest)
+		return
+	}
+	for group, role := range configReq.RoleMapping {
+		if !middleware.ValidRole(role) {
+			DefaultHandler.ServeHTTP(w, req, fmt.Errorf("invalid role(%s) of group(%s)", role, group), http.StatusBadRequest)
+			return
+		}
+	}
+	domain := strings.ToLower(strings.TrimSpace(configReq.EmailDomain))
+	old := bluedb.QuerySsoConfig(projectId)
+	// claiming a domain takes its users over, only platform admins do it
+	if claimsDomain(old, issuer, domain) {
+		if conf.GetInt("enable_auth") != -1 && !middleware.Allowed(middleware.CurrentSession(req), projectId, middleware.PermPlatform, "") {
+			DefaultHandler.ServeHTTP(w, req, errors.New("only admins can set the email domain or its issuer"), http.StatusForbidden)
+			return
+		}
+	}
+	if other := bluedb.QuerySsoConfigByDomain(domain); other != nil && other.ProjectId != projectId {
+		DefaultHandler.ServeHTTP(w, req, fmt.Errorf("domain(%s) is used by another project", domain), http.StatusConflict)
+		return
+	}
+	if configReq.Enabled {
+		if _, err := oidc.Discover(issuer); err != nil {
+			DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+			return
+		}
+	}
+	mapping, _ := json.Marshal(configReq.RoleMapping)
+	c := bluedb.SsoConfig{
+		ProjectId:    projectId,
+		Issuer:       issuer,
+		ClientId:     configReq.ClientId,
+		ClientSecret: configReq.ClientSecret,
+		Scopes:       strings.Join(configReq.Scopes, " "),
+		EmailDomain:  domain,
+		GroupsClaim:  configReq.GroupsClaim,
+		RoleMapping:  string(mapping),
+		DefaultRole:  configReq.DefaultRole,
+		Enabled:      configReq.Enabled,
+	}
+	if old != nil && len(c.ClientSecret) == 0 {
+		c.ClientSecret = old.ClientSecret
+	}
+	if err := bluedb.SaveSsoConfig(c); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	var before *SsoConfigReq
+	if old != nil {
+		before = ssoConfigInfo(old)
+	}
+	middleware.AuditChange(req, before, ssoConfigInfo(&c))
+	w.WriteHeader(http.StatusOK)
+}
+
+func DeleteSsoConfig(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	if old := bluedb.QuerySsoConfig(ps["projectId"]); old != nil {
+		middleware.AuditChange(req, ssoConfigInfo(old), nil)
+	}
+	if err := bluedb.DeleteSsoConfig(ps["projectId"]); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,77 @@
+package controller
+
+import (
+	"encoding/json"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/sesscache"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+)
+
+// ssoCallbackError returns the error the callback redirects the browser with.
+func ssoCallbackError(t *testing.T, query string) string {
+	req := httptest.NewRequest(http.MethodGet, ssoCallback+"?"+query, nil)
+	w := httptest.NewRecorder()
+	SsoCallback(w, req, nil)
+	if w.Code != http.StatusFound {
+		t.Fatalf("callback returns %d, want %d", w.Code, http.StatusFound)
+	}
+	u, err := url.Parse(w.Header().Get("Location"))
+	if err != nil {
+		t.Fatalf("invalid location(%s)", w.Header().Get("Location"))
+	}
+	return u.Query().Get("error")
+}
+
+func TestSsoCallbackState(t *testing.T) {
+	if e := ssoCallbackError(t, "code=c&state=unknown"); e != "invalid_state" {
+		t.Errorf("unknown state fails with %q", e)
+	}
+	if e := ssoCallbackError(t, "code=c"); e != "invalid_state" {
+		t.Errorf("missing state fails with %q", e)
+	}
+
+	val, _ := json.Marshal(ssoState{ProjectId: testProjectId, Nonce: "n", Verifier: "v"})
+	sesscache.SetWithExpired(ssoStatePrefix+"s-1", string(val), ssoStateExpire)
+	// the state is accepted, the project has no sso config in tests
+	if e := ssoCallbackError(t, "code=c&state=s-1"); e != "sso_disabled" {
+		t.Errorf("stored state fails with %q", e)
+	}
+	if e := ssoCallbackError(t, "code=c&state=s-1"); e != "invalid_state" {
+		t.Errorf("reused state fails with %q", e)
+	}
+}
+
+func TestSsoCallbackIssuerError(t *testing.T) {
+	if e := ssoCallbackError(t, "error=access_denied&state=s-2"); e != "access_denied" {
+		t.Errorf("issuer error is passed as %q", e)
+	}
+}
+
+func TestSsoClaimsDomain(t *testing.T) {
+	const issuer = "https://idp.example.com"
+	old := &bluedb.SsoConfig{Issuer: issuer, EmailDomain: "example.com"}
+	noDomain := &bluedb.SsoConfig{Issuer: issuer}
+	for i, c := range []struct {
+		old    *bluedb.SsoConfig
+		issuer string
+		domain string
+		claims bool
+	}{
+		{nil, issuer, "", false},
+		{nil, issuer, "example.com", true},
+		{old, issuer, "example.com", false},
+		{old, issuer, "other.com", true},
+		{old, issuer, "", true},
+		// the users of the domain would log in at another issuer
+		{old, "https://evil.example.net", "example.com", true},
+		{noDomain, "https://idp2.example.com", "", false},
+		{noDomain, issuer, "example.com", true},
+	} {
+		if got := claimsDomain(c.old, c.issuer, c.domain); got != c.claims {
+			t.Errorf("case %d: claims domain %v, want %v", i, got, c.claims)
+		}
+	}
+}
@@ -0,0 +1,282 @@
+package controller
+
+import (
+	"encoding/json"
+	"errors"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/bluedb"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/controller/middleware"
+	"github.com/ssrs100/blueserver/sesscache"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+const (
+	mfaLoginPrefix = "mfaLogin_"
+	mfaLoginExpire = 5 * time.Minute
+	// wrong codes allowed for a password login
+	mfaLoginTries = 5
+
+	totpStepPrefix = "totpStep_"
+	// longer than a code is accepted with the clock skew
+	totpStepExpire = 2 * time.Minute
+)
+
+var errInvalidCode = errors.New("invalid code")
+
+// mfaChallenge is the password login waiting for the second factor.
+type mfaChallenge struct {
+	UserId string `json:"user_id"`
+	Tries  int    `json:"tries"`
+}
+
+type TwoFactorReq struct {
+	MfaToken     string `json:"mfa_token,omitempty" valid:"MaxSize(128)"`
+	Code         string `json:"code" valid:"MaxSize(16)"`
+	RecoveryCode string `json:"recovery_code" valid:"MaxSize(32)"`
+}
+
+type TwoFactorInfo struct {
+	Enabled           bool `json:"enabled"`
+	RecoveryCodesLeft int  `json:"recovery_codes_left"`
+}
+
+type TwoFactorEnrollResp struct {
+	Secret string `json:"secret"`
+	// otpauth uri for the QR code
+	Uri string `json:"uri"`
+}
+
+type RecoveryCodesResp struct {
+	RecoveryCodes []string `json:"recovery_codes"`
+}
+
+func newMfaChallenge(userId string) (string, error) {
+	token, err := generateResetToken()
+	if err != nil {
+		return "", err
+	}
+	val, _ := json.Marshal(mfaChallenge{UserId: userId})
+	sesscache.SetWithExpired(mfaLoginPrefix+token, string(val), mfaLoginExpire)
+	return token, nil
+}
+
+// checkTotp checks the code of the user, a code can not be used twice.
+func checkTotp(tf *bluedb.TwoFactor, code string) bool {
+	step, ok := common.CheckTotp(tf.Secret, code, time.Now())
+	if !ok {
+		return false
+	}
+	// the step is claimed atomically, of concurrent logins with the same
+	// code only one gets it
+	stepKey := totpStepPrefix + tf.UserId + "_" + strconv.FormatInt(step, 10)
+	if !sesscache.SetNX(stepKey, "1", totpStepExpire) {
+		logs.Warn("replayed totp code of user(%s)", tf.UserId)
+		return false
+	}
+	// a code older than the last used one is rejected as well
+	lastKey := totpStepPrefix + tf.UserId
+	if last, err := strconv.ParseInt(sesscache.Get(lastKey), 10, 64); err == nil && step < last {
+		logs.Warn("outdated totp code of user(%s)", tf.UserId)
+		return false
+	}
+	sesscache.SetWithExpired(lastKey, strconv.FormatInt(step, 10), totpStepExpire)
+	return true
+}
+
+// checkSecondFactor checks the totp code or a recovery code, a recovery code
+// is used up.
+func checkSecondFactor(tf *bluedb.TwoFactor, r *TwoFactorReq) bool {
+	if len(r.Code) > 0 {
+		return checkTotp(tf, r.Code)
+	}
+	if len(r.RecoveryCode) > 0 {
+		return bluedb.UseRecoveryCode(tf.UserId, common.HashRecoveryCode(r.RecoveryCode))
+	}
+	return false
+}
+
+// LoginTwoFactor finishes the password login with the second factor.
+func LoginTwoFactor(w http.ResponseWriter, req *http.Request, _ map[string]string) {
+	var mfaReq TwoFactorReq
+	if !readJsonBody(w, req, &mfaReq) {
+		return
+	}
+	key := mfaLoginPrefix + mfaReq.MfaToken
+	val := sesscache.Get(key)
+	var challenge mfaChallenge
+	if len(mfaReq.MfaToken) == 0 || len(val) == 0 || json.Unmarshal([]byte(val), &challenge) != nil {
+		DefaultHandler.ServeHTTP(w, req, errors.New("invalid mfa token or expired"), http.StatusUnauthorized)
+		return
+	}
+	tf := bluedb.QueryTwoFactor(challenge.UserId)
+	user, err := bluedb.QueryUserById(challenge.UserId)
+	if tf == nil || !tf.Enabled || err != nil {
+		sesscache.Del(key)
+		DefaultHandler.ServeHTTP(w, req, errors.New("invalid mfa token or expired"), http.StatusUnauthorized)
+		return
+	}
+	failKey := loginFailKey(&user, "")
+	if loginLocked(w, failKey) {
+		return
+	}
+	if !checkSecondFactor(tf, &mfaReq) {
+		loginFailed(failKey)
+		challenge.Tries++
+		if challenge.Tries >= mfaLoginTries {
+			logs.Warn("too many wrong codes of user(%s)", challenge.UserId)
+			sesscache.Del(key)
+		} else {
+			val, _ := json.Marshal(challenge)
+			sesscache.SetWithExpired(key, string(val), mfaLoginExpire)
+		}
+		DefaultHandler.ServeHTTP(w, req, errInvalidCode, http.StatusUnauthorized)
+		return
+	}
+	// the token can not be used again
+	if len(sesscache.Take(key)) == 0 {
+		DefaultHandler.ServeHTTP(w, req, errors.New("invalid mfa token or expired"), http.StatusUnauthorized)
+		return
+	}
+	loginUser(w, req, &user, true)
+}
+
+func GetTwoFactor(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	info := TwoFactorInfo{}
+	if tf := bluedb.QueryTwoFactor(ps["projectId"]); tf != nil && tf.Enabled {
+		info.Enabled = true
+		info.RecoveryCodesLeft = len(tf.RecoveryHashes())
+	}
+	writeJsonResp(w, http.StatusOK, info)
+}
+
+// EnrollTwoFactor generates the secret of the user, it is enabled by
+// ActivateTwoFactor with the first code.
+func EnrollTwoFactor(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	userId := ps["projectId"]
+	user, err := bluedb.QueryUserById(userId)
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, errors.New("user not found"), http.StatusNotFound)
+		return
+	}
+	if tf := bluedb.QueryTwoFactor(userId); tf != nil && tf.Enabled {
+		DefaultHandler.ServeHTTP(w, req, errors.New("two-factor authentication is enabled already"), http.StatusConflict)
+		return
+	}
+	secret, err := common.NewTotpSecret()
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	if err := bluedb.SaveTwoFactor(bluedb.TwoFactor{UserId: userId, Secret: secret}); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	issuer := conf.GetString("totp_issuer")
+	if len(issuer) == 0 {
+		issuer = "Feasycom"
+	}
+	account := user.Email
+	if len(account) == 0 {
+		account = user.Name
+	}
+	writeJsonResp(w, http.StatusOK, TwoFactorEnrollResp{
+		Secret: secret,
+		Uri:    common.TotpURI(issuer, account, secret),
+	})
+}
+
+// ActivateTwoFactor enables the enrolled secret with its first code and
+// returns the recovery codes, the current session counts as passed.
+func ActivateTwoFactor(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	userId := ps["projectId"]
+	var mfaReq TwoFactorReq
+	if !readJsonBody(w, req, &mfaReq) {
+		return
+	}
+	tf := bluedb.QueryTwoFactor(userId)
+	if tf == nil || tf.Enabled {
+		DefaultHandler.ServeHTTP(w, req, errors.New("no two-factor enrollment"), http.StatusConflict)
+		return
+	}
+	if !checkTotp(tf, mfaReq.Code) {
+		DefaultHandler.ServeHTTP(w, req, errInvalidCode, http.StatusBadRequest)
+		return
+	}
+	codes, hashes, err := common.NewRecoveryCodes()
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	tf.Enabled = true
+	tf.SetRecoveryHashes(hashes)
+	if err := bluedb.SaveTwoFactor(*tf); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	if us := middleware.CurrentSession(req); us != nil && us.UserId == userId {
+		middleware.MarkSessionMfa(us.SessionId)
+	}
+	logs.Info("user(%s) enabled two-factor authentication", userId)
+	writeJsonResp(w, http.StatusOK, RecoveryCodesResp{RecoveryCodes: codes})
+}
+
+// DisableTwoFactor needs a code of the user, platform admins may disable it
+// for users who lost their device and recovery codes.
+func DisableTwoFactor(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	userId := ps["projectId"]
+	var mfaReq TwoFactorReq
+	if !readJsonBody(w, req, &mfaReq) {
+		return
+	}
+	tf := bluedb.QueryTwoFactor(userId)
+	if tf == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	us := middleware.CurrentSession(req)
+	isAdmin := us != nil && us.UserId != userId && middleware.Allowed(us, "", middleware.PermPlatform, "")
+	if tf.Enabled && !isAdmin && !checkSecondFactor(tf, &mfaReq) {
+		DefaultHandler.ServeHTTP(w, req, errInvalidCode, http.StatusBadRequest)
+		return
+	}
+	if err := bluedb.DeleteTwoFactor(userId); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	logs.Info("two-factor authentication of user(%s) disabled", userId)
+	w.WriteHeader(http.StatusOK)
+}
+
+// RegenerateRecoveryCodes replaces the recovery codes, the old ones are
+// invalid afterwards.
+func RegenerateRecoveryCodes(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	userId := ps["projectId"]
+	var mfaReq TwoFactorReq
+	if !readJsonBody(w, req, &mfaReq) {
+		return
+	}
+	tf := bluedb.QueryTwoFactor(userId)
+	if tf == nil || !tf.Enabled {
+		DefaultHandler.ServeHTTP(w, req, errors.New("two-factor authentication is not enabled"), http.StatusConflict)
+		return
+	}
+	if !checkTotp(tf, mfaReq.Code) {
+		DefaultHandler.ServeHTTP(w, req, errInvalidCode, http.StatusBadRequest)
+		return
+	}
+	codes, hashes, err := common.NewRecoveryCodes()
+	if err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	tf.SetRecoveryHashes(hashes)
+	if err := bluedb.SaveTwoFactor(*tf); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	writeJsonResp(w, http.StatusOK, RecoveryCodesResp{RecoveryCodes: codes})
+}
@@ -0,0 +1,56 @@
+package controller
+
+import (
+	"crypto/hmac"
+	"crypto/sha1"
+	"encoding/base32"
+	"encoding/binary"
+	"fmt"
+	"github.com/ssrs100/blueserver/bluedb"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestTotpReplay(t *testing.T) {
+	const secret = "GEZDGNBVGY3TQOJQGEZDGNBVGY3TQOJQ"
+	tf := &bluedb.TwoFactor{UserId: "u-totp", Secret: secret}
+	code := currentTotp(t, secret)
+
+	// of concurrent logins with the same code only one succeeds
+	var accepted int32
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if checkTotp(tf, code) {
+				atomic.AddInt32(&accepted, 1)
+			}
+		}()
+	}
+	wg.Wait()
+	if accepted != 1 {
+		t.Errorf("code is accepted %d times", accepted)
+	}
+	if checkTotp(tf, code) {
+		t.Errorf("code is accepted again")
+	}
+}
+
+// currentTotp computes the code of the secret valid now as an
+// authenticator app does.
+func currentTotp(t *testing.T, secret string) string {
+	key, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(secret)
+	if err != nil {
+		t.Fatalf("decode secret fail, err:%s", err.Error())
+	}
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, uint64(time.Now().Unix()/30))
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+	offset := sum[len(sum)-1] & 0x0f
+	return fmt.Sprintf("%06d", (binary.BigEndian.Uint32(sum[offset:offset+4])&0x7fffffff)%1000000)
+}
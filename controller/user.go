@@ -1,10 +1,11 @@
 package controller
 
 import (
+	"crypto/rand"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
-	"github.com/astaxie/beego/orm"
 	"github.com/astaxie/beego/utils"
 	"github.com/fernet/fernet-go"
 	"github.com/jack0liu/conf"
@@ -15,8 +16,6 @@ import (
 	"github.com/ssrs100/blueserver/controller/middleware"
 	"github.com/ssrs100/blueserver/mqttclient"
 	"github.com/ssrs100/blueserver/sesscache"
-	"io/ioutil"
-	"math/rand"
 	"net/http"
 	"strconv"
 	"strings"
@@ -26,30 +25,39 @@ import (
 const (
 	Confirmed   = 1
 	UnConfirmed = 0
+
+	resetTokenPrefix = "pwreset_"
+	resetMailPrefix  = "resetMail_"
+	loginFailPrefix  = "loginFail_"
 )
 
 type User struct {
 	Id      string `json:"id"`
-	Name    string `json:"name"`
-	Passwd  string `json:"passwd"`
-	Email   string `json:"email"`
-	Mobile  string `json:"mobile"`
-	Address string `json:"address"`
+	Name    string `json:"name" valid:"MaxSize(128)"`
+	Passwd  string `json:"passwd" valid:"MaxSize(128)"`
+	Email   string `json:"email" valid:"MaxSize(128)"`
+	Mobile  string `json:"mobile" valid:"MaxSize(128)"`
+	Address string `json:"address" valid:"MaxSize(512)"`
 }
 
 type ResetPassword struct {
-	Email      string `json:"email"`
-	VerifyCode string `json:"verify_code"`
+	Token  string `json:"token" valid:"MaxSize(128)"`
+	Passwd string `json:"passwd" valid:"Required"`
+}
+
+type ChangePassword struct {
+	OldPasswd string `json:"old_passwd" valid:"Required"`
+	NewPasswd string `json:"new_passwd"`
 }
 
 type Verify struct {
-	Email      string `json:"email"`
+	Email      string `json:"email" valid:"Required;MaxSize(128)"`
 }
 
 type BindAwsUserReq struct {
-	Name      string `json:"aws_username"`
-	AccessKey string `json:"aws_access_key"`
-	SecretKey string `json:"aws_secret_key"`
+	Name      string `json:"aws_username" valid:"MaxSize(128)"`
+	AccessKey string `json:"aws_access_key" valid:"MaxSize(128)"`
+	SecretKey string `json:"aws_secret_key" valid:"MaxSize(128)"`
 }
 
 func (u *User) dbObjectTrans(beacon bluedb.User) User {
@@ -90,13 +98,13 @@ func GetUsers(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 	logs.Debug("params:%v", params)
 
 	users := bluedb.QueryUsers(params)
-	logs.Debug("users:%v", users)
+	logs.Debug("list %d users", len(users))
 	if len(users) <= 0 {
 		users = []bluedb.User{}
 	}
 	var u = User{}
-	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(u.dbListObjectTrans(users))
 }
 
@@ -105,29 +113,28 @@ type CreateUserResponse struct {
 }
 
 type UserLoginResponse struct {
+	// the project created with the user
 	ProjectId string `json:"project_id"`
-	Token     string `json:"token"`
+	// all projects the user is a member of
+	Projects     []*ProjectInfo `json:"projects,omitempty"`
+	Token        string `json:"token,omitempty"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+	// seconds until the token expires
+	ExpiresIn int64 `json:"expires_in"`
+	// the login is finished by LoginTwoFactor with the mfa token
+	MfaRequired bool   `json:"mfa_required,omitempty"`
+	MfaToken    string `json:"mfa_token,omitempty"`
 }
 
 func UserLogin(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 	logs.Info("login user start...")
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
-	}
-	defer req.Body.Close()
-
 	var userReq = &User{}
-	err = json.Unmarshal(body, userReq)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	if !readJsonBody(w, req, userReq) {
 		return
 	}
 
 	var user *bluedb.User
+	var err error
 	if len(userReq.Name) > 0 {
 		user, err = bluedb.QueryUserByName(userReq.Name)
 		if err != nil {
@@ -135,18 +142,12 @@ func UserLogin(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 			DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
 			return
 		}
-		if user == nil || user.Passwd != userReq.Passwd {
-			strErr := "invalid user or passwd."
-			logs.Error(strErr)
-			DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusBadRequest)
+		if !passLogin(w, req, user, userReq.Name, userReq.Passwd, "invalid user or passwd.") {
 			return
 		}
 	} else if len(userReq.Email) > 0 {
 		user = bluedb.QueryUserByEmail(userReq.Email)
-		if user == nil || user.Passwd != userReq.Passwd {
-			strErr := "invalid email or passwd."
-			logs.Error(strErr)
-			DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusBadRequest)
+		if !passLogin(w, req, user, userReq.Email, userReq.Passwd, "invalid email or passwd.") {
 			return
 		}
 	} else {
@@ -163,51 +164,67 @@ func UserLogin(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 		return
 	}
 
-	// gen token
-	now := time.Now().UTC()
-	us := middleware.UserSession{
-		UserId:    user.Id,
-		Roles:     []string{"te_admin"},
-		CreatedAt: now.Format(time.RFC3339),
-		ExpiredAt: now.Add(time.Hour * 24).Format(time.RFC3339),
-	}
-	key := fernet.Key{}
-	err = key.Generate()
-	if err != nil {
-		logs.Error("Invalid key. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+	if tf := bluedb.QueryTwoFactor(user.Id); tf != nil && tf.Enabled {
+		mfaToken, err := newMfaChallenge(user.Id)
+		if err != nil {
+			logs.Error("new mfa challenge err:%s", err.Error())
+			DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+			return
+		}
+		logs.Info("user(%s) login needs a second factor", user.Id)
+		w.Header().Add("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UserLoginResponse{
+			ProjectId:   user.Id,
+			MfaRequired: true,
+			MfaToken:    mfaToken,
+		})
 		return
 	}
-	sId := key.Encode()
-	sess, err := json.Marshal(&us)
-	if err != nil {
-		logs.Error("Invalid sess. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
-		return
+	loginUser(w, req, user, false)
+}
+
+// platformRoles returns the platform roles of the user, a user is the
+// platform admin by its role column or by its name in the platform_admins
+// config, a comma separated list of user names.
+func platformRoles(user *bluedb.User) []string {
+	roles := make([]string, 0)
+	if user.Role == common.RoleAdmin {
+		return append(roles, common.RoleAdmin)
+	}
+	for _, name := range strings.Split(conf.GetString("platform_admins"), ",") {
+		if name = strings.TrimSpace(name); len(name) > 0 && name == user.Name {
+			return append(roles, common.RoleAdmin)
+		}
 	}
-	k := fernet.MustDecodeKeys(sId)
-	tok, err := fernet.EncryptAndSign(sess, k[0])
+	return roles
+}
+
+// loginUser starts a session of the authenticated user and returns its
+// tokens, mfa tells whether the user passed a second factor.
+func loginUser(w http.ResponseWriter, req *http.Request, user *bluedb.User, mfa bool) {
+	tokens, err := middleware.NewSession(user.Id, platformRoles(user), mfa, req)
 	if err != nil {
-		logs.Error("encrypt sess. err:%s", err.Error())
+		logs.Error("new session err:%s", err.Error())
 		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
 		return
 	}
+	middleware.ResetHits(loginFailKey(user, ""))
+	middleware.AuditActor(req, user.Id)
 	http.SetCookie(w, &http.Cookie{
 		Name:  common.CookieSessionId,
-		Value: string(tok),
+		Value: tokens.AccessToken,
 		Path:  "/",
 	})
-	sesscache.SetWithNoExpired("lastLogin_"+us.UserId, time.Now().Format(time.RFC3339))
-	sesscache.SetWithExpired(string(tok), sId, time.Hour*24*7)
-	logs.Info("key:%s", sId)
-	logs.Info("session:%s", string(tok))
+	sesscache.SetWithNoExpired("lastLogin_"+user.Id, time.Now().Format(time.RFC3339))
+	logs.Info("user(%s) login, session:%s", user.Id, tokens.SessionId)
 	// return
-	w.Header().Add("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(UserLoginResponse{
-		ProjectId: user.Id,
-		Token:     string(tok),
+	writeJsonResp(w, http.StatusOK, UserLoginResponse{
+		ProjectId:    user.Id,
+		Projects:     userProjects(user.Id),
+		Token:        tokens.AccessToken,
+		RefreshToken: tokens.RefreshToken,
+		ExpiresIn:    tokens.ExpiresIn,
 	})
-	w.WriteHeader(http.StatusOK)
 }
 
 func ActiveUser(w http.ResponseWriter, req *http.Request, _ map[string]string) {
@@ -220,7 +237,6 @@ func ActiveUser(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 		http.Redirect(w, req, failAddr, http.StatusFound)
 		return
 	}
-	logs.Info("active user key:%s", k)
 	keys := fernet.MustDecodeKeys(k)
 	tokenStr := fernet.VerifyAndDecrypt([]byte(token), 0, keys)
 	if len(tokenStr) == 0 {
@@ -251,19 +267,8 @@ func ActiveUser(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 }
 
 func CreateUser(w http.ResponseWriter, req *http.Request, _ map[string]string) {
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
-	}
-	defer req.Body.Close()
-
 	var userReq = &User{}
-	err = json.Unmarshal(body, userReq)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	if !readJsonBody(w, req, userReq) {
 		return
 	}
 	name := strings.TrimSpace(userReq.Name)
@@ -274,10 +279,9 @@ func CreateUser(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 		return
 	}
 	passwd := strings.TrimSpace(userReq.Passwd)
-	if len(passwd) <= 6 || len(passwd) > 120 {
-		strErr := fmt.Sprintf("Passwd is less 6 or exceed 120 bytes.")
-		logs.Error(strErr)
-		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusBadRequest)
+	if err := checkPasswdLen(passwd); err != nil {
+		logs.Error(err.Error())
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
 		return
 	}
 
@@ -288,16 +292,23 @@ func CreateUser(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusBadRequest)
 		return
 	}
+	// the users of a tenant with sso are provisioned on their first login
+	if bluedb.QuerySsoConfigByDomain(emailDomain(email)) != nil {
+		strErr := fmt.Sprintf("Email(%s) must log in by SSO.", email)
+		logs.Error(strErr)
+		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusBadRequest)
+		return
+	}
 
 	user := bluedb.QueryUserByEmail(email)
 	if user != nil && user.Status == Confirmed {
 		strErr := fmt.Sprintf("Email(%s) has been registed.", email)
 		logs.Error(strErr)
-		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusBadRequest)
+		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusConflict)
 		return
 	}
 
-	user, err = bluedb.QueryUserByName(name)
+	user, err := bluedb.QueryUserByName(name)
 	if err != nil {
 		strErr := fmt.Sprintf("get username(%s) err:%s.", name, err.Error())
 		logs.Error(strErr)
@@ -307,16 +318,22 @@ func CreateUser(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 	if user != nil && user.Status == Confirmed {
 		strErr := fmt.Sprintf("username(%s) has been registed.", name)
 		logs.Error(strErr)
-		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusBadRequest)
+		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusConflict)
 		return
 	}
 
 	userId := ""
 	if user == nil {
-		logs.Info("create user:%v", userReq)
+		logs.Info("create user(%s)", name)
+		hash, err := common.HashPasswd(passwd)
+		if err != nil {
+			logs.Error("hash passwd err:%s", err.Error())
+			DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+			return
+		}
 		var userDb = bluedb.User{
 			Name:    name,
-			Passwd:  passwd,
+			Passwd:  hash,
 			Email:   email,
 			Mobile:  userReq.Mobile,
 			Address: userReq.Address,
@@ -328,6 +345,13 @@ func CreateUser(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 			DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
 			return
 		}
+		// the project of the user has the id of the user
+		userDb.Id = userId
+		if err := bluedb.CreateUserProject(userDb); err != nil {
+			logs.Error("Create project fail. err:%s", err.Error())
+			DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+			return
+		}
 	} else {
 		logs.Info("unconfirmed user(%s)", name)
 		userId = user.Id
@@ -367,8 +391,8 @@ func CreateUser(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 	if mqttclient.Client != nil {
 		mqttclient.Client.NotifyUserAdd(name, passwd, userId)
 	}
-	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	//_, _ = w.Write([]byte("Please login your email to active your account in 20 minutes."))
 }
 
@@ -393,16 +417,20 @@ func sendActivateEmail(toUserEmails []string, token string) error {
 	return nil
 }
 
-func sendVerifyCodeEmail(toUserEmails []string, code string) error {
+// sendResetPasswdEmail mails the single-use link resetting the password.
+func sendResetPasswdEmail(toUserEmails []string, token string) error {
 	email := bluedb.GetSys("sysEmailUser")
 	pwd := bluedb.GetSys("sysEmailPwd")
 	config := fmt.Sprintf(`{"username":"%s","password":"%s","host":"smtp.exmail.qq.com","port":25}`, email, pwd)
 	temail := utils.NewEMail(config)
 	temail.To = toUserEmails
 	temail.From = email
-	temail.Subject = "Verify Code Check"
+	temail.Subject = "Reset Feasycom Account Password"
 
-	temail.HTML = fmt.Sprintf("Please use your Verify Code: <b>%s</b><br/>It will expire in 20 minutes.", code)
+	redirectAddr := conf.GetString("redirect_addr")
+	temail.HTML = "Please reset your password by clicking the following link:<br/>" +
+		"<href>" + redirectAddr + "/feasycom/reset?token=" + token + "</href><br/>" +
+		"It will expire in 20 minutes and can be used once."
 
 	err := temail.Send()
 	if err != nil {
@@ -412,171 +440,222 @@ func sendVerifyCodeEmail(toUserEmails []string, code string) error {
 	return nil
 }
 
-func generateVerifyCode() string {
-	length := 8
-	var code []byte = make([]byte, length, length)
-
-	sourceStr := NumStr
-	rand.Seed(time.Now().UnixNano())
-	for i := 0; i < length; i++ {
-		index := rand.Intn(len(sourceStr))
-		code[i] = sourceStr[index]
+func generateResetToken() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
 	}
-	return string(code)
+	return hex.EncodeToString(b), nil
 }
 
+func checkPasswdLen(passwd string) error {
+	if len(passwd) < common.PasswdMinLen || len(passwd) > common.PasswdMaxLen {
+		return errors.New("Passwd is less 6 or exceed 120 bytes.")
+	}
+	return nil
+}
 
-func SendVerifyCode(w http.ResponseWriter, req *http.Request, _ map[string]string) {
-	body, err := ioutil.ReadAll(req.Body)
+// checkLogin checks the password of the user, a plaintext or weak hash is
+// replaced by a new hash once the password is verified.
+func checkLogin(user *bluedb.User, passwd string) bool {
+	ok, rehash := common.CheckPasswd(user.Passwd, passwd)
+	if !ok || !rehash {
+		return ok
+	}
+	hash, err := common.HashPasswd(passwd)
+	if err == nil {
+		err = bluedb.UpdatePasswd(nil, user.Id, hash)
+	}
 	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
+		logs.Error("rehash passwd of user(%s) err:%s", user.Id, err.Error())
+	} else {
+		logs.Info("rehash passwd of user(%s)", user.Id)
+	}
+	return true
+}
+
+func loginFailKey(user *bluedb.User, ident string) string {
+	if user != nil {
+		return loginFailPrefix + user.Id
+	}
+	return loginFailPrefix + strings.ToLower(ident)
+}
+
+func loginLockThreshold() int {
+	return conf.GetIntWithDefault("login_lock_threshold", 5)
+}
+
+// loginFailed counts a failed login of the account, including the failed
+// second factors.
+func loginFailed(key string) {
+	duration := time.Duration(conf.GetIntWithDefault("login_lock_duration", 900)) * time.Second
+	middleware.Hit(key, loginLockThreshold(), duration)
+}
+
+// loginLocked rejects the login with 429 if the account is locked.
+func loginLocked(w http.ResponseWriter, key string) bool {
+	locked, wait := middleware.Blocked(key, loginLockThreshold())
+	if locked {
+		logs.Warn("login of %s is locked", key)
+		middleware.RetryAfter(w, wait)
+	}
+	return locked
+}
+
+// passLogin checks the password of the user, ident is the name or email the
+// user logs in with. The account is locked for a while after too many failed
+// logins, unknown accounts are counted the same way so that they can not be
+// told apart.
+func passLogin(w http.ResponseWriter, req *http.Request, user *bluedb.User, ident, passwd, strErr string) bool {
+	key := loginFailKey(user, ident)
+	if loginLocked(w, key) {
+		return false
+	}
+	if user == nil {
+		common.CheckNoPasswd(passwd)
 	}
-	defer req.Body.Close()
+	if user == nil || !checkLogin(user, passwd) {
+		loginFailed(key)
+		logs.Error(strErr)
+		DefaultHandler.ServeHTTP(w, req, errors.New(strErr), http.StatusBadRequest)
+		return false
+	}
+	return true
+}
 
+// ForgotPwd mails a reset link to the user of the email. It succeeds for
+// unknown emails too, so that it does not tell which emails are registered.
+func ForgotPwd(w http.ResponseWriter, req *http.Request, _ map[string]string) {
 	var verify = &Verify{}
-	err = json.Unmarshal(body, verify)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	if !readJsonBody(w, req, verify) {
 		return
 	}
 	user := bluedb.QueryUserByEmail(verify.Email)
 	if user == nil {
-		logs.Error("email is invalid :%s", verify.Email)
-		DefaultHandler.ServeHTTP(w, req, errors.New("not found email"), http.StatusBadRequest)
+		logs.Warn("reset password of unknown email:%s", verify.Email)
+		w.WriteHeader(http.StatusOK)
 		return
 	}
-	code := generateVerifyCode()
-	sesscache.SetWithExpired(user.Id + "_vc", code, 20*time.Minute)
-	if err := sendVerifyCodeEmail([]string{verify.Email}, code); err != nil {
+	if bluedb.QuerySsoConfigByDomain(emailDomain(user.Email)) != nil {
+		logs.Warn("reset password of sso email:%s", verify.Email)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if ok, _ := middleware.Hit(resetMailPrefix+user.Id, conf.GetIntWithDefault("reset_mail_rate", 3), time.Hour); !ok {
+		logs.Warn("too many reset mails to %s", verify.Email)
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	token, err := generateResetToken()
+	if err != nil {
+		logs.Error("generate reset token err:%s", err.Error())
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	sesscache.SetWithExpired(resetTokenPrefix+token, user.Id, 20*time.Minute)
+	if err := sendResetPasswdEmail([]string{verify.Email}, token); err != nil {
 		logs.Error("send email fail, err:%s", err.Error())
+		sesscache.Del(resetTokenPrefix + token)
 		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
 		return
 	}
 	w.WriteHeader(http.StatusOK)
-	w.Header().Add("Content-Type", "application/json")
 }
 
+// ResetPwd sets the new password of the user the reset link is mailed to,
+// the token of the link is deleted at once.
 func ResetPwd(w http.ResponseWriter, req *http.Request, _ map[string]string) {
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	var reset = &ResetPassword{}
+	if !readJsonBody(w, req, reset) {
 		return
 	}
-	defer req.Body.Close()
-
-	var reset = &ResetPassword{}
-	err = json.Unmarshal(body, reset)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
+	if err := checkPasswdLen(reset.Passwd); err != nil {
 		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
 		return
 	}
-	user := bluedb.QueryUserByEmail(reset.Email)
-	if user == nil {
-		logs.Error("email is invalid :%s", reset.Email)
-		DefaultHandler.ServeHTTP(w, req, errors.New("not found email"), http.StatusBadRequest)
-		return
+	userId := ""
+	if len(reset.Token) > 0 {
+		userId = sesscache.Take(resetTokenPrefix + reset.Token)
 	}
-	vc := sesscache.Get(user.Id + "_vc")
-	if vc != reset.VerifyCode {
-		logs.Error("Invalid verify code(%s) or expired.", reset.VerifyCode)
-		DefaultHandler.ServeHTTP(w, req, errors.New(fmt.Sprintf("Invalid verify code(%s) or expired.", reset.VerifyCode)), http.StatusBadRequest)
+	if len(userId) == 0 {
+		logs.Error("Invalid reset token or expired.")
+		DefaultHandler.ServeHTTP(w, req, errors.New("Invalid reset token or expired."), http.StatusBadRequest)
 		return
 	}
-	newPass := generatePasswd()
-	o := orm.NewOrm()
-	if err := o.Begin(); err != nil {
-		logs.Error("Invalid orm. err:%s", err.Error())
+	hash, err := common.HashPasswd(reset.Passwd)
+	if err != nil {
+		logs.Error("hash passwd err:%s", err.Error())
 		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
 		return
 	}
-	if err := bluedb.UpdatePasswd(o, user.Id, newPass); err != nil {
+	if err := bluedb.UpdatePasswd(nil, userId, hash); err != nil {
 		logs.Error("update pass err:%s", err.Error())
-		o.Rollback()
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
-		return
-	}
-	if err := sendResetPasswdEmail([]string{reset.Email}, newPass); err != nil {
-		logs.Error("send password email err:%s", err.Error())
-		o.Rollback()
 		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
 		return
 	}
-	o.Commit()
+	middleware.RevokeUserSessions(userId, "")
+	logs.Info("user(%s) reset passwd", userId)
 	w.WriteHeader(http.StatusOK)
-	w.Header().Add("Content-Type", "application/json")
 }
 
-const (
-	NumStr  = "0123456789"
-	CharStr = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
-	SpecStr = "+=-@#~,.[]()!%^*$"
-)
-
-func generatePasswd() string {
-	length := 8
-	var passwd []byte = make([]byte, length, length)
-
-	sourceStr := fmt.Sprintf("%s%s", NumStr, CharStr)
-	rand.Seed(time.Now().UnixNano())
-	for i := 0; i < length; i++ {
-		index := rand.Intn(len(sourceStr))
-		passwd[i] = sourceStr[index]
+// ChangePwd changes the password of the user, the old password is required.
+func ChangePwd(w http.ResponseWriter, req *http.Request, ps map[string]string) {
+	id := ps["projectId"]
+	var change = &ChangePassword{}
+	if !readJsonBody(w, req, change) {
+		return
 	}
-	return string(passwd)
-}
-
-func sendResetPasswdEmail(toUserEmails []string, newPass string) error {
-	email := bluedb.GetSys("sysEmailUser")
-	pwd := bluedb.GetSys("sysEmailPwd")
-	config := fmt.Sprintf(`{"username":"%s","password":"%s","host":"smtp.exmail.qq.com","port":25}`, email, pwd)
-	temail := utils.NewEMail(config)
-	temail.To = toUserEmails
-	temail.From = email
-	temail.Subject = "Reset Feasycom Account Password"
-
-	temail.HTML = fmt.Sprintf("Reset password success!<br/><br/>Please use your new password:<b>%s</b><br/>", newPass)
-
-	err := temail.Send()
+	if err := checkPasswdLen(change.NewPasswd); err != nil {
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+		return
+	}
+	user, err := bluedb.QueryUserById(id)
 	if err != nil {
-		logs.Error("send email fail, err:%s", err.Error())
-		return err
+		logs.Error("Get user fail. err:%s", err.Error())
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+		return
 	}
-	return nil
+	if ok, _ := common.CheckPasswd(user.Passwd, change.OldPasswd); !ok {
+		DefaultHandler.ServeHTTP(w, req, errors.New("invalid passwd."), http.StatusBadRequest)
+		return
+	}
+	hash, err := common.HashPasswd(change.NewPasswd)
+	if err != nil {
+		logs.Error("hash passwd err:%s", err.Error())
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	if err := bluedb.UpdatePasswd(nil, user.Id, hash); err != nil {
+		logs.Error("update pass err:%s", err.Error())
+		DefaultHandler.ServeHTTP(w, req, err, http.StatusInternalServerError)
+		return
+	}
+	current := ""
+	if us := middleware.CurrentSession(req); us != nil {
+		current = us.SessionId
+	}
+	middleware.RevokeUserSessions(user.Id, current)
+	logs.Info("user(%s) changed passwd", user.Id)
+	w.WriteHeader(http.StatusOK)
 }
 
 func BindAwsUser(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 	logs.Info("bind user start...")
 	projectId := ps["projectId"]
-	user, err := bluedb.QueryUserById(projectId)
+	user, err := bluedb.QueryProject(projectId)
 	if err != nil {
-		logs.Debug("get user err:%s", err.Error())
+		logs.Debug("get project err:%s", err.Error())
 		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
 		return
 	}
 	if len(user.AwsUsername) > 0 {
-		errStr := fmt.Sprintf("user(%s) has binded aws-user(%s)", user.Name, user.AwsUsername)
+		errStr := fmt.Sprintf("project(%s) has binded aws-user(%s)", user.Name, user.AwsUsername)
 		logs.Error(errStr)
 		DefaultHandler.ServeHTTP(w, req, errors.New(errStr), http.StatusBadRequest)
 		return
 	}
-	body, err := ioutil.ReadAll(req.Body)
-	if err != nil {
-		logs.Error("Receive body failed: %v", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
-		return
-	}
-	defer req.Body.Close()
-
 	var bindReq = &BindAwsUserReq{}
-	err = json.Unmarshal(body, bindReq)
-	if err != nil {
-		logs.Error("Invalid body. err:%s", err.Error())
-		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
+	if !readJsonBody(w, req, bindReq) {
 		return
 	}
 	name := strings.TrimSpace(bindReq.Name)
@@ -593,9 +672,9 @@ func BindAwsUser(w http.ResponseWriter, req *http.Request, ps map[string]string)
 	user.AwsUsername = bindReq.Name
 	user.AccessKey = bindReq.AccessKey
 	user.SecretKey = bindReq.SecretKey
-	err = bluedb.UpdateUser(user)
+	err = bluedb.UpdateProject(user, "aws_username", "access_key", "secret_key")
 	if err != nil {
-		logs.Error("update user fail. err:%s", err.Error())
+		logs.Error("update project fail. err:%s", err.Error())
 		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
 		return
 	}
@@ -618,8 +697,13 @@ func DeleteUser(w http.ResponseWriter, req *http.Request, ps map[string]string)
 	}
 
 	token := req.Header.Get(common.XAuthB)
-	logs.Info("delete session:%s", token)
+	logs.Info("delete session of user(%s)", id)
 	sesscache.Del(token)
+	middleware.RevokeUserSessions(id, "")
+	_ = bluedb.DeleteUserMemberships(id)
+	_ = bluedb.DeleteUserSsoIdentities(id)
+	_ = bluedb.DeleteTwoFactor(id)
+	middleware.ForgetRoles(id)
 	sesscache.Del("lastLogin_"+id)
 	sesscache.Del("lastAccess_"+id)
 	w.WriteHeader(http.StatusOK)
@@ -634,9 +718,8 @@ func GetUser(w http.ResponseWriter, req *http.Request, ps map[string]string) {
 		DefaultHandler.ServeHTTP(w, req, err, http.StatusBadRequest)
 		return
 	}
-	logs.Debug("user:%v", user)
 	var u = User{}
-	w.WriteHeader(http.StatusOK)
 	w.Header().Add("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
 	json.NewEncoder(w).Encode(u.dbObjectTrans(user))
 }
@@ -3,13 +3,16 @@ module github.com/ssrs100/blueserver
 go 1.13
 
 require (
+	github.com/alicebob/miniredis/v2 v2.30.0
 	github.com/astaxie/beego v1.12.1
 	github.com/aws/aws-sdk-go v1.30.29
 	github.com/dimfeld/httptreemux v5.0.1+incompatible
 	github.com/eclipse/paho.mqtt.golang v1.2.0
 	github.com/fernet/fernet-go v0.0.0-20191111064656-eff2850e6001
+	github.com/fxamacker/cbor/v2 v2.2.0
 	github.com/go-redis/redis v6.15.7+incompatible
 	github.com/go-sql-driver/mysql v1.5.0
+	github.com/gorilla/websocket v1.4.2
 	github.com/influxdata/influxdb1-client v0.0.0-20200515024757-02f0bf5dbca3
 	github.com/jack0liu/conf v0.0.0-20190815154530-1b62ab6abfb7
 	github.com/jack0liu/logs v0.0.0-20190716021738-c97d38ea3aa7
@@ -18,5 +21,7 @@ require (
 	github.com/onsi/gomega v1.10.0 // indirect
 	github.com/patrickmn/go-cache v2.1.0+incompatible
 	github.com/satori/go.uuid v1.2.0
+	golang.org/x/crypto v0.0.0-20191011191535-87dc89f01550
+	google.golang.org/protobuf v1.27.1
 	gopkg.in/alexcesaro/quotedprintable.v3 v3.0.0-20150716171945-2caba252f4dc // indirect
 )
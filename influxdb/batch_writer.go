@@ -0,0 +1,232 @@
+package influxdb
+
+import (
+	"errors"
+	"fmt"
+	client "github.com/influxdata/influxdb1-client"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/common"
+	"github.com/ssrs100/blueserver/metrics"
+	"github.com/ssrs100/blueserver/sesscache"
+	"sync"
+	"time"
+)
+
+const (
+	defaultBatchSize      = 500
+	defaultFlushInterval  = 1000
+	defaultWriteWorkers   = 4
+	defaultQueueSize      = 10000
+	defaultEnqueueTimeout = 2000
+	writeRetry            = 3
+)
+
+var ErrWriterBusy = errors.New("influx writer is busy, points dropped")
+
+var (
+	writeDuration = metrics.NewHistogram("influxdb_write_duration_seconds",
+		"Latency of the influxdb writes.", metrics.DefBuckets)
+	writeErrors = metrics.NewCounter("influxdb_write_errors_total",
+		"Number of influxdb writes which failed, the retries included.")
+	droppedPoints = metrics.NewCounter("influxdb_dropped_points_total",
+		"Number of points dropped, because the writer queue is full or the write failed.")
+)
+
+// batchWriter collects points from all tenants and writes them to influxdb
+// in batches. A batch is flushed when it reaches batchSize points or when
+// flushInterval passes, and batches are written by several workers.
+type batchWriter struct {
+	pointChan chan client.Point
+	batchChan chan []client.Point
+	stop      chan interface{}
+	wg        sync.WaitGroup
+
+	batchSize      int
+	flushInterval  time.Duration
+	workers        int
+	enqueueTimeout time.Duration
+}
+
+var (
+	// writerLock is held for read while points are handed to the writer, so
+	// that StopWriter does not close the writer under an enqueue.
+	writerLock sync.RWMutex
+	writer     *batchWriter
+)
+
+func newBatchWriter(batchSize int, flushInterval time.Duration, workers, queueSize int, enqueueTimeout time.Duration) *batchWriter {
+	return &batchWriter{
+		pointChan:      make(chan client.Point, queueSize),
+		batchChan:      make(chan []client.Point, workers),
+		stop:           make(chan interface{}),
+		batchSize:      batchSize,
+		flushInterval:  flushInterval,
+		workers:        workers,
+		enqueueTimeout: enqueueTimeout,
+	}
+}
+
+// StartWriter starts the shared batch writer, InsertSensorData and
+// InsertBeaconData write directly to influxdb until it is started.
+func StartWriter() {
+	startWriter(newBatchWriter(
+		conf.GetIntWithDefault("influx_batch_size", defaultBatchSize),
+		time.Duration(conf.GetIntWithDefault("influx_flush_interval", defaultFlushInterval))*time.Millisecond,
+		conf.GetIntWithDefault("influx_write_workers", defaultWriteWorkers),
+		conf.GetIntWithDefault("influx_queue_size", defaultQueueSize),
+		time.Duration(conf.GetIntWithDefault("influx_enqueue_timeout", defaultEnqueueTimeout))*time.Millisecond,
+	))
+}
+
+func startWriter(bw *batchWriter) {
+	bw.wg.Add(1)
+	go bw.batch()
+	for i := 0; i < bw.workers; i++ {
+		bw.wg.Add(1)
+		go bw.write()
+	}
+	writerLock.Lock()
+	writer = bw
+	writerLock.Unlock()
+	logs.Info("influx writer started, batch size:%d, workers:%d", bw.batchSize, bw.workers)
+}
+
+// StopWriter flushes the pending points and stops the shared batch writer.
+// The points written after it are written directly, the producers should
+// be stopped before it to keep the batching.
+func StopWriter() {
+	writerLock.Lock()
+	bw := writer
+	writer = nil
+	writerLock.Unlock()
+	if bw == nil {
+		return
+	}
+	close(bw.stop)
+	bw.wg.Wait()
+	logs.Info("influx writer stopped")
+}
+
+// enqueue adds points to the writer queue. When the queue is full it waits
+// up to enqueueTimeout, then the remaining points are dropped.
+func (bw *batchWriter) enqueue(pts []client.Point) error {
+	timer := time.NewTimer(bw.enqueueTimeout)
+	defer timer.Stop()
+	for i, p := range pts {
+		select {
+		case bw.pointChan <- p:
+		case <-timer.C:
+			logs.Error("influx queue is full, drop %d points", len(pts)-i)
+			droppedPoints.Add(float64(len(pts) - i))
+			countUnsaved(pts[i:])
+			return ErrWriterBusy
+		}
+	}
+	return nil
+}
+
+func (bw *batchWriter) batch() {
+	defer bw.wg.Done()
+	ticker := time.NewTicker(bw.flushInterval)
+	defer ticker.Stop()
+	pts := make([]client.Point, 0, bw.batchSize)
+	flush := func() {
+		if len(pts) == 0 {
+			return
+		}
+		bw.batchChan <- pts
+		pts = make([]client.Point, 0, bw.batchSize)
+	}
+	for {
+		select {
+		case p := <-bw.pointChan:
+			pts = append(pts, p)
+			if len(pts) >= bw.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		case <-bw.stop:
+			for len(bw.pointChan) > 0 {
+				pts = append(pts, <-bw.pointChan)
+				if len(pts) >= bw.batchSize {
+					flush()
+				}
+			}
+			flush()
+			close(bw.batchChan)
+			return
+		}
+	}
+}
+
+func (bw *batchWriter) write() {
+	defer bw.wg.Done()
+	for pts := range bw.batchChan {
+		var err error
+		for i := 0; i < writeRetry; i++ {
+			if err = writePoints(pts); err == nil {
+				break
+			}
+			time.Sleep(time.Duration(i+1) * 100 * time.Millisecond)
+		}
+		if err != nil {
+			logs.Error("write %d points fail, err:%s", len(pts), err.Error())
+			droppedPoints.Add(float64(len(pts)))
+			countUnsaved(pts)
+			continue
+		}
+		logs.Debug("write %d points success", len(pts))
+	}
+}
+
+func writePoints(pts []client.Point) error {
+	bps := client.BatchPoints{
+		Points:          pts,
+		Database:        dbName,
+		RetentionPolicy: retention,
+	}
+	start := time.Now()
+	resp, err := influx.c.Write(bps)
+	writeDuration.Since(start)
+	if err == nil && resp != nil && resp.Err != nil {
+		err = fmt.Errorf("write err:%v", resp.Err)
+	}
+	if err != nil {
+		writeErrors.Inc()
+		return err
+	}
+	return nil
+}
+
+// countUnsaved adds the points which are given up to the completeness of
+// their things, the sequences of the reports are already accepted so the
+// loss is not seen by the gap tracking.
+func countUnsaved(pts []client.Point) {
+	things := make(map[string]int64)
+	for _, p := range pts {
+		if thing := p.Tags[columnThing]; len(thing) > 0 {
+			things[thing]++
+		}
+	}
+	for thing, n := range things {
+		sesscache.HIncrBy(common.CompletenessKey(thing), common.CompleteUnsaved, n)
+	}
+}
+
+// writeOrEnqueue hands points to the shared writer if it is started,
+// otherwise writes them directly.
+func writeOrEnqueue(pts []client.Point) error {
+	writerLock.RLock()
+	defer writerLock.RUnlock()
+	if writer != nil {
+		return writer.enqueue(pts)
+	}
+	if err := writePoints(pts); err != nil {
+		droppedPoints.Add(float64(len(pts)))
+		countUnsaved(pts)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,141 @@
+package influxdb
+
+import (
+	"bufio"
+	client "github.com/influxdata/influxdb1-client"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeInflux records the batches written to it and the queries sent to it,
+// a query has no result.
+type fakeInflux struct {
+	sync.Mutex
+	batches []int
+	points  int
+	queries []string
+	// the writes wait for it when set
+	hold chan interface{}
+}
+
+func (f *fakeInflux) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path == "/query" {
+		f.Lock()
+		f.queries = append(f.queries, r.FormValue("q"))
+		f.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		w.Write([]byte(`{"results":[{"statement_id":0}]}`))
+		return
+	}
+	if f.hold != nil {
+		<-f.hold
+	}
+	n := 0
+	for sc := bufio.NewScanner(r.Body); sc.Scan(); {
+		if len(sc.Text()) > 0 {
+			n++
+		}
+	}
+	f.Lock()
+	f.batches = append(f.batches, n)
+	f.points += n
+	f.Unlock()
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// useFakeInflux points the client to f, the returned func restores it.
+func useFakeInflux(t *testing.T, f *fakeInflux) func() {
+	srv := httptest.NewServer(f)
+	u, _ := url.Parse(srv.URL)
+	c, err := client.NewClient(client.Config{URL: *u})
+	if err != nil {
+		srv.Close()
+		t.Fatalf("new client fail, err:%s", err.Error())
+	}
+	old := influx.c
+	influx.c = c
+	return func() {
+		influx.c = old
+		srv.Close()
+	}
+}
+
+func testPoints(n int) []client.Point {
+	pts := make([]client.Point, 0, n)
+	for i := 0; i < n; i++ {
+		pts = append(pts, client.Point{
+			Measurement: "test",
+			Fields:      map[string]interface{}{"n": i},
+			Time:        time.Unix(int64(i), 0),
+		})
+	}
+	return pts
+}
+
+func TestWriterBatches(t *testing.T) {
+	f := &fakeInflux{}
+	defer useFakeInflux(t, f)()
+	startWriter(newBatchWriter(3, time.Hour, 1, 100, time.Second))
+	if err := writeOrEnqueue(testPoints(7)); err != nil {
+		t.Fatalf("enqueue fail, err:%s", err.Error())
+	}
+	// the last batch is short of the batch size, it is flushed on stop
+	StopWriter()
+	if want := []int{3, 3, 1}; !reflect.DeepEqual(f.batches, want) {
+		t.Errorf("written batches %v, want %v", f.batches, want)
+	}
+
+	// without the writer the points are written directly
+	if err := writeOrEnqueue(testPoints(2)); err != nil {
+		t.Fatalf("write fail, err:%s", err.Error())
+	}
+	if want := []int{3, 3, 1, 2}; !reflect.DeepEqual(f.batches, want) {
+		t.Errorf("written batches %v, want %v", f.batches, want)
+	}
+}
+
+func TestWriterBusy(t *testing.T) {
+	f := &fakeInflux{hold: make(chan interface{})}
+	defer useFakeInflux(t, f)()
+	startWriter(newBatchWriter(1, time.Hour, 1, 1, 50*time.Millisecond))
+	// a held write, a pending batch, the batch in flush and a queued point
+	// fill the writer
+	if err := writeOrEnqueue(testPoints(10)); err != ErrWriterBusy {
+		t.Errorf("enqueue to a full writer returns %v", err)
+	}
+	close(f.hold)
+	StopWriter()
+	if f.points == 0 || f.points >= 10 {
+		t.Errorf("%d points of a full writer are written", f.points)
+	}
+}
+
+func TestWriterStopWithProducers(t *testing.T) {
+	f := &fakeInflux{}
+	defer useFakeInflux(t, f)()
+	startWriter(newBatchWriter(5, time.Millisecond, 2, 100, time.Second))
+	var wg sync.WaitGroup
+	for i := 0; i < 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j < 20; j++ {
+				if err := writeOrEnqueue(testPoints(3)); err != nil {
+					t.Errorf("write fail, err:%s", err.Error())
+				}
+			}
+		}()
+	}
+	time.Sleep(5 * time.Millisecond)
+	StopWriter()
+	wg.Wait()
+	// every point is either flushed by the writer or written directly
+	if f.points != 4*20*3 {
+		t.Errorf("%d points are written, want %d", f.points, 4*20*3)
+	}
+}
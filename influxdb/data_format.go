@@ -0,0 +1,104 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"strconv"
+	"strings"
+)
+
+const (
+	UnitCelsius    = "C"
+	UnitFahrenheit = "F"
+)
+
+// FormatOption controls how the stored readings are formatted to OutData.
+// Temperatures are stored in celsius, Raw adds the values before calibration.
+type FormatOption struct {
+	Unit string
+	Raw  bool
+}
+
+func ValidUnit(unit string) bool {
+	return unit == UnitCelsius || unit == UnitFahrenheit
+}
+
+func celsiusToFahrenheit(c float64) float64 {
+	return c*9/5 + 32
+}
+
+func fahrenheitToCelsius(f float64) float64 {
+	return (f - 32) * 5 / 9
+}
+
+// ConvertTemperature converts a celsius temperature to the display unit.
+func ConvertTemperature(unit string, c float64) float64 {
+	if unit == UnitFahrenheit {
+		return celsiusToFahrenheit(c)
+	}
+	return c
+}
+
+// TemperatureToCelsius converts a temperature of the display unit to celsius.
+func TemperatureToCelsius(unit string, t float64) float64 {
+	if unit == UnitFahrenheit {
+		return fahrenheitToCelsius(t)
+	}
+	return t
+}
+
+func (opt FormatOption) convertTemperature(val *json.Number) *json.Number {
+	if val == nil || opt.Unit != UnitFahrenheit {
+		return val
+	}
+	c, err := val.Float64()
+	if err != nil {
+		return val
+	}
+	f := json.Number(strconv.FormatFloat(celsiusToFahrenheit(c), 'G', 5, 64))
+	return &f
+}
+
+func (opt FormatOption) apply(d *OutData) {
+	if d == nil {
+		return
+	}
+	if opt.Raw {
+		// not calibrated, the raw value is the stored value
+		if d.RawTemperature == nil {
+			d.RawTemperature = d.Temperature
+		}
+		if d.RawHumidity == nil {
+			d.RawHumidity = d.Humidity
+		}
+	} else {
+		d.RawTemperature = nil
+		d.RawHumidity = nil
+	}
+	derive(d)
+	d.Temperature = opt.convertTemperature(d.Temperature)
+	d.DewPoint = opt.convertTemperature(d.DewPoint)
+	d.HeatIndex = opt.convertTemperature(d.HeatIndex)
+	d.RawTemperature = opt.convertTemperature(d.RawTemperature)
+}
+
+// applyGroup converts the values of a temperature like group query.
+func (opt FormatOption) applyGroup(measurement string, rows [][]interface{}) {
+	if opt.Unit != UnitFahrenheit {
+		return
+	}
+	switch measurement {
+	case columnTemperature, MeasurementDewPoint, MeasurementHeatIndex, MeasurementMkt:
+	default:
+		return
+	}
+	for _, row := range rows {
+		if len(row) < len(groupColumns) || row[1] == nil {
+			continue
+		}
+		c, err := strconv.ParseFloat(strings.TrimSpace(toString(row[1])), 64)
+		if err != nil {
+			continue
+		}
+		row[1] = json.Number(strconv.FormatFloat(celsiusToFahrenheit(c), 'G', 5, 64))
+	}
+}
@@ -23,6 +23,9 @@ var sensorColumns = []string{
 	columnProjectId,
 	columnDeviceName,
 	columnPower,
+	columnTimeSource,
+	columnRawTemp,
+	columnRawHumidity,
 }
 var broadcastColumns = []string{
 	columnTime,
@@ -33,6 +36,7 @@ var broadcastColumns = []string{
 	columnDeviceName,
 	columnPower,
 	columnData,
+	columnTimeSource,
 }
 var sensorColumnStr string
 var broadcastColumnStr string
@@ -101,6 +105,15 @@ func getOneSensorData(data []interface{}) *OutData {
 	ret.Thing = thingSegs[0]
 	ret.ProjectId, _ = data[6].(string)
 	ret.DeviceName, _ = data[7].(string)
+	ret.TimeSource, _ = data[9].(string)
+	if data[10] != nil {
+		rawTemp := json.Number(toString(data[10]))
+		ret.RawTemperature = &rawTemp
+	}
+	if data[11] != nil {
+		rawHumi := json.Number(toString(data[11]))
+		ret.RawHumidity = &rawHumi
+	}
 	return &ret
 }
 
@@ -116,7 +129,7 @@ func getOneBroadcastData(data []interface{}) *OutData {
 	rssi := json.Number(toString(data[2]))
 	ret.Rssi = rssi
 
-	power := toString(data[8])
+	power := toString(data[6])
 	ret.Power = power + "%"
 
 	thingName, _ := data[3].(string)
@@ -124,8 +137,8 @@ func getOneBroadcastData(data []interface{}) *OutData {
 	ret.Thing = thingSegs[0]
 	ret.ProjectId, _ = data[4].(string)
 	ret.DeviceName, _ = data[5].(string)
-	ret.Power, _ = data[6].(string)
-	d := data[7].(string)
+	d, _ := data[7].(string)
 	ret.Data = &d
+	ret.TimeSource, _ = data[8].(string)
 	return &ret
 }
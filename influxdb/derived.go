@@ -0,0 +1,490 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	client "github.com/influxdata/influxdb1-client"
+	"github.com/jack0liu/conf"
+	"github.com/jack0liu/logs"
+	"github.com/ssrs100/blueserver/sesscache"
+	"math"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	TableMktRollup = "mkt_rollup"
+
+	MeasurementDewPoint    = "dew_point"
+	MeasurementAbsHumidity = "absolute_humidity"
+	MeasurementHeatIndex   = "heat_index"
+	MeasurementMkt         = "mkt"
+
+	columnExpSum = "exp_sum"
+	columnCount  = "count"
+
+	// gas constant, kJ/(mol*K)
+	gasConstant = 8.3144e-3
+	// activation energy of the usual pharma products, kJ/mol
+	defaultActivationEnergy = 83.144
+	kelvin                  = 273.15
+
+	mktRollupInterval = time.Hour
+	// hours recomputed by every rollup, older hours are recomputed when late
+	// or backfilled data is written to them, see markMktHours
+	mktRollupHours = 3
+	// the set of the older hours to recompute, as unix seconds
+	mktDirtyHoursKey = "mkt_dirty_hours"
+)
+
+var (
+	mktRollupStop = make(chan interface{})
+	mktRollupDone = make(chan interface{})
+)
+
+// mktFactor is ΔH/R in kelvin.
+func mktFactor() float64 {
+	energy := conf.GetFloatWithDefault("mkt_activation_energy", defaultActivationEnergy)
+	if energy <= 0 {
+		energy = defaultActivationEnergy
+	}
+	return energy / gasConstant
+}
+
+// DewPoint returns the dew point in celsius by the Magnus formula.
+func DewPoint(temp, humidity float64) float64 {
+	if humidity <= 0 {
+		return math.NaN()
+	}
+	a, b := 17.62, 243.12
+	gamma := math.Log(humidity/100) + a*temp/(b+temp)
+	return b * gamma / (a - gamma)
+}
+
+// AbsoluteHumidity returns the water vapour density in g/m³.
+func AbsoluteHumidity(temp, humidity float64) float64 {
+	return 6.112 * math.Exp(17.67*temp/(temp+243.5)) * humidity * 2.1674 / (kelvin + temp)
+}
+
+// HeatIndex returns the heat index in celsius by the Rothfusz regression,
+// below 80°F the simple formula of the NWS is used.
+func HeatIndex(temp, humidity float64) float64 {
+	t := celsiusToFahrenheit(temp)
+	hi := 0.5 * (t + 61.0 + (t-68.0)*1.2 + humidity*0.094)
+	if (hi+t)/2 >= 80 {
+		hi = -42.379 + 2.04901523*t + 10.14333127*humidity -
+			0.22475541*t*humidity - 0.00683783*t*t -
+			0.05481717*humidity*humidity + 0.00122874*t*t*humidity +
+			0.00085282*t*humidity*humidity - 0.00000199*t*t*humidity*humidity
+		if humidity < 13 && t >= 80 && t <= 112 {
+			hi -= (13 - humidity) / 4 * math.Sqrt((17-math.Abs(t-95))/17)
+		} else if humidity > 85 && t >= 80 && t <= 87 {
+			hi += (humidity - 85) / 10 * (87 - t) / 5
+		}
+	}
+	return (hi - 32) * 5 / 9
+}
+
+// mktFromSum returns the mean kinetic temperature in celsius from the sum of
+// exp(-ΔH/RT) of count readings.
+func mktFromSum(expSum float64, count int64) float64 {
+	if count == 0 || expSum <= 0 {
+		return math.NaN()
+	}
+	return -mktFactor()/math.Log(expSum/float64(count)) - kelvin
+}
+
+func mktExp(temp float64) float64 {
+	return math.Exp(-mktFactor() / (temp + kelvin))
+}
+
+func toNumber(v float64) *json.Number {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil
+	}
+	n := json.Number(strconv.FormatFloat(v, 'G', 5, 64))
+	return &n
+}
+
+func toFloat(val interface{}) (float64, bool) {
+	if val == nil {
+		return 0, false
+	}
+	f, err := strconv.ParseFloat(toString(val), 64)
+	if err != nil {
+		return 0, false
+	}
+	return f, true
+}
+
+// derive fills the computed fields of a sensor reading, in celsius.
+func derive(d *OutData) {
+	if d.Temperature == nil || d.Humidity == nil {
+		return
+	}
+	temp, err := d.Temperature.Float64()
+	if err != nil {
+		return
+	}
+	hum, err := d.Humidity.Float64()
+	if err != nil {
+		return
+	}
+	d.DewPoint = toNumber(DewPoint(temp, hum))
+	d.AbsoluteHumidity = toNumber(AbsoluteHumidity(temp, hum))
+	d.HeatIndex = toNumber(HeatIndex(temp, hum))
+}
+
+func IsDerivedMeasurement(measurement string) bool {
+	switch measurement {
+	case MeasurementDewPoint, MeasurementAbsHumidity, MeasurementHeatIndex, MeasurementMkt:
+		return true
+	}
+	return false
+}
+
+// getDerivedGroupData computes the derived measurement of every group, dew
+// point and the like from the group means, mkt from the hourly rollups.
+func getDerivedGroupData(measurement string, thing, startAt, endAt string, devices []string, projectId, timeInterval string) ([][]interface{}, error) {
+	var cmd string
+	if measurement == MeasurementMkt {
+		// the rollups can not be split into smaller groups
+		if d, err := parseInterval(timeInterval); err != nil || d < mktRollupInterval || d%mktRollupInterval != 0 {
+			return nil, fmt.Errorf("interval(%s) of mkt must be whole hours", timeInterval)
+		}
+		cmd = fmt.Sprintf("select sum(%s), sum(%s) from %s where %s and project_id=%s",
+			columnExpSum, columnCount, TableMktRollup, deviceCond(devices), quoteLiteral(projectId))
+	} else {
+		cmd = fmt.Sprintf("select mean(%s), mean(%s) from %s where %s and project_id=%s",
+			columnTemperature, columnHumidity, TableTemperature, deviceCond(devices), quoteLiteral(projectId))
+	}
+	if len(thing) > 0 && measurement != MeasurementMkt {
+		cmd = cmd + fmt.Sprintf(" and thing=%s", quoteLiteral(thing))
+	}
+	cmd = cmd + fmt.Sprintf(" and time >= %s and time < %s GROUP BY time(%s)",
+		quoteLiteral(startAt), quoteLiteral(endAt), timeInterval)
+	q := client.Query{
+		Command:  cmd,
+		Database: dbName,
+	}
+	logs.Debug("%s", q.Command)
+	response, err := influx.c.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	if response.Err != nil {
+		return nil, response.Err
+	}
+	retList := make([][]interface{}, 0)
+	for _, v := range response.Results {
+		if len(v.Series) == 0 {
+			logs.Warn("series is 0")
+			continue
+		}
+		for _, data := range v.Series[0].Values {
+			if len(data) < 3 {
+				continue
+			}
+			row := []interface{}{data[0], nil}
+			a, okA := toFloat(data[1])
+			b, okB := toFloat(data[2])
+			if okA && okB {
+				var val float64
+				switch measurement {
+				case MeasurementMkt:
+					val = mktFromSum(a, int64(b))
+				case MeasurementDewPoint:
+					val = DewPoint(a, b)
+				case MeasurementAbsHumidity:
+					val = AbsoluteHumidity(a, b)
+				case MeasurementHeatIndex:
+					val = HeatIndex(a, b)
+				}
+				if n := toNumber(val); n != nil {
+					row[1] = *n
+				}
+			}
+			retList = append(retList, row)
+		}
+	}
+	return retList, nil
+}
+
+type Mkt struct {
+	Device     string       `json:"device"`
+	StartAt    string       `json:"start_at"`
+	EndAt      string       `json:"end_at"`
+	Count      int64        `json:"count"`
+	Mkt        *json.Number `json:"mkt,omitempty"`
+	Unit       string       `json:"unit"`
+	FromRollup bool         `json:"from_rollup"`
+}
+
+// GetMkt returns the mean kinetic temperature of a device in [start, end).
+// The whole hours are read from the rollups, the rest from the raw data.
+func GetMkt(device, projectId string, start, end time.Time, opt FormatOption) (*Mkt, error) {
+	var expSum float64
+	var count int64
+	rollupStart := start.Truncate(mktRollupInterval)
+	if rollupStart.Before(start) {
+		rollupStart = rollupStart.Add(mktRollupInterval)
+	}
+	rollupEnd := end.Truncate(mktRollupInterval)
+	// the current hours are not rolled up yet
+	if latest := time.Now().Add(-mktRollupInterval).Truncate(mktRollupInterval); rollupEnd.After(latest) {
+		rollupEnd = latest
+	}
+	fromRollup := rollupStart.Before(rollupEnd)
+	ranges := [][2]time.Time{{start, end}}
+	if fromRollup {
+		s, c, err := queryMktRollup(device, projectId, rollupStart, rollupEnd)
+		if err != nil {
+			return nil, err
+		}
+		expSum, count = expSum+s, count+c
+		ranges = [][2]time.Time{{start, rollupStart}, {rollupEnd, end}}
+	}
+	for _, r := range ranges {
+		if !r[0].Before(r[1]) {
+			continue
+		}
+		s, c, err := queryMktRaw(device, projectId, r[0], r[1])
+		if err != nil {
+			return nil, err
+		}
+		expSum, count = expSum+s, count+c
+	}
+	m := Mkt{
+		Device:     device,
+		StartAt:    start.UTC().Format(time.RFC3339),
+		EndAt:      end.UTC().Format(time.RFC3339),
+		Count:      count,
+		Unit:       opt.Unit,
+		FromRollup: fromRollup,
+	}
+	if len(m.Unit) == 0 {
+		m.Unit = UnitCelsius
+	}
+	m.Mkt = opt.convertTemperature(toNumber(mktFromSum(expSum, count)))
+	return &m, nil
+}
+
+// mktRollupCmd sums the rollups of the device, the values from the request
+// are quoted so that they can not change the conditions.
+func mktRollupCmd(device, projectId string, start, end time.Time) string {
+	return fmt.Sprintf("select sum(%s), sum(%s) from %s where device=%s and project_id=%s and time >= '%s' and time < '%s'",
+		columnExpSum, columnCount, TableMktRollup, quoteLiteral(device), quoteLiteral(projectId),
+		start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339))
+}
+
+func queryMktRollup(device, projectId string, start, end time.Time) (float64, int64, error) {
+	q := client.Query{
+		Command:  mktRollupCmd(device, projectId, start, end),
+		Database: dbName,
+	}
+	logs.Debug("%s", q.Command)
+	response, err := influx.c.Query(q)
+	if err != nil {
+		return 0, 0, err
+	}
+	if response.Err != nil {
+		return 0, 0, response.Err
+	}
+	for _, v := range response.Results {
+		if len(v.Series) == 0 {
+			continue
+		}
+		for _, data := range v.Series[0].Values {
+			if len(data) < 3 {
+				continue
+			}
+			s, _ := toFloat(data[1])
+			c, _ := toFloat(data[2])
+			return s, int64(c), nil
+		}
+	}
+	return 0, 0, nil
+}
+
+// mktRawCmd selects the raw temperatures of the device, quoted like
+// mktRollupCmd.
+func mktRawCmd(device, projectId string, start, end time.Time) string {
+	return fmt.Sprintf("select %s from %s where device=%s and project_id=%s and time >= '%s' and time < '%s'",
+		columnTemperature, TableTemperature, quoteLiteral(device), quoteLiteral(projectId),
+		start.UTC().Format(time.RFC3339Nano), end.UTC().Format(time.RFC3339Nano))
+}
+
+func queryMktRaw(device, projectId string, start, end time.Time) (float64, int64, error) {
+	q := client.Query{
+		Command:  mktRawCmd(device, projectId, start, end),
+		Database: dbName,
+	}
+	logs.Debug("%s", q.Command)
+	response, err := influx.c.Query(q)
+	if err != nil {
+		return 0, 0, err
+	}
+	if response.Err != nil {
+		return 0, 0, response.Err
+	}
+	var expSum float64
+	var count int64
+	for _, v := range response.Results {
+		for _, s := range v.Series {
+			for _, data := range s.Values {
+				if len(data) < 2 {
+					continue
+				}
+				if temp, ok := toFloat(data[1]); ok {
+					expSum += mktExp(temp)
+					count++
+				}
+			}
+		}
+	}
+	return expSum, count, nil
+}
+
+// StartMktRollup stores the hourly mkt sums of every device, so that the
+// mkt of a long window does not scan the raw data.
+func StartMktRollup() {
+	go func() {
+		defer close(mktRollupDone)
+		rollupMkt(time.Now())
+		ticker := time.NewTicker(mktRollupInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case now := <-ticker.C:
+				rollupMkt(now)
+			case <-mktRollupStop:
+				return
+			}
+		}
+	}()
+	logs.Info("mkt rollup started")
+}
+
+// StopMktRollup stops the rollup, it returns once the rollup in progress
+// has handed its points to the writer.
+func StopMktRollup() {
+	close(mktRollupStop)
+	<-mktRollupDone
+	logs.Info("mkt rollup stopped")
+}
+
+func rollupMkt(now time.Time) {
+	end := now.Truncate(mktRollupInterval)
+	hours := make([]time.Time, 0, mktRollupHours)
+	for i := mktRollupHours; i > 0; i-- {
+		hours = append(hours, end.Add(-time.Duration(i)*mktRollupInterval))
+	}
+	for _, member := range sesscache.SMembers(mktDirtyHoursKey) {
+		sesscache.SRem(mktDirtyHoursKey, member)
+		sec, err := strconv.ParseInt(member, 10, 64)
+		if err != nil {
+			continue
+		}
+		if start := time.Unix(sec, 0); start.Before(hours[0]) {
+			hours = append(hours, start)
+		}
+	}
+	for _, start := range hours {
+		if err := rollupMktHour(start, start.Add(mktRollupInterval)); err != nil {
+			logs.Error("mkt rollup of %s fail, err:%s", start.Format(time.RFC3339), err.Error())
+			sesscache.SAdd(mktDirtyHoursKey, start.Unix())
+		}
+	}
+}
+
+// markMktHours marks the hours of the points which are older than the hours
+// every rollup recomputes, their rollups are recomputed with the next one.
+func markMktHours(pts []client.Point) {
+	recomputed := time.Now().Truncate(mktRollupInterval).Add(-mktRollupHours * mktRollupInterval)
+	hours := make(map[int64]bool)
+	for _, p := range pts {
+		if hour := p.Time.Truncate(mktRollupInterval); hour.Before(recomputed) {
+			hours[hour.Unix()] = true
+		}
+	}
+	for hour := range hours {
+		sesscache.SAdd(mktDirtyHoursKey, hour)
+	}
+}
+
+// parseInterval parses a duration of influxql, like 10m, 1h or 7d.
+func parseInterval(s string) (time.Duration, error) {
+	switch {
+	case strings.HasSuffix(s, "d"), strings.HasSuffix(s, "w"):
+		n, err := strconv.Atoi(s[:len(s)-1])
+		if err != nil {
+			return 0, err
+		}
+		d := time.Duration(n) * 24 * time.Hour
+		if strings.HasSuffix(s, "w") {
+			d *= 7
+		}
+		return d, nil
+	default:
+		return time.ParseDuration(s)
+	}
+}
+
+func rollupMktHour(start, end time.Time) error {
+	cmd := fmt.Sprintf("select %s from %s where time >= '%s' and time < '%s' group by %s, %s",
+		columnTemperature, TableTemperature,
+		start.UTC().Format(time.RFC3339), end.UTC().Format(time.RFC3339),
+		columnProjectId, columnDevice)
+	q := client.Query{
+		Command:  cmd,
+		Database: dbName,
+	}
+	logs.Debug("%s", q.Command)
+	response, err := influx.c.Query(q)
+	if err != nil {
+		return err
+	}
+	if response.Err != nil {
+		return response.Err
+	}
+	pts := make([]client.Point, 0)
+	for _, v := range response.Results {
+		for _, s := range v.Series {
+			var expSum float64
+			var count int64
+			for _, data := range s.Values {
+				if len(data) < 2 {
+					continue
+				}
+				if temp, ok := toFloat(data[1]); ok {
+					expSum += mktExp(temp)
+					count++
+				}
+			}
+			if count == 0 {
+				continue
+			}
+			fields := make(map[string]interface{})
+			fields[columnExpSum] = expSum
+			fields[columnCount] = count
+			if mkt := mktFromSum(expSum, count); !math.IsNaN(mkt) {
+				fields[MeasurementMkt] = mkt
+			}
+			pts = append(pts, client.Point{
+				Measurement: TableMktRollup,
+				Tags: map[string]string{
+					columnProjectId: s.Tags[columnProjectId],
+					columnDevice:    s.Tags[columnDevice],
+				},
+				Fields: fields,
+				Time:   start,
+			})
+		}
+	}
+	if len(pts) == 0 {
+		return nil
+	}
+	logs.Info("mkt rollup of %s, %d devices", start.Format(time.RFC3339), len(pts))
+	return writeOrEnqueue(pts)
+}
@@ -0,0 +1,61 @@
+package influxdb
+
+import (
+	"math"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestParseInterval(t *testing.T) {
+	for s, want := range map[string]time.Duration{
+		"30m": 30 * time.Minute,
+		"1h":  time.Hour,
+		"90m": 90 * time.Minute,
+		"1d":  24 * time.Hour,
+		"2w":  14 * 24 * time.Hour,
+	} {
+		got, err := parseInterval(s)
+		if err != nil || got != want {
+			t.Errorf("parseInterval(%s) = %s, %v, want %s", s, got, err, want)
+		}
+	}
+	for _, s := range []string{"", "h", "xd", "1y"} {
+		if _, err := parseInterval(s); err == nil {
+			t.Errorf("parseInterval(%s) is accepted", s)
+		}
+	}
+}
+
+func TestMktOfConstantTemperature(t *testing.T) {
+	var sum float64
+	for i := 0; i < 10; i++ {
+		sum += mktExp(25)
+	}
+	if got := mktFromSum(sum, 10); math.Abs(got-25) > 1e-9 {
+		t.Errorf("mkt of 25°C readings is %v", got)
+	}
+}
+
+func TestMktIntervalUnderOneHour(t *testing.T) {
+	for _, interval := range []string{"10m", "30m", "90m"} {
+		if _, err := getDerivedGroupData(MeasurementMkt, "", "", "", []string{"dev-1"}, "p1", interval); err == nil {
+			t.Errorf("mkt interval %s is accepted", interval)
+		}
+	}
+}
+
+func TestMktCmdQuotesDevice(t *testing.T) {
+	start := time.Date(2020, 5, 1, 0, 0, 0, 0, time.UTC)
+	end := start.Add(time.Hour)
+	device := `x' or project_id='other' or device='y\`
+	want := `device='x\' or project_id=\'other\' or device=\'y\\' and project_id='p-1' and `
+	for name, cmd := range map[string]string{
+		"rollup": mktRollupCmd(device, "p-1", start, end),
+		"raw":    mktRawCmd(device, "p-1", start, end),
+	} {
+		if !strings.Contains(cmd, want) {
+			t.Errorf("%s query does not quote the device: %s", name, cmd)
+		}
+	}
+}
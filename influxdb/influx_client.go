@@ -8,6 +8,8 @@ import (
 	"github.com/jack0liu/conf"
 	"github.com/jack0liu/logs"
 	"net/url"
+	"regexp"
+	"strings"
 	"time"
 )
 
@@ -23,6 +25,8 @@ type ReportData struct {
 	Power       string      `json:"power"`
 	DataType    string      `json:"data_type,omitempty"`
 	Data        string      `json:"data,omitempty"`
+	Firmware    string      `json:"firmware,omitempty"`
+	TimeSource  string      `json:"-"`
 }
 
 type ReportDataList struct {
@@ -43,6 +47,33 @@ type RecordData struct {
 	Power       float64 `json:"power"`
 	DataType    string  `json:"data_type,omitempty"`
 	Data        string  `json:"data,omitempty"`
+	TimeSource  string  `json:"time_source,omitempty"`
+
+	// readings before calibration, only stored when Calibrated
+	Calibrated     bool    `json:"-"`
+	RawTemperature float64 `json:"-"`
+	RawHumidity    float64 `json:"-"`
+
+	// the sensor reported no value, the reading is not stored
+	MissingTemperature bool `json:"-"`
+	MissingHumidity    bool `json:"-"`
+}
+
+// MarshalJSON leaves out the readings the sensor did not report.
+func (d RecordData) MarshalJSON() ([]byte, error) {
+	type record RecordData
+	out := struct {
+		record
+		Temperature *float64 `json:"temperature,omitempty"`
+		Humidity    *float64 `json:"humidity,omitempty"`
+	}{record: record(d)}
+	if !d.MissingTemperature {
+		out.Temperature = &d.Temperature
+	}
+	if !d.MissingHumidity {
+		out.Humidity = &d.Humidity
+	}
+	return json.Marshal(out)
 }
 
 type OutData struct {
@@ -56,13 +87,22 @@ type OutData struct {
 	DeviceName  string       `json:"device_name"`
 	Power       string       `json:"power"`
 	Data        *string      `json:"data,omitempty"`
+	TimeSource  string       `json:"time_source,omitempty"`
+
+	RawTemperature *json.Number `json:"raw_temperature,omitempty"`
+	RawHumidity    *json.Number `json:"raw_humidity,omitempty"`
+
+	DewPoint         *json.Number `json:"dew_point,omitempty"`
+	AbsoluteHumidity *json.Number `json:"absolute_humidity,omitempty"`
+	HeatIndex        *json.Number `json:"heat_index,omitempty"`
 }
 
 type GroupData []interface{}
 
 type OutDataList struct {
-	Datas []*OutData `json:"datas"`
-	Count int        `json:"count"`
+	Datas []*OutData   `json:"datas"`
+	Count int          `json:"count"`
+	Mkt   *json.Number `json:"mkt,omitempty"`
 }
 
 type DeviceList struct {
@@ -92,6 +132,9 @@ const (
 	columnDeviceName  = "device_name"
 	columnPower       = "power"
 	columnData        = "data"
+	columnTimeSource  = "time_source"
+	columnRawTemp     = "raw_temperature"
+	columnRawHumidity = "raw_humidity"
 
 	columnMean = "mean"
 )
@@ -117,11 +160,26 @@ func InsertSensorData(table string, dataList []*RecordData) error {
 	pts := make([]client.Point, 0)
 	for _, data := range dataList {
 		fields := make(map[string]interface{})
-		fields[columnTemperature] = data.Temperature
-		fields[columnHumidity] = data.Humidity
+		if !data.MissingTemperature {
+			fields[columnTemperature] = data.Temperature
+			if data.Calibrated {
+				fields[columnRawTemp] = data.RawTemperature
+			}
+		}
+		if !data.MissingHumidity {
+			fields[columnHumidity] = data.Humidity
+			if data.Calibrated {
+				fields[columnRawHumidity] = data.RawHumidity
+			}
+		}
 		fields[columnRssi] = data.Rssi
 		fields[columnDeviceName] = data.DeviceName
 		fields[columnPower] = data.Power
+		// a field, so that the points of a device are one series whatever
+		// the source of their time
+		if len(data.TimeSource) > 0 {
+			fields[columnTimeSource] = data.TimeSource
+		}
 		rdTime := time.Unix(0, data.Timestamp*1000000)
 
 		tags := make(map[string]string)
@@ -138,22 +196,9 @@ func InsertSensorData(table string, dataList []*RecordData) error {
 		}
 		pts = append(pts, p)
 	}
-	logs.Info("write sensor data:%v", pts)
-	bps := client.BatchPoints{
-		Points:          pts,
-		Database:        dbName,
-		RetentionPolicy: retention,
-	}
-	resp, err := influx.c.Write(bps)
-	if err != nil {
-		return err
-	}
-	if resp != nil && resp.Err != nil {
-		logs.Error("write err:%v", resp.Err)
-	} else {
-		logs.Info("write success")
-	}
-	return nil
+	logs.Debug("write sensor data:%v", pts)
+	markMktHours(pts)
+	return writeOrEnqueue(pts)
 }
 
 func InsertBeaconData(table string, dataList []*RecordData) error {
@@ -167,6 +212,9 @@ func InsertBeaconData(table string, dataList []*RecordData) error {
 		fields[columnRssi] = data.Rssi
 		fields[columnDeviceName] = data.DeviceName
 		fields[columnPower] = data.Power
+		if len(data.TimeSource) > 0 {
+			fields[columnTimeSource] = data.TimeSource
+		}
 		fields[columnData] = data.Data
 		rdTime := time.Unix(0, data.Timestamp*1000000)
 
@@ -184,16 +232,7 @@ func InsertBeaconData(table string, dataList []*RecordData) error {
 		}
 		pts = append(pts, p)
 	}
-	bps := client.BatchPoints{
-		Points:          pts,
-		Database:        dbName,
-		RetentionPolicy: retention,
-	}
-	_, err := influx.c.Write(bps)
-	if err != nil {
-		return err
-	}
-	return nil
+	return writeOrEnqueue(pts)
 }
 
 func getColumnStr(table string) string {
@@ -211,18 +250,18 @@ func checkTable(table string) error {
 	return nil
 }
 
-func GetLatest(table string, thing, device, projectId string) (data *OutData, err error) {
+func GetLatest(table string, thing, device, projectId string, opt FormatOption) (data *OutData, err error) {
 	if err := checkTable(table); err != nil {
 		return nil, err
 	}
 	columnStr := getColumnStr(table)
-	cmd := fmt.Sprintf("select %s from %s where project_id='%s'", columnStr, table, projectId)
+	cmd := fmt.Sprintf("select %s from %s where project_id=%s", columnStr, table, quoteLiteral(projectId))
 	tail := " order by time desc limit 1"
 	if len(thing) > 0 {
-		cmd = cmd + fmt.Sprintf(" and thing='%s'", thing)
+		cmd = cmd + " and thing=" + quoteLiteral(thing)
 	}
 	if len(device) > 0 {
-		cmd = cmd + fmt.Sprintf(" and device='%s'", device)
+		cmd = cmd + " and device=" + quoteLiteral(device)
 	}
 	cmd = cmd + tail
 
@@ -241,24 +280,103 @@ func GetLatest(table string, thing, device, projectId string) (data *OutData, er
 			continue
 		}
 		for _, data := range v.Series[0].Values {
-			return tableData[table](data), nil
+			d := tableData[table](data)
+			opt.apply(d)
+			return d, nil
 		}
 	}
 
 	return nil, response.Err
 }
 
-func GetGroupDataByTime(measurement string, thing, startAt, endAt, device, projectId, timeInterval string) (datas [][]interface{}, err error) {
+// GetLatestOfDevices returns the latest data of each device by one query,
+// the devices without data are left out of the map.
+func GetLatestOfDevices(table string, devices []string, projectId string, opt FormatOption) (map[string]*OutData, error) {
+	if err := checkTable(table); err != nil {
+		return nil, err
+	}
+	datas := make(map[string]*OutData, len(devices))
+	if len(devices) == 0 {
+		return datas, nil
+	}
+	cmd := fmt.Sprintf("select %s from %s where project_id=%s and %s group by %s order by time desc limit 1",
+		getColumnStr(table), table, quoteLiteral(projectId), deviceCond(devices), columnDevice)
+	q := client.Query{
+		Command:  cmd,
+		Database: dbName,
+	}
+	logs.Debug("%s", q.Command)
+	response, err := influx.c.Query(q)
+	if err != nil {
+		return nil, err
+	}
+	for _, v := range response.Results {
+		for _, series := range v.Series {
+			if len(series.Values) == 0 {
+				continue
+			}
+			d := tableData[table](series.Values[0])
+			if d == nil {
+				continue
+			}
+			if len(d.Device) == 0 {
+				// the column of a tag in group by is empty
+				d.Device = series.Tags[columnDevice]
+			}
+			opt.apply(d)
+			datas[d.Device] = d
+		}
+	}
+	return datas, response.Error()
+}
+
+// quoteLiteral quotes the value as a string literal of influxql, a quote in
+// the value can not end the literal.
+func quoteLiteral(v string) string {
+	return "'" + literalEscaper.Replace(v) + "'"
+}
+
+var literalEscaper = strings.NewReplacer(`\`, `\\`, `'`, `\'`)
+
+// deviceCond matches any of the devices by one regular expression, instead
+// of one clause per device.
+func deviceCond(devices []string) string {
+	quoted := make([]string, 0, len(devices))
+	for _, d := range devices {
+		quoted = append(quoted, strings.Replace(regexp.QuoteMeta(d), "/", `\/`, -1))
+	}
+	return fmt.Sprintf("%s =~ /^(%s)$/", columnDevice, strings.Join(quoted, "|"))
+}
+
+// GetGroupDataByTime returns the measurement grouped by time, the readings of
+// all devices fall into the same groups.
+func GetGroupDataByTime(measurement string, thing, startAt, endAt string, devices []string, projectId, timeInterval string, opt FormatOption) (datas [][]interface{}, err error) {
+	if len(devices) == 0 {
+		return nil, errors.New("no device")
+	}
 	// startAt, endAt like '2019-08-17T06:40:27.995Z'
+	if IsDerivedMeasurement(measurement) {
+		datas, err = getDerivedGroupData(measurement, thing, startAt, endAt, devices, projectId, timeInterval)
+		if err != nil {
+			return nil, err
+		}
+		opt.applyGroup(measurement, datas)
+		return datas, nil
+	}
 	if measurement != columnHumidity && measurement != columnTemperature {
 		return nil, fmt.Errorf("invalid measurement %s", measurement)
 	}
 
-	cmd := fmt.Sprintf("select mean(%s) from temperature where device='%s' and project_id='%s'",
-		measurement, device, projectId)
-	tail := fmt.Sprintf(" and time >= '%s' and time < '%s' GROUP BY time(%s) fill(linear)", startAt, endAt, timeInterval)
+	if _, err := parseInterval(timeInterval); err != nil {
+		return nil, fmt.Errorf("invalid interval %s", timeInterval)
+	}
+
+	cmd := fmt.Sprintf("select mean(%s) from temperature where %s and project_id=%s",
+		measurement, deviceCond(devices), quoteLiteral(projectId))
+	tail := fmt.Sprintf(" and time >= %s and time < %s GROUP BY time(%s) fill(linear)",
+		quoteLiteral(startAt), quoteLiteral(endAt), timeInterval)
 	if len(thing) > 0 {
-		cmd = cmd + fmt.Sprintf(" and thing='%s'", thing)
+		cmd = cmd + " and thing=" + quoteLiteral(thing)
 	}
 	cmd = cmd + tail
 	q := client.Query{
@@ -281,24 +399,44 @@ func GetGroupDataByTime(measurement string, thing, startAt, endAt, device, proje
 			retList = append(retList, data)
 		}
 	}
+	opt.applyGroup(measurement, retList)
 
 	return retList, nil
 }
 
-func GetDataByTime(table string, thing, startAt, endAt, device, projectId string) (datas []*OutData, err error) {
+func GetDataByTime(table string, thing, startAt, endAt, device, projectId string, opt FormatOption) (datas []*OutData, err error) {
 	// startAt, endAt like '2019-08-17T06:40:27.995Z'
 	if err := checkTable(table); err != nil {
 		return nil, err
 	}
 	columnStr := getColumnStr(table)
-	cmd := fmt.Sprintf("select %s from %s where time >= '%s' and time < '%s' and project_id='%s'", columnStr, table, startAt, endAt, projectId)
-	tail := " order by time desc limit 1000"
+	cmd := fmt.Sprintf("select %s from %s where time >= %s and time < %s and project_id=%s",
+		columnStr, table, quoteLiteral(startAt), quoteLiteral(endAt), quoteLiteral(projectId))
 	if len(thing) > 0 {
-		cmd = cmd + fmt.Sprintf(" and thing='%s'", thing)
+		cmd = cmd + " and thing=" + quoteLiteral(thing)
 	}
 	if len(device) > 0 {
-		cmd = cmd + fmt.Sprintf(" and device='%s'", device)
+		cmd = cmd + " and device=" + quoteLiteral(device)
 	}
+	return queryDataByTime(table, cmd, opt)
+}
+
+// GetMultiDataByTime returns the readings of all the devices, newest first.
+func GetMultiDataByTime(table string, startAt, endAt string, devices []string, projectId string, opt FormatOption) (datas []*OutData, err error) {
+	if err := checkTable(table); err != nil {
+		return nil, err
+	}
+	if len(devices) == 0 {
+		return make([]*OutData, 0), nil
+	}
+	columnStr := getColumnStr(table)
+	cmd := fmt.Sprintf("select %s from %s where time >= %s and time < %s and project_id=%s and %s",
+		columnStr, table, quoteLiteral(startAt), quoteLiteral(endAt), quoteLiteral(projectId), deviceCond(devices))
+	return queryDataByTime(table, cmd, opt)
+}
+
+func queryDataByTime(table, cmd string, opt FormatOption) (datas []*OutData, err error) {
+	tail := " order by time desc limit 1000"
 	cmd = cmd + tail
 	q := client.Query{
 		Command:  cmd,
@@ -317,6 +455,7 @@ func GetDataByTime(table string, thing, startAt, endAt, device, projectId string
 		}
 		for _, data := range v.Series[0].Values {
 			d := tableData[table](data)
+			opt.apply(d)
 			retList = append(retList, d)
 		}
 	}
@@ -329,10 +468,11 @@ func GetDevicesByThing(table string, thing, projectId string) (devices []string,
 	if err := checkTable(table); err != nil {
 		return nil, err
 	}
-	cmd := fmt.Sprintf("select count(*) from %s where project_id='%s' group by device", table, projectId)
+	cmd := fmt.Sprintf("select count(*) from %s where project_id=%s", table, quoteLiteral(projectId))
 	if len(thing) > 0 {
-		cmd = cmd + fmt.Sprintf(" and thing='%s'", thing)
+		cmd = cmd + " and thing=" + quoteLiteral(thing)
 	}
+	cmd = cmd + " group by device"
 	var q client.Query
 	q = client.Query{
 		Command:  cmd,
@@ -361,11 +501,16 @@ func GetDevicesByThing(table string, thing, projectId string) (devices []string,
 	return retList, nil
 }
 
-func DeleteData(table string, thing, projectId string) error {
+// DeleteData deletes the data relayed by the thing, only of the devices if
+// they are given.
+func DeleteData(table string, thing, projectId string, devices []string) error {
 	//cmd := fmt.Sprintf("select distinct(device) from %s where project_id='%s'", table, projectId)
-	cmd := fmt.Sprintf("delete from %s where project_id='%s'", table, projectId)
+	cmd := fmt.Sprintf("delete from %s where project_id=%s", table, quoteLiteral(projectId))
 	if len(thing) > 0 {
-		cmd = cmd + fmt.Sprintf(" and thing='%s'", thing)
+		cmd = cmd + " and thing=" + quoteLiteral(thing)
+	}
+	if len(devices) > 0 {
+		cmd = cmd + " and " + deviceCond(devices)
 	}
 	var q client.Query
 	q = client.Query{
@@ -0,0 +1,41 @@
+package influxdb
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestQueriesQuoteValues(t *testing.T) {
+	f := &fakeInflux{}
+	defer useFakeInflux(t, f)()
+	evil := `x' or project_id='other`
+	quoted := `'x\' or project_id=\'other'`
+	start, end := "2020-05-01T00:00:00Z", "2020-05-02T00:00:00Z"
+	opt := FormatOption{}
+
+	GetLatest(TableTemperature, evil, evil, evil, opt)
+	GetLatestOfDevices(TableTemperature, []string{"dev-1"}, evil, opt)
+	GetGroupDataByTime(columnTemperature, evil, start, end, []string{"dev-1"}, evil, "1h", opt)
+	GetDataByTime(TableTemperature, evil, start, evil, evil, evil, opt)
+	GetMultiDataByTime(TableTemperature, evil, end, []string{"dev-1"}, evil, opt)
+	GetDevicesByThing(TableTemperature, evil, evil)
+	DeleteData(TableTemperature, evil, evil, nil)
+	if len(f.queries) != 7 {
+		t.Fatalf("%d queries are sent, want 7", len(f.queries))
+	}
+	for _, q := range f.queries {
+		if strings.Contains(q, "project_id='other") || !strings.Contains(q, "project_id="+quoted) {
+			t.Errorf("query does not quote the values: %s", q)
+		}
+	}
+}
+
+func TestGroupDataInvalidInterval(t *testing.T) {
+	f := &fakeInflux{}
+	defer useFakeInflux(t, f)()
+	_, err := GetGroupDataByTime(columnTemperature, "", "2020-05-01T00:00:00Z", "2020-05-02T00:00:00Z",
+		[]string{"dev-1"}, "p-1", "1h) fill(none", FormatOption{})
+	if err == nil || len(f.queries) != 0 {
+		t.Errorf("invalid interval is queried, err:%v", err)
+	}
+}
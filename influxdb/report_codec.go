@@ -0,0 +1,328 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/fxamacker/cbor/v2"
+	"google.golang.org/protobuf/encoding/protowire"
+	"math"
+	"strconv"
+	"strings"
+)
+
+const (
+	EncodingJson  = "json"
+	EncodingCbor  = "cbor"
+	EncodingProto = "proto"
+
+	// A binary report may start with one of these header bytes instead of
+	// being published on the topic with the encoding suffix. They can never
+	// be the first byte of a json document.
+	HeaderCbor  byte = 0xC1
+	HeaderProto byte = 0xC2
+)
+
+// compactReport is the binary form of ReportData, numbers are carried as
+// numbers instead of strings, and map keys are small integers. The readings
+// are pointers, a reading of 0 is sent while a missing one is left out.
+type compactReport struct {
+	Device      string   `cbor:"1,keyasint,omitempty"`
+	Timestamp   int64    `cbor:"2,keyasint,omitempty"`
+	Rssi        int32    `cbor:"3,keyasint,omitempty"`
+	Temperature *float32 `cbor:"4,keyasint,omitempty"`
+	Humidity    *float32 `cbor:"5,keyasint,omitempty"`
+	DeviceName  string   `cbor:"6,keyasint,omitempty"`
+	Power       string   `cbor:"7,keyasint,omitempty"`
+	DataType    string   `cbor:"8,keyasint,omitempty"`
+	Data        string   `cbor:"9,keyasint,omitempty"`
+	Firmware    string   `cbor:"10,keyasint,omitempty"`
+}
+
+// compactReportList is the binary form of ReportDataList, see report.proto.
+type compactReportList struct {
+	SessionId string           `cbor:"1,keyasint,omitempty"`
+	Seq       int64            `cbor:"2,keyasint,omitempty"`
+	Objects   []*compactReport `cbor:"3,keyasint,omitempty"`
+}
+
+// EncodingFromTopic returns the encoding given by the topic suffix,
+// like $aws/things/<thing>/reports/cbor.
+func EncodingFromTopic(topic string) string {
+	switch {
+	case strings.HasSuffix(topic, "/"+EncodingCbor):
+		return EncodingCbor
+	case strings.HasSuffix(topic, "/"+EncodingProto):
+		return EncodingProto
+	default:
+		return EncodingJson
+	}
+}
+
+// UnmarshalReport decodes a report in the given encoding. A json report
+// which starts with a binary header byte is decoded as that encoding, the
+// encoding actually used is returned.
+func UnmarshalReport(encoding string, payload []byte, rdList *ReportDataList) (string, error) {
+	if encoding == EncodingJson && len(payload) > 0 {
+		switch payload[0] {
+		case HeaderCbor:
+			encoding, payload = EncodingCbor, payload[1:]
+		case HeaderProto:
+			encoding, payload = EncodingProto, payload[1:]
+		}
+	}
+	var cl compactReportList
+	switch encoding {
+	case EncodingJson:
+		return encoding, json.Unmarshal(payload, rdList)
+	case EncodingCbor:
+		if err := cbor.Unmarshal(payload, &cl); err != nil {
+			return encoding, err
+		}
+	case EncodingProto:
+		if err := cl.unmarshalProto(payload); err != nil {
+			return encoding, err
+		}
+	default:
+		return encoding, fmt.Errorf("unknown encoding(%s)", encoding)
+	}
+	cl.toReportDataList(rdList)
+	return encoding, nil
+}
+
+// MarshalReport encodes a report, binary encodings are not prefixed with a
+// header byte, use withHeader to add it.
+func MarshalReport(encoding string, rdList *ReportDataList, withHeader bool) ([]byte, error) {
+	switch encoding {
+	case EncodingJson:
+		return json.Marshal(rdList)
+	case EncodingCbor:
+		cl, err := newCompactReportList(rdList)
+		if err != nil {
+			return nil, err
+		}
+		data, err := cbor.Marshal(cl)
+		if err != nil {
+			return nil, err
+		}
+		if withHeader {
+			data = append([]byte{HeaderCbor}, data...)
+		}
+		return data, nil
+	case EncodingProto:
+		cl, err := newCompactReportList(rdList)
+		if err != nil {
+			return nil, err
+		}
+		var data []byte
+		if withHeader {
+			data = []byte{HeaderProto}
+		}
+		return cl.appendProto(data), nil
+	default:
+		return nil, fmt.Errorf("unknown encoding(%s)", encoding)
+	}
+}
+
+func newCompactReportList(rdList *ReportDataList) (*compactReportList, error) {
+	cl := compactReportList{
+		SessionId: rdList.SessionId,
+		Seq:       rdList.Seq,
+	}
+	for _, rd := range rdList.Objects {
+		cr := compactReport{
+			Device:     rd.Device,
+			Timestamp:  rd.Timestamp,
+			DeviceName: rd.DeviceName,
+			Power:      rd.Power,
+			DataType:   rd.DataType,
+			Data:       rd.Data,
+			Firmware:   rd.Firmware,
+		}
+		if len(rd.Rssi) > 0 {
+			rssi, err := strconv.ParseInt(string(rd.Rssi), 10, 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid rssi(%s)", rd.Rssi)
+			}
+			cr.Rssi = int32(rssi)
+		}
+		if len(rd.Temperature) > 0 {
+			temp, err := strconv.ParseFloat(string(rd.Temperature), 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid temperature(%s)", rd.Temperature)
+			}
+			t := float32(temp)
+			cr.Temperature = &t
+		}
+		if len(rd.Humidity) > 0 {
+			hum, err := strconv.ParseFloat(string(rd.Humidity), 32)
+			if err != nil {
+				return nil, fmt.Errorf("invalid humidity(%s)", rd.Humidity)
+			}
+			h := float32(hum)
+			cr.Humidity = &h
+		}
+		cl.Objects = append(cl.Objects, &cr)
+	}
+	return &cl, nil
+}
+
+func (cl *compactReportList) toReportDataList(rdList *ReportDataList) {
+	rdList.SessionId = cl.SessionId
+	rdList.Seq = cl.Seq
+	rdList.Objects = make([]*ReportData, 0, len(cl.Objects))
+	for _, cr := range cl.Objects {
+		rd := ReportData{
+			Device:     cr.Device,
+			Timestamp:  cr.Timestamp,
+			Rssi:       json.Number(strconv.FormatInt(int64(cr.Rssi), 10)),
+			DeviceName: cr.DeviceName,
+			Power:      cr.Power,
+			DataType:   cr.DataType,
+			Data:       cr.Data,
+			Firmware:   cr.Firmware,
+		}
+		// a missing reading is left empty, it is not a reading of 0
+		if cr.Temperature != nil {
+			rd.Temperature = json.Number(strconv.FormatFloat(float64(*cr.Temperature), 'f', -1, 32))
+		}
+		if cr.Humidity != nil {
+			rd.Humidity = json.Number(strconv.FormatFloat(float64(*cr.Humidity), 'f', -1, 32))
+		}
+		rdList.Objects = append(rdList.Objects, &rd)
+	}
+}
+
+func (cl *compactReportList) appendProto(b []byte) []byte {
+	if len(cl.SessionId) > 0 {
+		b = protowire.AppendTag(b, 1, protowire.BytesType)
+		b = protowire.AppendString(b, cl.SessionId)
+	}
+	if cl.Seq != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(cl.Seq))
+	}
+	for _, cr := range cl.Objects {
+		b = protowire.AppendTag(b, 3, protowire.BytesType)
+		b = protowire.AppendBytes(b, cr.appendProto(nil))
+	}
+	return b
+}
+
+func (cl *compactReportList) unmarshalProto(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch {
+		case num == 1 && typ == protowire.BytesType:
+			cl.SessionId, n = protowire.ConsumeString(b)
+		case num == 2 && typ == protowire.VarintType:
+			var v uint64
+			v, n = protowire.ConsumeVarint(b)
+			cl.Seq = int64(v)
+		case num == 3 && typ == protowire.BytesType:
+			var v []byte
+			v, n = protowire.ConsumeBytes(b)
+			if n >= 0 {
+				cr := compactReport{}
+				if err := cr.unmarshalProto(v); err != nil {
+					return err
+				}
+				cl.Objects = append(cl.Objects, &cr)
+			}
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+	}
+	return nil
+}
+
+func (cr *compactReport) appendProto(b []byte) []byte {
+	appendString := func(num protowire.Number, s string) {
+		if len(s) > 0 {
+			b = protowire.AppendTag(b, num, protowire.BytesType)
+			b = protowire.AppendString(b, s)
+		}
+	}
+	appendFloat := func(num protowire.Number, f *float32) {
+		if f != nil {
+			b = protowire.AppendTag(b, num, protowire.Fixed32Type)
+			b = protowire.AppendFixed32(b, math.Float32bits(*f))
+		}
+	}
+	appendString(1, cr.Device)
+	if cr.Timestamp != 0 {
+		b = protowire.AppendTag(b, 2, protowire.VarintType)
+		b = protowire.AppendVarint(b, uint64(cr.Timestamp))
+	}
+	if cr.Rssi != 0 {
+		b = protowire.AppendTag(b, 3, protowire.VarintType)
+		b = protowire.AppendVarint(b, protowire.EncodeZigZag(int64(cr.Rssi)))
+	}
+	appendFloat(4, cr.Temperature)
+	appendFloat(5, cr.Humidity)
+	appendString(6, cr.DeviceName)
+	appendString(7, cr.Power)
+	appendString(8, cr.DataType)
+	appendString(9, cr.Data)
+	appendString(10, cr.Firmware)
+	return b
+}
+
+func (cr *compactReport) unmarshalProto(b []byte) error {
+	for len(b) > 0 {
+		num, typ, n := protowire.ConsumeTag(b)
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		var s string
+		var v uint64
+		var f uint32
+		switch typ {
+		case protowire.BytesType:
+			s, n = protowire.ConsumeString(b)
+		case protowire.VarintType:
+			v, n = protowire.ConsumeVarint(b)
+		case protowire.Fixed32Type:
+			f, n = protowire.ConsumeFixed32(b)
+		default:
+			n = protowire.ConsumeFieldValue(num, typ, b)
+		}
+		if n < 0 {
+			return protowire.ParseError(n)
+		}
+		b = b[n:]
+		switch num {
+		case 1:
+			cr.Device = s
+		case 2:
+			cr.Timestamp = int64(v)
+		case 3:
+			cr.Rssi = int32(protowire.DecodeZigZag(v))
+		case 4:
+			t := math.Float32frombits(f)
+			cr.Temperature = &t
+		case 5:
+			h := math.Float32frombits(f)
+			cr.Humidity = &h
+		case 6:
+			cr.DeviceName = s
+		case 7:
+			cr.Power = s
+		case 8:
+			cr.DataType = s
+		case 9:
+			cr.Data = s
+		case 10:
+			cr.Firmware = s
+		}
+	}
+	return nil
+}
@@ -0,0 +1,115 @@
+package influxdb
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func testReportList() *ReportDataList {
+	return &ReportDataList{
+		SessionId: "sess-1",
+		Seq:       42,
+		Objects: []*ReportData{
+			{
+				Device:      "dev-1",
+				Timestamp:   1600000000123,
+				Rssi:        "-71",
+				Temperature: "-18.5",
+				Humidity:    "0",
+				DeviceName:  "freezer",
+				Power:       "87%",
+				DataType:    "sensor",
+				Firmware:    "1.4.2",
+			},
+			{
+				Device:    "dev-2",
+				Timestamp: 1600000000456,
+				Rssi:      "-60",
+				DataType:  "broadcast",
+				Data:      "0201061aff4c00",
+			},
+		},
+	}
+}
+
+func TestReportRoundTrip(t *testing.T) {
+	for _, encoding := range []string{EncodingCbor, EncodingProto} {
+		for _, withHeader := range []bool{false, true} {
+			want := testReportList()
+			payload, err := MarshalReport(encoding, want, withHeader)
+			if err != nil {
+				t.Fatalf("%s: marshal fail, err:%s", encoding, err.Error())
+			}
+			topicEncoding := encoding
+			if withHeader {
+				// a report with the header byte is published on the json topic
+				topicEncoding = EncodingJson
+			}
+			got := ReportDataList{}
+			used, err := UnmarshalReport(topicEncoding, payload, &got)
+			if err != nil {
+				t.Fatalf("%s: unmarshal fail, err:%s", encoding, err.Error())
+			}
+			if used != encoding {
+				t.Errorf("%s: decoded as %s", encoding, used)
+			}
+			if !reflect.DeepEqual(&got, want) {
+				g, _ := json.Marshal(got)
+				w, _ := json.Marshal(want)
+				t.Errorf("%s(header:%v): got %s, want %s", encoding, withHeader, g, w)
+			}
+		}
+	}
+}
+
+func TestReportMissingReading(t *testing.T) {
+	for _, encoding := range []string{EncodingCbor, EncodingProto} {
+		payload, err := MarshalReport(encoding, testReportList(), false)
+		if err != nil {
+			t.Fatalf("%s: marshal fail, err:%s", encoding, err.Error())
+		}
+		got := ReportDataList{}
+		if _, err := UnmarshalReport(encoding, payload, &got); err != nil {
+			t.Fatalf("%s: unmarshal fail, err:%s", encoding, err.Error())
+		}
+		if got.Objects[0].Humidity != "0" {
+			t.Errorf("%s: humidity of 0 is %q", encoding, got.Objects[0].Humidity)
+		}
+		if got.Objects[1].Temperature != "" || got.Objects[1].Humidity != "" {
+			t.Errorf("%s: missing readings are %q and %q, want them empty",
+				encoding, got.Objects[1].Temperature, got.Objects[1].Humidity)
+		}
+	}
+}
+
+func TestReportTruncated(t *testing.T) {
+	for _, encoding := range []string{EncodingCbor, EncodingProto} {
+		payload, err := MarshalReport(encoding, testReportList(), false)
+		if err != nil {
+			t.Fatalf("%s: marshal fail, err:%s", encoding, err.Error())
+		}
+		for n := 0; n < len(payload); n++ {
+			func() {
+				defer func() {
+					if p := recover(); p != nil {
+						t.Errorf("%s: panic with %d of %d bytes: %v", encoding, n, len(payload), p)
+					}
+				}()
+				_, _ = UnmarshalReport(encoding, payload[:n], &ReportDataList{})
+			}()
+		}
+		// cut inside the last field of the last object
+		if _, err := UnmarshalReport(encoding, payload[:len(payload)-1], &ReportDataList{}); err == nil {
+			t.Errorf("%s: truncated report is accepted", encoding)
+		}
+	}
+}
+
+func TestReportInvalid(t *testing.T) {
+	for _, encoding := range []string{EncodingJson, EncodingCbor, EncodingProto, "xml"} {
+		if _, err := UnmarshalReport(encoding, []byte{0xff, 0xff, 0xff}, &ReportDataList{}); err == nil {
+			t.Errorf("%s: garbage is accepted", encoding)
+		}
+	}
+}
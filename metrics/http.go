@@ -0,0 +1,82 @@
+package metrics
+
+import (
+	"bufio"
+	"errors"
+	"github.com/dimfeld/httptreemux"
+	"net"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+var (
+	httpRequests = NewCounter("http_requests_total",
+		"Number of http requests by route and status.", "method", "route", "status")
+	httpDuration = NewHistogram("http_request_duration_seconds",
+		"Latency of the http requests by route.", DefBuckets, "method", "route")
+)
+
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (sw *statusWriter) WriteHeader(code int) {
+	if sw.status == 0 {
+		sw.status = code
+	}
+	sw.ResponseWriter.WriteHeader(code)
+}
+
+func (sw *statusWriter) Write(b []byte) (int, error) {
+	if sw.status == 0 {
+		sw.status = http.StatusOK
+	}
+	return sw.ResponseWriter.Write(b)
+}
+
+// Flush and Hijack keep the event streams and the websockets working
+// through the writer.
+func (sw *statusWriter) Flush() {
+	if f, ok := sw.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+func (sw *statusWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	h, ok := sw.ResponseWriter.(http.Hijacker)
+	if !ok {
+		return nil, nil, errors.New("response writer is not a hijacker")
+	}
+	if sw.status == 0 {
+		sw.status = http.StatusSwitchingProtocols
+	}
+	return h.Hijack()
+}
+
+// Instrument counts the requests of the route and their latency. The route
+// is the pattern it is registered with, such as /v1/projects/:projectId, so
+// that the series do not grow with the ids.
+func Instrument(method, route string, fn httptreemux.HandlerFunc) httptreemux.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request, ps map[string]string) {
+		start := time.Now()
+		sw := &statusWriter{ResponseWriter: w}
+		defer func() {
+			status := sw.status
+			p := recover()
+			if p != nil {
+				status = http.StatusInternalServerError
+			} else if status == 0 {
+				status = http.StatusOK
+			}
+			httpRequests.Inc(method, route, strconv.Itoa(status))
+			httpDuration.Since(start, method, route)
+			if p != nil {
+				// left to the panic handler of the router
+				panic(p)
+			}
+		}()
+		fn(sw, r, ps)
+	}
+}
@@ -0,0 +1,278 @@
+// Package metrics keeps the counters, gauges and histograms of a service
+// and exposes them in the prometheus text format, see Handler. The metrics
+// are registered when they are created, usually as package variables next
+// to the code which updates them.
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const contentType = "text/plain; version=0.0.4; charset=utf-8"
+
+// DefBuckets are the buckets in seconds of the latency histograms.
+var DefBuckets = []float64{.005, .01, .025, .05, .1, .25, .5, 1, 2.5, 5, 10}
+
+var (
+	mu       sync.Mutex
+	families = make(map[string]*family)
+)
+
+var startTime = time.Now()
+
+func init() {
+	NewGaugeFunc("process_start_time_seconds", "Start time of the process since unix epoch in seconds.", func() float64 {
+		return float64(startTime.UnixNano()) / 1e9
+	})
+	NewGaugeFunc("go_goroutines", "Number of goroutines that currently exist.", func() float64 {
+		return float64(runtime.NumGoroutine())
+	})
+}
+
+type series struct {
+	values []string
+	value  float64
+	counts []uint64 // of the histogram buckets, not cumulative
+	sum    float64
+	count  uint64
+}
+
+// family is the metric of a name, with a series for every set of label
+// values it has been updated with.
+type family struct {
+	name    string
+	help    string
+	kind    string
+	labels  []string
+	buckets []float64
+	fn      func() float64
+
+	mu     sync.Mutex
+	series map[string]*series
+}
+
+func register(f *family) *family {
+	mu.Lock()
+	defer mu.Unlock()
+	if _, ok := families[f.name]; ok {
+		panic(fmt.Sprintf("metric(%s) is registered twice", f.name))
+	}
+	f.series = make(map[string]*series)
+	families[f.name] = f
+	return f
+}
+
+// get is the series of the label values, it is called with f.mu held.
+func (f *family) get(values []string) *series {
+	if len(values) != len(f.labels) {
+		panic(fmt.Sprintf("metric(%s) has labels %v, got values %v", f.name, f.labels, values))
+	}
+	key := strings.Join(values, "\xff")
+	s, ok := f.series[key]
+	if !ok {
+		s = &series{values: append([]string(nil), values...)}
+		if f.kind == "histogram" {
+			s.counts = make([]uint64, len(f.buckets))
+		}
+		f.series[key] = s
+	}
+	return s
+}
+
+// Counter is a metric which only goes up, such as the number of requests.
+type Counter struct {
+	f *family
+}
+
+func NewCounter(name, help string, labels ...string) *Counter {
+	return &Counter{f: register(&family{name: name, help: help, kind: "counter", labels: labels})}
+}
+
+// Inc adds one to the series of the label values.
+func (c *Counter) Inc(values ...string) {
+	c.Add(1, values...)
+}
+
+func (c *Counter) Add(v float64, values ...string) {
+	if v < 0 {
+		panic(fmt.Sprintf("counter(%s) can not decrease", c.f.name))
+	}
+	c.f.mu.Lock()
+	c.f.get(values).value += v
+	c.f.mu.Unlock()
+}
+
+// Gauge is a metric which goes up and down, such as the things online.
+type Gauge struct {
+	f *family
+}
+
+func NewGauge(name, help string, labels ...string) *Gauge {
+	return &Gauge{f: register(&family{name: name, help: help, kind: "gauge", labels: labels})}
+}
+
+// NewGaugeFunc registers a gauge without labels whose value is taken from fn
+// when the metrics are exposed.
+func NewGaugeFunc(name, help string, fn func() float64) {
+	register(&family{name: name, help: help, kind: "gauge", fn: fn})
+}
+
+func (g *Gauge) Set(v float64, values ...string) {
+	g.f.mu.Lock()
+	g.f.get(values).value = v
+	g.f.mu.Unlock()
+}
+
+func (g *Gauge) Add(v float64, values ...string) {
+	g.f.mu.Lock()
+	g.f.get(values).value += v
+	g.f.mu.Unlock()
+}
+
+// Reset removes all the series, so that the label values which are gone are
+// not exposed anymore.
+func (g *Gauge) Reset() {
+	g.f.mu.Lock()
+	g.f.series = make(map[string]*series)
+	g.f.mu.Unlock()
+}
+
+// Histogram counts the observations, such as latencies, in buckets.
+type Histogram struct {
+	f *family
+}
+
+// NewHistogram registers a histogram with the upper bounds of buckets, they
+// are sorted in increasing order.
+func NewHistogram(name, help string, buckets []float64, labels ...string) *Histogram {
+	return &Histogram{f: register(&family{name: name, help: help, kind: "histogram", labels: labels, buckets: buckets})}
+}
+
+func (h *Histogram) Observe(v float64, values ...string) {
+	h.f.mu.Lock()
+	s := h.f.get(values)
+	if i := sort.SearchFloat64s(h.f.buckets, v); i < len(s.counts) {
+		s.counts[i]++
+	}
+	s.sum += v
+	s.count++
+	h.f.mu.Unlock()
+}
+
+// Since observes the seconds passed since start.
+func (h *Histogram) Since(start time.Time, values ...string) {
+	h.Observe(time.Since(start).Seconds(), values...)
+}
+
+// Handler exposes the metrics in the prometheus text format.
+func Handler() http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", contentType)
+		w.WriteHeader(http.StatusOK)
+		Write(w)
+	})
+}
+
+// Serve is Handler for the routers of httptreemux.
+func Serve(w http.ResponseWriter, r *http.Request, _ map[string]string) {
+	Handler().ServeHTTP(w, r)
+}
+
+// Write writes all the metrics, sorted by name, in the prometheus text
+// format.
+func Write(w io.Writer) {
+	mu.Lock()
+	fs := make([]*family, 0, len(families))
+	for _, f := range families {
+		fs = append(fs, f)
+	}
+	mu.Unlock()
+	sort.Slice(fs, func(i, j int) bool {
+		return fs[i].name < fs[j].name
+	})
+	for _, f := range fs {
+		f.write(w)
+	}
+}
+
+func (f *family) write(w io.Writer) {
+	fmt.Fprintf(w, "# HELP %s %s\n", f.name, escapeHelp(f.help))
+	fmt.Fprintf(w, "# TYPE %s %s\n", f.name, f.kind)
+	if f.fn != nil {
+		fmt.Fprintf(w, "%s %s\n", f.name, formatFloat(f.fn()))
+		return
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	keys := make([]string, 0, len(f.series))
+	for k := range f.series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		s := f.series[k]
+		if f.kind != "histogram" {
+			fmt.Fprintf(w, "%s%s %s\n", f.name, f.labelPairs(s.values, ""), formatFloat(s.value))
+			continue
+		}
+		var cumulative uint64
+		for i, b := range f.buckets {
+			cumulative += s.counts[i]
+			fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelPairs(s.values, formatFloat(b)), cumulative)
+		}
+		fmt.Fprintf(w, "%s_bucket%s %d\n", f.name, f.labelPairs(s.values, "+Inf"), s.count)
+		fmt.Fprintf(w, "%s_sum%s %s\n", f.name, f.labelPairs(s.values, ""), formatFloat(s.sum))
+		fmt.Fprintf(w, "%s_count%s %d\n", f.name, f.labelPairs(s.values, ""), s.count)
+	}
+}
+
+// labelPairs is {name="value",...} of the series, with the le label of a
+// histogram bucket if le is not empty.
+func (f *family) labelPairs(values []string, le string) string {
+	pairs := make([]string, 0, len(values)+1)
+	for i, v := range values {
+		pairs = append(pairs, fmt.Sprintf("%s=\"%s\"", f.labels[i], escapeValue(v)))
+	}
+	if len(le) > 0 {
+		pairs = append(pairs, fmt.Sprintf("le=\"%s\"", le))
+	}
+	if len(pairs) == 0 {
+		return ""
+	}
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var (
+	helpEscaper  = strings.NewReplacer(`\`, `\\`, "\n", `\n`)
+	valueEscaper = strings.NewReplacer(`\`, `\\`, "\n", `\n`, `"`, `\"`)
+)
+
+func escapeHelp(s string) string {
+	return helpEscaper.Replace(s)
+}
+
+func escapeValue(s string) string {
+	return valueEscaper.Replace(s)
+}
+
+func formatFloat(v float64) string {
+	switch {
+	case math.IsInf(v, 1):
+		return "+Inf"
+	case math.IsInf(v, -1):
+		return "-Inf"
+	case math.IsNaN(v):
+		return "NaN"
+	}
+	return strconv.FormatFloat(v, 'g', -1, 64)
+}
@@ -0,0 +1,74 @@
+package metrics
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+)
+
+func written(f *family) string {
+	var buf bytes.Buffer
+	f.write(&buf)
+	return buf.String()
+}
+
+func TestCounterFormat(t *testing.T) {
+	c := NewCounter("test_requests_total", "Requests\nby \\ path.", "path", "code")
+	c.Inc("/b", "200")
+	c.Add(2.5, "/a", "500")
+	c.Inc("/b", "200")
+	want := strings.Join([]string{
+		`# HELP test_requests_total Requests\nby \\ path.`,
+		`# TYPE test_requests_total counter`,
+		`test_requests_total{path="/a",code="500"} 2.5`,
+		`test_requests_total{path="/b",code="200"} 2`,
+	}, "\n") + "\n"
+	if got := written(c.f); got != want {
+		t.Errorf("counter is written as\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestLabelEscaping(t *testing.T) {
+	g := NewGauge("test_escaped", "Escaped labels.", "name")
+	g.Set(1, "a \"quoted\" \\ path\nnext")
+	want := `test_escaped{name="a \"quoted\" \\ path\nnext"} 1` + "\n"
+	if got := written(g.f); !strings.HasSuffix(got, want) {
+		t.Errorf("gauge is written as\n%s\nwant the series\n%s", got, want)
+	}
+}
+
+func TestHistogramFormat(t *testing.T) {
+	h := NewHistogram("test_latency_seconds", "Latency.", []float64{.1, .5, 1}, "op")
+	// on a bound the observation falls into its bucket, above all the bounds
+	// only into +Inf
+	for _, v := range []float64{.05, .1, .3, 2} {
+		h.Observe(v, "read")
+	}
+	want := strings.Join([]string{
+		`# HELP test_latency_seconds Latency.`,
+		`# TYPE test_latency_seconds histogram`,
+		`test_latency_seconds_bucket{op="read",le="0.1"} 2`,
+		`test_latency_seconds_bucket{op="read",le="0.5"} 3`,
+		`test_latency_seconds_bucket{op="read",le="1"} 3`,
+		`test_latency_seconds_bucket{op="read",le="+Inf"} 4`,
+		`test_latency_seconds_sum{op="read"} 2.45`,
+		`test_latency_seconds_count{op="read"} 4`,
+	}, "\n") + "\n"
+	if got := written(h.f); got != want {
+		t.Errorf("histogram is written as\n%s\nwant\n%s", got, want)
+	}
+}
+
+func TestUnlabeledFormat(t *testing.T) {
+	h := NewHistogram("test_unlabeled_seconds", "Unlabeled.", []float64{1})
+	h.Observe(3)
+	if got := written(h.f); !strings.Contains(got, "test_unlabeled_seconds_bucket{le=\"+Inf\"} 1\ntest_unlabeled_seconds_sum 3\n") {
+		t.Errorf("unlabeled histogram is written as\n%s", got)
+	}
+	NewGaugeFunc("test_func", "Func.", func() float64 { return 7 })
+	var buf bytes.Buffer
+	Write(&buf)
+	if !strings.Contains(buf.String(), "# TYPE test_func gauge\ntest_func 7\n") {
+		t.Errorf("gauge func is not written:\n%s", buf.String())
+	}
+}
@@ -78,17 +78,14 @@ func InitClient() *MQTTClient {
 	opts.AddBroker(broker)
 	opts.SetClientID(conf.GetStringWithDefault("clientId", ClientId))
 	opts.SetStore(myNoOpStore)
-	admin, err := bluedb.QueryUserByName("admin")
-	if err != nil {
-		logs.Error("get admin err:%s", err.Error())
-		return nil
-	}
-	if admin == nil {
-		logs.Error("admin user not found")
+	// the stored passwords are hashed, the broker account is configured
+	password := conf.GetString("mqtt_password")
+	if len(password) == 0 {
+		logs.Error("mqtt_password is not configured")
 		return nil
 	}
-	opts.SetUsername(admin.Name)
-	opts.SetPassword(admin.Passwd)
+	opts.SetUsername(conf.GetStringWithDefault("mqtt_username", "admin"))
+	opts.SetPassword(password)
 
 	Client = &MQTTClient{
 		c: mqtt.NewClient(opts),
@@ -155,7 +152,7 @@ func (mc *MQTTClient) NotifyUserAdd(name, password, id string) {
 		logs.Error(err.Error())
 		return
 	}
-	logs.Debug("Notify user add bytesData:%s", bytesData)
+	logs.Debug("Notify user add, user:%s, project:%s", name, id)
 
 	var netTransport = &http.Transport{
 		Dial: (&net.Dialer{
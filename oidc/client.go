@@ -0,0 +1,103 @@
+package oidc
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// Client is a relying party registered at an issuer.
+type Client struct {
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Scopes       []string
+}
+
+// RandomString returns a url safe random string, used for the state, the
+// nonce and the PKCE verifier.
+func RandomString() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b), nil
+}
+
+func codeChallenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+// AuthCodeURL returns the url the browser is redirected to for the login, the
+// code is bound to the verifier by PKCE S256.
+func (c *Client) AuthCodeURL(p *Provider, state, nonce, verifier string) string {
+	scopes := append([]string{"openid"}, c.Scopes...)
+	v := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.ClientId},
+		"redirect_uri":          {c.RedirectUrl},
+		"scope":                 {strings.Join(scopes, " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {codeChallenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationEndpoint + sep + v.Encode()
+}
+
+// Exchange redeems the code at the token endpoint and verifies the returned
+// id token.
+func (c *Client) Exchange(p *Provider, code, verifier, nonce string) (*IdToken, error) {
+	v := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.RedirectUrl},
+		"client_id":     {c.ClientId},
+		"code_verifier": {verifier},
+	}
+	req, err := http.NewRequest(http.MethodPost, p.TokenEndpoint, strings.NewReader(v.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if len(c.ClientSecret) > 0 {
+		req.SetBasicAuth(url.QueryEscape(c.ClientId), url.QueryEscape(c.ClientSecret))
+	}
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	var tokens struct {
+		IdToken          string `json:"id_token"`
+		Error            string `json:"error"`
+		ErrorDescription string `json:"error_description"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return nil, fmt.Errorf("invalid token response: %s", resp.Status)
+	}
+	if resp.StatusCode != http.StatusOK || len(tokens.Error) > 0 {
+		return nil, fmt.Errorf("exchange code: %s %s", tokens.Error, tokens.ErrorDescription)
+	}
+	if len(tokens.IdToken) == 0 {
+		return nil, errors.New("no id token in token response")
+	}
+	return p.Verify(tokens.IdToken, c.ClientId, nonce)
+}
@@ -0,0 +1,272 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+const (
+	testClientId = "blueserver"
+	testKid      = "key-1"
+)
+
+// mockIdp is an issuer serving the discovery, the keys and the token
+// endpoint. The authorization endpoint is not served, a test takes the code
+// of an authorization url by authorize.
+type mockIdp struct {
+	*httptest.Server
+	key *rsa.PrivateKey
+
+	mu sync.Mutex
+	// the code challenges and nonces of the issued codes
+	challenges map[string]string
+	nonces     map[string]string
+	// claims changes the claims of the next id tokens
+	claims func(claims map[string]interface{})
+	// signer signs the next id tokens instead of key
+	signer *rsa.PrivateKey
+}
+
+func newMockIdp(t *testing.T) *mockIdp {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key fail, err:%s", err.Error())
+	}
+	idp := &mockIdp{
+		key:        key,
+		challenges: make(map[string]string),
+		nonces:     make(map[string]string),
+	}
+	mux := http.NewServeMux()
+	mux.HandleFunc(discoveryPath, func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]string{
+			"issuer":                 idp.URL,
+			"authorization_endpoint": idp.URL + "/authorize",
+			"token_endpoint":         idp.URL + "/token",
+			"jwks_uri":               idp.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("/jwks", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"keys": []map[string]string{{
+				"kid": testKid,
+				"kty": "RSA",
+				"use": "sig",
+				"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+				"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+			}},
+		})
+	})
+	mux.HandleFunc("/token", idp.token)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+// authorize issues a code for the authorization url, as the issuer does
+// after the user logs in.
+func (idp *mockIdp) authorize(t *testing.T, authUrl string) string {
+	u, err := url.Parse(authUrl)
+	if err != nil {
+		t.Fatalf("invalid auth url(%s)", authUrl)
+	}
+	q := u.Query()
+	if q.Get("code_challenge_method") != "S256" || len(q.Get("code_challenge")) == 0 {
+		t.Fatalf("auth url(%s) has no S256 code challenge", authUrl)
+	}
+	if q.Get("client_id") != testClientId || q.Get("response_type") != "code" {
+		t.Fatalf("invalid auth url(%s)", authUrl)
+	}
+	code := "code-" + q.Get("state")
+	idp.mu.Lock()
+	idp.challenges[code] = q.Get("code_challenge")
+	idp.nonces[code] = q.Get("nonce")
+	idp.mu.Unlock()
+	return code
+}
+
+func (idp *mockIdp) token(w http.ResponseWriter, r *http.Request) {
+	if err := r.ParseForm(); err != nil || r.Form.Get("grant_type") != "authorization_code" {
+		http.Error(w, `{"error":"invalid_request"}`, http.StatusBadRequest)
+		return
+	}
+	code := r.Form.Get("code")
+	idp.mu.Lock()
+	challenge, ok := idp.challenges[code]
+	nonce := idp.nonces[code]
+	delete(idp.challenges, code)
+	idp.mu.Unlock()
+	sum := sha256.Sum256([]byte(r.Form.Get("code_verifier")))
+	if !ok || base64.RawURLEncoding.EncodeToString(sum[:]) != challenge {
+		http.Error(w, `{"error":"invalid_grant"}`, http.StatusBadRequest)
+		return
+	}
+	claims := map[string]interface{}{
+		"iss":            idp.URL,
+		"aud":            testClientId,
+		"sub":            "subject-1",
+		"email":          "alice@example.com",
+		"email_verified": true,
+		"nonce":          nonce,
+		"iat":            time.Now().Unix(),
+		"exp":            time.Now().Add(time.Hour).Unix(),
+	}
+	if idp.claims != nil {
+		idp.claims(claims)
+	}
+	signer := idp.key
+	if idp.signer != nil {
+		signer = idp.signer
+	}
+	_ = json.NewEncoder(w).Encode(map[string]string{
+		"id_token": signToken(signer, claims),
+	})
+}
+
+func signToken(key *rsa.PrivateKey, claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "kid": testKid, "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	sig, _ := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+type login struct {
+	client   *Client
+	provider *Provider
+	code     string
+	nonce    string
+	verifier string
+}
+
+func startLogin(t *testing.T, idp *mockIdp) *login {
+	p, err := Discover(idp.URL)
+	if err != nil {
+		t.Fatalf("discover fail, err:%s", err.Error())
+	}
+	l := &login{
+		client: &Client{
+			ClientId:    testClientId,
+			RedirectUrl: "https://blueserver.example.com/v1/sso/callback",
+		},
+		provider: p,
+	}
+	state, _ := RandomString()
+	l.nonce, _ = RandomString()
+	l.verifier, _ = RandomString()
+	l.code = idp.authorize(t, l.client.AuthCodeURL(p, state, l.nonce, l.verifier))
+	return l
+}
+
+func TestLogin(t *testing.T) {
+	idp := newMockIdp(t)
+	l := startLogin(t, idp)
+	token, err := l.client.Exchange(l.provider, l.code, l.verifier, l.nonce)
+	if err != nil {
+		t.Fatalf("exchange fail, err:%s", err.Error())
+	}
+	if token.Subject != "subject-1" || token.Email != "alice@example.com" || !token.EmailVerified {
+		t.Errorf("unexpected token %+v", token)
+	}
+}
+
+func TestPkceVerifierMismatch(t *testing.T) {
+	idp := newMockIdp(t)
+	l := startLogin(t, idp)
+	other, _ := RandomString()
+	if _, err := l.client.Exchange(l.provider, l.code, other, l.nonce); err == nil {
+		t.Error("code is redeemed with another verifier")
+	}
+}
+
+func TestNonceMismatch(t *testing.T) {
+	idp := newMockIdp(t)
+	l := startLogin(t, idp)
+	other, _ := RandomString()
+	if _, err := l.client.Exchange(l.provider, l.code, l.verifier, other); err == nil {
+		t.Error("id token of another login is accepted")
+	}
+}
+
+func TestClaimsRejected(t *testing.T) {
+	for name, change := range map[string]func(claims map[string]interface{}){
+		"issuer":   func(claims map[string]interface{}) { claims["iss"] = "https://evil.example.com" },
+		"audience": func(claims map[string]interface{}) { claims["aud"] = "another-client" },
+		"expired":  func(claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"future":   func(claims map[string]interface{}) { claims["iat"] = time.Now().Add(time.Hour).Unix() },
+		"subject":  func(claims map[string]interface{}) { delete(claims, "sub") },
+	} {
+		idp := newMockIdp(t)
+		idp.claims = change
+		l := startLogin(t, idp)
+		if _, err := l.client.Exchange(l.provider, l.code, l.verifier, l.nonce); err == nil {
+			t.Errorf("id token with wrong %s is accepted", name)
+		}
+	}
+}
+
+func TestAudienceList(t *testing.T) {
+	idp := newMockIdp(t)
+	idp.claims = func(claims map[string]interface{}) {
+		claims["aud"] = []string{"another-client", testClientId}
+	}
+	l := startLogin(t, idp)
+	if _, err := l.client.Exchange(l.provider, l.code, l.verifier, l.nonce); err != nil {
+		t.Errorf("audience list with the client is rejected, err:%s", err.Error())
+	}
+}
+
+func TestSignatureRejected(t *testing.T) {
+	idp := newMockIdp(t)
+	other, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("generate key fail, err:%s", err.Error())
+	}
+	idp.signer = other
+	l := startLogin(t, idp)
+	if _, err := l.client.Exchange(l.provider, l.code, l.verifier, l.nonce); err == nil {
+		t.Error("id token signed by another key is accepted")
+	}
+
+	claims := map[string]interface{}{
+		"iss": idp.URL, "aud": testClientId, "sub": "subject-1", "nonce": "n",
+		"exp": time.Now().Add(time.Hour).Unix(),
+	}
+	valid := signToken(idp.key, claims)
+	if _, err := l.provider.Verify(valid, testClientId, "n"); err != nil {
+		t.Fatalf("valid token is rejected, err:%s", err.Error())
+	}
+	parts := strings.Split(valid, ".")
+	claims["sub"] = "admin"
+	payload, _ := json.Marshal(claims)
+	tampered := parts[0] + "." + base64.RawURLEncoding.EncodeToString(payload) + "." + parts[2]
+	if _, err := l.provider.Verify(tampered, testClientId, "n"); err == nil {
+		t.Error("tampered token is accepted")
+	}
+	none, _ := json.Marshal(map[string]string{"alg": "none", "kid": testKid})
+	unsigned := base64.RawURLEncoding.EncodeToString(none) + "." + parts[1] + "."
+	if _, err := l.provider.Verify(unsigned, testClientId, "n"); err == nil {
+		t.Error("unsigned token is accepted")
+	}
+}
+
+func TestDiscoverIssuerMismatch(t *testing.T) {
+	idp := newMockIdp(t)
+	// the discovery of the issuer names the issuer without the path
+	if _, err := Discover(idp.URL + "/tenant"); err == nil {
+		t.Error("configuration of another issuer is accepted")
+	}
+}
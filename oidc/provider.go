@@ -0,0 +1,174 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"github.com/jack0liu/logs"
+	"github.com/patrickmn/go-cache"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	discoveryPath = "/.well-known/openid-configuration"
+	// keys are fetched again for an unknown kid at most once in this interval
+	jwksRefreshInterval = time.Minute
+)
+
+var (
+	httpClient = &http.Client{Timeout: 10 * time.Second}
+	// providers caches the discovered providers by issuer
+	providers = cache.New(time.Hour, 10*time.Minute)
+)
+
+// Provider is the discovered configuration of an issuer.
+type Provider struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JwksUri               string `json:"jwks_uri"`
+
+	mu     sync.Mutex
+	keys   map[string]crypto.PublicKey
+	keysAt time.Time
+}
+
+type jsonWebKey struct {
+	Kid string `json:"kid"`
+	Kty string `json:"kty"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func getJson(url string, v interface{}) error {
+	resp, err := httpClient.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("get %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
+
+// Discover returns the provider of the issuer, the configuration is cached
+// for an hour.
+func Discover(issuer string) (*Provider, error) {
+	issuer = strings.TrimSuffix(issuer, "/")
+	if p, ok := providers.Get(issuer); ok {
+		return p.(*Provider), nil
+	}
+	p := &Provider{}
+	if err := getJson(issuer+discoveryPath, p); err != nil {
+		logs.Error("discover issuer(%s) err:%s", issuer, err.Error())
+		return nil, err
+	}
+	if strings.TrimSuffix(p.Issuer, "/") != issuer {
+		return nil, fmt.Errorf("issuer(%s) does not match the discovered issuer(%s)", issuer, p.Issuer)
+	}
+	if len(p.AuthorizationEndpoint) == 0 || len(p.TokenEndpoint) == 0 || len(p.JwksUri) == 0 {
+		return nil, fmt.Errorf("incomplete configuration of issuer(%s)", issuer)
+	}
+	providers.Set(issuer, p, cache.DefaultExpiration)
+	return p, nil
+}
+
+func parseKey(k jsonWebKey) (crypto.PublicKey, error) {
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeSegment(k.N)
+		if err != nil {
+			return nil, err
+		}
+		e, err := decodeSegment(k.E)
+		if err != nil {
+			return nil, err
+		}
+		return &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}, nil
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		case "P-521":
+			curve = elliptic.P521()
+		default:
+			return nil, fmt.Errorf("unsupported curve(%s)", k.Crv)
+		}
+		x, err := decodeSegment(k.X)
+		if err != nil {
+			return nil, err
+		}
+		y, err := decodeSegment(k.Y)
+		if err != nil {
+			return nil, err
+		}
+		return &ecdsa.PublicKey{
+			Curve: curve,
+			X:     new(big.Int).SetBytes(x),
+			Y:     new(big.Int).SetBytes(y),
+		}, nil
+	}
+	return nil, fmt.Errorf("unsupported key type(%s)", k.Kty)
+}
+
+// key returns the signing key of the kid, the key set is fetched again when
+// the kid is unknown, so that rotated keys are picked up.
+func (p *Provider) key(kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	if time.Since(p.keysAt) < jwksRefreshInterval {
+		return nil, fmt.Errorf("unknown key(%s)", kid)
+	}
+	var set struct {
+		Keys []jsonWebKey `json:"keys"`
+	}
+	if err := getJson(p.JwksUri, &set); err != nil {
+		logs.Error("fetch keys of issuer(%s) err:%s", p.Issuer, err.Error())
+		return nil, err
+	}
+	p.keys = make(map[string]crypto.PublicKey, len(set.Keys))
+	p.keysAt = time.Now()
+	for _, k := range set.Keys {
+		if len(k.Use) > 0 && k.Use != "sig" {
+			continue
+		}
+		pub, err := parseKey(k)
+		if err != nil {
+			logs.Error("skip key(%s) of issuer(%s), err:%s", k.Kid, p.Issuer, err.Error())
+			continue
+		}
+		p.keys[k.Kid] = pub
+	}
+	if k, ok := p.keys[kid]; ok {
+		return k, nil
+	}
+	// a single key may be used without kid
+	if len(kid) == 0 && len(p.keys) == 1 {
+		for _, k := range p.keys {
+			return k, nil
+		}
+	}
+	return nil, errors.New("unknown key(" + kid + ")")
+}
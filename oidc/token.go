@@ -0,0 +1,192 @@
+package oidc
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/rsa"
+	_ "crypto/sha256"
+	_ "crypto/sha512"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+	"time"
+)
+
+// allowed clock skew between the issuer and us
+const clockSkew = time.Minute
+
+var ErrInvalidToken = errors.New("invalid id token")
+
+// IdToken is the verified id token of a login.
+type IdToken struct {
+	Subject           string
+	Email             string
+	EmailVerified     bool
+	Name              string
+	PreferredUsername string
+	// all claims of the token
+	Claims map[string]interface{}
+}
+
+// Strings returns the claim as a list of strings, a single string is a list
+// of one.
+func (t *IdToken) Strings(claim string) []string {
+	var list []string
+	switch v := t.Claims[claim].(type) {
+	case string:
+		list = append(list, v)
+	case []interface{}:
+		for _, s := range v {
+			if str, ok := s.(string); ok {
+				list = append(list, str)
+			}
+		}
+	}
+	return list
+}
+
+func decodeSegment(seg string) ([]byte, error) {
+	return base64.RawURLEncoding.DecodeString(strings.TrimRight(seg, "="))
+}
+
+func claimString(claims map[string]interface{}, name string) string {
+	s, _ := claims[name].(string)
+	return s
+}
+
+func claimTime(claims map[string]interface{}, name string) (time.Time, bool) {
+	v, ok := claims[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+func hasAudience(claims map[string]interface{}, clientId string) bool {
+	switch aud := claims["aud"].(type) {
+	case string:
+		return aud == clientId
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientId {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) error {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512", "ES512":
+		hash = crypto.SHA512
+	default:
+		return fmt.Errorf("unsupported alg(%s)", alg)
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch k := key.(type) {
+	case *rsa.PublicKey:
+		if !strings.HasPrefix(alg, "RS") {
+			return ErrInvalidToken
+		}
+		return rsa.VerifyPKCS1v15(k, hash, digest, sig)
+	case *ecdsa.PublicKey:
+		size := (k.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(sig) != 2*size {
+			return ErrInvalidToken
+		}
+		r := new(big.Int).SetBytes(sig[:size])
+		s := new(big.Int).SetBytes(sig[size:])
+		if !ecdsa.Verify(k, digest, r, s) {
+			return ErrInvalidToken
+		}
+		return nil
+	}
+	return ErrInvalidToken
+}
+
+// Verify checks the signature of the raw id token with the keys of the
+// provider, and its issuer, audience, expiry and nonce.
+func (p *Provider) Verify(raw, clientId, nonce string) (*IdToken, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, ErrInvalidToken
+	}
+	headerBytes, err := decodeSegment(parts[0])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := json.Unmarshal(headerBytes, &header); err != nil {
+		return nil, ErrInvalidToken
+	}
+	sig, err := decodeSegment(parts[2])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig); err != nil {
+		return nil, ErrInvalidToken
+	}
+
+	payload, err := decodeSegment(parts[1])
+	if err != nil {
+		return nil, ErrInvalidToken
+	}
+	claims := make(map[string]interface{})
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return nil, ErrInvalidToken
+	}
+	if strings.TrimSuffix(claimString(claims, "iss"), "/") != strings.TrimSuffix(p.Issuer, "/") {
+		return nil, errors.New("id token of another issuer")
+	}
+	if !hasAudience(claims, clientId) {
+		return nil, errors.New("id token of another client")
+	}
+	now := time.Now()
+	exp, ok := claimTime(claims, "exp")
+	if !ok || now.After(exp.Add(clockSkew)) {
+		return nil, errors.New("id token expired")
+	}
+	if iat, ok := claimTime(claims, "iat"); ok && iat.After(now.Add(clockSkew)) {
+		return nil, errors.New("id token issued in the future")
+	}
+	if claimString(claims, "nonce") != nonce {
+		return nil, errors.New("id token of another login")
+	}
+
+	t := &IdToken{
+		Subject:           claimString(claims, "sub"),
+		Email:             claimString(claims, "email"),
+		Name:              claimString(claims, "name"),
+		PreferredUsername: claimString(claims, "preferred_username"),
+		Claims:            claims,
+	}
+	// some issuers send it as a string
+	switch v := claims["email_verified"].(type) {
+	case bool:
+		t.EmailVerified = v
+	case string:
+		t.EmailVerified = v == "true"
+	}
+	if len(t.Subject) == 0 {
+		return nil, ErrInvalidToken
+	}
+	return t, nil
+}
@@ -29,6 +29,13 @@ func SetWithExpired(key, value string, expiration time.Duration) {
 	re.Set(key, value, expiration)
 }
 
+// SetNX sets the key only if it does not exist, it returns false if the key
+// exists already.
+func SetNX(key, value string, expiration time.Duration) bool {
+	ok, err := re.SetNX(key, value, expiration).Result()
+	return err == nil && ok
+}
+
 func SetWithNoExpired(key, value string) {
 	re.Set(key, value, 0)
 }
@@ -49,3 +56,99 @@ func Del(key string) {
 func TouchWithExpired(key string, expiration time.Duration) {
 	re.Expire(key, expiration)
 }
+
+func HIncrBy(key, field string, incr int64) {
+	re.HIncrBy(key, field, incr)
+}
+
+func HGetAll(key string) map[string]string {
+	result, err := re.HGetAll(key).Result()
+	if err != nil {
+		return map[string]string{}
+	}
+	return result
+}
+
+func Publish(channel, message string) error {
+	return re.Publish(channel, message).Err()
+}
+
+// PSubscribe subscribes the channels matching the patterns, the caller
+// closes the returned PubSub.
+func PSubscribe(patterns ...string) *redis.PubSub {
+	return re.PSubscribe(patterns...)
+}
+
+// Take gets the value of the key and deletes the key in one transaction, a
+// key can be taken once.
+func Take(key string) string {
+	var get *redis.StringCmd
+	_, err := re.TxPipelined(func(pipe redis.Pipeliner) error {
+		get = pipe.Get(key)
+		pipe.Del(key)
+		return nil
+	})
+	if err != nil {
+		return ""
+	}
+	return get.Val()
+}
+
+func HSet(key, field, value string) {
+	re.HSet(key, field, value)
+}
+
+func HDel(key string, fields ...string) {
+	re.HDel(key, fields...)
+}
+
+func SAdd(key string, members ...interface{}) {
+	re.SAdd(key, members...)
+}
+
+func SRem(key string, members ...interface{}) {
+	re.SRem(key, members...)
+}
+
+func SMembers(key string) []string {
+	result, err := re.SMembers(key).Result()
+	if err != nil {
+		return []string{}
+	}
+	return result
+}
+
+var incrScript = redis.NewScript(`
+local n = redis.call("INCR", KEYS[1])
+if n == 1 then
+	redis.call("PEXPIRE", KEYS[1], ARGV[1])
+end
+return {n, redis.call("PTTL", KEYS[1])}
+`)
+
+// IncrWithExpired increases the counter of the key, the key expires after
+// the expiration from its first increase. It returns the count and the time
+// left until the key expires.
+func IncrWithExpired(key string, expiration time.Duration) (int64, time.Duration, error) {
+	result, err := incrScript.Run(re, []string{key}, int64(expiration/time.Millisecond)).Result()
+	if err != nil {
+		return 0, 0, err
+	}
+	vals, ok := result.([]interface{})
+	if !ok || len(vals) != 2 {
+		return 0, 0, redis.Nil
+	}
+	n, _ := vals[0].(int64)
+	ttl, _ := vals[1].(int64)
+	return n, time.Duration(ttl) * time.Millisecond, nil
+}
+
+// TTL returns the time left until the key expires, it is 0 if the key does
+// not exist or never expires.
+func TTL(key string) time.Duration {
+	ttl, err := re.PTTL(key).Result()
+	if err != nil || ttl < 0 {
+		return 0
+	}
+	return ttl
+}